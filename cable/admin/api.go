@@ -0,0 +1,147 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Handler returns an http.Handler serving the admin API of the manager under
+// /admin/, authenticating calls with the given bearer token:
+//
+//	GET    /admin/bridges                      lists the bridges
+//	POST   /admin/bridges                      creates a bridge given its spec
+//	GET    /admin/bridges/<name>               describes a bridge
+//	DELETE /admin/bridges/<name>               deletes a bridge
+//	POST   /admin/bridges/<name>/pause         pauses a bridge
+//	POST   /admin/bridges/<name>/resume        resumes a bridge
+//	GET    /admin/bridges/<name>/dead-letters  lists the messages that could not be written
+//	POST   /admin/bridges/<name>/replay        writes them again, optionally ?endpoint=<name>
+//	POST   /admin/bridges/<name>/messages      relays a message given its endpoint and text
+func Handler(manager *Manager, token string) http.Handler {
+	api := &api{manager: manager}
+	return authenticate(token, http.HandlerFunc(api.serve))
+}
+
+// api serves the admin API of a manager
+type api struct {
+	manager *Manager
+}
+
+// serve routes the calls to the admin API
+func (a *api) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin"), "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "bridges" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, ErrNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, a.manager.List())
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		a.create(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		status, err := a.manager.Get(parts[1])
+		respond(w, status, err)
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		respond(w, nil, a.manager.Delete(parts[1]))
+	case len(parts) == 3 && parts[2] == "pause" && r.Method == http.MethodPost:
+		respond(w, nil, a.manager.Pause(parts[1]))
+	case len(parts) == 3 && parts[2] == "resume" && r.Method == http.MethodPost:
+		respond(w, nil, a.manager.Resume(parts[1]))
+	case len(parts) == 3 && parts[2] == "dead-letters" && r.Method == http.MethodGet:
+		letters, err := a.manager.DeadLetters(parts[1])
+		respond(w, letters, err)
+	case len(parts) == 3 && parts[2] == "replay" && r.Method == http.MethodPost:
+		replayed, err := a.manager.Replay(parts[1], r.URL.Query().Get("endpoint"))
+		respond(w, map[string]int{"replayed": replayed}, err)
+	case len(parts) == 3 && parts[2] == "messages" && r.Method == http.MethodPost:
+		a.send(w, r, parts[1])
+	default:
+		writeError(w, http.StatusNotFound, ErrNotFound)
+	}
+}
+
+// create creates a bridge given the spec in the body of the request
+func (a *api) create(w http.ResponseWriter, r *http.Request) {
+	var spec BridgeSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := a.manager.Create(spec); err != nil {
+		if err == ErrExists {
+			writeError(w, http.StatusConflict, err)
+		} else {
+			writeError(w, http.StatusBadRequest, err)
+		}
+		return
+	}
+	status, err := a.manager.Get(spec.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, status)
+}
+
+// send relays the message in the body of the request through a bridge
+func (a *api) send(w http.ResponseWriter, r *http.Request, name string) {
+	var message OutgoingMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil || message.Text == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the body must be a JSON document with the text of the message"))
+		return
+	}
+	err := a.manager.Send(name, message.Endpoint, message.Text)
+	if err != nil && err != ErrNotFound {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	respond(w, nil, err)
+}
+
+// authenticate returns a handler calling next only if the request carries the
+// given bearer token
+func authenticate(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		given := strings.TrimPrefix(header, "Bearer ")
+		if token == "" || given == header || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cable"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respond writes body as JSON, or the error with the appropriate status if
+// err is not nil. A nil body results in 204 No Content.
+func respond(w http.ResponseWriter, body interface{}, err error) {
+	switch {
+	case err == ErrNotFound:
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	case body == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, body)
+	}
+}
+
+// writeError writes err as a JSON document with the given status
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes body as a JSON document with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
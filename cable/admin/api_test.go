@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func call(handler http.Handler, method string, path string, body string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestHandler_Authentication(t *testing.T) {
+	manager, _ := newTestManager(&memoryStore{})
+	handler := Handler(manager, "secret")
+
+	Equal(t, http.StatusUnauthorized, call(handler, "GET", "/admin/bridges", "", "").Code)
+	Equal(t, http.StatusUnauthorized, call(handler, "GET", "/admin/bridges", "", "wrong").Code)
+	Equal(t, http.StatusOK, call(handler, "GET", "/admin/bridges", "", "secret").Code)
+	bare := httptest.NewRequest("GET", "/admin/bridges", nil)
+	bare.Header.Set("Authorization", "secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, bare)
+	Equal(t, http.StatusUnauthorized, recorder.Code, "tokens must be given as bearer tokens")
+	Equal(t, http.StatusUnauthorized, call(Handler(manager, ""), "GET", "/admin/bridges", "", "").Code, "the API is closed without a token")
+}
+
+func TestHandler_Bridges(t *testing.T) {
+	manager, pumpers := newTestManager(&memoryStore{})
+	handler := Handler(manager, "secret")
+
+	body := `{"name":"ops","endpoints":[{"platform":"fake","channel":"a"},{"name":"other","platform":"fake","channel":"b","direction":"read-only"}]}`
+	res := call(handler, "POST", "/admin/bridges", body, "secret")
+	Equal(t, http.StatusCreated, res.Code)
+	var status BridgeStatus
+	Nil(t, json.Unmarshal(res.Body.Bytes(), &status))
+	Equal(t, "ops", status.Name)
+	Equal(t, "fake", status.Endpoints[0].Name)
+	Equal(t, "read-only", status.Endpoints[1].Direction)
+
+	Equal(t, http.StatusConflict, call(handler, "POST", "/admin/bridges", body, "secret").Code)
+	Equal(t, http.StatusBadRequest, call(handler, "POST", "/admin/bridges", `{"name":"bad"}`, "secret").Code)
+	Equal(t, http.StatusBadRequest, call(handler, "POST", "/admin/bridges", `not json`, "secret").Code)
+
+	var statuses []BridgeStatus
+	Nil(t, json.Unmarshal(call(handler, "GET", "/admin/bridges", "", "secret").Body.Bytes(), &statuses))
+	Equal(t, 1, len(statuses))
+
+	Equal(t, http.StatusNoContent, call(handler, "POST", "/admin/bridges/ops/pause", "", "secret").Code)
+	Nil(t, json.Unmarshal(call(handler, "GET", "/admin/bridges/ops", "", "secret").Body.Bytes(), &status))
+	True(t, status.Paused)
+	Equal(t, http.StatusNoContent, call(handler, "POST", "/admin/bridges/ops/resume", "", "secret").Code)
+
+	pumpers["a"].DeadLetters().Add(&fakeMessage{text: "lost"}, errors.New("timeout"))
+	var letters []DeadLetter
+	Nil(t, json.Unmarshal(call(handler, "GET", "/admin/bridges/ops/dead-letters", "", "secret").Body.Bytes(), &letters))
+	Equal(t, 1, len(letters))
+	Equal(t, "lost", letters[0].Message)
+
+	res = call(handler, "POST", "/admin/bridges/ops/replay?endpoint=fake", "", "secret")
+	Equal(t, http.StatusOK, res.Code)
+	Equal(t, `{"replayed":1}`, strings.TrimSpace(res.Body.String()))
+	Equal(t, "lost", (<-pumpers["a"].Outbox()).String())
+
+	Equal(t, http.StatusNoContent, call(handler, "POST", "/admin/bridges/ops/messages", `{"endpoint":"other","text":"testing"}`, "secret").Code)
+	Equal(t, "testing", (<-pumpers["a"].Outbox()).String())
+	Equal(t, http.StatusBadRequest, call(handler, "POST", "/admin/bridges/ops/messages", `{}`, "secret").Code)
+	Equal(t, http.StatusNotFound, call(handler, "POST", "/admin/bridges/ops/messages", `{"endpoint":"unknown","text":"testing"}`, "secret").Code)
+
+	Equal(t, http.StatusNoContent, call(handler, "DELETE", "/admin/bridges/ops", "", "secret").Code)
+	Equal(t, http.StatusNotFound, call(handler, "GET", "/admin/bridges/ops", "", "secret").Code)
+	Equal(t, http.StatusNotFound, call(handler, "GET", "/admin/unknown", "", "secret").Code)
+}
@@ -0,0 +1,545 @@
+// Package admin manages the bridges relaying messages at runtime, storing
+// their configuration and exposing them through an HTTP API.
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/miguelff/cable/cable"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a bridge or endpoint does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrExists is returned when creating a bridge whose name is taken
+	ErrExists = errors.New("already exists")
+)
+
+// EndpointSpec describes an endpoint of a bridge
+type EndpointSpec struct {
+	// Name identifies the endpoint within its bridge. Defaults to Platform.
+	Name string `json:"name"`
+	// Platform is the kind of endpoint, like "slack" or "telegram"
+	Platform string `json:"platform"`
+	// Channel identifies the conversation relayed within the platform, like
+	// a slack channel ID or a telegram chat ID
+	Channel string `json:"channel"`
+	// Direction is either "read-write" (the default), "read-only" or
+	// "write-only"
+	Direction string `json:"direction,omitempty"`
+	// Inbound and Outbound are middleware pipeline specifications, see
+	// cable.ParsePipeline
+	Inbound  string `json:"inbound,omitempty"`
+	Outbound string `json:"outbound,omitempty"`
+}
+
+// BridgeSpec describes a bridge relaying messages between its endpoints
+type BridgeSpec struct {
+	// Name identifies the bridge
+	Name string `json:"name"`
+	// Paused tells whether messages are not being relayed
+	Paused bool `json:"paused"`
+	// Endpoints are the endpoints connected by the bridge
+	Endpoints []EndpointSpec `json:"endpoints"`
+	// Batching merges consecutive messages before relaying them, see
+	// cable.ParseBatching
+	Batching string `json:"batching,omitempty"`
+}
+
+// EndpointStatus describes the current state of an endpoint of a bridge
+type EndpointStatus struct {
+	EndpointSpec
+	// Outbox is the number of messages waiting to be written to the endpoint
+	Outbox int `json:"outbox"`
+	// DeadLetters is the number of messages that could not be written to the
+	// endpoint
+	DeadLetters int `json:"dead_letters"`
+	// Health is the health of the endpoint, if it reports it
+	Health *cable.HealthStatus `json:"health,omitempty"`
+}
+
+// BridgeStatus describes the current state of a bridge
+type BridgeStatus struct {
+	Name      string           `json:"name"`
+	Paused    bool             `json:"paused"`
+	Batching  string           `json:"batching,omitempty"`
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// DeadLetter is a message that could not be written to an endpoint of a
+// bridge
+type DeadLetter struct {
+	Endpoint string    `json:"endpoint"`
+	Message  string    `json:"message"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Builder creates the pumper of an endpoint given its spec
+type Builder func(spec EndpointSpec) (cable.Pumper, error)
+
+// bridge is a bridge managed by a Manager
+type bridge struct {
+	spec      BridgeSpec
+	endpoints []cable.Endpoint
+	batching  *cable.Batching
+	// hub relays the messages of the bridge, and is nil while paused
+	hub *cable.Hub
+	// started tells whether the pumps of the endpoints were ever started
+	started bool
+}
+
+// Manager runs bridges, creating, pausing, resuming and deleting them at
+// runtime without disturbing the rest, and keeping their specs in a Store.
+// It is safe for concurrent use.
+type Manager struct {
+	store    Store
+	health   *cable.HealthChecker
+	builders map[string]Builder
+	mutex    sync.Mutex
+	bridges  map[string]*bridge
+}
+
+// NewManager returns the address of a new Manager storing the specs of its
+// bridges in store, building their endpoints with the builder of their
+// platform, and registering their health in health, if not nil
+func NewManager(store Store, health *cable.HealthChecker, builders map[string]Builder) *Manager {
+	return &Manager{
+		store:    store,
+		health:   health,
+		builders: builders,
+		bridges:  make(map[string]*bridge),
+	}
+}
+
+// Load starts the bridges in the store, along with the given default bridges,
+// which are configured elsewhere, like in the environment. Defaults replace
+// the stored bridges with the same name, which only keep whether they are
+// paused, so changes to their configuration apply, and they are started
+// even if the store was lost. Every bridge started is stored then.
+func (m *Manager) Load(defaults ...BridgeSpec) error {
+	specs, err := m.store.Load()
+	if err != nil {
+		return err
+	}
+	specs = MergeDefaults(specs, defaults)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, spec := range specs {
+		b, err := m.build(spec)
+		if err != nil {
+			return fmt.Errorf("bridge %q: %v", spec.Name, err)
+		}
+		m.bridges[spec.Name] = b
+		if !spec.Paused {
+			m.start(b)
+		}
+	}
+	return m.save()
+}
+
+// MergeDefaults returns the stored specs of bridges with the default ones in
+// place of the ones with the same name, whose paused state they keep, and
+// the rest of defaults added, which are the bridges a Manager loads
+func MergeDefaults(stored []BridgeSpec, defaults []BridgeSpec) []BridgeSpec {
+	merged := append([]BridgeSpec(nil), stored...)
+	for _, spec := range defaults {
+		replaced := false
+		for i, s := range merged {
+			if s.Name == spec.Name {
+				spec.Paused = s.Paused
+				merged[i], replaced = spec, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, spec)
+		}
+	}
+	return merged
+}
+
+// List returns the status of every bridge, sorted by name
+func (m *Manager) List() []BridgeStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	statuses := []BridgeStatus{}
+	for _, name := range m.names() {
+		statuses = append(statuses, m.bridges[name].status())
+	}
+	return statuses
+}
+
+// Get returns the status of the bridge with the given name
+func (m *Manager) Get(name string) (BridgeStatus, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, ok := m.bridges[name]
+	if !ok {
+		return BridgeStatus{}, ErrNotFound
+	}
+	return b.status(), nil
+}
+
+// Create starts a new bridge, unless paused, and stores it
+func (m *Manager) Create(spec BridgeSpec) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.bridges[spec.Name]; ok {
+		return ErrExists
+	}
+	b, err := m.build(spec)
+	if err != nil {
+		return err
+	}
+	m.bridges[spec.Name] = b
+	if err := m.save(); err != nil {
+		delete(m.bridges, spec.Name)
+		return err
+	}
+	if !spec.Paused {
+		m.start(b)
+	}
+	return nil
+}
+
+// Pause stops relaying the messages of a bridge
+func (m *Manager) Pause(name string) error {
+	return m.setPaused(name, true)
+}
+
+// Resume starts relaying again the messages of a paused bridge, through new
+// pumpers if it had been started, which keep the dead letters of the old ones
+func (m *Manager) Resume(name string) error {
+	return m.setPaused(name, false)
+}
+
+// Delete stops a bridge and removes it from the store
+func (m *Manager) Delete(name string) error {
+	var hub *cable.Hub
+	defer func() { stop(hub) }()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, ok := m.bridges[name]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.bridges, name)
+	if err := m.save(); err != nil {
+		m.bridges[name] = b
+		return err
+	}
+	hub = m.detach(b)
+	return nil
+}
+
+// DeadLetters returns the messages that could not be written to the
+// endpoints of a bridge
+func (m *Manager) DeadLetters(name string) ([]DeadLetter, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, ok := m.bridges[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	letters := []DeadLetter{}
+	for _, e := range b.endpoints {
+		if reporter, ok := e.Pumper.(cable.DeadLetterReporter); ok {
+			for _, letter := range reporter.DeadLetters().List() {
+				letters = append(letters, DeadLetter{
+					Endpoint: e.Name,
+					Message:  letter.Message.String(),
+					Error:    letter.Error,
+					FailedAt: letter.FailedAt,
+				})
+			}
+		}
+	}
+	return letters, nil
+}
+
+// Replay writes again the messages that could not be written to the given
+// endpoint of a bridge, or to any of its endpoints if empty, returning the
+// number of messages enqueued
+func (m *Manager) Replay(name string, endpoint string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, ok := m.bridges[name]
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	replayed, found := 0, false
+	for _, e := range b.endpoints {
+		if endpoint != "" && e.Name != endpoint {
+			continue
+		}
+		found = true
+		reporter, ok := e.Pumper.(cable.DeadLetterReporter)
+		if !ok {
+			continue
+		}
+		for _, letter := range reporter.DeadLetters().Drain() {
+			select {
+			case e.Pumper.Outbox() <- letter.Message:
+				replayed++
+			default:
+				reporter.DeadLetters().Add(letter.Message, errors.New("outbox full when replaying"))
+			}
+		}
+	}
+	if !found {
+		return 0, ErrNotFound
+	}
+	log.WithFields(log.Fields{"bridge": name, "endpoint": endpoint, "replayed": replayed}).Info("Dead letters replayed")
+	return replayed, nil
+}
+
+// Send relays a plain text message written by cable through a bridge, as if it
+// was read from the given endpoint, or from the first one reading messages if
+// empty. It is useful to check that messages get through.
+func (m *Manager) Send(name string, endpoint string, text string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, ok := m.bridges[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if b.hub == nil {
+		return errors.New("the bridge is paused")
+	}
+
+	for _, e := range b.endpoints {
+		if endpoint != "" && e.Name != endpoint {
+			continue
+		}
+		if !e.Direction.Reads() {
+			if endpoint == "" {
+				continue
+			}
+			return fmt.Errorf("endpoint %q does not read messages", endpoint)
+		}
+		select {
+		case e.Pumper.Inbox() <- &cable.Notice{Text: text, Plain: true}:
+			log.WithFields(log.Fields{"bridge": name, "endpoint": e.Name}).Info("Message sent")
+			return nil
+		default:
+			return fmt.Errorf("the inbox of endpoint %q is full", e.Name)
+		}
+	}
+	if endpoint == "" {
+		return errors.New("no endpoint reads messages")
+	}
+	return ErrNotFound
+}
+
+// setPaused pauses or resumes a bridge, storing its new state
+func (m *Manager) setPaused(name string, paused bool) error {
+	var hub *cable.Hub
+	defer func() { stop(hub) }()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b, ok := m.bridges[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if b.spec.Paused == paused {
+		return nil
+	}
+	var resumed *bridge
+	if !paused && b.started {
+		// the pumps stopped when pausing may still be running, so the bridge
+		// resumes with new ones
+		fresh, err := m.build(b.spec)
+		if err != nil {
+			return err
+		}
+		resumed = fresh
+	}
+	b.spec.Paused = paused
+	if err := m.save(); err != nil {
+		b.spec.Paused = !paused
+		return err
+	}
+	if paused {
+		hub = m.detach(b)
+		return nil
+	}
+	if resumed != nil {
+		handOver(b.endpoints, resumed.endpoints)
+		b.endpoints = resumed.endpoints
+	}
+	m.start(b)
+	return nil
+}
+
+// handOver moves the dead letters of the endpoints of a bridge to the ones
+// replacing them
+func handOver(old []cable.Endpoint, replacements []cable.Endpoint) {
+	for i, e := range old {
+		from, ok := e.Pumper.(cable.DeadLetterReporter)
+		to, replaced := replacements[i].Pumper.(cable.DeadLetterReporter)
+		if !ok || !replaced {
+			continue
+		}
+		for _, letter := range from.DeadLetters().Drain() {
+			to.DeadLetters().Add(letter.Message, errors.New(letter.Error))
+		}
+	}
+}
+
+// Validate checks the spec of a bridge without building its endpoints, so
+// nothing is connected
+func (m *Manager) Validate(spec BridgeSpec) error {
+	_, err := m.check(spec, false)
+	return err
+}
+
+// build validates the spec of a bridge, building its endpoints
+func (m *Manager) build(spec BridgeSpec) (*bridge, error) {
+	return m.check(spec, true)
+}
+
+// check validates the spec of a bridge, building its endpoints if asked to
+func (m *Manager) check(spec BridgeSpec, connect bool) (*bridge, error) {
+	if spec.Name == "" || strings.Contains(spec.Name, "/") {
+		return nil, errors.New("bridges must have a name, without slashes")
+	}
+	if len(spec.Endpoints) < 2 {
+		return nil, errors.New("bridges must have at least two endpoints")
+	}
+
+	batching, err := cable.ParseBatching(spec.Batching)
+	if err != nil {
+		return nil, err
+	}
+	b := &bridge{spec: spec, batching: batching}
+	b.spec.Endpoints = append([]EndpointSpec(nil), spec.Endpoints...)
+	names := make(map[string]bool)
+	for i, es := range b.spec.Endpoints {
+		if es.Name == "" {
+			es.Name = es.Platform
+			b.spec.Endpoints[i].Name = es.Name
+		}
+		if names[es.Name] {
+			return nil, fmt.Errorf("duplicated endpoint %q", es.Name)
+		}
+		names[es.Name] = true
+
+		builder, ok := m.builders[es.Platform]
+		if !ok {
+			return nil, fmt.Errorf("endpoint %q: unknown platform %q", es.Name, es.Platform)
+		}
+		direction, err := cable.ParseDirection(es.Direction)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: %v", es.Name, err)
+		}
+		inbound, err := cable.ParsePipeline(es.Inbound)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: inbound: %v", es.Name, err)
+		}
+		outbound, err := cable.ParsePipeline(es.Outbound)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: outbound: %v", es.Name, err)
+		}
+		if !connect {
+			continue
+		}
+		pumper, err := builder(es)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %q: %v", es.Name, err)
+		}
+
+		b.endpoints = append(b.endpoints, cable.Endpoint{
+			Name:      es.Name,
+			Platform:  es.Platform,
+			Pumper:    pumper,
+			Direction: direction,
+			Inbound:   inbound,
+			Outbound:  outbound,
+		})
+	}
+	return b, nil
+}
+
+// start starts relaying the messages of a bridge, registering the health of
+// its endpoints
+func (m *Manager) start(b *bridge) {
+	b.hub = cable.NewHub(b.spec.Name, b.endpoints...)
+	b.hub.Batching = b.batching
+	b.hub.Go()
+	b.started = true
+	for _, e := range b.endpoints {
+		if reporter, ok := e.Pumper.(cable.HealthReporter); ok && m.health != nil {
+			m.health.Register(b.spec.Name+"/"+e.Name, reporter)
+		}
+		log.WithFields(log.Fields{"bridge": b.spec.Name, "endpoint": e.Name, "platform": e.Platform, "direction": e.Direction.String()}).Info("Endpoint connected")
+	}
+}
+
+// detach detaches the hub relaying the messages of a bridge, unregistering
+// the health of its endpoints, and returns it to be stopped once the manager
+// is unlocked, as the commands handled by its endpoints lock it too
+func (m *Manager) detach(b *bridge) *cable.Hub {
+	hub := b.hub
+	if hub == nil {
+		return nil
+	}
+	b.hub = nil
+	for _, e := range b.endpoints {
+		if m.health != nil {
+			m.health.Unregister(b.spec.Name + "/" + e.Name)
+		}
+		log.WithFields(log.Fields{"bridge": b.spec.Name, "endpoint": e.Name, "platform": e.Platform}).Info("Endpoint disconnected")
+	}
+	return hub
+}
+
+// stop stops a hub detached from its bridge, if any
+func stop(hub *cable.Hub) {
+	if hub != nil {
+		hub.Stop()
+	}
+}
+
+// save stores the specs of every bridge
+func (m *Manager) save() error {
+	var specs []BridgeSpec
+	for _, name := range m.names() {
+		specs = append(specs, m.bridges[name].spec)
+	}
+	return m.store.Save(specs)
+}
+
+// names returns the sorted names of the bridges
+func (m *Manager) names() []string {
+	var names []string
+	for name := range m.bridges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// status returns the current status of the bridge
+func (b *bridge) status() BridgeStatus {
+	status := BridgeStatus{Name: b.spec.Name, Paused: b.spec.Paused, Batching: b.spec.Batching}
+	for i, e := range b.endpoints {
+		es := EndpointStatus{EndpointSpec: b.spec.Endpoints[i], Outbox: len(e.Pumper.Outbox())}
+		if reporter, ok := e.Pumper.(cable.DeadLetterReporter); ok {
+			es.DeadLetters = reporter.DeadLetters().Len()
+		}
+		if reporter, ok := e.Pumper.(cable.HealthReporter); ok {
+			health := reporter.Health()
+			es.Health = &health
+		}
+		status.Endpoints = append(status.Endpoints, es)
+	}
+	return status
+}
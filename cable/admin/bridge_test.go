@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestManager_Load(t *testing.T) {
+	store := &memoryStore{}
+	manager, _ := newTestManager(store)
+
+	Nil(t, manager.Load(createBridgeSpec("default", "a", "b")))
+	Equal(t, 1, len(store.specs), "defaults are stored when the store is empty")
+
+	stored := createBridgeSpec("default", "x", "y")
+	stored.Paused = true
+	store = &memoryStore{specs: []BridgeSpec{createBridgeSpec("stored", "c", "d"), stored}}
+	manager, _ = newTestManager(store)
+	Nil(t, manager.Load(createBridgeSpec("default", "a", "b")))
+	bridges := manager.List()
+	Equal(t, 2, len(bridges), "defaults are loaded along with the stored bridges")
+	Equal(t, "default", bridges[0].Name)
+	Equal(t, "a", bridges[0].Endpoints[0].Channel, "defaults replace the stored bridges with their name")
+	True(t, bridges[0].Paused, "defaults stay paused if paused")
+	Equal(t, "stored", bridges[1].Name)
+	Equal(t, 2, len(store.specs))
+}
+
+func TestManager_Lifecycle(t *testing.T) {
+	store := &memoryStore{}
+	manager, pumpers := newTestManager(store)
+	Nil(t, manager.Load(createBridgeSpec("first", "a", "b")))
+
+	Nil(t, manager.Create(createBridgeSpec("second", "c", "d")))
+	Equal(t, ErrExists, manager.Create(createBridgeSpec("second", "e", "f")))
+	Equal(t, 2, len(store.specs))
+
+	pumpers["c"].Inbox() <- &fakeMessage{text: "relayed"}
+	Equal(t, "relayed", (<-pumpers["d"].Outbox()).String())
+
+	Nil(t, manager.Pause("second"))
+	True(t, store.specs[1].Paused)
+	status, _ := manager.Get("second")
+	True(t, status.Paused)
+	_, statuses := manager.health.Ready()
+	Equal(t, 2, len(statuses), "paused bridges are not checked")
+
+	pumpers["a"].Inbox() <- &fakeMessage{text: "unaffected"}
+	Equal(t, "unaffected", (<-pumpers["b"].Outbox()).String(), "other bridges keep relaying")
+
+	paused := pumpers["c"]
+	paused.DeadLetters().Add(&fakeMessage{text: "lost"}, errors.New("channel_not_found"))
+	Nil(t, manager.Resume("second"))
+	True(t, paused != pumpers["c"], "bridges resume with new pumpers, as the old ones may still be stopping")
+	Equal(t, 1, pumpers["c"].DeadLetters().Len(), "dead letters are kept when resuming")
+	pumpers["d"].Inbox() <- &fakeMessage{text: "resumed"}
+	Equal(t, "resumed", (<-pumpers["c"].Outbox()).String())
+
+	Nil(t, manager.Delete("second"))
+	Equal(t, ErrNotFound, manager.Delete("second"))
+	Equal(t, 1, len(store.specs))
+	Equal(t, ErrNotFound, manager.Pause("second"))
+}
+
+func TestManager_Create_Invalid(t *testing.T) {
+	manager, _ := newTestManager(&memoryStore{})
+
+	NotNil(t, manager.Create(BridgeSpec{Name: "lonely", Endpoints: []EndpointSpec{{Platform: "fake"}}}))
+	NotNil(t, manager.Create(createBridgeSpec("a/b", "a", "b")))
+	NotNil(t, manager.Create(createBridgeSpec("duplicated", "a", "a")))
+
+	spec := createBridgeSpec("unknown", "a", "b")
+	spec.Endpoints[0].Platform = "carrier-pigeon"
+	NotNil(t, manager.Create(spec))
+
+	spec = createBridgeSpec("direction", "a", "b")
+	spec.Endpoints[0].Direction = "sideways"
+	NotNil(t, manager.Create(spec))
+
+	spec = createBridgeSpec("pipeline", "a", "b")
+	spec.Endpoints[0].Inbound = "unknown"
+	NotNil(t, manager.Create(spec))
+
+	spec = createBridgeSpec("batching", "a", "b")
+	spec.Batching = "everyone:10s"
+	NotNil(t, manager.Create(spec))
+
+	Empty(t, manager.List())
+}
+
+func TestManager_Create_StoreFailure(t *testing.T) {
+	manager, _ := newTestManager(&memoryStore{fail: true})
+	NotNil(t, manager.Create(createBridgeSpec("unsaved", "a", "b")))
+	Empty(t, manager.List(), "bridges are not created if they cannot be stored")
+}
+
+func TestManager_DeadLetters(t *testing.T) {
+	manager, pumpers := newTestManager(&memoryStore{})
+	Nil(t, manager.Create(createBridgeSpec("bridge", "a", "b")))
+
+	pumpers["b"].DeadLetters().Add(&fakeMessage{text: "lost"}, errors.New("channel_not_found"))
+	letters, err := manager.DeadLetters("bridge")
+	Nil(t, err)
+	Equal(t, 1, len(letters))
+	Equal(t, "b", letters[0].Endpoint)
+	Equal(t, "lost", letters[0].Message)
+	Equal(t, "channel_not_found", letters[0].Error)
+
+	status, _ := manager.Get("bridge")
+	Equal(t, 1, status.Endpoints[1].DeadLetters)
+
+	_, err = manager.Replay("bridge", "unknown")
+	Equal(t, ErrNotFound, err)
+
+	replayed, err := manager.Replay("bridge", "b")
+	Nil(t, err)
+	Equal(t, 1, replayed)
+	Equal(t, "lost", (<-pumpers["b"].Outbox()).String())
+	Equal(t, 0, pumpers["b"].DeadLetters().Len())
+}
+
+func TestManager_Validate(t *testing.T) {
+	manager, pumpers := newTestManager(&memoryStore{})
+
+	Nil(t, manager.Validate(createBridgeSpec("valid", "a", "b")))
+	Equal(t, 0, len(pumpers), "endpoints are not built")
+
+	invalid := createBridgeSpec("invalid", "a", "b")
+	invalid.Endpoints[1].Outbound = "unknown-middleware"
+	NotNil(t, manager.Validate(invalid))
+	NotNil(t, manager.Validate(BridgeSpec{Name: "lonely", Endpoints: []EndpointSpec{{Platform: "fake"}}}))
+}
+
+func TestManager_Send(t *testing.T) {
+	manager, pumpers := newTestManager(&memoryStore{})
+	spec := createBridgeSpec("ops", "a", "b", "c")
+	spec.Endpoints[0].Direction = "write-only"
+	Nil(t, manager.Create(spec))
+
+	Nil(t, manager.Send("ops", "", "testing 1, 2"))
+	Equal(t, "testing 1, 2", (<-pumpers["a"].Outbox()).String(), "messages are sent from the first endpoint reading")
+	Equal(t, "testing 1, 2", (<-pumpers["c"].Outbox()).String())
+
+	Nil(t, manager.Send("ops", "c", "testing 3"))
+	Equal(t, "testing 3", (<-pumpers["b"].Outbox()).String())
+
+	NotNil(t, manager.Send("ops", "a", "testing"), "write-only endpoints do not read messages")
+	Equal(t, ErrNotFound, manager.Send("ops", "z", "testing"))
+	Equal(t, ErrNotFound, manager.Send("unknown", "", "testing"))
+
+	Nil(t, manager.Pause("ops"))
+	NotNil(t, manager.Send("ops", "", "testing"))
+}
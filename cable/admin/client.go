@@ -0,0 +1,116 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OutgoingMessage is a message relayed through a bridge with the admin API
+type OutgoingMessage struct {
+	// Endpoint is the name of the endpoint the message is relayed from, as if
+	// it was read from it. Defaults to the first endpoint reading messages.
+	Endpoint string `json:"endpoint,omitempty"`
+	// Text is the text of the message
+	Text string `json:"text"`
+}
+
+// Client calls the admin API of a running cable
+type Client struct {
+	// URL is the base URL cable is served at, like http://localhost:8080
+	URL string
+	// Token is the bearer token authenticating the calls
+	Token string
+	// HTTPClient is the client making the calls
+	HTTPClient *http.Client
+}
+
+// NewClient returns the address of a new Client calling the admin API served
+// at the given base URL
+func NewClient(baseURL string, token string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// List returns the status of every bridge
+func (c *Client) List() ([]BridgeStatus, error) {
+	var statuses []BridgeStatus
+	err := c.call(http.MethodGet, "/admin/bridges", nil, &statuses)
+	return statuses, err
+}
+
+// Send relays a message through a bridge
+func (c *Client) Send(bridge string, message OutgoingMessage) error {
+	return c.call(http.MethodPost, "/admin/bridges/"+url.PathEscape(bridge)+"/messages", message, nil)
+}
+
+// DeadLetters returns the messages that could not be written to the
+// endpoints of a bridge
+func (c *Client) DeadLetters(bridge string) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := c.call(http.MethodGet, "/admin/bridges/"+url.PathEscape(bridge)+"/dead-letters", nil, &letters)
+	return letters, err
+}
+
+// Replay writes again the messages that could not be written to the given
+// endpoint of a bridge, or to any of its endpoints if empty, returning the
+// number of messages enqueued
+func (c *Client) Replay(bridge string, endpoint string) (int, error) {
+	path := "/admin/bridges/" + url.PathEscape(bridge) + "/replay"
+	if endpoint != "" {
+		path += "?endpoint=" + url.QueryEscape(endpoint)
+	}
+	var result struct {
+		Replayed int `json:"replayed"`
+	}
+	err := c.call(http.MethodPost, path, nil, &result)
+	return result.Replayed, err
+}
+
+// call makes a call to the admin API, sending body and decoding the response
+// into result, unless they are nil
+func (c *Client) call(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.URL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&failure) == nil && failure.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, failure.Error)
+		}
+		return fmt.Errorf("admin API responded %s", resp.Status)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,39 @@
+package admin
+
+import (
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClient(t *testing.T) {
+	manager, pumpers := newTestManager(&memoryStore{})
+	Nil(t, manager.Create(createBridgeSpec("ops", "a", "b")))
+	server := httptest.NewServer(Handler(manager, "secret"))
+	defer server.Close()
+	client := NewClient(server.URL+"/", "secret")
+
+	statuses, err := client.List()
+	Nil(t, err)
+	Equal(t, 1, len(statuses))
+
+	Nil(t, client.Send("ops", OutgoingMessage{Endpoint: "b", Text: "testing"}))
+	Equal(t, "testing", (<-pumpers["a"].Outbox()).String())
+
+	pumpers["b"].DeadLetters().Add(&fakeMessage{text: "lost"}, errors.New("timeout"))
+	letters, err := client.DeadLetters("ops")
+	Nil(t, err)
+	Equal(t, 1, len(letters))
+	Equal(t, "b", letters[0].Endpoint)
+
+	replayed, err := client.Replay("ops", "b")
+	Nil(t, err)
+	Equal(t, 1, replayed)
+	Equal(t, "lost", (<-pumpers["b"].Outbox()).String())
+
+	_, err = client.DeadLetters("unknown")
+	EqualError(t, err, "404 Not Found: not found")
+	_, err = NewClient(server.URL, "wrong").List()
+	EqualError(t, err, "401 Unauthorized: unauthorized")
+}
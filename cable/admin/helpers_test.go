@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"errors"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	"github.com/nlopes/slack"
+	"time"
+)
+
+/* fake pumper */
+
+type fakePumper struct {
+	*cable.Pump
+	channel string
+}
+
+func (p *fakePumper) GoRead() {
+	go func() { <-p.ReadStopper }()
+}
+
+func (p *fakePumper) GoWrite() {
+	go func() { <-p.WriteStopper }()
+}
+
+/* fake message */
+
+type fakeMessage struct {
+	text string
+}
+
+func (m *fakeMessage) ToSlack() ([]slack.MsgOption, error) {
+	return []slack.MsgOption{slack.MsgOptionText(m.text, false)}, nil
+}
+
+func (m *fakeMessage) ToTelegram(chatID int64) (telegram.MessageConfig, error) {
+	return telegram.NewMessage(chatID, m.text), nil
+}
+
+func (m *fakeMessage) String() string                     { return m.text }
+func (m *fakeMessage) Content() string                    { return m.text }
+func (m *fakeMessage) WithContent(s string) cable.Message { return &fakeMessage{text: s} }
+func (m *fakeMessage) Author() string                     { return "" }
+func (m *fakeMessage) FromBot() bool                      { return false }
+func (m *fakeMessage) SentAt() time.Time                  { return time.Time{} }
+
+/* in memory store */
+
+type memoryStore struct {
+	specs []BridgeSpec
+	fail  bool
+}
+
+func (s *memoryStore) Load() ([]BridgeSpec, error) {
+	return s.specs, nil
+}
+
+func (s *memoryStore) Save(specs []BridgeSpec) error {
+	if s.fail {
+		return errors.New("store unavailable")
+	}
+	s.specs = specs
+	return nil
+}
+
+/* factories */
+
+// newTestManager returns a manager with a fake platform, which records the
+// pumpers it builds by channel
+func newTestManager(store Store) (*Manager, map[string]*fakePumper) {
+	pumpers := make(map[string]*fakePumper)
+	builders := map[string]Builder{
+		"fake": func(spec EndpointSpec) (cable.Pumper, error) {
+			p := &fakePumper{Pump: cable.NewPump(), channel: spec.Channel}
+			pumpers[spec.Channel] = p
+			return p, nil
+		},
+	}
+	return NewManager(store, cable.NewHealthChecker(time.Minute), builders), pumpers
+}
+
+func createBridgeSpec(name string, channels ...string) BridgeSpec {
+	spec := BridgeSpec{Name: name}
+	for _, channel := range channels {
+		spec.Endpoints = append(spec.Endpoints, EndpointSpec{Name: channel, Platform: "fake", Channel: channel})
+	}
+	return spec
+}
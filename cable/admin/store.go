@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Store keeps the specs of the bridges managed at runtime
+type Store interface {
+	// Load returns the stored specs, or none if nothing was stored yet
+	Load() ([]BridgeSpec, error)
+	// Save replaces the stored specs
+	Save(specs []BridgeSpec) error
+}
+
+// FileStore is a Store keeping the specs in a JSON file
+type FileStore struct {
+	// Path is the path of the JSON file
+	Path string
+}
+
+// Load reads the specs from the file, returning none if it does not exist
+func (s *FileStore) Load() ([]BridgeSpec, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var specs []BridgeSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, err
+	}
+	return specs, nil
+}
+
+// Save writes the specs to the file. The file is replaced atomically, so it
+// is never left half written.
+func (s *FileStore) Save(specs []BridgeSpec) error {
+	data, err := json.MarshalIndent(specs, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), ".bridges")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
@@ -0,0 +1,31 @@
+package admin
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cable-store")
+	Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := &FileStore{Path: filepath.Join(dir, "bridges.json")}
+	specs, err := store.Load()
+	Nil(t, err)
+	Empty(t, specs, "nothing is loaded if the file does not exist")
+
+	saved := []BridgeSpec{createBridgeSpec("default", "a", "b")}
+	saved[0].Paused = true
+	Nil(t, store.Save(saved))
+
+	specs, err = store.Load()
+	Nil(t, err)
+	Equal(t, saved, specs)
+
+	files, _ := ioutil.ReadDir(dir)
+	Equal(t, 1, len(files), "temporary files are cleaned up")
+}
@@ -0,0 +1,132 @@
+package cable
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* Section: relaying audio and transcribing it */
+
+// DefaultTranscriptionTimeout is the time a WhisperTranscriber waits for a
+// transcription unless told otherwise
+const DefaultTranscriptionTimeout = 5 * time.Minute
+
+// Audio is a recording shared in a message, like a voice message
+type Audio struct {
+	// Filename is the name of the file, with the extension of its format
+	Filename string
+	// MimeType is the media type of the file, like "audio/ogg", which is
+	// empty if unknown
+	MimeType string
+	// Duration is the length of the recording
+	Duration time.Duration
+	// Content is the recording
+	Content []byte
+}
+
+// Audible is implemented by the messages carrying recordings, which
+// platforms able to upload files share as files along with the text of the
+// message
+type Audible interface {
+	// Audio returns the recordings carried by the message
+	Audio() []Audio
+}
+
+// AudioOf returns the recordings carried by m, which are none if it cannot
+// carry them
+func AudioOf(m Message) []Audio {
+	if audible, ok := m.(Audible); ok {
+		return audible.Audio()
+	}
+	return nil
+}
+
+// Transcriber turns the speech of recordings into text
+type Transcriber interface {
+	// Transcribe returns the text spoken in the recording
+	Transcribe(audio Audio) (string, error)
+}
+
+// WhisperTranscriber transcribes recordings running a command line
+// interface compatible with the one of whisper.cpp, which reads 16kHz WAV
+// files, so recordings are converted with ffmpeg first.
+type WhisperTranscriber struct {
+	// Command is the path of the whisper.cpp executable, like whisper-cli
+	Command string
+	// Model is the path of the model it transcribes with
+	Model string
+	// Language is the language spoken, or "auto" to detect it
+	Language string
+	// FFmpeg is the path of the ffmpeg executable the recordings are
+	// converted with, or empty if the command reads them as they are
+	FFmpeg string
+	// Timeout is the time to wait for a transcription, or zero to wait up to
+	// DefaultTranscriptionTimeout
+	Timeout time.Duration
+}
+
+// Transcribe writes the recording to a temporary file, converts it to WAV
+// and returns the text the command prints for it, without timestamps
+func (w *WhisperTranscriber) Transcribe(audio Audio) (string, error) {
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = DefaultTranscriptionTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "cable-audio")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input"+filepath.Ext(audio.Filename))
+	if err := ioutil.WriteFile(input, audio.Content, 0600); err != nil {
+		return "", err
+	}
+	if w.FFmpeg != "" {
+		wav := filepath.Join(dir, "audio.wav")
+		if _, err := run(ctx, w.FFmpeg, "-nostdin", "-loglevel", "error", "-i", input, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wav); err != nil {
+			return "", err
+		}
+		input = wav
+	}
+
+	language := w.Language
+	if language == "" {
+		language = "auto"
+	}
+	args := []string{"-f", input, "-l", language, "--no-timestamps", "--no-prints"}
+	if w.Model != "" {
+		args = append([]string{"-m", w.Model}, args...)
+	}
+	out, err := run(ctx, w.Command, args...)
+	if err != nil {
+		return "", err
+	}
+	// the text is printed in segments, one per line
+	return strings.Join(strings.Fields(out), " "), nil
+}
+
+// run runs a command, returning what it prints, or an error telling what it
+// printed to its standard error if it fails
+func run(ctx context.Context, command string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("%s: %v: %s", filepath.Base(command), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
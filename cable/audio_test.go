@@ -0,0 +1,65 @@
+package cable
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// audibleMessage is a fake message carrying recordings
+type audibleMessage struct {
+	fakeMessage
+	audio []Audio
+}
+
+func (m *audibleMessage) Audio() []Audio {
+	return m.audio
+}
+
+// script writes an executable shell script with the given body, returning
+// its path
+func script(t *testing.T, dir string, name string, body string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAudioOf(t *testing.T) {
+	combined := Combine(
+		&audibleMessage{audio: []Audio{{Filename: "voice.ogg", Content: []byte("OGG")}}},
+		&fakeMessage{text: "no audio"},
+	)
+	Equal(t, []Audio{{Filename: "voice.ogg", Content: []byte("OGG")}}, AudioOf(combined))
+	Nil(t, AudioOf(&fakeMessage{text: "no audio"}))
+}
+
+func TestWhisperTranscriber(t *testing.T) {
+	dir, err := ioutil.TempDir("", "whisper")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the fake ffmpeg copies the input, after -i, to the output, the last
+	// argument
+	ffmpeg := script(t, dir, "ffmpeg", `while [ "$1" != "-i" ]; do shift; done; in="$2"; for out; do :; done; cp "$in" "$out"`)
+	// the fake whisper prints its arguments and the recording in segments
+	whisper := script(t, dir, "whisper-cli", `for f; do case "$prev" in -f) file="$f";; esac; prev="$f"; done; echo " [$*]" | sed "s#$file#FILE#"; echo; echo "  $(cat "$file")"`)
+
+	transcriber := &WhisperTranscriber{Command: whisper, Model: "ggml-base.bin", FFmpeg: ffmpeg}
+	text, err := transcriber.Transcribe(Audio{Filename: "voice.ogg", Content: []byte("Sup Jay!")})
+	NoError(t, err)
+	Equal(t, "[-m ggml-base.bin -f FILE -l auto --no-timestamps --no-prints] Sup Jay!", text)
+
+	transcriber = &WhisperTranscriber{Command: script(t, dir, "broken", `echo "failed to load model" >&2; exit 1`)}
+	_, err = transcriber.Transcribe(Audio{Filename: "voice.ogg"})
+	EqualError(t, err, "broken: exit status 1: failed to load model")
+
+	transcriber = &WhisperTranscriber{Command: script(t, dir, "slow", `exec sleep 5`), Timeout: 10 * time.Millisecond}
+	_, err = transcriber.Transcribe(Audio{Filename: "voice.ogg"})
+	Error(t, err)
+	Contains(t, err.Error(), "deadline exceeded")
+}
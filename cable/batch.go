@@ -0,0 +1,213 @@
+package cable
+
+import (
+	"fmt"
+	"github.com/miguelff/cable/cable/tracing"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Section: batching the messages read from an endpoint */
+
+// batchLogSize is the number of messages a BatchLog remembers
+const batchLogSize = 1000
+
+// Batching tells how a hub merges consecutive messages read from an endpoint
+// into a single one before relaying it, so a busy chat does not flood the
+// others with notifications
+type Batching struct {
+	// Window is how long a batch waits for more messages since its first one
+	Window time.Duration
+	// ByAuthor tells whether only consecutive messages of the same author are
+	// merged. Otherwise, every message read within the window is.
+	ByAuthor bool
+}
+
+// ParseBatching parses a batching specification: "author:<window>" merges
+// the consecutive messages of the same author read within the window, and
+// "all:<window>" every message read within it, like "author:10s". An empty
+// specification disables batching, returning nil.
+func ParseBatching(spec string) (*Batching, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("batching %q is not one of author:<window> or all:<window>", spec)
+	}
+
+	batching := &Batching{}
+	switch strings.ToLower(parts[0]) {
+	case "author":
+		batching.ByAuthor = true
+	case "all":
+	default:
+		return nil, fmt.Errorf("unknown batching %q, use author or all", parts[0])
+	}
+	window, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("batching window %q: %v", parts[1], err)
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("batching window %q has to be positive", parts[1])
+	}
+	batching.Window = window
+	return batching, nil
+}
+
+// String returns the specification of the batching
+func (b *Batching) String() string {
+	if b == nil {
+		return ""
+	}
+	if b.ByAuthor {
+		return "author:" + b.Window.String()
+	}
+	return "all:" + b.Window.String()
+}
+
+// batch holds the messages read from an endpoint while batching them
+type batch struct {
+	parts []Message
+	// spans trace the relay of every part
+	spans []*tracing.Span
+	// bounces is the highest number of times any part bounced between the
+	// endpoints of the hub
+	bounces int
+	// deadline fires when the window of the batch is over
+	deadline <-chan time.Time
+}
+
+// accepts tells whether m can join the batch
+func (b *batch) accepts(m Message, batching *Batching) bool {
+	return len(b.parts) == 0 || !batching.ByAuthor || b.parts[0].Author() == m.Author()
+}
+
+// add adds m, whose relay is traced by span, to the batch, opening its window
+// if it is the first message
+func (b *batch) add(m Message, span *tracing.Span, bounces int, batching *Batching) {
+	if len(b.parts) == 0 {
+		b.deadline = time.After(batching.Window)
+	}
+	b.parts = append(b.parts, m)
+	b.spans = append(b.spans, span)
+	if bounces > b.bounces {
+		b.bounces = bounces
+	}
+}
+
+// BatchLog remembers the batch every message relayed as part of one went
+// into, by the ID of the message, so a later edit of any part can update the
+// batched copy
+type BatchLog struct {
+	ids     []string
+	batches map[string]*Combined
+	mutex   sync.Mutex
+}
+
+// NewBatchLog returns the address of a new, empty BatchLog
+func NewBatchLog() *BatchLog {
+	return &BatchLog{batches: make(map[string]*Combined)}
+}
+
+// Add remembers the batch of every part with an ID, forgetting the oldest
+// parts if full. Nothing is remembered by a nil log.
+func (l *BatchLog) Add(c *Combined) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for _, part := range c.Parts {
+		identifiable, ok := part.(Identifiable)
+		if !ok || identifiable.ID() == "" {
+			continue
+		}
+		id := identifiable.ID()
+		if _, ok := l.batches[id]; !ok {
+			l.ids = append(l.ids, id)
+		}
+		l.batches[id] = c
+	}
+	for len(l.ids) > batchLogSize {
+		delete(l.batches, l.ids[0])
+		l.ids = l.ids[1:]
+	}
+}
+
+// Get returns the batch the message with the given ID went into, and whether
+// it was batched at all
+func (l *BatchLog) Get(id string) (*Combined, bool) {
+	if l == nil {
+		return nil, false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	c, ok := l.batches[id]
+	return c, ok
+}
+
+// Update replaces the part with the ID of the edited message in its batch,
+// returning the updated batch, which edits the one relayed before, and
+// whether the message was batched at all
+func (l *BatchLog) Update(edited Message) (*Combined, bool) {
+	identifiable, ok := edited.(Identifiable)
+	if !ok {
+		return nil, false
+	}
+	id := identifiable.ID()
+	c, ok := l.Get(id)
+	if !ok {
+		return nil, false
+	}
+
+	updated := &Combined{Parts: append([]Message(nil), c.Parts...), Edited: true, id: c.id}
+	for i, part := range updated.Parts {
+		if p, ok := part.(Identifiable); ok && p.ID() == id {
+			updated.Parts[i] = edited
+		}
+	}
+	l.Add(updated)
+	return updated, true
+}
+
+// Remove removes the part deleted by a deletion from its batch, returning the
+// message relayed instead, and whether the message was batched at all. It is
+// the updated batch, which edits the one relayed before, or the deletion of
+// the whole batch if no other part is left.
+func (l *BatchLog) Remove(deletion *Deletion) (Message, bool) {
+	c, ok := l.Get(deletion.ID())
+	if !ok {
+		return nil, false
+	}
+
+	updated := &Combined{Edited: true, id: c.ID()}
+	for _, part := range c.Parts {
+		if IDOf(part) != deletion.ID() {
+			updated.Parts = append(updated.Parts, part)
+		}
+	}
+	l.forget(deletion.ID())
+	if len(updated.Parts) == 0 {
+		whole := *deletion
+		whole.Deleted = updated.ID()
+		return &whole, true
+	}
+	l.Add(updated)
+	return updated, true
+}
+
+// forget forgets the batch the message with the given ID went into
+func (l *BatchLog) forget(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.batches, id)
+	for i, logged := range l.ids {
+		if logged == id {
+			l.ids = append(l.ids[:i], l.ids[i+1:]...)
+			break
+		}
+	}
+}
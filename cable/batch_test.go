@@ -0,0 +1,92 @@
+package cable
+
+import (
+	"github.com/miguelff/cable/cable/tracing"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// identifiedMessage is a fake message with an ID
+type identifiedMessage struct {
+	fakeMessage
+	id string
+}
+
+func (m identifiedMessage) ID() string {
+	return m.id
+}
+
+func (m identifiedMessage) WithSpan(span *tracing.Span) Message {
+	m.span = span
+	return m
+}
+
+func TestParseBatching(t *testing.T) {
+	batching, err := ParseBatching("")
+	NoError(t, err)
+	Nil(t, batching)
+
+	batching, err = ParseBatching("author:10s")
+	NoError(t, err)
+	Equal(t, &Batching{Window: 10 * time.Second, ByAuthor: true}, batching)
+	Equal(t, "author:10s", batching.String())
+
+	batching, err = ParseBatching("all:1m")
+	NoError(t, err)
+	Equal(t, &Batching{Window: time.Minute}, batching)
+	Equal(t, "all:1m0s", batching.String())
+
+	for _, spec := range []string{"10s", "everyone:10s", "author:soon", "all:0s"} {
+		_, err = ParseBatching(spec)
+		Error(t, err, spec)
+	}
+}
+
+func TestBatchLog(t *testing.T) {
+	log := NewBatchLog()
+	first := identifiedMessage{fakeMessage{text: "one"}, "1"}
+	second := identifiedMessage{fakeMessage{text: "two"}, "2"}
+	batched := &Combined{Parts: []Message{first, &fakeMessage{text: "anonymous"}, second}}
+	log.Add(batched)
+	Equal(t, "1", batched.ID())
+
+	c, ok := log.Get("2")
+	True(t, ok)
+	Equal(t, batched, c)
+	_, ok = log.Get("3")
+	False(t, ok)
+
+	updated, ok := log.Update(identifiedMessage{fakeMessage{text: "two, edited"}, "2"})
+	True(t, ok)
+	Equal(t, "one\nanonymous\ntwo, edited", updated.String())
+	True(t, updated.IsEdit(), "the updated batch edits the one relayed")
+	Equal(t, "one\nanonymous\ntwo", batched.String(), "the batch relayed is not changed")
+	c, _ = log.Get("1")
+	Equal(t, updated, c, "every part refers to the updated batch")
+
+	_, ok = log.Update(identifiedMessage{fakeMessage{text: "unknown"}, "3"})
+	False(t, ok)
+
+	removed, ok := log.Remove(NewDeletion("test", "", "1"))
+	True(t, ok)
+	Equal(t, "anonymous\ntwo, edited", removed.String())
+	True(t, IsEdit(removed), "the updated batch edits the one relayed")
+	Equal(t, "1", IDOf(removed), "the batch keeps the ID of its first part")
+	_, ok = log.Get("1")
+	False(t, ok)
+	_, ok = log.Remove(NewDeletion("test", "", "3"))
+	False(t, ok)
+
+	log.Add(&Combined{Parts: []Message{identifiedMessage{fakeMessage{text: "four"}, "4"}, identifiedMessage{fakeMessage{text: "five"}, "5"}}})
+	log.Remove(NewDeletion("test", "", "4"))
+	removed, _ = log.Remove(NewDeletion("test", "", "5"))
+	deletion, ok := DeletionOf(removed)
+	True(t, ok, "deleting every part deletes the batch")
+	Equal(t, "4", deletion.ID())
+
+	var nilLog *BatchLog
+	nilLog.Add(batched)
+	_, ok = nilLog.Get("1")
+	False(t, ok)
+}
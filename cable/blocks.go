@@ -0,0 +1,118 @@
+package cable
+
+import (
+	"encoding/json"
+	"github.com/nlopes/slack"
+	"net/url"
+	"reflect"
+	"unsafe"
+)
+
+/* Section: laying out slack messages with Block Kit */
+
+const (
+	// SlackFormatBlocks lays out the messages relayed to slack with Block Kit
+	SlackFormatBlocks = "blocks"
+	// SlackFormatAttachments lays out the messages relayed to slack with
+	// legacy attachments
+	SlackFormatAttachments = "attachments"
+	// SlackBlocksLimit is the maximum number of blocks of a slack message
+	SlackBlocksLimit = 50
+	// SlackSectionLimit is the maximum length of the text of a section block
+	SlackSectionLimit = 3000
+)
+
+// SlackBlock is a block of the Block Kit layout of a slack message
+type SlackBlock struct {
+	// Type is the type of the block, like "section"
+	Type string `json:"type"`
+	// Text is the text of section blocks
+	Text *SlackBlockElement `json:"text,omitempty"`
+	// Elements are the texts and images of context blocks
+	Elements []SlackBlockElement `json:"elements,omitempty"`
+	// ImageURL and AltText are the image of image blocks, and its description
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+// SlackBlockElement is a text or an image inside a block
+type SlackBlockElement struct {
+	// Type is the type of the element, like "mrkdwn" or "image"
+	Type string `json:"type"`
+	// Text is the text of text elements
+	Text string `json:"text,omitempty"`
+	// ImageURL and AltText are the image of image elements, and its
+	// description
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+// MarkdownElement returns a text element formatted with slack markdown
+func MarkdownElement(text string) SlackBlockElement {
+	return SlackBlockElement{Type: "mrkdwn", Text: text}
+}
+
+// ImageElement returns an image element showing the image at the given URL
+func ImageElement(imageURL string, altText string) SlackBlockElement {
+	return SlackBlockElement{Type: "image", ImageURL: imageURL, AltText: altText}
+}
+
+// ContextBlock returns a block showing the given elements in small print,
+// like the author of a message
+func ContextBlock(elements ...SlackBlockElement) SlackBlock {
+	return SlackBlock{Type: "context", Elements: elements}
+}
+
+// SectionBlock returns a block showing a text formatted with slack markdown
+func SectionBlock(text string) SlackBlock {
+	element := MarkdownElement(text)
+	return SlackBlock{Type: "section", Text: &element}
+}
+
+// ImageBlock returns a block showing the image at the given URL
+func ImageBlock(imageURL string, altText string) SlackBlock {
+	return SlackBlock{Type: "image", ImageURL: imageURL, AltText: altText}
+}
+
+// BlockRenderer is implemented by the messages that can be laid out in slack
+// with Block Kit, besides with attachments
+type BlockRenderer interface {
+	// ToSlackBlocks converts the message into a slack message laid out with
+	// blocks, and a text for notifications
+	ToSlackBlocks() ([]slack.MsgOption, error)
+}
+
+// ToSlackBlocks converts m into a slack message laid out with blocks, or with
+// its ToSlack method if it cannot be
+func ToSlackBlocks(m Message) ([]slack.MsgOption, error) {
+	if renderer, ok := m.(BlockRenderer); ok {
+		return renderer.ToSlackBlocks()
+	}
+	return m.ToSlack()
+}
+
+// msgOptionType is the type of the options of slack messages
+var msgOptionType = reflect.TypeOf(slack.MsgOption(nil))
+
+// MsgOptionBlocks returns the option laying out a slack message with the
+// given blocks.
+//
+// The slack client predates Block Kit, and its options can only set the
+// parameters of the request it knows about, in a configuration unexported by
+// the client. So the option is made by reflection, setting the blocks
+// parameter in the values of the configuration it receives.
+func MsgOptionBlocks(blocks ...SlackBlock) slack.MsgOption {
+	encoded, err := json.Marshal(blocks)
+	option := reflect.MakeFunc(msgOptionType, func(args []reflect.Value) []reflect.Value {
+		result := reflect.New(msgOptionType.Out(0)).Elem()
+		if err != nil {
+			result.Set(reflect.ValueOf(err))
+			return []reflect.Value{result}
+		}
+		field := args[0].Elem().FieldByName("values")
+		values := *(*url.Values)(unsafe.Pointer(field.UnsafeAddr()))
+		values.Set("blocks", string(encoded))
+		return []reflect.Value{result}
+	})
+	return option.Interface().(slack.MsgOption)
+}
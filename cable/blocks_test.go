@@ -0,0 +1,47 @@
+package cable
+
+import (
+	"github.com/nlopes/slack"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// blocksMessage is a fake message laid out in slack with a section block
+type blocksMessage struct {
+	fakeMessage
+}
+
+func (m blocksMessage) ToSlackBlocks() ([]slack.MsgOption, error) {
+	return []slack.MsgOption{slack.MsgOptionText(m.text, false), MsgOptionBlocks(SectionBlock("*" + m.text + "*"))}, nil
+}
+
+func TestMsgOptionBlocks(t *testing.T) {
+	options := []slack.MsgOption{
+		slack.MsgOptionText("fallback", false),
+		MsgOptionBlocks(
+			ContextBlock(ImageElement("https://example.com/will.jpg", "Will"), MarkdownElement("*Will*")),
+			SectionBlock("Sup Jay!"),
+			ImageBlock("https://example.com/photo.jpg", "photo"),
+		),
+	}
+	_, values, err := slack.UnsafeApplyMsgOptions("TOKEN", "CHANNEL", options...)
+	NoError(t, err)
+	Equal(t, "fallback", values.Get("text"))
+	Equal(t, `[{"type":"context","elements":[{"type":"image","image_url":"https://example.com/will.jpg","alt_text":"Will"},{"type":"mrkdwn","text":"*Will*"}]},`+
+		`{"type":"section","text":{"type":"mrkdwn","text":"Sup Jay!"}},`+
+		`{"type":"image","image_url":"https://example.com/photo.jpg","alt_text":"photo"}]`, values.Get("blocks"))
+	Equal(t, "CHANNEL", values.Get("channel"), "the other parameters are kept")
+}
+
+func TestToSlackBlocks(t *testing.T) {
+	options, err := ToSlackBlocks(blocksMessage{fakeMessage{text: "bold"}})
+	NoError(t, err)
+	_, values, _ := slack.UnsafeApplyMsgOptions("", "", options...)
+	Equal(t, `[{"type":"section","text":{"type":"mrkdwn","text":"*bold*"}}]`, values.Get("blocks"))
+
+	options, err = ToSlackBlocks(&Notice{Text: "plain", Plain: true})
+	NoError(t, err)
+	_, values, _ = slack.UnsafeApplyMsgOptions("", "", options...)
+	Equal(t, "plain", values.Get("text"), "messages without blocks are converted with ToSlack")
+	Empty(t, values.Get("blocks"))
+}
@@ -0,0 +1,191 @@
+package cable
+
+import (
+	"path"
+	"strings"
+)
+
+/* Section: relaying code blocks and snippets */
+
+// snippetName is the name of the snippets extracted from code blocks too long
+// to be relayed as text
+const snippetName = "snippet"
+
+// extensions are the extensions of the files of code written in the
+// languages code blocks and snippets are tagged with
+var extensions = map[string]string{
+	"c":          "c",
+	"cpp":        "cpp",
+	"csharp":     "cs",
+	"css":        "css",
+	"diff":       "diff",
+	"dockerfile": "dockerfile",
+	"go":         "go",
+	"html":       "html",
+	"java":       "java",
+	"javascript": "js",
+	"json":       "json",
+	"kotlin":     "kt",
+	"markdown":   "md",
+	"php":        "php",
+	"python":     "py",
+	"ruby":       "rb",
+	"rust":       "rs",
+	"scala":      "scala",
+	"shell":      "sh",
+	"sql":        "sql",
+	"swift":      "swift",
+	"text":       "txt",
+	"typescript": "ts",
+	"xml":        "xml",
+	"yaml":       "yml",
+}
+
+// languageAliases are other names the languages are known by
+var languageAliases = map[string]string{
+	"bash":   "shell",
+	"c#":     "csharp",
+	"c++":    "cpp",
+	"golang": "go",
+	"js":     "javascript",
+	"md":     "markdown",
+	"plain":  "text",
+	"py":     "python",
+	"rb":     "ruby",
+	"rs":     "rust",
+	"sh":     "shell",
+	"ts":     "typescript",
+	"yml":    "yaml",
+}
+
+// Snippet is a piece of code shared as a file
+type Snippet struct {
+	// Filename is the name of the file, with the extension of its language
+	Filename string
+	// Language is the language of the code, like "python", which is empty if
+	// unknown
+	Language string
+	// Content is the code
+	Content string
+}
+
+// Snippeted is implemented by the messages carrying code snippets, which
+// platforms able to upload files share as documents along with the text of
+// the message
+type Snippeted interface {
+	// Snippets returns the snippets carried by the message
+	Snippets() []Snippet
+}
+
+// SnippetsOf returns the snippets carried by m, which are none if it cannot
+// carry them
+func SnippetsOf(m Message) []Snippet {
+	if snippeted, ok := m.(Snippeted); ok {
+		return snippeted.Snippets()
+	}
+	return nil
+}
+
+// Language returns the language known by the given name, like "python" for
+// "py", or an empty string if unknown
+func Language(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if language, ok := languageAliases[name]; ok {
+		return language
+	}
+	if _, ok := extensions[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// SnippetFilename returns the name of the file of a snippet of code in the
+// given language: the name itself if it has an extension, and the name, or
+// "snippet" if empty, with the extension of the language otherwise
+func SnippetFilename(name string, language string) string {
+	name = strings.TrimSpace(name)
+	if name != "" && path.Ext(name) != "" {
+		return name
+	}
+	if name == "" {
+		name = snippetName
+	}
+	extension, ok := extensions[Language(language)]
+	if !ok {
+		extension = extensions["text"]
+	}
+	return name + "." + extension
+}
+
+// Fragment is a piece of a text formatted with backticks, which is either code
+// or the text around it
+type Fragment struct {
+	// Text is the text of the fragment, without the backticks delimiting code
+	Text string
+	// Code tells whether the fragment is inline code, like `x`, and Block
+	// whether it is a fenced code block, like ```x```
+	Code, Block bool
+	// Language is the language a code block is tagged with in its first line,
+	// which is empty if none
+	Language string
+}
+
+// Fragments breaks a text formatted with backticks into its code spans, code
+// blocks and the text around them. Backticks without a closing one are text.
+func Fragments(text string) []Fragment {
+	var fragments []Fragment
+	plain := func(s string) {
+		if s == "" {
+			return
+		}
+		if n := len(fragments); n > 0 && !fragments[n-1].Code && !fragments[n-1].Block {
+			fragments[n-1].Text += s
+			return
+		}
+		fragments = append(fragments, Fragment{Text: s})
+	}
+
+	for text != "" {
+		start := strings.Index(text, "`")
+		if start < 0 {
+			plain(text)
+			break
+		}
+		plain(text[:start])
+		text = text[start:]
+
+		if strings.HasPrefix(text, "```") {
+			if end := strings.Index(text[3:], "```"); end >= 0 {
+				fragments = append(fragments, codeBlock(text[3:3+end]))
+				text = text[3+end+3:]
+				continue
+			}
+			plain("```")
+			text = text[3:]
+			continue
+		}
+		if end := strings.IndexAny(text[1:], "`\n"); end > 0 && text[1+end] == '`' {
+			fragments = append(fragments, Fragment{Text: text[1 : 1+end], Code: true})
+			text = text[1+end+1:]
+			continue
+		}
+		plain("`")
+		text = text[1:]
+	}
+	return fragments
+}
+
+// codeBlock returns the fragment of the code block with the given code, whose
+// first line tags its language if it is the name of a known one
+func codeBlock(code string) Fragment {
+	block := Fragment{Block: true}
+	if newline := strings.Index(code, "\n"); newline >= 0 {
+		if language := Language(code[:newline]); language != "" {
+			block.Language = language
+			code = code[newline+1:]
+		}
+	}
+	code = strings.TrimPrefix(code, "\n")
+	block.Text = strings.TrimSuffix(code, "\n")
+	return block
+}
@@ -0,0 +1,48 @@
+package cable
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// snippetedMessage is a fake message carrying snippets
+type snippetedMessage struct {
+	fakeMessage
+	snippets []Snippet
+}
+
+func (m *snippetedMessage) Snippets() []Snippet {
+	return m.snippets
+}
+
+func TestLanguage(t *testing.T) {
+	Equal(t, "python", Language("python"))
+	Equal(t, "python", Language(" PY "))
+	Equal(t, "shell", Language("bash"))
+	Equal(t, "", Language("func main() {"))
+	Equal(t, "", Language(""))
+}
+
+func TestSnippetFilename(t *testing.T) {
+	Equal(t, "main.go", SnippetFilename("main.go", "python"), "names with an extension are kept")
+	Equal(t, "script.py", SnippetFilename("script", "py"))
+	Equal(t, "snippet.rb", SnippetFilename("", "ruby"))
+	Equal(t, "notes.txt", SnippetFilename("notes", "klingon"))
+}
+
+func TestFragments(t *testing.T) {
+	Equal(t, []Fragment{{Text: "no code"}}, Fragments("no code"))
+	Equal(t, []Fragment{
+		{Text: "run "},
+		{Text: "make test", Code: true},
+		{Text: " and see:"},
+		{Text: "ok  \tcable", Block: true},
+	}, Fragments("run `make test` and see:```ok  \tcable```"))
+	Equal(t, []Fragment{
+		{Text: "def hi():\n    print(\"hi\")", Block: true, Language: "python"},
+	}, Fragments("```python\ndef hi():\n    print(\"hi\")\n```"), "the first line tags the language")
+	Equal(t, []Fragment{
+		{Text: "for x in xs:\n    pass", Block: true},
+	}, Fragments("```for x in xs:\n    pass```"), "the first line is code if it is not a language")
+	Equal(t, []Fragment{{Text: "a ` b\nc ``` d"}}, Fragments("a ` b\nc ``` d"), "unclosed backticks are text")
+}
@@ -0,0 +1,273 @@
+package cable
+
+import (
+	"encoding/json"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable/tracing"
+	"github.com/nlopes/slack"
+	"strings"
+	"time"
+)
+
+// Combined is a message combining several ones, written as a single message
+// in the order they were sent
+type Combined struct {
+	// Parts are the messages combined
+	Parts []Message
+	// Edited tells whether the combined message edits the one written for
+	// the same parts before, as one of them was edited
+	Edited bool
+	// id is the ID identifying the combined message if not the one of its
+	// first part, as the first part written was deleted since
+	id string
+}
+
+// Combine returns a message combining the given ones, or the message itself
+// if there is only one
+func Combine(parts ...Message) Message {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return &Combined{Parts: parts}
+}
+
+// ToSlack converts the parts into slack messages, joining their texts and
+// attachments into a single one
+func (c Combined) ToSlack() ([]slack.MsgOption, error) {
+	return c.combineSlack(func(part Message) ([]slack.MsgOption, error) {
+		return part.ToSlack()
+	})
+}
+
+// ToSlackBlocks converts the parts into slack messages laid out with blocks,
+// joining their blocks, texts and attachments into a single one. The texts of
+// the parts without blocks are laid out in sections, and the parts are
+// converted with ToSlack if there are too many blocks for a message.
+func (c Combined) ToSlackBlocks() ([]slack.MsgOption, error) {
+	options, err := c.combineSlack(func(part Message) ([]slack.MsgOption, error) {
+		options, err := ToSlackBlocks(part)
+		if err != nil {
+			return nil, err
+		}
+		_, values, err := slack.UnsafeApplyMsgOptions("", "", options...)
+		if err != nil || values.Get("blocks") != "" {
+			return options, err
+		}
+		var blocks []SlackBlock
+		for _, chunk := range ChunkText(values.Get("text"), SlackSectionLimit, telegram.ModeMarkdown) {
+			if chunk != "" {
+				blocks = append(blocks, SectionBlock(chunk))
+			}
+		}
+		return append(options, MsgOptionBlocks(blocks...)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, values, err := slack.UnsafeApplyMsgOptions("", "", options...)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []SlackBlock
+	if encoded := values.Get("blocks"); encoded != "" {
+		if err := json.Unmarshal([]byte(encoded), &blocks); err != nil {
+			return nil, err
+		}
+	}
+	if len(blocks) > SlackBlocksLimit {
+		return c.ToSlack()
+	}
+	return options, nil
+}
+
+// combineSlack converts the parts into slack messages with the given
+// function, joining their texts, attachments and blocks into a single one
+func (c Combined) combineSlack(convert func(Message) ([]slack.MsgOption, error)) ([]slack.MsgOption, error) {
+	var texts []string
+	var attachments []slack.Attachment
+	var blocks []SlackBlock
+	for _, part := range c.Parts {
+		options, err := convert(part)
+		if err != nil {
+			return nil, err
+		}
+		_, values, err := slack.UnsafeApplyMsgOptions("", "", options...)
+		if err != nil {
+			return nil, err
+		}
+		if text := values.Get("text"); text != "" {
+			texts = append(texts, text)
+		}
+		if encoded := values.Get("attachments"); encoded != "" {
+			var partAttachments []slack.Attachment
+			if err := json.Unmarshal([]byte(encoded), &partAttachments); err != nil {
+				return nil, err
+			}
+			attachments = append(attachments, partAttachments...)
+		}
+		if encoded := values.Get("blocks"); encoded != "" {
+			var partBlocks []SlackBlock
+			if err := json.Unmarshal([]byte(encoded), &partBlocks); err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, partBlocks...)
+		}
+	}
+
+	var options []slack.MsgOption
+	if len(texts) > 0 {
+		options = append(options, slack.MsgOptionText(strings.Join(texts, "\n"), false))
+	}
+	if len(attachments) > 0 {
+		options = append(options, slack.MsgOptionAttachments(attachments...))
+	}
+	if len(blocks) > 0 {
+		options = append(options, MsgOptionBlocks(blocks...))
+	}
+	return options, nil
+}
+
+// ToTelegram converts the parts into telegram messages, joining their texts
+// into a single one. The texts keep their formatting if every part uses the
+// same parse mode, and are sent as plain text otherwise.
+func (c Combined) ToTelegram(telegramChatID int64) (telegram.MessageConfig, error) {
+	var texts []string
+	var parseMode string
+	for i, part := range c.Parts {
+		msg, err := part.ToTelegram(telegramChatID)
+		if err != nil {
+			return telegram.MessageConfig{}, err
+		}
+		texts = append(texts, msg.Text)
+		if i == 0 {
+			parseMode = msg.ParseMode
+		} else if msg.ParseMode != parseMode {
+			parseMode = ""
+		}
+	}
+	return telegram.MessageConfig{
+		BaseChat:  telegram.BaseChat{ChatID: telegramChatID},
+		Text:      strings.Join(texts, "\n\n"),
+		ParseMode: parseMode,
+	}, nil
+}
+
+// String returns the representation of the parts, one per line
+func (c Combined) String() string {
+	var lines []string
+	for _, part := range c.Parts {
+		lines = append(lines, part.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Content returns the contents of the parts, one per line
+func (c Combined) Content() string {
+	var lines []string
+	for _, part := range c.Parts {
+		lines = append(lines, part.Content())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WithContent returns the first part with the given content, as the content
+// cannot be split back into the parts
+func (c Combined) WithContent(content string) Message {
+	return c.Parts[0].WithContent(content)
+}
+
+// Author returns the author of the parts if all of them have the same one,
+// and an empty string otherwise
+func (c Combined) Author() string {
+	author := c.Parts[0].Author()
+	for _, part := range c.Parts[1:] {
+		if part.Author() != author {
+			return ""
+		}
+	}
+	return author
+}
+
+// FromBot tells whether every part was written by a bot
+func (c Combined) FromBot() bool {
+	for _, part := range c.Parts {
+		if !part.FromBot() {
+			return false
+		}
+	}
+	return true
+}
+
+// SentAt returns the time the first part was sent
+func (c Combined) SentAt() time.Time {
+	return c.Parts[0].SentAt()
+}
+
+// ID returns the ID of the first part written, which identifies the combined
+// message, or an empty string if it has none
+func (c Combined) ID() string {
+	if c.id != "" {
+		return c.id
+	}
+	if identifiable, ok := c.Parts[0].(Identifiable); ok {
+		return identifiable.ID()
+	}
+	return ""
+}
+
+// Span returns the span of the first part, which traces the delivery of the
+// combined message
+func (c Combined) Span() *tracing.Span {
+	return SpanOf(c.Parts[0])
+}
+
+// WithSpan returns a copy of the combined message whose first part carries the
+// given span
+func (c Combined) WithSpan(span *tracing.Span) Message {
+	parts := append([]Message{WithSpan(c.Parts[0], span)}, c.Parts[1:]...)
+	return &Combined{Parts: parts, Edited: c.Edited, id: c.id}
+}
+
+// IsEdit tells whether the combined message edits the one written for the
+// same parts before
+func (c Combined) IsEdit() bool {
+	return c.Edited
+}
+
+// Provenance returns the provenance of the part relayed the most times
+func (c Combined) Provenance() Provenance {
+	var provenance Provenance
+	for _, part := range c.Parts {
+		if p := ProvenanceOf(part); p.Hops > provenance.Hops {
+			provenance = p
+		}
+	}
+	return provenance
+}
+
+// Snippets returns the snippets carried by every part
+func (c Combined) Snippets() []Snippet {
+	var snippets []Snippet
+	for _, part := range c.Parts {
+		snippets = append(snippets, SnippetsOf(part)...)
+	}
+	return snippets
+}
+
+// Audio returns the recordings carried by every part
+func (c Combined) Audio() []Audio {
+	var audio []Audio
+	for _, part := range c.Parts {
+		audio = append(audio, AudioOf(part)...)
+	}
+	return audio
+}
+
+// Images returns the pictures carried by every part
+func (c Combined) Images() []Image {
+	var images []Image
+	for _, part := range c.Parts {
+		images = append(images, ImagesOf(part)...)
+	}
+	return images
+}
@@ -0,0 +1,93 @@
+package cable
+
+import (
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/nlopes/slack"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCombine(t *testing.T) {
+	single := &Notice{Text: "alone", Plain: true}
+	Equal(t, single, Combine(single))
+
+	combined := Combine(&Notice{Text: "one", Plain: true}, &fakeMessage{text: "two", author: "will"}, &fakeMessage{text: "three", author: "will", provenance: Provenance{Origin: "other", Hops: 2}})
+	Equal(t, "one\ntwo\nthree", combined.String())
+	Equal(t, "one\ntwo\nthree", combined.Content())
+	Equal(t, "", combined.Author())
+	False(t, combined.FromBot())
+	Equal(t, Provenance{Origin: "other", Hops: 2}, ProvenanceOf(combined))
+	Equal(t, "will", Combine(&fakeMessage{text: "a", author: "will"}, &fakeMessage{text: "b", author: "will"}).Author())
+}
+
+func TestCombined_ToSlack(t *testing.T) {
+	combined := Combine(&Notice{Text: "one", Plain: true}, &Notice{Text: "two", Plain: true})
+	options, err := combined.ToSlack()
+	NoError(t, err)
+	_, values, err := slack.UnsafeApplyMsgOptions("", "", options...)
+	NoError(t, err)
+	Equal(t, "one\ntwo", values.Get("text"))
+	Equal(t, "", values.Get("attachments"))
+
+	_, err = Combine(&Notice{Text: "one"}, &fakeMessage{text: "two"}).ToSlack()
+	Error(t, err, "parts that cannot be converted fail")
+}
+
+func TestCombined_ToSlackBlocks(t *testing.T) {
+	combined := Combine(blocksMessage{fakeMessage{text: "one"}}, &Notice{Text: "two", Plain: true})
+	options, err := combined.(BlockRenderer).ToSlackBlocks()
+	NoError(t, err)
+	_, values, err := slack.UnsafeApplyMsgOptions("", "", options...)
+	NoError(t, err)
+	Equal(t, "one\ntwo", values.Get("text"))
+	Equal(t, `[{"type":"section","text":{"type":"mrkdwn","text":"*one*"}},{"type":"section","text":{"type":"mrkdwn","text":"two"}}]`,
+		values.Get("blocks"), "parts without blocks are laid out in sections")
+
+	var parts []Message
+	for i := 0; i <= SlackBlocksLimit; i++ {
+		parts = append(parts, blocksMessage{fakeMessage{text: "part"}})
+	}
+	options, err = Combine(parts...).(BlockRenderer).ToSlackBlocks()
+	Error(t, err, "parts are converted with ToSlack if there are too many blocks")
+	Nil(t, options)
+}
+
+func TestCombined_ToTelegram(t *testing.T) {
+	combined := Combine(&Notice{Text: "one", Plain: true}, &Notice{Text: "two", Plain: true})
+	msg, err := combined.ToTelegram(42)
+	NoError(t, err)
+	Equal(t, int64(42), msg.ChatID)
+	Equal(t, "one\n\ntwo", msg.Text)
+	Equal(t, "", msg.ParseMode)
+
+	markdown := Combine(markdownMessage{fakeMessage{text: "*one*"}}, markdownMessage{fakeMessage{text: "*two*"}})
+	msg, err = markdown.ToTelegram(42)
+	NoError(t, err)
+	Equal(t, telegram.ModeMarkdown, msg.ParseMode, "the parse mode is kept when shared")
+
+	msg, err = Combine(markdownMessage{fakeMessage{text: "*one*"}}, &Notice{Text: "two", Plain: true}).ToTelegram(42)
+	NoError(t, err)
+	Equal(t, "", msg.ParseMode)
+}
+
+// markdownMessage is a message written to telegram with markdown
+type markdownMessage struct {
+	fakeMessage
+}
+
+func (m markdownMessage) ToTelegram(telegramChatID int64) (telegram.MessageConfig, error) {
+	return telegram.MessageConfig{BaseChat: telegram.BaseChat{ChatID: telegramChatID}, Text: m.text, ParseMode: telegram.ModeMarkdown}, nil
+}
+
+func TestCombined_Snippets(t *testing.T) {
+	combined := Combine(
+		&snippetedMessage{snippets: []Snippet{{Filename: "a.go", Language: "go", Content: "package a"}}},
+		&fakeMessage{text: "no snippets"},
+		&snippetedMessage{snippets: []Snippet{{Filename: "b.py", Language: "python", Content: "pass"}}},
+	)
+	Equal(t, []Snippet{
+		{Filename: "a.go", Language: "go", Content: "package a"},
+		{Filename: "b.py", Language: "python", Content: "pass"},
+	}, SnippetsOf(combined))
+	Nil(t, SnippetsOf(&fakeMessage{text: "no snippets"}))
+}
@@ -0,0 +1,231 @@
+package cable
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CommandPrefix is the prefix of the commands cable understands in bridged
+// chats, like "/cable status"
+const CommandPrefix = "/cable"
+
+// Command is a command written in a bridged chat. Commands are intercepted by
+// the pumpers reading them, so they are never relayed.
+type Command struct {
+	// Name is the name of the command, like "pause"
+	Name string
+	// Args are the arguments written after the name, like "30m"
+	Args []string
+	// Message is the message the command was written in
+	Message Message
+	// FromAdmin tells whether the author of the command is an administrator
+	// of the chat, and thus can run privileged commands
+	FromAdmin bool
+}
+
+// ParseCommand tells whether the message is a command, returning it. Commands
+// are written as CommandPrefix, optionally followed by @ and the name of the
+// bot as telegram does, the name of the command and its arguments, separated
+// by spaces. A bare CommandPrefix is the help command.
+func ParseCommand(m Message) (Command, bool) {
+	fields := strings.Fields(m.Content())
+	if len(fields) == 0 || (fields[0] != CommandPrefix && !strings.HasPrefix(fields[0], CommandPrefix+"@")) {
+		return Command{}, false
+	}
+	return newCommand(m, fields[1:]), true
+}
+
+// ParseMentionCommand tells whether the message is a command, returning it.
+// Besides the commands ParseCommand understands, commands can be addressed to
+// the bot starting the message with the given mention of it instead of
+// CommandPrefix, like "<@U024BE7LH> status", as slack takes the messages
+// starting with a slash for slash commands, never posting them. A bare
+// mention is the help command.
+func ParseMentionCommand(m Message, mention string) (Command, bool) {
+	fields := strings.Fields(m.Content())
+	if mention == "" || len(fields) == 0 || fields[0] != mention {
+		return ParseCommand(m)
+	}
+	return newCommand(m, fields[1:]), true
+}
+
+// newCommand returns the command written in m with the given name and
+// arguments, which is the help command if there are none
+func newCommand(m Message, fields []string) Command {
+	command := Command{Name: "help", Message: m}
+	if len(fields) > 0 {
+		command.Name = strings.ToLower(fields[0])
+		command.Args = fields[1:]
+	}
+	return command
+}
+
+// CommandReceiver is implemented by the pumpers intercepting the commands
+// written in their chat, like the ones embedding a Pump
+type CommandReceiver interface {
+	// SetCommandHandler sets the function handling the commands read by the
+	// pumper, or stops intercepting them if nil
+	SetCommandHandler(handler func(Command))
+}
+
+// ParticipantLister is implemented by the pumpers that can list the
+// participants of their chat
+type ParticipantLister interface {
+	Participants() ([]string, error)
+}
+
+// Linker is implemented by the pumpers that can link to their chat
+type Linker interface {
+	Link() (string, error)
+}
+
+/* Section: commands run by a Hub */
+
+// commandHelp describes the commands understood by a Hub
+var commandHelp = strings.Join([]string{
+	"Commands:",
+	CommandPrefix + " status: describes this bridge",
+	CommandPrefix + " pause [duration]: stops relaying messages, for instance for 30m (admins only)",
+	CommandPrefix + " resume: starts relaying messages again (admins only)",
+	CommandPrefix + " who: lists the participants on the other side",
+	CommandPrefix + " link: links to the chats on the other side",
+	"In slack, mention the bot instead of writing " + CommandPrefix + ", like @cable status",
+}, "\n")
+
+// runCommand runs a command read from the i-th endpoint, replying to it. The
+// commands asking the other endpoints reply once they answer, without
+// holding up the source.
+func (h *Hub) runCommand(i int, c Command) {
+	source := h.Endpoints[i]
+	h.logger(source, "inbound").WithFields(log.Fields{"command": c.Name, "args": c.Args, "author": c.Message.Author()}).Debug("Command read")
+
+	var reply string
+	switch c.Name {
+	case "status":
+		reply = h.describe()
+	case "pause", "resume":
+		if !c.FromAdmin {
+			reply = fmt.Sprintf("only chat administrators can %s the bridge", c.Name)
+			break
+		}
+		if c.Name == "resume" {
+			h.Resume()
+			h.announce(fmt.Sprintf("Bridge resumed by %s", c.Message.Author()))
+			return
+		}
+		duration := time.Duration(0)
+		if len(c.Args) > 0 {
+			var err error
+			if duration, err = time.ParseDuration(c.Args[0]); err != nil || duration <= 0 {
+				reply = fmt.Sprintf("%q is not a valid duration, try for instance 30m or 2h", c.Args[0])
+				break
+			}
+		}
+		h.Pause(duration)
+		if duration > 0 {
+			h.announce(fmt.Sprintf("Bridge paused by %s for %s", c.Message.Author(), duration))
+		} else {
+			h.announce(fmt.Sprintf("Bridge paused by %s until resumed", c.Message.Author()))
+		}
+		return
+	case "who", "link":
+		// they call the APIs of the other endpoints, which can be slow, so
+		// they run on their own rather than stall reading the source
+		go func() {
+			h.reply(source, h.lookUp(i, c.Name))
+		}()
+		return
+	default:
+		reply = commandHelp
+	}
+	h.reply(source, reply)
+}
+
+// lookUp returns the reply to the who and link commands read from the i-th
+// endpoint, asking the other endpoints
+func (h *Hub) lookUp(i int, name string) string {
+	if name == "link" {
+		return h.collect(i, "Chats on the other side:", func(e Endpoint) (string, bool) {
+			linker, ok := e.Pumper.(Linker)
+			if !ok {
+				return "", false
+			}
+			link, err := linker.Link()
+			if err != nil {
+				return fmt.Sprintf("cannot link (%v)", err), true
+			}
+			return link, true
+		})
+	}
+	return h.collect(i, "Participants on the other side:", func(e Endpoint) (string, bool) {
+		lister, ok := e.Pumper.(ParticipantLister)
+		if !ok {
+			return "", false
+		}
+		participants, err := lister.Participants()
+		if err != nil {
+			return fmt.Sprintf("cannot list participants (%v)", err), true
+		}
+		sort.Strings(participants)
+		return strings.Join(participants, ", "), true
+	})
+}
+
+// reply writes the reply to a command to the endpoint it was read from,
+// unless it is read-only
+func (h *Hub) reply(source Endpoint, reply string) {
+	if source.Direction.Writes() {
+		h.deliver(source, &Notice{Text: reply, Plain: true})
+	}
+}
+
+// describe returns the status of the hub and its endpoints
+func (h *Hub) describe() string {
+	lines := []string{fmt.Sprintf("Bridge %s is relaying messages", h.Name)}
+	if until, paused := h.PausedUntil(); paused {
+		if until.IsZero() {
+			lines[0] = fmt.Sprintf("Bridge %s is paused until resumed", h.Name)
+		} else {
+			lines[0] = fmt.Sprintf("Bridge %s is paused until %s", h.Name, until.UTC().Format("15:04 MST"))
+		}
+	}
+	for _, e := range h.Endpoints {
+		state := "unknown"
+		if reporter, ok := e.Pumper.(HealthReporter); ok {
+			state = reporter.Health().State
+		}
+		lines = append(lines, fmt.Sprintf("• %s (%s): %s", e.Name, e.Direction, state))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// collect returns a line per endpoint other than the i-th one with the
+// result of describing it, under the given title, skipping the endpoints
+// describe does not know about
+func (h *Hub) collect(i int, title string, describe func(Endpoint) (string, bool)) string {
+	lines := []string{title}
+	for j, e := range h.Endpoints {
+		if j == i {
+			continue
+		}
+		if description, ok := describe(e); ok {
+			lines = append(lines, fmt.Sprintf("• %s: %s", e.Name, description))
+		}
+	}
+	if len(lines) == 1 {
+		return "No chat on the other side supports this command"
+	}
+	return strings.Join(lines, "\n")
+}
+
+// announce writes a notice to every writable endpoint of the hub
+func (h *Hub) announce(text string) {
+	for _, e := range h.Endpoints {
+		if e.Direction.Writes() {
+			h.deliver(e, &Notice{Text: text, Plain: true})
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package cable
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+/* fake pumper listing participants and linking to its chat */
+
+type fakeChatPumper struct {
+	*fakePumper
+	participants []string
+	link         string
+	// listing, if not nil, holds listing the participants until closed
+	listing chan bool
+}
+
+func (p *fakeChatPumper) Participants() ([]string, error) {
+	if p.listing != nil {
+		<-p.listing
+	}
+	return p.participants, nil
+}
+
+func (p *fakeChatPumper) Link() (string, error) {
+	return p.link, nil
+}
+
+func TestParseCommand(t *testing.T) {
+	command, ok := ParseCommand(&fakeMessage{text: "/cable pause 30m"})
+	True(t, ok)
+	Equal(t, "pause", command.Name)
+	Equal(t, []string{"30m"}, command.Args)
+
+	command, ok = ParseCommand(&fakeMessage{text: "/cable@cable_bot STATUS"})
+	True(t, ok)
+	Equal(t, "status", command.Name)
+
+	command, ok = ParseCommand(&fakeMessage{text: "/cable"})
+	True(t, ok)
+	Equal(t, "help", command.Name)
+
+	_, ok = ParseCommand(&fakeMessage{text: "/cablecar is fun"})
+	False(t, ok)
+	_, ok = ParseCommand(&fakeMessage{text: "what does /cable do?"})
+	False(t, ok)
+}
+
+func TestParseMentionCommand(t *testing.T) {
+	command, ok := ParseMentionCommand(&fakeMessage{text: "<@UCABLE> pause 30m"}, "<@UCABLE>")
+	True(t, ok)
+	Equal(t, "pause", command.Name)
+	Equal(t, []string{"30m"}, command.Args)
+
+	command, ok = ParseMentionCommand(&fakeMessage{text: "<@UCABLE>"}, "<@UCABLE>")
+	True(t, ok)
+	Equal(t, "help", command.Name)
+
+	command, ok = ParseMentionCommand(&fakeMessage{text: "/cable status"}, "<@UCABLE>")
+	True(t, ok)
+	Equal(t, "status", command.Name)
+
+	_, ok = ParseMentionCommand(&fakeMessage{text: "thanks <@UCABLE>"}, "<@UCABLE>")
+	False(t, ok)
+	_, ok = ParseMentionCommand(&fakeMessage{text: "<@UWILL> status"}, "<@UCABLE>")
+	False(t, ok)
+	_, ok = ParseMentionCommand(&fakeMessage{text: " status"}, "")
+	False(t, ok)
+}
+
+func TestHub_Commands(t *testing.T) {
+	slack := newFakePumper()
+	telegram := &fakeChatPumper{fakePumper: newFakePumper(), participants: []string{"will", "carlton"}, link: "https://t.me/joinchat/banks"}
+
+	hub := NewHub("commands", Endpoint{Name: "slack", Pumper: slack}, Endpoint{Name: "telegram", Pumper: telegram})
+	hub.Go()
+	defer hub.Stop()
+
+	run := func(text string, admin bool) {
+		command, _ := ParseCommand(&fakeMessage{text: text, author: "phil"})
+		command.FromAdmin = admin
+		True(t, slack.HandleCommand(command))
+	}
+
+	run("/cable status", false)
+	Equal(t, "Bridge commands is relaying messages\n• slack (read-write): idle\n• telegram (read-write): idle", (<-slack.Outbox()).String())
+
+	run("/cable who", false)
+	Equal(t, "Participants on the other side:\n• telegram: carlton, will", (<-slack.Outbox()).String())
+
+	run("/cable link", false)
+	Equal(t, "Chats on the other side:\n• telegram: https://t.me/joinchat/banks", (<-slack.Outbox()).String())
+
+	run("/cable pause", false)
+	Equal(t, "only chat administrators can pause the bridge", (<-slack.Outbox()).String())
+
+	run("/cable pause forever", true)
+	Contains(t, (<-slack.Outbox()).String(), "is not a valid duration")
+
+	run("/cable pause", true)
+	Equal(t, "Bridge paused by phil until resumed", (<-slack.Outbox()).String())
+	Equal(t, "Bridge paused by phil until resumed", (<-telegram.Outbox()).String())
+
+	slack.Inbox() <- &fakeMessage{text: "Not relayed"}
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 0, len(telegram.Outbox()), "messages are not relayed while paused")
+
+	run("/cable resume", true)
+	Equal(t, "Bridge resumed by phil", (<-slack.Outbox()).String())
+	Equal(t, "Bridge resumed by phil", (<-telegram.Outbox()).String())
+
+	slack.Inbox() <- &fakeMessage{text: "Relayed"}
+	Equal(t, "Relayed", (<-telegram.Outbox()).String())
+
+	run("/cable unknown", false)
+	Contains(t, (<-slack.Outbox()).String(), "Commands:")
+}
+
+func TestHub_Commands_Slow(t *testing.T) {
+	slack := newFakePumper()
+	telegram := &fakeChatPumper{fakePumper: newFakePumper(), participants: []string{"will"}, listing: make(chan bool)}
+
+	hub := NewHub("slow", Endpoint{Name: "slack", Pumper: slack}, Endpoint{Name: "telegram", Pumper: telegram})
+	hub.Go()
+	defer hub.Stop()
+
+	command, _ := ParseCommand(&fakeMessage{text: "/cable who"})
+	handled := make(chan bool)
+	go func() { handled <- slack.HandleCommand(command) }()
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		FailNow(t, "commands asking the other endpoints stall reading the source")
+	}
+	close(telegram.listing)
+	Equal(t, "Participants on the other side:\n• telegram: will", (<-slack.Outbox()).String())
+}
+
+func TestHub_PausedUntil(t *testing.T) {
+	hub := NewHub("pause")
+	_, paused := hub.PausedUntil()
+	False(t, paused)
+
+	hub.Pause(time.Millisecond)
+	_, paused = hub.PausedUntil()
+	True(t, paused)
+
+	time.Sleep(5 * time.Millisecond)
+	_, paused = hub.PausedUntil()
+	False(t, paused, "pauses expire after their duration")
+}
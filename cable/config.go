@@ -6,17 +6,115 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Config struct containing the configuration for the application
+// Config struct containing the configuration for the application. Inbound and
+// Outbound settings are middleware pipeline specifications, see ParsePipeline.
 type Config struct {
-	ListeningPort          string
-	SlackToken             string
-	SlackRelayedChannel    string
+	ListeningPort       string
+	SlackToken          string
+	SlackRelayedChannel string
+	// SlackBotUserID and TelegramBotUserID override the IDs of the bots,
+	// which are discovered with their tokens if empty
 	SlackBotUserID         string
+	SlackDirection         Direction
+	SlackInbound           string
+	SlackOutbound          string
 	TelegramToken          string
 	TelegramRelayedChannel int64
 	TelegramBotUserID      int
+	TelegramDirection      Direction
+	TelegramInbound        string
+	TelegramOutbound       string
+	// SlackCoalesceBacklog and TelegramCoalesceBacklog are the number of
+	// messages waiting to be written to a chat above which they are combined
+	// into one, or zero to never combine them
+	SlackCoalesceBacklog    int
+	TelegramCoalesceBacklog int
+	// SlackMaxParts and TelegramMaxParts are the number of parts above which
+	// messages too long for the platform are uploaded as a text file instead
+	// of split, or zero to always split them
+	SlackMaxParts    int
+	TelegramMaxParts int
+	// SlackMessageFormat lays out the messages relayed to slack with either
+	// SlackFormatBlocks or SlackFormatAttachments
+	SlackMessageFormat string
+	// PublicURL is the URL cable is reachable at, which the photos shared in
+	// telegram are served under to show them in slack, or empty not to
+	PublicURL string
+	// Batching merges consecutive messages read from a chat before relaying
+	// them, see ParseBatching
+	Batching string
+	// TranscriptionCommand is the path of the whisper.cpp compatible
+	// executable the voice messages read from telegram are transcribed with,
+	// or empty not to transcribe them, see WhisperTranscriber
+	TranscriptionCommand  string
+	TranscriptionModel    string
+	TranscriptionLanguage string
+	// FFmpeg is the path of the ffmpeg executable the voice messages are
+	// converted with before transcribing them, and the stickers read from
+	// telegram are converted into images with
+	FFmpeg string
+	// RelayedEvents are the comma separated kinds of events, like people
+	// joining a chat, relayed as notices, and MirroredEvents the ones
+	// mirrored in the other chats, like pins, see ParseEventRelay
+	RelayedEvents  string
+	MirroredEvents string
+	// Email settings are optional: the email endpoint is only connected when
+	// EmailTo is set
+	EmailSMTPAddr  string
+	EmailIMAPAddr  string
+	EmailIMAPTLS   bool
+	EmailUsername  string
+	EmailPassword  string
+	EmailMailbox   string
+	EmailFrom      string
+	EmailTo        string
+	EmailDigest    time.Duration
+	EmailDirection Direction
+	EmailInbound   string
+	EmailOutbound  string
+	// HealthGracePeriod is the time an endpoint can be disconnected before
+	// cable is reported as not alive
+	HealthGracePeriod time.Duration
+	// AdminToken is the bearer token authenticating calls to the admin API,
+	// which is only served when set
+	AdminToken string
+	// BridgesFile is the path of the JSON file where the bridges managed
+	// through the admin API are stored, along with the default bridge
+	BridgesFile string
+	// LogLevel is the minimum level of the messages logged, like debug or
+	// info
+	LogLevel string
+	// LogFormat is the format of the logs, either text or json
+	LogFormat string
+	// LogMessageBodies tells whether the content of the messages relayed is
+	// logged, along with their metadata
+	LogMessageBodies bool
+	// TracingExporter is where the traces of the messages relayed are
+	// exported: nowhere if empty, to stdout, or to an otlp collector
+	TracingExporter string
+	// TracingEndpoint is the base URL of the OTLP collector traces are
+	// exported to
+	TracingEndpoint string
+	// TracingHeaders are the comma separated key=value headers sent to the
+	// OTLP collector
+	TracingHeaders string
+	// TracingServiceName identifies cable in the traces exported
+	TracingServiceName string
+	// SlackAPIURL and TelegramAPIURL are the base URLs of the APIs of the
+	// platforms, which can point to emulators. The real APIs are used if
+	// empty.
+	SlackAPIURL    string
+	TelegramAPIURL string
+	// RecordFile is the path of the JSONL file where the events read from
+	// slack and telegram are recorded, to replay them later. Events are not
+	// recorded if empty.
+	RecordFile string
+	// RecordRedaction tells whether sensitive information in the texts of the
+	// events recorded is redacted
+	RecordRedaction bool
 }
 
 // NewConfig creates a new value of Config
@@ -26,14 +124,70 @@ func NewConfig() *Config {
 		listeningPort = fmt.Sprintf(":%s", listeningPort)
 	}
 
+	config := NewBridgeConfig()
+	config.ListeningPort = listeningPort
+	config.SlackToken = getEnv("SLACK_TOKEN")
+	config.TelegramToken = getEnv("TELEGRAM_TOKEN")
+	return config
+}
+
+// NewBridgeConfig creates a new value of Config for the commands that work
+// with the bridges without connecting to any platform, like validating them.
+// Unlike NewConfig, it does not require the listening port nor the tokens of
+// the bots.
+func NewBridgeConfig() *Config {
 	return &Config{
-		ListeningPort:          listeningPort,
-		SlackToken:             getEnv("SLACK_TOKEN"),
-		SlackRelayedChannel:    getEnv("SLACK_RELAYED_CHANNEL"),
-		SlackBotUserID:         getEnv("SLACK_BOT_USER_ID"),
-		TelegramToken:          getEnv("TELEGRAM_TOKEN"),
-		TelegramRelayedChannel: getEnvAsInt64("TELEGRAM_RELAYED_CHANNEL"),
-		TelegramBotUserID:      int(getEnvAsInt64("TELEGRAM_BOT_USER_ID")),
+		SlackToken:              getEnvOrDefault("SLACK_TOKEN", ""),
+		SlackRelayedChannel:     getEnv("SLACK_RELAYED_CHANNEL"),
+		SlackBotUserID:          getEnvOrDefault("SLACK_BOT_USER_ID", ""),
+		SlackDirection:          getEnvAsDirection("SLACK_DIRECTION"),
+		SlackInbound:            getEnvAsPipelineSpec("SLACK_INBOUND_MIDDLEWARES"),
+		SlackOutbound:           getEnvAsPipelineSpec("SLACK_OUTBOUND_MIDDLEWARES"),
+		TelegramToken:           getEnvOrDefault("TELEGRAM_TOKEN", ""),
+		TelegramRelayedChannel:  getEnvAsInt64("TELEGRAM_RELAYED_CHANNEL"),
+		TelegramBotUserID:       int(getEnvAsInt64OrDefault("TELEGRAM_BOT_USER_ID", 0)),
+		TelegramDirection:       getEnvAsDirection("TELEGRAM_DIRECTION"),
+		TelegramInbound:         getEnvAsPipelineSpec("TELEGRAM_INBOUND_MIDDLEWARES"),
+		TelegramOutbound:        getEnvAsPipelineSpec("TELEGRAM_OUTBOUND_MIDDLEWARES"),
+		SlackCoalesceBacklog:    int(getEnvAsInt64OrDefault("SLACK_COALESCE_BACKLOG", 0)),
+		TelegramCoalesceBacklog: int(getEnvAsInt64OrDefault("TELEGRAM_COALESCE_BACKLOG", 0)),
+		SlackMaxParts:           int(getEnvAsInt64OrDefault("SLACK_MAX_PARTS", 0)),
+		TelegramMaxParts:        int(getEnvAsInt64OrDefault("TELEGRAM_MAX_PARTS", 0)),
+		SlackMessageFormat:      getEnvAsOneOf("SLACK_MESSAGE_FORMAT", SlackFormatBlocks, SlackFormatAttachments),
+		PublicURL:               getEnvOrDefault("PUBLIC_URL", ""),
+		Batching:                getEnvAsBatchingSpec("BATCHING"),
+		TranscriptionCommand:    getEnvOrDefault("TRANSCRIPTION_COMMAND", ""),
+		TranscriptionModel:      getEnvOrDefault("TRANSCRIPTION_MODEL", ""),
+		TranscriptionLanguage:   getEnvOrDefault("TRANSCRIPTION_LANGUAGE", "auto"),
+		FFmpeg:                  getEnvOrDefault("FFMPEG", "ffmpeg"),
+		RelayedEvents:           getEnvAsEventsSpec("RELAYED_EVENTS", false),
+		MirroredEvents:          getEnvAsEventsSpec("MIRRORED_EVENTS", true),
+		EmailSMTPAddr:           getEnvOrDefault("EMAIL_SMTP_ADDR", ""),
+		EmailIMAPAddr:           getEnvOrDefault("EMAIL_IMAP_ADDR", ""),
+		EmailIMAPTLS:            getEnvAsBool("EMAIL_IMAP_TLS", true),
+		EmailUsername:           getEnvOrDefault("EMAIL_USERNAME", ""),
+		EmailPassword:           getEnvOrDefault("EMAIL_PASSWORD", ""),
+		EmailMailbox:            getEnvOrDefault("EMAIL_MAILBOX", "INBOX"),
+		EmailFrom:               getEnvOrDefault("EMAIL_FROM", ""),
+		EmailTo:                 getEnvOrDefault("EMAIL_TO", ""),
+		EmailDigest:             getEnvAsDuration("EMAIL_DIGEST", 0),
+		EmailDirection:          getEnvAsDirection("EMAIL_DIRECTION"),
+		EmailInbound:            getEnvAsPipelineSpec("EMAIL_INBOUND_MIDDLEWARES"),
+		EmailOutbound:           getEnvAsPipelineSpec("EMAIL_OUTBOUND_MIDDLEWARES"),
+		HealthGracePeriod:       getEnvAsDuration("HEALTH_GRACE_PERIOD", 5*time.Minute),
+		AdminToken:              getEnvOrDefault("ADMIN_TOKEN", ""),
+		BridgesFile:             getEnvOrDefault("BRIDGES_FILE", "bridges.json"),
+		LogLevel:                getEnvOrDefault("LOG_LEVEL", "info"),
+		LogFormat:               getEnvOrDefault("LOG_FORMAT", "text"),
+		LogMessageBodies:        getEnvAsBool("LOG_MESSAGE_BODIES", false),
+		TracingExporter:         getEnvAsOneOf("TRACING_EXPORTER", "", "stdout", "otlp"),
+		TracingEndpoint:         getEnvOrDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
+		TracingHeaders:          getEnvOrDefault("OTEL_EXPORTER_OTLP_HEADERS", ""),
+		TracingServiceName:      getEnvOrDefault("OTEL_SERVICE_NAME", "cable"),
+		SlackAPIURL:             getEnvOrDefault("SLACK_API_URL", ""),
+		TelegramAPIURL:          getEnvOrDefault("TELEGRAM_API_URL", ""),
+		RecordFile:              getEnvOrDefault("RECORD_FILE", ""),
+		RecordRedaction:         getEnvAsBool("RECORD_REDACTION", true),
 	}
 }
 
@@ -47,6 +201,16 @@ func getEnv(key string) string {
 	return value
 }
 
+// getEnvOrDefault is a helper function to read an optional environment
+// variable, returning defaultValue if it is missing
+func getEnvOrDefault(key string, defaultValue string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	return value
+}
+
 // getEnvAsInt64 is a helper function to read an environment variable as a int64
 // and panic if it is missing or it cannot be parsed to int64
 func getEnvAsInt64(key string) int64 {
@@ -57,3 +221,104 @@ func getEnvAsInt64(key string) int64 {
 	}
 	return value
 }
+
+// getEnvAsInt64OrDefault is a helper function to read an optional environment
+// variable as a int64, and panic if it cannot be parsed
+func getEnvAsInt64OrDefault(key string, defaultValue int64) int64 {
+	if _, exists := os.LookupEnv(key); !exists {
+		return defaultValue
+	}
+	return getEnvAsInt64(key)
+}
+
+// getEnvAsBool is a helper function to read an optional environment variable
+// as a bool, and panic if it cannot be parsed
+func getEnvAsBool(key string, defaultValue bool) bool {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		log.Panicf("ENV VAR %s=%s cannot be converted to a boolean", key, valueStr)
+	}
+	return value
+}
+
+// getEnvAsDuration is a helper function to read an optional environment
+// variable as a time.Duration (e.g. "30m"), and panic if it cannot be parsed
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	valueStr, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		log.Panicf("ENV VAR %s=%s cannot be converted to a duration", key, valueStr)
+	}
+	return value
+}
+
+// getEnvAsDirection is a helper function to read an optional environment
+// variable as a Direction, and panic if it cannot be parsed. Endpoints are
+// read-write by default.
+func getEnvAsDirection(key string) Direction {
+	valueStr := getEnvOrDefault(key, "")
+	value, err := ParseDirection(valueStr)
+	if err != nil {
+		log.Panicf("ENV VAR %s=%s is not one of read-write, read-only or write-only", key, valueStr)
+	}
+	return value
+}
+
+// getEnvAsOneOf is a helper function to read an optional environment variable
+// that has to be one of the given values, and panic if it is not. The first
+// value is the default.
+func getEnvAsOneOf(key string, values ...string) string {
+	valueStr := getEnvOrDefault(key, values[0])
+	for _, value := range values {
+		if valueStr == value {
+			return value
+		}
+	}
+	log.Panicf("ENV VAR %s=%s is not one of %s", key, valueStr, strings.Join(values, ", "))
+	return ""
+}
+
+// getEnvAsPipelineSpec is a helper function to read an optional environment
+// variable as a middleware Pipeline specification, and panic if it cannot be
+// parsed. See ParsePipeline for the syntax of the specification.
+func getEnvAsPipelineSpec(key string) string {
+	valueStr := getEnvOrDefault(key, "")
+	if _, err := ParsePipeline(valueStr); err != nil {
+		log.Panicf("ENV VAR %s=%s is not a valid pipeline: %v", key, valueStr, err)
+	}
+	return valueStr
+}
+
+// getEnvAsBatchingSpec is a helper function to read an optional environment
+// variable with a batching specification, and panic if it is not valid
+func getEnvAsBatchingSpec(key string) string {
+	valueStr := getEnvOrDefault(key, "")
+	if _, err := ParseBatching(valueStr); err != nil {
+		log.Panicf("ENV VAR %s=%s is not a valid batching: %v", key, valueStr, err)
+	}
+	return valueStr
+}
+
+// getEnvAsEventsSpec is a helper function to read an optional environment
+// variable with the kinds of events relayed, or mirrored if mirrored, and
+// panic if it is not valid
+func getEnvAsEventsSpec(key string, mirrored bool) string {
+	valueStr := getEnvOrDefault(key, "")
+	var err error
+	if mirrored {
+		_, err = ParseEventRelay("", valueStr)
+	} else {
+		_, err = ParseEventRelay(valueStr, "")
+	}
+	if err != nil {
+		log.Panicf("ENV VAR %s=%s is not valid: %v", key, valueStr, err)
+	}
+	return valueStr
+}
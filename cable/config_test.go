@@ -4,6 +4,7 @@ import (
 	. "github.com/stretchr/testify/assert"
 	"os"
 	"testing"
+	"time"
 )
 
 var oldConfig = map[string]string{
@@ -63,3 +64,122 @@ func TestNewConfig_WrongNumericKey(t *testing.T) {
 	os.Setenv("TELEGRAM_BOT_USER_ID", "NOT_AN_INTEGER")
 	NewConfig()
 }
+
+func TestNewConfig_OptionalKeys(t *testing.T) {
+	defer resetEnv()
+
+	setEnv()
+	os.Setenv("TELEGRAM_DIRECTION", "write-only")
+	os.Setenv("EMAIL_DIGEST", "30m")
+	defer os.Unsetenv("TELEGRAM_DIRECTION")
+	defer os.Unsetenv("EMAIL_DIGEST")
+
+	config := NewConfig()
+	Equal(t, ReadWrite, config.SlackDirection)
+	Equal(t, WriteOnly, config.TelegramDirection)
+	Equal(t, "INBOX", config.EmailMailbox)
+	Equal(t, true, config.EmailIMAPTLS)
+	Equal(t, "", config.EmailTo)
+	Equal(t, 30*time.Minute, config.EmailDigest)
+	Equal(t, "info", config.LogLevel)
+	Equal(t, "text", config.LogFormat)
+	Equal(t, false, config.LogMessageBodies)
+	Equal(t, "", config.RecordFile)
+	Equal(t, true, config.RecordRedaction)
+	Equal(t, 0, config.SlackCoalesceBacklog)
+	Equal(t, 0, config.TelegramCoalesceBacklog)
+	Equal(t, 0, config.SlackMaxParts)
+	Equal(t, 0, config.TelegramMaxParts)
+	Equal(t, SlackFormatBlocks, config.SlackMessageFormat)
+	Equal(t, "", config.PublicURL)
+	Equal(t, "", config.Batching)
+	Equal(t, "", config.TranscriptionCommand)
+	Equal(t, "auto", config.TranscriptionLanguage)
+	Equal(t, "ffmpeg", config.FFmpeg)
+	Equal(t, "", config.RelayedEvents)
+	Equal(t, "", config.MirroredEvents)
+}
+
+func TestNewConfig_BotIDsDiscovered(t *testing.T) {
+	defer resetEnv()
+
+	setEnv()
+	os.Unsetenv("SLACK_BOT_USER_ID")
+	os.Unsetenv("TELEGRAM_BOT_USER_ID")
+
+	config := NewConfig()
+	Equal(t, "", config.SlackBotUserID)
+	Equal(t, 0, config.TelegramBotUserID)
+}
+
+func TestNewBridgeConfig_NoTokens(t *testing.T) {
+	defer resetEnv()
+
+	setEnv()
+	os.Unsetenv("PORT")
+	os.Unsetenv("SLACK_TOKEN")
+	os.Unsetenv("TELEGRAM_TOKEN")
+
+	config := NewBridgeConfig()
+	Equal(t, "CLMKRRQRM", config.SlackRelayedChannel)
+	Equal(t, int64(-3764886), config.TelegramRelayedChannel)
+	Equal(t, "", config.SlackToken)
+	Equal(t, "", config.TelegramToken)
+	Panics(t, func() { NewConfig() }, "serving needs the tokens")
+}
+
+func TestNewConfig_WrongDirection(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			Fail(t, "NewConfig() did not panicked when a direction is unknown")
+		}
+		os.Unsetenv("SLACK_DIRECTION")
+		resetEnv()
+	}()
+
+	setEnv()
+	os.Setenv("SLACK_DIRECTION", "sideways")
+	NewConfig()
+}
+
+func TestNewConfig_WrongPipeline(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			Fail(t, "NewConfig() did not panicked when a pipeline cannot be parsed")
+		}
+		os.Unsetenv("SLACK_INBOUND_MIDDLEWARES")
+		resetEnv()
+	}()
+
+	setEnv()
+	os.Setenv("SLACK_INBOUND_MIDDLEWARES", "drop-bots;unknown")
+	NewConfig()
+}
+
+func TestNewConfig_WrongBatching(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			Fail(t, "NewConfig() did not panicked when a batching cannot be parsed")
+		}
+		os.Unsetenv("BATCHING")
+		resetEnv()
+	}()
+
+	setEnv()
+	os.Setenv("BATCHING", "author:forever")
+	NewConfig()
+}
+
+func TestNewConfig_WrongMirroredEvents(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			Fail(t, "NewConfig() did not panicked when a kind of event cannot be mirrored")
+		}
+		os.Unsetenv("MIRRORED_EVENTS")
+		resetEnv()
+	}()
+
+	setEnv()
+	os.Setenv("MIRRORED_EVENTS", "pin,join")
+	NewConfig()
+}
@@ -0,0 +1,73 @@
+package cable
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultDeadLetterCapacity is the number of dead letters kept by a pump.
+// When full, the oldest dead letters are discarded to make room for new ones.
+const DefaultDeadLetterCapacity = 100
+
+// DeadLetter is a message a pumper failed to write
+type DeadLetter struct {
+	// Message is the message that could not be written
+	Message Message
+	// Error describes why the message could not be written
+	Error string
+	// FailedAt is the time writing the message failed
+	FailedAt time.Time
+}
+
+// DeadLetterQueue keeps the last messages a pumper failed to write, so they
+// can be inspected and written again later. It is safe for concurrent use.
+type DeadLetterQueue struct {
+	mutex    sync.Mutex
+	capacity int
+	letters  []DeadLetter
+}
+
+// NewDeadLetterQueue returns the address of a new DeadLetterQueue keeping up
+// to capacity dead letters
+func NewDeadLetterQueue(capacity int) *DeadLetterQueue {
+	return &DeadLetterQueue{capacity: capacity}
+}
+
+// Add records that writing the message failed because of err
+func (q *DeadLetterQueue) Add(m Message, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.letters = append(q.letters, DeadLetter{Message: m, Error: err.Error(), FailedAt: time.Now()})
+	if len(q.letters) > q.capacity {
+		q.letters = q.letters[len(q.letters)-q.capacity:]
+	}
+}
+
+// List returns the dead letters in the queue, oldest first
+func (q *DeadLetterQueue) List() []DeadLetter {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return append([]DeadLetter(nil), q.letters...)
+}
+
+// Drain empties the queue, returning the dead letters it had, oldest first
+func (q *DeadLetterQueue) Drain() []DeadLetter {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	letters := q.letters
+	q.letters = nil
+	return letters
+}
+
+// Len returns the number of dead letters in the queue
+func (q *DeadLetterQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.letters)
+}
+
+// DeadLetterReporter is implemented by the pumpers keeping the messages they
+// failed to write, like the ones embedding a Pump
+type DeadLetterReporter interface {
+	DeadLetters() *DeadLetterQueue
+}
@@ -0,0 +1,23 @@
+package cable
+
+import (
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDeadLetterQueue(t *testing.T) {
+	queue := NewDeadLetterQueue(2)
+	queue.Add(&fakeMessage{text: "first"}, errors.New("timeout"))
+	queue.Add(&fakeMessage{text: "second"}, errors.New("timeout"))
+	queue.Add(&fakeMessage{text: "third"}, errors.New("channel_not_found"))
+
+	letters := queue.List()
+	Equal(t, 2, len(letters), "the oldest dead letters are discarded when full")
+	Equal(t, "second", letters[0].Message.String())
+	Equal(t, "third", letters[1].Message.String())
+	Equal(t, "channel_not_found", letters[1].Error)
+
+	Equal(t, 2, len(queue.Drain()))
+	Equal(t, 0, queue.Len())
+}
@@ -0,0 +1,112 @@
+package cable
+
+import (
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable/tracing"
+	"github.com/nlopes/slack"
+	"time"
+)
+
+/* Section: relaying the deletion of messages */
+
+// Deletion tells a message read before was deleted, so the messages written
+// for it are deleted too. It has the ID of the message deleted.
+type Deletion struct {
+	// Platform is the platform the message was deleted in, like "slack"
+	Platform string
+	// Actor is the name of the person who deleted the message, if known
+	Actor string
+	// Deleted is the ID of the message deleted
+	Deleted string
+	// Time is the time the message was deleted
+	Time time.Time
+	// span traces the relay of the deletion
+	span *tracing.Span
+}
+
+// ToSlack converts the deletion into a plain slack message with its notice,
+// as it is not written as a message
+func (d Deletion) ToSlack() ([]slack.MsgOption, error) {
+	return []slack.MsgOption{slack.MsgOptionText(d.String(), false)}, nil
+}
+
+// ToTelegram converts the deletion into a plain telegram message with its
+// notice, as it is not written as a message
+func (d Deletion) ToTelegram(telegramChatID int64) (telegram.MessageConfig, error) {
+	return telegram.MessageConfig{
+		BaseChat: telegram.BaseChat{
+			ChatID: telegramChatID,
+		},
+		Text: d.String(),
+	}, nil
+}
+
+// String returns the notice of the deletion, like "🗑 Will Smith deleted a
+// message on slack"
+func (d Deletion) String() string {
+	if d.Actor == "" {
+		return fmt.Sprintf("🗑 A message was deleted on %s", d.Platform)
+	}
+	return fmt.Sprintf("🗑 %s deleted a message on %s", d.Actor, d.Platform)
+}
+
+// Content returns no text, as the message deleted is gone
+func (d Deletion) Content() string {
+	return ""
+}
+
+// WithContent returns a copy of the deletion, which has no text to replace
+func (d Deletion) WithContent(content string) Message {
+	return &d
+}
+
+// Author returns the name of the person who deleted the message
+func (d Deletion) Author() string {
+	return d.Actor
+}
+
+// FromBot returns false, as the messages bots delete are not read
+func (d Deletion) FromBot() bool {
+	return false
+}
+
+// SentAt returns the time the message was deleted
+func (d Deletion) SentAt() time.Time {
+	return d.Time
+}
+
+// ID returns the ID of the message deleted
+func (d Deletion) ID() string {
+	return d.Deleted
+}
+
+// Span returns the span tracing the relay of the deletion
+func (d Deletion) Span() *tracing.Span {
+	return d.span
+}
+
+// WithSpan returns a copy of the deletion carrying the given span
+func (d Deletion) WithSpan(span *tracing.Span) Message {
+	d.span = span
+	return &d
+}
+
+// NewDeletion returns the address of a new Deletion of the message with the
+// given ID, which happened in the given platform, starting the trace of its
+// relay
+func NewDeletion(platform string, actor string, deleted string) *Deletion {
+	return &Deletion{
+		Platform: platform,
+		Actor:    actor,
+		Deleted:  deleted,
+		Time:     time.Now(),
+		span:     tracing.StartTrace("relay").SetAttribute("platform", platform).SetAttribute("deleted", deleted),
+	}
+}
+
+// DeletionOf returns the deletion m is, if it is one
+func DeletionOf(m Message) (*Deletion, bool) {
+	deletion, ok := m.(*Deletion)
+	return deletion, ok
+}
@@ -0,0 +1,721 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/metrics"
+	s "github.com/miguelff/cable/cable/slack"
+	t "github.com/miguelff/cable/cable/telegram"
+	"github.com/miguelff/cable/cable/tracing"
+	"github.com/nlopes/slack"
+	log "github.com/sirupsen/logrus"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// idleTimeout is the time the IMAP connection is kept idle before
+	// re-issuing the IDLE command. RFC 2177 recommends doing it at least every
+	// 29 minutes to avoid being logged off by the server.
+	idleTimeout = 29 * time.Minute
+	// reconnectDelay is the time to wait before connecting again to the IMAP
+	// server after the connection failed.
+	reconnectDelay = 10 * time.Second
+	// maxThreads is the number of sent emails whose Message-ID is remembered
+	// to thread replies back to the chat message they originated from.
+	maxThreads = 1000
+	// subjectPrefix is prepended to the subject of every email sent
+	subjectPrefix = "[cable]"
+	// maxSubjectLength is the number of characters of a chat message used as
+	// the subject of the email relaying it
+	maxSubjectLength = 60
+)
+
+// replyHeaderRegexp matches the line mail clients write before the quoted text
+// of a reply, like "On Mon, 1 Jul 2019 at 10:00, Will <will@example.com> wrote:"
+var replyHeaderRegexp = regexp.MustCompile(`^On .* wrote:$`)
+
+/* Section: Email API interface and its SMTP/IMAP adapter */
+
+// API lets us replace the SMTP and IMAP servers with something that behaves
+// like them. This is used to improve testability
+type API interface {
+	// IncomingMessages returns the channel of emails arriving at the watched
+	// mailbox
+	IncomingMessages() <-chan *mail.Message
+	// SendMail delivers msg, a RFC 5322 formatted email, to the given
+	// recipients
+	SendMail(from string, to []string, msg []byte) error
+}
+
+// APIAdapter implements the API interface by sending emails through a SMTP
+// server and reading them from an IMAP mailbox
+type APIAdapter struct {
+	// SMTPAddr is the host:port of the SMTP server used to send emails
+	SMTPAddr string
+	// IMAPAddr is the host:port of the IMAP server used to read emails
+	IMAPAddr string
+	// IMAPTLS tells whether to connect to the IMAP server over TLS
+	IMAPTLS bool
+	// Username and Password are the credentials used to authenticate in both
+	// the SMTP and the IMAP servers
+	Username string
+	Password string
+	// Mailbox is the IMAP mailbox watched for replies
+	Mailbox string
+	// incoming is the channel of emails read from the mailbox
+	incoming chan *mail.Message
+	// incomingOnce guards the lazy start of the mailbox watcher
+	incomingOnce sync.Once
+	// connected tells whether the watcher connected to the IMAP server before
+	connected bool
+	// Health, when set, is kept up to date with the state of the connection
+	// to the IMAP server
+	Health *cable.HealthTracker
+}
+
+// IncomingMessages returns the channel of emails arriving at the mailbox.
+//
+// When called for the first time, it lazily spawns a goroutine that keeps a
+// connection to the IMAP server, waiting for new messages using IDLE.
+func (adapter *APIAdapter) IncomingMessages() <-chan *mail.Message {
+	adapter.incomingOnce.Do(func() {
+		adapter.incoming = make(chan *mail.Message)
+		go adapter.watchMailbox()
+	})
+	return adapter.incoming
+}
+
+// SendMail sends the message through the SMTP server, authenticating with
+// the adapter credentials if any
+func (adapter *APIAdapter) SendMail(from string, to []string, msg []byte) error {
+	var auth smtp.Auth
+	if adapter.Username != "" {
+		host, _, _ := net.SplitHostPort(adapter.SMTPAddr)
+		auth = smtp.PlainAuth("", adapter.Username, adapter.Password, host)
+	}
+	start := time.Now()
+	defer func() {
+		metrics.APICallDuration.Observe(time.Since(start).Seconds(), "email", "smtp")
+	}()
+	return smtp.SendMail(adapter.SMTPAddr, auth, from, to, msg)
+}
+
+// watchMailbox feeds the incoming channel with the unseen messages of the
+// mailbox, reconnecting to the server whenever the connection fails
+func (adapter *APIAdapter) watchMailbox() {
+	for {
+		if err := adapter.watchMailboxOnce(); err != nil {
+			log.WithField("platform", "email").WithError(err).Error("Email error watching mailbox")
+			if adapter.Health != nil {
+				adapter.Health.RecordReadError(err)
+				adapter.Health.SetDisconnected(err)
+			}
+		}
+		time.Sleep(reconnectDelay)
+	}
+}
+
+// watchMailboxOnce connects to the IMAP server and feeds the incoming channel
+// until the connection fails
+func (adapter *APIAdapter) watchMailboxOnce() error {
+	client, err := dialIMAP(adapter.IMAPAddr, adapter.IMAPTLS)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if adapter.connected {
+		metrics.Reconnects.Inc("email")
+	}
+	adapter.connected = true
+
+	if err := client.Login(adapter.Username, adapter.Password); err != nil {
+		return err
+	}
+	if err := client.Select(adapter.Mailbox); err != nil {
+		return err
+	}
+	if adapter.Health != nil {
+		adapter.Health.SetConnected()
+	}
+
+	for {
+		uids, err := client.SearchUnseen()
+		if err != nil {
+			return err
+		}
+		for _, uid := range uids {
+			msg, err := client.Fetch(uid)
+			if err != nil {
+				return err
+			}
+			adapter.incoming <- msg
+		}
+		if err := client.Idle(idleTimeout); err != nil {
+			return err
+		}
+	}
+}
+
+/* Section: Email type implementing GoRead() and GoWrite() */
+
+// Options configures the servers, credentials and addresses used by Email
+type Options struct {
+	// SMTPAddr is the host:port of the SMTP server used to send emails
+	SMTPAddr string
+	// IMAPAddr is the host:port of the IMAP server used to read replies
+	IMAPAddr string
+	// IMAPTLS tells whether to connect to the IMAP server over TLS
+	IMAPTLS bool
+	// Username and Password are the credentials of the SMTP and IMAP servers
+	Username string
+	Password string
+	// Mailbox is the IMAP mailbox watched for replies. Defaults to INBOX
+	Mailbox string
+	// From is the address emails are sent from. Emails read from this address
+	// are discarded, as they are looped back by cable itself
+	From string
+	// To is the address (usually a mailing list) emails are sent to
+	To string
+	// Digest is the interval used to batch messages into a single email. When
+	// zero, every message is sent as soon as it arrives.
+	Digest time.Duration
+}
+
+// Email adapts a mailing list creating a Pump of messages. Messages arriving
+// at the outbox are sent to the list, and replies to them are read from an
+// IMAP mailbox and threaded back to the chat message they answer
+type Email struct {
+	// Pump is the pair of InboxCh and OutboxCh channel to receive
+	// messages from and write messages to the mailing list
+	*cable.Pump
+	// client is the email API client
+	client API
+	// from is the address emails are sent from
+	from mail.Address
+	// to is the address emails are sent to
+	to string
+	// digestInterval is the interval used to batch messages into a single
+	// email, or zero if messages are not batched
+	digestInterval time.Duration
+	// threads remembers the chat messages relayed by each email sent
+	threads *threadIndex
+}
+
+// NewEmail returns the address of a new value of Email
+func NewEmail(options Options) *Email {
+	mailbox := options.Mailbox
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+
+	pump := cable.NewPump()
+	return &Email{
+		Pump: pump,
+		client: &APIAdapter{
+			SMTPAddr: options.SMTPAddr,
+			IMAPAddr: options.IMAPAddr,
+			IMAPTLS:  options.IMAPTLS,
+			Username: options.Username,
+			Password: options.Password,
+			Mailbox:  mailbox,
+			Health:   pump.HealthTracker,
+		},
+		from:           mail.Address{Name: "cable", Address: options.From},
+		to:             options.To,
+		digestInterval: options.Digest,
+		threads:        newThreadIndex(maxThreads),
+	}
+}
+
+// GoRead makes email listen for messages in a different goroutine.
+// Those messages will be pushed to the InboxCh of the Pump.
+//
+// The goroutine can be stopped by feeding ReadStopper synchronization channel
+// which can be done by calling StopRead() - a method coming from Pump and
+// which is accessed directly through the Email value.
+func (e *Email) GoRead() {
+	e.SetConnecting()
+	go func() {
+		for {
+			select {
+			case raw := <-e.client.IncomingMessages():
+				msg, err := e.parse(raw)
+				if err != nil {
+					log.WithField("platform", "email").WithError(err).Error("Email error reading message")
+					e.RecordReadError(err)
+					continue
+				}
+				if strings.EqualFold(msg.From.Address, e.from.Address) {
+					continue
+				}
+				metrics.MessagesRead.Inc("email")
+				e.RecordRead()
+				msg.span = tracing.StartTrace("relay").SetAttribute("platform", "email")
+				e.Inbox() <- msg
+			case <-e.ReadStopper:
+				return
+			}
+		}
+	}()
+}
+
+// GoWrite spawns a goroutine that takes care of sending the messages arriving
+// at the OutboxCh of the Pump, either one by one or batched in digests.
+//
+// The goroutine can be stopped by feeding WriteStopper synchronization channel
+// which can be done by calling StopWrite() - a method coming from Pump and
+// which is accessed directly through the Email value. Pending messages of the
+// current digest are sent before stopping.
+func (e *Email) GoWrite() {
+	go func() {
+		var pending []cable.Message
+		var flush <-chan time.Time
+		if e.digestInterval > 0 {
+			ticker := time.NewTicker(e.digestInterval)
+			defer ticker.Stop()
+			flush = ticker.C
+		}
+
+		for {
+			select {
+			case m := <-e.Outbox():
+				deliver := cable.SpanOf(m)
+				deliver.ChildSince("outbox", deliver.StartTime()).End()
+				if e.digestInterval > 0 {
+					pending = append(pending, m)
+					continue
+				}
+				e.send(m)
+			case <-flush:
+				if len(pending) > 0 {
+					e.send(pending...)
+					pending = nil
+				}
+			case <-e.WriteStopper:
+				if len(pending) > 0 {
+					e.send(pending...)
+				}
+				return
+			}
+		}
+	}()
+}
+
+// send composes a single email relaying the given messages and sends it to
+// the list, remembering its Message-ID to thread replies
+func (e *Email) send(messages ...cable.Message) {
+	messageID := e.newMessageID()
+	start := time.Now()
+	err := e.client.SendMail(e.from.Address, []string{e.to}, e.compose(messageID, messages))
+	for _, m := range messages {
+		deliver := cable.SpanOf(m)
+		deliver.ChildSince("email.sendmail", start).SetAttribute("messages", len(messages)).EndWithError(err)
+		deliver.EndWithError(err)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"platform": "email", "messages": len(messages)}).WithError(err).Error("Email error writing message")
+		metrics.MessagesFailed.Add(float64(len(messages)), "email")
+		e.RecordWriteError(err)
+		for _, m := range messages {
+			e.DeadLetters().Add(m, err)
+		}
+		return
+	}
+	e.RecordWrite()
+	e.threads.add(messageID, messages...)
+	for _, m := range messages {
+		metrics.ObserveDeliveryLatency("email", m.SentAt())
+		log.WithFields(cable.MessageFields(m)).WithFields(log.Fields{"platform": "email", "email_id": messageID}).Debug("Message written")
+	}
+}
+
+// compose returns the RFC 5322 representation of an email relaying the given
+// messages
+func (e *Email) compose(messageID string, messages []cable.Message) []byte {
+	var subject string
+	var texts []string
+	for _, m := range messages {
+		texts = append(texts, m.String())
+	}
+	if len(messages) == 1 {
+		subject = fmt.Sprintf("%s %s", subjectPrefix, summarize(texts[0]))
+	} else {
+		subject = fmt.Sprintf("%s Digest of %d messages", subjectPrefix, len(messages))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", e.from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", e.to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", messageID)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprint(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	body := quotedprintable.NewWriter(&buf)
+	_, _ = body.Write([]byte(strings.Join(texts, "\r\n\r\n")))
+	_ = body.Close()
+
+	return buf.Bytes()
+}
+
+// newMessageID generates a unique Message-ID in the domain of the sender
+// address
+func (e *Email) newMessageID() string {
+	domain := "cable"
+	if at := strings.LastIndex(e.from.Address, "@"); at >= 0 {
+		domain = e.from.Address[at+1:]
+	}
+
+	random := make([]byte, 8)
+	_, _ = rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
+
+// parse converts an email read from the mailbox into a Message, looking up
+// the chat message it replies to
+func (e *Email) parse(raw *mail.Message) (*Message, error) {
+	from, err := mail.ParseAddress(raw.Header.Get("From"))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := readBody(raw.Header, raw.Body)
+	if err != nil {
+		return nil, err
+	}
+	text, quoted := stripQuotedText(body), quotedText(body)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(raw.Header.Get("Subject"))
+	if err != nil {
+		subject = raw.Header.Get("Subject")
+	}
+
+	references := strings.Fields(raw.Header.Get("References"))
+	references = append(references, strings.TrimSpace(raw.Header.Get("In-Reply-To")))
+
+	var original cable.Message
+	for i := len(references) - 1; i >= 0 && original == nil; i-- {
+		original = e.threads.get(references[i], quoted)
+	}
+
+	date, _ := raw.Header.Date()
+
+	return &Message{
+		From:      from,
+		Subject:   subject,
+		Date:      date,
+		Text:      text,
+		InReplyTo: original,
+	}, nil
+}
+
+/* Section: thread index */
+
+// threadIndex is a bounded index of the chat messages relayed by each email
+// sent, which are several for digests, keyed by the email Message-ID. When
+// full, the oldest entries are evicted first.
+type threadIndex struct {
+	mutex    sync.Mutex
+	capacity int
+	order    []string
+	messages map[string][]cable.Message
+}
+
+// newThreadIndex returns the address of a new threadIndex with the given
+// capacity
+func newThreadIndex(capacity int) *threadIndex {
+	return &threadIndex{
+		capacity: capacity,
+		messages: make(map[string][]cable.Message),
+	}
+}
+
+// add indexes the messages relayed by the email with the given Message-ID
+func (ti *threadIndex) add(messageID string, messages ...cable.Message) {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+
+	if len(ti.order) == ti.capacity {
+		delete(ti.messages, ti.order[0])
+		ti.order = ti.order[1:]
+	}
+	ti.order = append(ti.order, messageID)
+	ti.messages[messageID] = messages
+}
+
+// get returns the message relayed by the email with the given Message-ID, or
+// nil if it is unknown. Replies to digests reply to the last message of the
+// digest they quote, or to the last message of the digest if they quote none.
+func (ti *threadIndex) get(messageID string, quoted string) cable.Message {
+	ti.mutex.Lock()
+	defer ti.mutex.Unlock()
+	messages := ti.messages[messageID]
+	if len(messages) == 0 {
+		return nil
+	}
+	quoted = normalizeSpace(quoted)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if text := normalizeSpace(messages[i].String()); text != "" && strings.Contains(quoted, text) {
+			return messages[i]
+		}
+	}
+	return messages[len(messages)-1]
+}
+
+// normalizeSpace collapses the whitespace of a text, which mail clients
+// rewrap when quoting it
+func normalizeSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+/* Section: Email message */
+
+// Message is an email read from the mailbox, and implements the Message
+// interface
+type Message struct {
+	// From is the sender of the email
+	From *mail.Address
+	// Subject is the decoded subject of the email
+	Subject string
+	// Date is the time the email was sent, or the zero time if unknown
+	Date time.Time
+	// Text is the plain text body of the email, without the quoted text of
+	// the message it replies to
+	Text string
+	// InReplyTo is the chat message the email replies to, or nil if it does
+	// not reply to any message relayed by cable
+	InReplyTo cable.Message
+	span      *tracing.Span
+}
+
+// ToSlack converts a received email into a proper representation in slack. If
+// the email replies to a slack message, it is posted in its thread.
+func (em Message) ToSlack() ([]slack.MsgOption, error) {
+	attachment := slack.Attachment{
+		Fallback:   em.Text,
+		AuthorName: em.authorName(),
+		Text:       em.Text,
+	}
+
+	options := []slack.MsgOption{slack.MsgOptionAttachments(attachment)}
+	if ev := slackEvent(em.InReplyTo); ev != nil {
+		threadTimestamp := ev.ThreadTimestamp
+		if threadTimestamp == "" {
+			threadTimestamp = ev.Timestamp
+		}
+		options = append(options, slack.MsgOptionTS(threadTimestamp))
+	}
+	return options, nil
+}
+
+// ToTelegram converts a received email into a proper representation in
+// telegram, escaping its text, which is not written in any markup. If the
+// email replies to a telegram message, it is sent as a reply to it.
+func (em Message) ToTelegram(telegramChatID int64) (telegram.MessageConfig, error) {
+	replyToMessageID := 0
+	if update := telegramUpdate(em.InReplyTo); update != nil && update.Message != nil {
+		replyToMessageID = update.Message.MessageID
+	}
+
+	return telegram.MessageConfig{
+		BaseChat: telegram.BaseChat{
+			ChatID:           telegramChatID,
+			ReplyToMessageID: replyToMessageID,
+		},
+		Text:                  fmt.Sprintf("<b>%s:</b> %s", html.EscapeString(em.authorName()), html.EscapeString(em.Text)),
+		DisableWebPagePreview: false,
+		ParseMode:             telegram.ModeHTML,
+	}, nil
+}
+
+// String returns a human readable representation of an email for debugging
+// purposes
+func (em Message) String() string {
+	return fmt.Sprintf("%s: %s", em.authorName(), em.Text)
+}
+
+// Content returns the text of the email, without the quoted text
+func (em Message) Content() string {
+	return em.Text
+}
+
+// WithContent returns a copy of the email with the given text
+func (em Message) WithContent(content string) cable.Message {
+	em.Text = content
+	return &em
+}
+
+// Span returns the span carried by the email
+func (em Message) Span() *tracing.Span {
+	return em.span
+}
+
+// WithSpan returns a copy of the email carrying the given span
+func (em Message) WithSpan(span *tracing.Span) cable.Message {
+	em.span = span
+	return &em
+}
+
+// Author returns the address of the sender of the email
+func (em Message) Author() string {
+	return em.From.Address
+}
+
+// FromBot returns false, as there is no reliable way of telling whether an
+// email was sent by a bot
+func (em Message) FromBot() bool {
+	return false
+}
+
+// SentAt returns the date of the email
+func (em Message) SentAt() time.Time {
+	return em.Date
+}
+
+// authorName returns the name and address of the sender of the email
+func (em Message) authorName() string {
+	if em.From.Name == "" {
+		return em.From.Address
+	}
+	return fmt.Sprintf("%s (%s)", em.From.Name, em.From.Address)
+}
+
+// slackEvent returns the slack event wrapped by m, or nil if m is not a
+// slack message
+func slackEvent(m cable.Message) *slack.MessageEvent {
+	switch original := m.(type) {
+	case *s.Message:
+		return original.MessageEvent
+	case s.Message:
+		return original.MessageEvent
+	}
+	return nil
+}
+
+// telegramUpdate returns the telegram update wrapped by m, or nil if m is not
+// a telegram message
+func telegramUpdate(m cable.Message) *telegram.Update {
+	switch original := m.(type) {
+	case *t.Message:
+		return &original.Update
+	case t.Message:
+		return &original.Update
+	}
+	return nil
+}
+
+/* Section: helpers */
+
+// headerGetter is implemented by both mail.Header and the header of the parts
+// of a multipart message
+type headerGetter interface {
+	Get(key string) string
+}
+
+// readBody returns the plain text content of an email body, decoding it
+func readBody(header headerGetter, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := parts.NextPart()
+			if err == io.EOF {
+				return "", fmt.Errorf("no text/plain part found")
+			}
+			if err != nil {
+				return "", err
+			}
+			text, err := readBody(part.Header, part)
+			if err == nil {
+				return text, nil
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", fmt.Errorf("unsupported content type %s", mediaType)
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// stripQuotedText removes from the body of a reply the text quoted from the
+// original email, and the signature of the sender
+func stripQuotedText(body string) string {
+	var lines []string
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \r")
+		if line == "--" || replyHeaderRegexp.MatchString(line) || strings.HasPrefix(line, "-----Original Message-----") {
+			break
+		}
+		if strings.HasPrefix(line, ">") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// quotedText returns the text a reply quotes from the original email, without
+// the quote markers
+func quotedText(body string) string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), " \r"); strings.HasPrefix(line, ">") {
+			lines = append(lines, strings.TrimLeft(line, "> "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// summarize returns the first line of text, truncated to be used as the
+// subject of an email
+func summarize(text string) string {
+	if newline := strings.Index(text, "\n"); newline >= 0 {
+		text = text[:newline]
+	}
+	if runes := []rune(text); len(runes) > maxSubjectLength {
+		text = string(runes[:maxSubjectLength-1]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,215 @@
+package email
+
+import (
+	"encoding/json"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	slackAPI "github.com/nlopes/slack"
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestEmail(client API, digest time.Duration) *Email {
+	return &Email{
+		Pump:           cable.NewPump(),
+		client:         client,
+		from:           mail.Address{Name: "cable", Address: cableAddress},
+		to:             listAddress,
+		digestInterval: digest,
+		threads:        newThreadIndex(maxThreads),
+	}
+}
+
+func TestEmail_GoRead(t *testing.T) {
+	incoming := make(chan *mail.Message, 3)
+	fakeEmail := newTestEmail(&fakeEmailAPI{incoming: incoming}, 0)
+	original := createSlackMessage("Sup Jay!", "1561975000.000100")
+	fakeEmail.threads.add("<1@example.com>", original)
+
+	incoming <- createRawEmail(cableAddress, "", "Hey Hey!")                                                                // discarded, because sent by cable itself
+	incoming <- createRawEmail("Will Smith <"+userAddress+">", "<1@example.com>", "Yo!\r\n\r\nOn Mon, Jay wrote:\r\n> Sup") // selected, replying to the slack message
+	incoming <- createRawEmail(userAddress, "<unknown@example.com>", "Uncle Phil?")                                         // selected, not replying to any message
+
+	fakeEmail.GoRead()
+
+	first := (<-fakeEmail.Inbox()).(*Message)
+	Equal(t, "Will Smith (will@example.com): Yo!", first.String())
+	Equal(t, original, first.InReplyTo)
+
+	second := (<-fakeEmail.Inbox()).(*Message)
+	Equal(t, "will@example.com: Uncle Phil?", second.String())
+	Nil(t, second.InReplyTo)
+
+	fakeEmail.StopRead()
+	Equal(t, 0, len(fakeEmail.Inbox()))
+}
+
+func TestEmail_GoRead_DigestReplies(t *testing.T) {
+	incoming := make(chan *mail.Message, 2)
+	fakeEmail := newTestEmail(&fakeEmailAPI{incoming: incoming}, 0)
+	jay := createSlackMessage("Sup Jay!", "1561975000.000100")
+	will := createTelegramMessage("Sup Will!", 42)
+	fakeEmail.threads.add("<digest@example.com>", jay, will)
+
+	incoming <- createRawEmail(userAddress, "<digest@example.com>", "> freshprince: Sup Jay!\r\n\r\nYo!")
+	incoming <- createRawEmail(userAddress, "<digest@example.com>", "Hey!")
+	fakeEmail.GoRead()
+	defer fakeEmail.StopRead()
+
+	Equal(t, jay, (<-fakeEmail.Inbox()).(*Message).InReplyTo, "replies to digests reply to the message they quote")
+	Equal(t, will, (<-fakeEmail.Inbox()).(*Message).InReplyTo)
+}
+
+func TestEmail_GoWrite(t *testing.T) {
+	client := &fakeEmailAPI{}
+	fakeEmail := newTestEmail(client, 0)
+
+	fakeEmail.Outbox() <- createSlackMessage("Sup Jay!", "1561975000.000100")
+	fakeEmail.GoWrite()
+
+	// wait for the pump to process the channel up to 1 second, or timeout
+	timeout := time.NewTimer(1 * time.Second)
+
+WAIT:
+	for {
+		select {
+		case <-timeout.C:
+			Fail(t, "timeout while processing the Write Pump")
+			break WAIT
+		default:
+			if len(fakeEmail.Outbox()) == 0 {
+				break WAIT
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	fakeEmail.StopWrite()
+	deadline := time.Now().Add(time.Second)
+	for len(client.Sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Equal(t, 1, len(client.Sent()))
+	sent := client.Sent()[0]
+	Equal(t, cableAddress, sent.from)
+	Equal(t, []string{listAddress}, sent.to)
+	Equal(t, "[cable] freshprince: Sup Jay!", sent.msg.Header.Get("Subject"))
+	body, _ := readBody(sent.msg.Header, sent.msg.Body)
+	Equal(t, "freshprince: Sup Jay!", stripQuotedText(body))
+
+	messageID := sent.msg.Header.Get("Message-ID")
+	True(t, strings.HasSuffix(messageID, "@example.com>"))
+	Equal(t, "freshprince: Sup Jay!", fakeEmail.threads.get(messageID, "").String())
+}
+
+func TestEmail_GoWrite_Digest(t *testing.T) {
+	client := &fakeEmailAPI{}
+	fakeEmail := newTestEmail(client, time.Hour)
+
+	fakeEmail.Outbox() <- createSlackMessage("Sup Jay!", "1561975000.000100")
+	fakeEmail.Outbox() <- createTelegramMessage("Sup Will!", 42)
+	fakeEmail.GoWrite()
+
+	for len(fakeEmail.Outbox()) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the digest is not sent until the interval elapses or the pump is stopped
+	Equal(t, 0, len(client.Sent()))
+	fakeEmail.StopWrite()
+
+	timeout := time.Now().Add(1 * time.Second)
+	for len(client.Sent()) == 0 && time.Now().Before(timeout) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	Equal(t, 1, len(client.Sent()))
+	sent := client.Sent()[0]
+	Equal(t, "[cable] Digest of 2 messages", sent.msg.Header.Get("Subject"))
+	body, _ := readBody(sent.msg.Header, sent.msg.Body)
+	Equal(t, "freshprince: Sup Jay!\n\nJazz: Sup Will!", stripQuotedText(body))
+	digestID := sent.msg.Header.Get("Message-ID")
+	Equal(t, "Jazz: Sup Will!", fakeEmail.threads.get(digestID, "").String(), "replies quoting nothing reply to the last message")
+	Equal(t, "freshprince: Sup Jay!", fakeEmail.threads.get(digestID, "freshprince: Sup\nJay!").String(), "every message of a digest is threaded")
+}
+
+func TestAPIAdapter_SendMail(t *testing.T) {
+	server := newFakeSMTPServer()
+	defer server.listener.Close()
+
+	adapter := &APIAdapter{SMTPAddr: server.listener.Addr().String()}
+	err := adapter.SendMail(cableAddress, []string{listAddress}, []byte("Subject: hi\r\n\r\nSup Jay!\r\n"))
+	Nil(t, err)
+
+	Equal(t, 1, len(server.received))
+	Equal(t, "Subject: hi\r\n\r\nSup Jay!\r\n", server.received[0])
+}
+
+func TestAPIAdapter_IncomingMessages(t *testing.T) {
+	server := newFakeIMAPServer("Subject: first\r\n\r\nSup Jay!")
+	defer server.listener.Close()
+
+	adapter := &APIAdapter{IMAPAddr: server.listener.Addr().String(), Mailbox: "INBOX"}
+
+	first := <-adapter.IncomingMessages()
+	Equal(t, "first", first.Header.Get("Subject"))
+
+	server.append("Subject: second\r\n\r\nSup Will!")
+	second := <-adapter.IncomingMessages()
+	Equal(t, "second", second.Header.Get("Subject"))
+	body, _ := ioutil.ReadAll(second.Body)
+	Equal(t, "Sup Will!", string(body))
+}
+
+func TestEmailMessage_ToSlack(t *testing.T) {
+	msg := Message{
+		From:      &mail.Address{Name: "Will Smith", Address: userAddress},
+		Text:      "Yo!",
+		InReplyTo: createSlackMessage("Sup Jay!", "1561975000.000100"),
+	}
+
+	options, _ := msg.ToSlack()
+	_, values, _ := slackAPI.UnsafeApplyMsgOptions("SAMPLE_TOKEN", "SAMPLE_CHANNEL", options...)
+	Equal(t, "1561975000.000100", values.Get("thread_ts"))
+
+	var attachments []slackAPI.Attachment
+	_ = json.Unmarshal([]byte(values.Get("attachments")), &attachments)
+	Equal(t, "Will Smith (will@example.com)", attachments[0].AuthorName)
+	Equal(t, "Yo!", attachments[0].Text)
+}
+
+func TestEmailMessage_ToTelegram(t *testing.T) {
+	msg := Message{
+		From:      &mail.Address{Address: userAddress},
+		Text:      "Yo! *bold_ <3",
+		InReplyTo: createTelegramMessage("Sup Will!", 42),
+	}
+
+	expected := telegram.MessageConfig{
+		BaseChat: telegram.BaseChat{
+			ChatID:           123,
+			ReplyToMessageID: 42,
+		},
+		Text:      "<b>will@example.com:</b> Yo! *bold_ &lt;3",
+		ParseMode: "HTML",
+	}
+
+	actual, _ := msg.ToTelegram(123)
+	Equal(t, expected, actual)
+}
+
+func TestReadBody_Multipart(t *testing.T) {
+	raw := "Content-Type: multipart/alternative; boundary=XYZ\r\n\r\n" +
+		"--XYZ\r\nContent-Type: text/html\r\n\r\n<p>Yo!</p>\r\n" +
+		"--XYZ\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nYo=21\r\n\r\n-- \r\nWill\r\n" +
+		"--XYZ--\r\n"
+	msg, _ := mail.ReadMessage(strings.NewReader(raw))
+
+	body, err := readBody(msg.Header, msg.Body)
+	Nil(t, err)
+	Equal(t, "Yo!", stripQuotedText(body))
+}
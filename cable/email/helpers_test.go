@@ -0,0 +1,244 @@
+package email
+
+import (
+	"bufio"
+	"fmt"
+	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable/slack"
+	"github.com/miguelff/cable/cable/telegram"
+	slackAPI "github.com/nlopes/slack"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+)
+
+/* Constants used in tests */
+
+const (
+	cableAddress = "cable@example.com"
+	listAddress  = "list@example.com"
+	userAddress  = "will@example.com"
+)
+
+/* fake Email API */
+
+type sentMail struct {
+	from string
+	to   []string
+	msg  *mail.Message
+}
+
+type fakeEmailAPI struct {
+	incoming chan *mail.Message
+	sent     []sentMail
+	// mutex guards what is sent, as the write pump sends it concurrently
+	mutex sync.Mutex
+}
+
+func (api *fakeEmailAPI) IncomingMessages() <-chan *mail.Message {
+	return api.incoming
+}
+
+func (api *fakeEmailAPI) SendMail(from string, to []string, msg []byte) error {
+	parsed, err := mail.ReadMessage(strings.NewReader(string(msg)))
+	if err != nil {
+		return err
+	}
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.sent = append(api.sent, sentMail{from, to, parsed})
+	return nil
+}
+
+// Sent returns a copy of the emails sent
+func (api *fakeEmailAPI) Sent() []sentMail {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return append([]sentMail(nil), api.sent...)
+}
+
+/* factories */
+
+// createRawEmail creates an email as it would be read from the mailbox
+func createRawEmail(from string, inReplyTo string, body string) *mail.Message {
+	raw := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: Re: [cable] hi\r\nIn-Reply-To: %s\r\n\r\n%s", from, listAddress, inReplyTo, body)
+	msg, _ := mail.ReadMessage(strings.NewReader(raw))
+	return msg
+}
+
+// createSlackMessage is a factory of slack messages for the tests below
+func createSlackMessage(text string, timestamp string) slack.Message {
+	return slack.Message{
+		MessageEvent: &slackAPI.MessageEvent{Msg: slackAPI.Msg{User: "USER", Text: text, Timestamp: timestamp}},
+		Users:        slack.UserMap{"USER": slackAPI.User{ID: "USER", Name: "freshprince"}},
+	}
+}
+
+// createTelegramMessage is a factory of telegram messages for the tests below
+func createTelegramMessage(text string, messageID int) telegram.Message {
+	return telegram.Message{
+		Update: telegramAPI.Update{
+			Message: &telegramAPI.Message{
+				MessageID: messageID,
+				From:      &telegramAPI.User{UserName: "Jazz"},
+				Text:      text,
+			},
+		},
+	}
+}
+
+/* local SMTP and IMAP stand-ins */
+
+// fakeSMTPServer is a SMTP server accepting any message and recording the
+// DATA received
+type fakeSMTPServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	received []string
+}
+
+func newFakeSMTPServer() *fakeSMTPServer {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (server *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line)[0]); command {
+		case "EHLO", "HELO", "MAIL", "RCPT":
+			fmt.Fprint(conn, "250 OK\r\n")
+		case "DATA":
+			fmt.Fprint(conn, "354 Go ahead\r\n")
+			var data []string
+			for {
+				line, _ := reader.ReadString('\n')
+				if line == ".\r\n" {
+					break
+				}
+				data = append(data, line)
+			}
+			server.mutex.Lock()
+			server.received = append(server.received, strings.Join(data, ""))
+			server.mutex.Unlock()
+			fmt.Fprint(conn, "250 OK\r\n")
+		case "QUIT":
+			fmt.Fprint(conn, "221 Bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "502 Not implemented\r\n")
+		}
+	}
+}
+
+// fakeIMAPServer is an IMAP server with a single mailbox supporting the
+// commands used by imapClient
+type fakeIMAPServer struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	messages []string
+	seen     map[int]bool
+	appended chan bool
+}
+
+func newFakeIMAPServer(messages ...string) *fakeIMAPServer {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &fakeIMAPServer{
+		listener: listener,
+		messages: messages,
+		seen:     make(map[int]bool),
+		appended: make(chan bool, 1),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+// append adds a message to the mailbox, notifying idling clients
+func (server *fakeIMAPServer) append(message string) {
+	server.mutex.Lock()
+	server.messages = append(server.messages, message)
+	server.mutex.Unlock()
+	server.appended <- true
+}
+
+func (server *fakeIMAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK IMAP4rev1 ready\r\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		tag, command := fields[0], strings.ToUpper(strings.Join(fields[1:], " "))
+
+		server.mutex.Lock()
+		switch {
+		case strings.HasPrefix(command, "LOGIN"):
+			fmt.Fprintf(conn, "%s OK LOGIN completed\r\n", tag)
+		case strings.HasPrefix(command, "SELECT"):
+			fmt.Fprintf(conn, "* %d EXISTS\r\n%s OK SELECT completed\r\n", len(server.messages), tag)
+		case command == "UID SEARCH UNSEEN":
+			var uids []string
+			for i := range server.messages {
+				if !server.seen[i+1] {
+					uids = append(uids, fmt.Sprint(i+1))
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK SEARCH completed\r\n", strings.Join(uids, " "), tag)
+		case strings.HasPrefix(command, "UID FETCH"):
+			var uid int
+			fmt.Sscanf(fields[3], "%d", &uid)
+			server.seen[uid] = true
+			msg := server.messages[uid-1]
+			fmt.Fprintf(conn, "* %d FETCH (UID %d BODY[] {%d}\r\n%s)\r\n%s OK FETCH completed\r\n", uid, uid, len(msg), msg, tag)
+		case command == "IDLE":
+			server.mutex.Unlock()
+			fmt.Fprint(conn, "+ idling\r\n")
+			done := make(chan bool)
+			go func() {
+				_, _ = reader.ReadString('\n')
+				done <- true
+			}()
+			select {
+			case <-server.appended:
+				server.mutex.Lock()
+				fmt.Fprintf(conn, "* %d EXISTS\r\n", len(server.messages))
+				server.mutex.Unlock()
+				<-done
+			case <-done:
+			}
+			server.mutex.Lock()
+			fmt.Fprintf(conn, "%s OK IDLE terminated\r\n", tag)
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+		server.mutex.Unlock()
+	}
+}
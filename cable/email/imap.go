@@ -0,0 +1,230 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* Section: minimal IMAP client */
+
+// literalRegexp matches the announcement of a literal at the end of an IMAP
+// response line, for instance "* 1 FETCH (UID 4 BODY[] {342}"
+var literalRegexp = regexp.MustCompile(`\{(\d+)\}$`)
+
+// imapResponse is a single untagged response sent by the server. Any literals
+// found in the response are read and kept apart from the textual part.
+type imapResponse struct {
+	line     string
+	literals [][]byte
+}
+
+// imapClient is a minimal IMAP4rev1 client, implementing the subset of the
+// protocol needed to watch a mailbox: LOGIN, SELECT, UID SEARCH, UID FETCH and
+// IDLE (RFC 2177).
+type imapClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+// dialIMAP connects to the IMAP server listening at addr and consumes its
+// greeting. When useTLS is true the connection is established over TLS.
+func dialIMAP(addr string, useTLS bool) (*imapClient, error) {
+	var conn net.Conn
+	var err error
+
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.Dial("tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapClient{conn: conn, reader: bufio.NewReader(conn)}
+	greeting, err := c.readResponse()
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	if !strings.HasPrefix(greeting.line, "* OK") {
+		_ = conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", greeting.line)
+	}
+	return c, nil
+}
+
+// Close closes the underlying connection to the server
+func (c *imapClient) Close() error {
+	return c.conn.Close()
+}
+
+// Login authenticates the client with the given credentials
+func (c *imapClient) Login(username, password string) error {
+	_, err := c.command("LOGIN %s %s", quote(username), quote(password))
+	return err
+}
+
+// Select opens the given mailbox
+func (c *imapClient) Select(mailbox string) error {
+	_, err := c.command("SELECT %s", quote(mailbox))
+	return err
+}
+
+// SearchUnseen returns the UIDs of the messages in the selected mailbox that
+// have not been seen yet
+func (c *imapClient) SearchUnseen() ([]uint32, error) {
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+
+	var uids []uint32
+	for _, r := range responses {
+		if !strings.HasPrefix(r.line, "* SEARCH") {
+			continue
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(r.line, "* SEARCH")) {
+			uid, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("malformed SEARCH response: %s", r.line)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// Fetch retrieves and parses the message with the given UID. Fetching the
+// message marks it as seen.
+func (c *imapClient) Fetch(uid uint32) (*mail.Message, error) {
+	responses, err := c.command("UID FETCH %d (BODY[])", uid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range responses {
+		if strings.Contains(r.line, "FETCH") && len(r.literals) > 0 {
+			return mail.ReadMessage(bytes.NewReader(r.literals[0]))
+		}
+	}
+	return nil, fmt.Errorf("message with UID %d not found", uid)
+}
+
+// Idle waits until the server notifies new messages in the selected mailbox,
+// or the timeout expires, whatever happens first.
+func (c *imapClient) Idle(timeout time.Duration) error {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s IDLE\r\n", tag); err != nil {
+		return err
+	}
+
+	continuation, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(continuation.line, "+") {
+		return fmt.Errorf("IDLE not accepted: %s", continuation.line)
+	}
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		r, err := c.readResponse()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			return err
+		}
+		if strings.HasSuffix(r.line, "EXISTS") {
+			break
+		}
+	}
+	_ = c.conn.SetReadDeadline(time.Time{})
+
+	if _, err := fmt.Fprint(c.conn, "DONE\r\n"); err != nil {
+		return err
+	}
+	_, err = c.waitFor(tag)
+	return err
+}
+
+// command sends a tagged command to the server and returns the untagged
+// responses received before its completion
+func (c *imapClient) command(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+	return c.waitFor(tag)
+}
+
+// waitFor reads responses until the one tagged with tag arrives, returning an
+// error if the command did not complete successfully
+func (c *imapClient) waitFor(tag string) ([]imapResponse, error) {
+	var responses []imapResponse
+	for {
+		r, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(r.line, tag+" ") {
+			status := strings.TrimPrefix(r.line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, fmt.Errorf("IMAP command failed: %s", status)
+			}
+			return responses, nil
+		}
+		responses = append(responses, r)
+	}
+}
+
+// readResponse reads a response line from the server, together with any
+// literals it contains
+func (c *imapClient) readResponse() (imapResponse, error) {
+	var r imapResponse
+	var line strings.Builder
+
+	for {
+		part, err := c.reader.ReadString('\n')
+		if err != nil {
+			return r, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		line.WriteString(part)
+
+		match := literalRegexp.FindStringSubmatch(part)
+		if match == nil {
+			break
+		}
+		size, _ := strconv.Atoi(match[1])
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, literal); err != nil {
+			return r, err
+		}
+		r.literals = append(r.literals, literal)
+	}
+
+	r.line = line.String()
+	return r, nil
+}
+
+// quote returns s as an IMAP quoted string
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,55 @@
+package email
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestIMAPClient(t *testing.T) {
+	server := newFakeIMAPServer("Subject: first\r\n\r\nSup Jay!", "Subject: second\r\n\r\nSup Will!")
+	defer server.listener.Close()
+
+	client, err := dialIMAP(server.listener.Addr().String(), false)
+	Nil(t, err)
+	defer client.Close()
+
+	Nil(t, client.Login("cable", `pa"ss`))
+	Nil(t, client.Select("INBOX"))
+
+	uids, err := client.SearchUnseen()
+	Nil(t, err)
+	Equal(t, []uint32{1, 2}, uids)
+
+	msg, err := client.Fetch(2)
+	Nil(t, err)
+	Equal(t, "second", msg.Header.Get("Subject"))
+	body, _ := ioutil.ReadAll(msg.Body)
+	Equal(t, "Sup Will!", string(body))
+
+	uids, _ = client.SearchUnseen()
+	Equal(t, []uint32{1}, uids)
+}
+
+func TestIMAPClient_Idle(t *testing.T) {
+	server := newFakeIMAPServer()
+	defer server.listener.Close()
+
+	client, _ := dialIMAP(server.listener.Addr().String(), false)
+	defer client.Close()
+	_ = client.Select("INBOX")
+
+	// times out when nothing arrives
+	Nil(t, client.Idle(50*time.Millisecond))
+
+	go server.append("Subject: new\r\n\r\nSup Jay!")
+	Nil(t, client.Idle(time.Minute))
+
+	uids, _ := client.SearchUnseen()
+	Equal(t, []uint32{1}, uids)
+}
+
+func TestQuote(t *testing.T) {
+	Equal(t, `"pa\"ss\\word"`, quote(`pa"ss\word`))
+}
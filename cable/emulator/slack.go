@@ -0,0 +1,511 @@
+// Package emulator serves fake Slack Web and RTM APIs, and a fake Telegram Bot
+// API, on localhost. Creating Slack and Telegram pumpers with their URLs
+// exercises the real API clients end to end, without network access.
+//
+// Emulators are scripted: tests make users write messages with Say, and
+// assert on the messages the bots wrote with WaitForPosts or WaitForMessages.
+package emulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/nlopes/slack"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when waiting for something that did not happen in
+// time
+var ErrTimeout = errors.New("timeout")
+
+// SlackPost is a message posted to the emulated slack with chat.postMessage,
+// or a file shared with files.upload
+type SlackPost struct {
+	// Channel is the ID of the channel the message was posted to
+	Channel string
+	// Text is the text of the message
+	Text string
+	// Attachments are the attachments of the message
+	Attachments []slack.Attachment
+	// ThreadTimestamp is the timestamp of the message the post replies to, if
+	// posted in a thread
+	ThreadTimestamp string
+	// Timestamp is the timestamp the emulator gave to the message
+	Timestamp string
+	// Metadata is the metadata the message was posted with, if any
+	Metadata json.RawMessage
+	// Blocks are the Block Kit blocks the message was laid out with, if any
+	Blocks json.RawMessage
+	// File is the file uploaded with files.upload, if the post shares one,
+	// and Text its initial comment
+	File *SlackFile
+}
+
+// SlackFile is a file uploaded to the emulated slack
+type SlackFile struct {
+	// ID is the ID the emulator gave to the file
+	ID string
+	// Name is the name of the file
+	Name string
+	// Content is the content of the file
+	Content string
+}
+
+// Slack emulates the slack Web and RTM APIs
+type Slack struct {
+	// Token is the token clients have to authenticate with. Any token is
+	// accepted when empty.
+	Token string
+	// BotID is the ID of the bot posting messages, which is set in the events
+	// slack sends when a message is posted
+	BotID string
+	// UserID is the ID of the user of the bot
+	UserID string
+
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+
+	mutex       sync.Mutex
+	users       []slack.User
+	channels    []slack.Channel
+	members     map[string][]string
+	posts       []SlackPost
+	files       map[string]string
+	changed     chan struct{}
+	connections map[*slackConnection]bool
+	lastTS      int64
+}
+
+// slackConnection is an RTM websocket connection of a client
+type slackConnection struct {
+	mutex sync.Mutex
+	conn  *websocket.Conn
+}
+
+// write sends an event to the client
+func (c *slackConnection) write(event interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.conn.WriteJSON(event)
+}
+
+// NewSlack returns the address of a new Slack emulator, listening on a random
+// port of localhost until closed
+func NewSlack() *Slack {
+	s := &Slack{
+		BotID:       "BEMULATOR",
+		UserID:      "UEMULATOR",
+		members:     make(map[string][]string),
+		files:       make(map[string]string),
+		changed:     make(chan struct{}),
+		connections: make(map[*slackConnection]bool),
+	}
+	// slack clients connect to the RTM API with the origin of slack.com
+	s.upgrader.CheckOrigin = func(*http.Request) bool { return true }
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// URL returns the base URL of the Web API, to be passed to slack.NewSlack
+func (s *Slack) URL() string {
+	return s.server.URL + "/api/"
+}
+
+// Close closes the RTM connections and stops serving
+func (s *Slack) Close() {
+	s.Disconnect()
+	s.server.Close()
+}
+
+// AddUser adds a user to the workspace
+func (s *Slack) AddUser(user slack.User) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.users = append(s.users, user)
+}
+
+// AddChannel adds a channel to the workspace, listed by conversations.list
+func (s *Slack) AddChannel(id string, name string, private bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	channel := slack.Channel{}
+	channel.ID, channel.Name, channel.IsPrivate, channel.IsChannel = id, name, private, true
+	s.channels = append(s.channels, channel)
+}
+
+// SetMembers sets the IDs of the members of a channel
+func (s *Slack) SetMembers(channel string, userIDs ...string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.members[channel] = userIDs
+}
+
+// Say makes the given user write a message in a channel, sending it to every
+// client connected to the RTM API, and returns its timestamp
+func (s *Slack) Say(channel string, userID string, text string) string {
+	ts := s.nextTimestamp()
+	s.broadcast(map[string]interface{}{
+		"type":    "message",
+		"channel": channel,
+		"user":    userID,
+		"text":    text,
+		"ts":      ts,
+	})
+	return ts
+}
+
+// ShareSnippet makes the given user share a snippet of code in a channel,
+// with the given name and file type, like "python", sending the message event
+// to every client connected to the RTM API. The content of the snippet can be
+// downloaded from its private URL. It returns the timestamp of the message.
+func (s *Slack) ShareSnippet(channel string, userID string, name string, filetype string, content string) string {
+	ts := s.nextTimestamp()
+	id := "F" + strings.Replace(ts, ".", "", 1)
+	s.mutex.Lock()
+	s.files[id] = content
+	s.mutex.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "message",
+		"subtype": "file_share",
+		"channel": channel,
+		"user":    userID,
+		"files": []map[string]string{{
+			"id":                   id,
+			"name":                 name,
+			"filetype":             filetype,
+			"mode":                 "snippet",
+			"preview":              strings.SplitN(content, "\n", 2)[0],
+			"url_private_download": s.server.URL + "/files/" + id,
+		}},
+		"upload": true,
+		"ts":     ts,
+	})
+	return ts
+}
+
+// SayAsApp sends a message event to the RTM clients, as if another app posted
+// it to the given channel with the given metadata, like another cable
+// instance does. It returns the timestamp of the message.
+func (s *Slack) SayAsApp(channel string, botID string, text string, metadata json.RawMessage) string {
+	ts := s.nextTimestamp()
+	s.broadcast(map[string]interface{}{
+		"type":     "message",
+		"subtype":  "bot_message",
+		"channel":  channel,
+		"bot_id":   botID,
+		"text":     text,
+		"ts":       ts,
+		"metadata": metadata,
+	})
+	return ts
+}
+
+// Disconnect closes the RTM connections, as slack does from time to time.
+// Clients are expected to reconnect.
+func (s *Slack) Disconnect() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.connections {
+		_ = c.conn.Close()
+		delete(s.connections, c)
+	}
+	s.notify()
+}
+
+// WaitForConnection waits until a client is connected to the RTM API
+func (s *Slack) WaitForConnection(timeout time.Duration) error {
+	return s.waitFor(timeout, func() bool { return len(s.connections) > 0 })
+}
+
+// Posts returns the messages posted so far
+func (s *Slack) Posts() []SlackPost {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]SlackPost(nil), s.posts...)
+}
+
+// WaitForPosts waits until at least n messages are posted, returning them
+func (s *Slack) WaitForPosts(n int, timeout time.Duration) ([]SlackPost, error) {
+	err := s.waitFor(timeout, func() bool { return len(s.posts) >= n })
+	return s.Posts(), err
+}
+
+// waitFor waits until condition, which is called with the mutex locked, is
+// true
+func (s *Slack) waitFor(timeout time.Duration, condition func() bool) error {
+	deadline := time.After(timeout)
+	for {
+		s.mutex.Lock()
+		done, changed := condition(), s.changed
+		s.mutex.Unlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return ErrTimeout
+		}
+	}
+}
+
+// notify wakes up the goroutines waiting for changes. It has to be called
+// with the mutex locked.
+func (s *Slack) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// nextTimestamp returns a new message timestamp, unique and increasing
+func (s *Slack) nextTimestamp() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts := time.Now().UnixNano() / int64(time.Microsecond)
+	if ts <= s.lastTS {
+		ts = s.lastTS + 1
+	}
+	s.lastTS = ts
+	return fmt.Sprintf("%d.%06d", ts/1000000, ts%1000000)
+}
+
+// broadcast sends an event to every client connected to the RTM API
+func (s *Slack) broadcast(event interface{}) {
+	s.mutex.Lock()
+	var connections []*slackConnection
+	for c := range s.connections {
+		connections = append(connections, c)
+	}
+	s.mutex.Unlock()
+
+	for _, c := range connections {
+		_ = c.write(event)
+	}
+}
+
+/* Section: Web and RTM API handlers */
+
+// serve routes the requests to the Web API methods and the RTM websocket
+func (s *Slack) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/rtm" {
+		s.serveRTM(w, r)
+		return
+	}
+
+	_ = r.ParseForm()
+	token := r.Form.Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	switch {
+	case token == "":
+		writeSlack(w, map[string]interface{}{"ok": false, "error": "not_authed"})
+		return
+	case s.Token != "" && token != s.Token:
+		writeSlack(w, map[string]interface{}{"ok": false, "error": "invalid_auth"})
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/files/") {
+		s.serveFile(w, strings.TrimPrefix(r.URL.Path, "/files/"))
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/api/") {
+	case "auth.test":
+		writeSlack(w, map[string]interface{}{"ok": true, "team": "emulator", "team_id": "TEMULATOR", "user": "cable", "user_id": s.UserID, "bot_id": s.BotID})
+	case "rtm.connect":
+		writeSlack(w, map[string]interface{}{
+			"ok":   true,
+			"url":  "ws" + strings.TrimPrefix(s.server.URL, "http") + "/rtm",
+			"self": map[string]string{"id": s.UserID, "name": "cable"},
+			"team": map[string]string{"id": "TEMULATOR", "name": "emulator", "domain": "emulator"},
+		})
+	case "users.list":
+		writeSlack(w, map[string]interface{}{"ok": true, "members": s.workspaceUsers(), "response_metadata": map[string]string{"next_cursor": ""}})
+	case "users.info":
+		for _, user := range s.workspaceUsers() {
+			if user.ID == r.Form.Get("user") {
+				writeSlack(w, map[string]interface{}{"ok": true, "user": user})
+				return
+			}
+		}
+		if r.Form.Get("user") == s.UserID {
+			bot := slack.User{ID: s.UserID, Name: "cable", IsBot: true, Profile: slack.UserProfile{BotID: s.BotID}}
+			writeSlack(w, map[string]interface{}{"ok": true, "user": bot})
+			return
+		}
+		writeSlack(w, map[string]interface{}{"ok": false, "error": "user_not_found"})
+	case "conversations.list":
+		writeSlack(w, map[string]interface{}{"ok": true, "channels": s.workspaceChannels(), "response_metadata": map[string]string{"next_cursor": ""}})
+	case "conversations.members":
+		s.mutex.Lock()
+		members := append([]string{}, s.members[r.Form.Get("channel")]...)
+		s.mutex.Unlock()
+		writeSlack(w, map[string]interface{}{"ok": true, "members": members, "response_metadata": map[string]string{"next_cursor": ""}})
+	case "chat.postMessage":
+		post := s.post(r)
+		writeSlack(w, map[string]interface{}{"ok": true, "channel": post.Channel, "ts": post.Timestamp})
+	case "files.upload":
+		post := s.upload(r)
+		writeSlack(w, map[string]interface{}{"ok": true, "file": map[string]string{"id": post.File.ID, "name": post.File.Name}})
+	default:
+		writeSlack(w, map[string]interface{}{"ok": false, "error": "unknown_method"})
+	}
+}
+
+// workspaceUsers returns the users of the workspace
+func (s *Slack) workspaceUsers() []slack.User {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]slack.User{}, s.users...)
+}
+
+// workspaceChannels returns the channels of the workspace, telling whether
+// the bot is a member of each
+func (s *Slack) workspaceChannels() []slack.Channel {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	channels := append([]slack.Channel{}, s.channels...)
+	for i, c := range channels {
+		for _, member := range s.members[c.ID] {
+			if member == s.UserID {
+				channels[i].IsMember = true
+			}
+		}
+	}
+	return channels
+}
+
+// post records the message posted with a chat.postMessage request, and sends
+// it back to the RTM clients as slack does
+func (s *Slack) post(r *http.Request) SlackPost {
+	post := SlackPost{
+		Channel:         r.Form.Get("channel"),
+		Text:            r.Form.Get("text"),
+		ThreadTimestamp: r.Form.Get("thread_ts"),
+		Timestamp:       s.nextTimestamp(),
+	}
+	if attachments := r.Form.Get("attachments"); attachments != "" {
+		_ = json.Unmarshal([]byte(attachments), &post.Attachments)
+	}
+	if metadata := r.Form.Get("metadata"); metadata != "" {
+		post.Metadata = json.RawMessage(metadata)
+	}
+	if blocks := r.Form.Get("blocks"); blocks != "" {
+		post.Blocks = json.RawMessage(blocks)
+	}
+
+	s.mutex.Lock()
+	s.posts = append(s.posts, post)
+	s.notify()
+	s.mutex.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "message",
+		"subtype":     "bot_message",
+		"channel":     post.Channel,
+		"bot_id":      s.BotID,
+		"text":        post.Text,
+		"attachments": post.Attachments,
+		"thread_ts":   post.ThreadTimestamp,
+		"ts":          post.Timestamp,
+		"metadata":    post.Metadata,
+	})
+	return post
+}
+
+// upload records the file shared with a files.upload request as a post, and
+// sends it back to the RTM clients as slack does
+func (s *Slack) upload(r *http.Request) SlackPost {
+	post := SlackPost{
+		Channel:         strings.Split(r.Form.Get("channels"), ",")[0],
+		Text:            r.Form.Get("initial_comment"),
+		ThreadTimestamp: r.Form.Get("thread_ts"),
+		Timestamp:       s.nextTimestamp(),
+		File:            &SlackFile{Name: r.Form.Get("filename"), Content: r.Form.Get("content")},
+	}
+	post.File.ID = "F" + strings.Replace(post.Timestamp, ".", "", 1)
+
+	s.mutex.Lock()
+	s.posts = append(s.posts, post)
+	s.notify()
+	s.mutex.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "message",
+		"subtype":   "file_share",
+		"channel":   post.Channel,
+		"user":      s.UserID,
+		"text":      post.Text,
+		"files":     []map[string]string{{"id": post.File.ID, "name": post.File.Name}},
+		"thread_ts": post.ThreadTimestamp,
+		"ts":        post.Timestamp,
+	})
+	return post
+}
+
+// serveFile writes the content of the file with the given ID, as slack does
+// when downloading it from its private URL
+func (s *Slack) serveFile(w http.ResponseWriter, id string) {
+	s.mutex.Lock()
+	content, ok := s.files[id]
+	s.mutex.Unlock()
+	if !ok {
+		http.NotFound(w, nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(content))
+}
+
+// serveRTM upgrades the request to an RTM websocket connection, greeting the
+// client and answering its pings until it is closed
+func (s *Slack) serveRTM(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &slackConnection{conn: conn}
+	if err := c.write(map[string]string{"type": "hello"}); err != nil {
+		_ = conn.Close()
+		return
+	}
+
+	s.mutex.Lock()
+	s.connections[c] = true
+	s.notify()
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.connections, c)
+		s.notify()
+		s.mutex.Unlock()
+		_ = conn.Close()
+	}()
+
+	for {
+		var event struct {
+			ID   int    `json:"id"`
+			Type string `json:"type"`
+			Time int64  `json:"timestamp"`
+		}
+		if err := conn.ReadJSON(&event); err != nil {
+			return
+		}
+		if event.Type == "ping" {
+			_ = c.write(map[string]interface{}{"type": "pong", "reply_to": event.ID, "timestamp": event.Time})
+		}
+	}
+}
+
+// writeSlack writes the response of a Web API method
+func writeSlack(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,304 @@
+package emulator
+
+import (
+	"encoding/json"
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUploadSize is the size of the files sent above which they are stored in
+// temporary files while parsing the request
+const maxUploadSize = 1 << 20
+
+// TelegramMessage is a message sent to the emulated telegram with sendMessage,
+// or a file sent with sendDocument
+type TelegramMessage struct {
+	// ChatID is the ID of the chat the message was sent to
+	ChatID int64
+	// Text is the text of the message
+	Text string
+	// ParseMode is the markup the text is written in, if any
+	ParseMode string
+	// ReplyToMessageID is the ID of the message it replies to, if any
+	ReplyToMessageID int
+	// MessageID is the ID the emulator gave to the message
+	MessageID int
+	// Document is the file sent with sendDocument, if any, and Text its
+	// caption
+	Document *TelegramDocument
+}
+
+// TelegramDocument is a file sent to the emulated telegram
+type TelegramDocument struct {
+	// Name is the name of the file
+	Name string
+	// Content is the content of the file
+	Content string
+}
+
+// Telegram emulates the telegram Bot API
+type Telegram struct {
+	// Token is the token of the bot. Requests with other tokens are rejected.
+	Token string
+	// Bot is the user of the bot
+	Bot telegram.User
+	// PollTimeout is the maximum time getUpdates waits for updates, which is
+	// shorter than the one requested by clients so they can be stopped
+	// quickly
+	PollTimeout time.Duration
+
+	server *httptest.Server
+	closed chan struct{}
+
+	mutex          sync.Mutex
+	updates        []telegram.Update
+	sent           []TelegramMessage
+	administrators map[int64][]telegram.User
+	changed        chan struct{}
+	lastUpdateID   int
+	lastMessageID  int
+}
+
+// NewTelegram returns the address of a new Telegram emulator of the bot with
+// the given token, listening on a random port of localhost until closed
+func NewTelegram(token string) *Telegram {
+	t := &Telegram{
+		Token:          token,
+		Bot:            telegram.User{ID: 1000, IsBot: true, FirstName: "cable", UserName: "cable_bot"},
+		PollTimeout:    time.Second,
+		closed:         make(chan struct{}),
+		administrators: make(map[int64][]telegram.User),
+		changed:        make(chan struct{}),
+	}
+	t.server = httptest.NewServer(http.HandlerFunc(t.serve))
+	return t
+}
+
+// URL returns the base URL of the Bot API, to be passed to
+// telegram.NewTelegram
+func (t *Telegram) URL() string {
+	return t.server.URL
+}
+
+// Close ends pending polls and stops serving
+func (t *Telegram) Close() {
+	close(t.closed)
+	t.server.Close()
+}
+
+// SetAdministrators sets the administrators of a chat
+func (t *Telegram) SetAdministrators(chatID int64, users ...telegram.User) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.administrators[chatID] = users
+}
+
+// Say makes the given user write a message in a chat, which is delivered to
+// the bot with getUpdates, and returns its ID
+func (t *Telegram) Say(chatID int64, from telegram.User, text string) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastUpdateID++
+	t.lastMessageID++
+	t.updates = append(t.updates, telegram.Update{
+		UpdateID: t.lastUpdateID,
+		Message: &telegram.Message{
+			MessageID: t.lastMessageID,
+			From:      &from,
+			Chat:      &telegram.Chat{ID: chatID, Type: "supergroup"},
+			Date:      int(time.Now().Unix()),
+			Text:      text,
+		},
+	})
+	t.notify()
+	return t.lastMessageID
+}
+
+// Messages returns the messages sent so far
+func (t *Telegram) Messages() []TelegramMessage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]TelegramMessage(nil), t.sent...)
+}
+
+// WaitForMessages waits until at least n messages are sent, returning them
+func (t *Telegram) WaitForMessages(n int, timeout time.Duration) ([]TelegramMessage, error) {
+	deadline := time.After(timeout)
+	for {
+		t.mutex.Lock()
+		done, changed := len(t.sent) >= n, t.changed
+		t.mutex.Unlock()
+		if done {
+			return t.Messages(), nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return t.Messages(), ErrTimeout
+		}
+	}
+}
+
+// notify wakes up the goroutines waiting for changes. It has to be called
+// with the mutex locked.
+func (t *Telegram) notify() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+/* Section: Bot API handlers */
+
+// serve routes the requests to the Bot API methods, which are served at
+// /bot<token>/<method>
+func (t *Telegram) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] != "bot"+t.Token {
+		writeTelegramError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	// files are sent as multipart forms, and the rest of the requests as
+	// url encoded ones, which are parsed regardless
+	_ = r.ParseMultipartForm(maxUploadSize)
+	chatID, _ := strconv.ParseInt(r.Form.Get("chat_id"), 10, 64)
+
+	switch parts[1] {
+	case "getMe":
+		writeTelegram(w, t.Bot)
+	case "getUpdates":
+		offset, _ := strconv.Atoi(r.Form.Get("offset"))
+		timeout, _ := strconv.Atoi(r.Form.Get("timeout"))
+		writeTelegram(w, t.poll(offset, time.Duration(timeout)*time.Second))
+	case "sendMessage":
+		writeTelegram(w, t.send(r, chatID))
+	case "sendDocument":
+		writeTelegram(w, t.sendDocument(r, chatID))
+	case "getChatAdministrators":
+		t.mutex.Lock()
+		var members []telegram.ChatMember
+		for _, user := range t.administrators[chatID] {
+			user := user
+			members = append(members, telegram.ChatMember{User: &user, Status: "administrator"})
+		}
+		t.mutex.Unlock()
+		writeTelegram(w, members)
+	case "exportChatInviteLink":
+		writeTelegram(w, fmt.Sprintf("https://t.me/joinchat/emulator%d", -chatID))
+	case "getChat":
+		writeTelegram(w, telegram.Chat{ID: chatID, Type: "supergroup", Title: "emulator"})
+	default:
+		writeTelegramError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+// poll returns the updates with an ID of at least offset, waiting for them
+// up to timeout, or PollTimeout if shorter
+func (t *Telegram) poll(offset int, timeout time.Duration) []telegram.Update {
+	if timeout > t.PollTimeout {
+		timeout = t.PollTimeout
+	}
+	deadline := time.After(timeout)
+	for {
+		t.mutex.Lock()
+		updates := []telegram.Update{}
+		for _, update := range t.updates {
+			if update.UpdateID >= offset {
+				updates = append(updates, update)
+			}
+		}
+		changed := t.changed
+		t.mutex.Unlock()
+
+		if len(updates) > 0 {
+			return updates
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return updates
+		case <-t.closed:
+			return updates
+		}
+	}
+}
+
+// send records the message sent with a sendMessage request, returning it as
+// telegram does
+func (t *Telegram) send(r *http.Request, chatID int64) telegram.Message {
+	replyTo, _ := strconv.Atoi(r.Form.Get("reply_to_message_id"))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastMessageID++
+	t.sent = append(t.sent, TelegramMessage{
+		ChatID:           chatID,
+		Text:             r.Form.Get("text"),
+		ParseMode:        r.Form.Get("parse_mode"),
+		ReplyToMessageID: replyTo,
+		MessageID:        t.lastMessageID,
+	})
+	t.notify()
+
+	bot := t.Bot
+	return telegram.Message{
+		MessageID: t.lastMessageID,
+		From:      &bot,
+		Chat:      &telegram.Chat{ID: chatID, Type: "supergroup"},
+		Date:      int(time.Now().Unix()),
+		Text:      r.Form.Get("text"),
+	}
+}
+
+// sendDocument records the file sent with a sendDocument request, returning
+// the message as telegram does
+func (t *Telegram) sendDocument(r *http.Request, chatID int64) telegram.Message {
+	document := &TelegramDocument{}
+	if file, header, err := r.FormFile("document"); err == nil {
+		content, _ := ioutil.ReadAll(file)
+		_ = file.Close()
+		document.Name, document.Content = header.Filename, string(content)
+	}
+	replyTo, _ := strconv.Atoi(r.Form.Get("reply_to_message_id"))
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lastMessageID++
+	t.sent = append(t.sent, TelegramMessage{
+		ChatID:           chatID,
+		Text:             r.Form.Get("caption"),
+		ParseMode:        r.Form.Get("parse_mode"),
+		ReplyToMessageID: replyTo,
+		MessageID:        t.lastMessageID,
+		Document:         document,
+	})
+	t.notify()
+
+	bot := t.Bot
+	return telegram.Message{
+		MessageID: t.lastMessageID,
+		From:      &bot,
+		Chat:      &telegram.Chat{ID: chatID, Type: "supergroup"},
+		Date:      int(time.Now().Unix()),
+		Caption:   r.Form.Get("caption"),
+		Document:  &telegram.Document{FileID: fmt.Sprintf("document%d", t.lastMessageID), FileName: document.Name},
+	}
+}
+
+// writeTelegram writes the successful response of a Bot API method
+func writeTelegram(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// writeTelegramError writes the response of a failed Bot API method
+func writeTelegramError(w http.ResponseWriter, status int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": status, "description": description})
+}
@@ -0,0 +1,79 @@
+package emulator
+
+import (
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	t "github.com/miguelff/cable/cable/telegram"
+	. "github.com/stretchr/testify/assert"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTelegram_ReadAndWrite(test *testing.T) {
+	emulator := NewTelegram("1:read-and-write")
+	defer emulator.Close()
+
+	jazz := telegram.User{ID: 42, FirstName: "Jazz", UserName: "jazz"}
+	emulator.SetAdministrators(-100, jazz)
+
+	pumper := t.NewTelegram(emulator.Token, emulator.URL(), -100, emulator.Bot.ID, false)
+	pumper.GoRead()
+	pumper.GoWrite()
+	defer pumper.StopRead()
+	defer pumper.StopWrite()
+
+	emulator.Say(-200, jazz, "Not relayed")
+	id := emulator.Say(-100, jazz, "Sup Will!")
+	select {
+	case m := <-pumper.Inbox():
+		Equal(test, "Sup Will!", m.Content())
+		Equal(test, "jazz", m.Author())
+		Equal(test, strconv.Itoa(id), m.(cable.Identifiable).ID())
+	case <-time.After(5 * time.Second):
+		Fail(test, "the message was not read")
+	}
+	True(test, waitForState(pumper, cable.StateConnected))
+
+	pumper.Outbox() <- &cable.Notice{Text: "Bridge resumed", Plain: true}
+	messages, err := emulator.WaitForMessages(1, 5*time.Second)
+	if !NoError(test, err) {
+		return
+	}
+	Equal(test, TelegramMessage{ChatID: -100, Text: "Bridge resumed", MessageID: 3}, messages[0])
+
+	participants, err := pumper.Participants()
+	NoError(test, err)
+	Equal(test, []string{"jazz"}, participants)
+	link, err := pumper.Link()
+	NoError(test, err)
+	Equal(test, "https://t.me/joinchat/emulator100", link)
+}
+
+func TestTelegram_LongMessages(test *testing.T) {
+	emulator := NewTelegram("1:long-messages")
+	defer emulator.Close()
+
+	pumper := t.NewTelegram(emulator.Token, emulator.URL(), -100, emulator.Bot.ID, false)
+	pumper.GoWrite()
+	defer pumper.StopWrite()
+
+	long := strings.Repeat("All work and no play makes Jack a dull boy.\n", 100)
+	pumper.Outbox() <- &cable.Notice{Text: long, Plain: true}
+	messages, err := emulator.WaitForMessages(2, 5*time.Second)
+	if !NoError(test, err) {
+		return
+	}
+	True(test, strings.HasPrefix(messages[0].Text, "(1/2) All work"))
+	True(test, strings.HasPrefix(messages[1].Text, "(2/2) All work"))
+
+	pumper.SetMaxParts(1)
+	pumper.Outbox() <- &cable.Notice{Text: long, Plain: true}
+	messages, err = emulator.WaitForMessages(3, 5*time.Second)
+	if !NoError(test, err) {
+		return
+	}
+	Equal(test, &TelegramDocument{Name: "message.txt", Content: long}, messages[2].Document)
+	True(test, strings.HasPrefix(messages[2].Text, "All work"))
+}
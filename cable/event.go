@@ -0,0 +1,235 @@
+package cable
+
+import (
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable/tracing"
+	"github.com/nlopes/slack"
+	"strings"
+	"time"
+)
+
+// Kinds of events happening in a chat besides messages being written
+const (
+	// EventJoin is people joining the chat, or being added to it
+	EventJoin = "join"
+	// EventLeave is people leaving the chat, or being removed from it
+	EventLeave = "leave"
+	// EventTopic is the topic, purpose, name or title of the chat changing
+	EventTopic = "topic"
+	// EventPin is a message being pinned
+	EventPin = "pin"
+)
+
+// eventKinds are all the kinds of events, and mirroredKinds the ones that can
+// be mirrored in the other chats instead of relayed as notices
+var (
+	eventKinds    = []string{EventJoin, EventLeave, EventTopic, EventPin}
+	mirroredKinds = []string{EventTopic, EventPin}
+)
+
+// pinQuoteLength is the length of the start of the pinned messages quoted
+// in the notices of pins
+const pinQuoteLength = 200
+
+/* Section: relaying membership and channel-metadata events */
+
+// EventKinds is a set of kinds of events
+type EventKinds map[string]bool
+
+// EventRelay tells which kinds of events the pumpers read: the ones relayed
+// as compact notices, and the ones mirrored in the other chats, like pinning
+// the copy of a pinned message. Events that cannot be mirrored are relayed as
+// notices if their kind is relayed, and dropped otherwise.
+type EventRelay struct {
+	Relayed  EventKinds
+	Mirrored EventKinds
+}
+
+// ParseEventRelay parses the comma separated lists of kinds of events
+// relayed and mirrored, like "join,leave,topic,pin" and "pin", where "all"
+// stands for every kind. Only topic and pin events can be mirrored.
+func ParseEventRelay(relayed string, mirrored string) (EventRelay, error) {
+	var relay EventRelay
+	var err error
+	if relay.Relayed, err = parseEventKinds(relayed, eventKinds); err != nil {
+		return EventRelay{}, err
+	}
+	if relay.Mirrored, err = parseEventKinds(mirrored, mirroredKinds); err != nil {
+		return EventRelay{}, err
+	}
+	return relay, nil
+}
+
+// parseEventKinds parses a comma separated list of the given kinds of events
+func parseEventKinds(spec string, allowed []string) (EventKinds, error) {
+	kinds := make(EventKinds)
+	for _, kind := range strings.Split(spec, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		switch {
+		case kind == "":
+		case kind == "all":
+			for _, k := range allowed {
+				kinds[k] = true
+			}
+		case contains(allowed, kind):
+			kinds[kind] = true
+		default:
+			return nil, fmt.Errorf("unknown kind of event %q, use %s or all", kind, strings.Join(allowed, ", "))
+		}
+	}
+	return kinds, nil
+}
+
+// contains tells whether the values include value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads tells whether events of the given kind are read, to be either
+// relayed or mirrored
+func (r EventRelay) Reads(kind string) bool {
+	return r.Relayed[kind] || r.Mirrored[kind]
+}
+
+// Event is something happening in a chat besides a message being written,
+// like people joining it or a message being pinned, which is relayed as a
+// compact notice, or mirrored in the other chats
+type Event struct {
+	// Kind is the kind of event, like EventJoin
+	Kind string
+	// Platform is the platform the event happened in, like "slack"
+	Platform string
+	// Actor is the name of the person who made the event happen
+	Actor string
+	// Members are the names of the people who joined or left, which is just
+	// the actor if empty
+	Members []string
+	// Subject is what changed in topic events, like "topic" or "title"
+	Subject string
+	// Text is the new topic of topic events, and the text of the message
+	// pinned in pin events
+	Text string
+	// Pinned is the ID of the message pinned, and PinnedSource the ID of the
+	// message it was relayed from, if cable wrote it
+	Pinned       string
+	PinnedSource string
+	// Time is the time the event happened
+	Time time.Time
+	// span traces the relay of the event
+	span *tracing.Span
+}
+
+// ToSlack converts the event into a plain slack message with its notice
+func (e Event) ToSlack() ([]slack.MsgOption, error) {
+	return []slack.MsgOption{slack.MsgOptionText(e.String(), false)}, nil
+}
+
+// ToTelegram converts the event into a plain telegram message with its
+// notice
+func (e Event) ToTelegram(telegramChatID int64) (telegram.MessageConfig, error) {
+	return telegram.MessageConfig{
+		BaseChat: telegram.BaseChat{
+			ChatID: telegramChatID,
+		},
+		Text: e.String(),
+	}, nil
+}
+
+// String returns the notice of the event, like "➡️ Will Smith joined on
+// telegram"
+func (e Event) String() string {
+	members := enumerate(e.Members)
+	byOther := members != "" && members != e.Actor
+	switch e.Kind {
+	case EventJoin:
+		if byOther {
+			return fmt.Sprintf("➡️ %s added %s on %s", e.Actor, members, e.Platform)
+		}
+		return fmt.Sprintf("➡️ %s joined on %s", e.Actor, e.Platform)
+	case EventLeave:
+		if byOther {
+			return fmt.Sprintf("⬅️ %s removed %s on %s", e.Actor, members, e.Platform)
+		}
+		return fmt.Sprintf("⬅️ %s left on %s", e.Actor, e.Platform)
+	case EventTopic:
+		if e.Text == "" {
+			return fmt.Sprintf("📝 %s cleared the %s on %s", e.Actor, e.Subject, e.Platform)
+		}
+		return fmt.Sprintf("📝 %s set the %s on %s to: %s", e.Actor, e.Subject, e.Platform, e.Text)
+	case EventPin:
+		if e.Text == "" {
+			return fmt.Sprintf("📌 %s pinned a message on %s", e.Actor, e.Platform)
+		}
+		return fmt.Sprintf("📌 %s pinned a message on %s: %s", e.Actor, e.Platform, TruncateText(e.Text, pinQuoteLength, ""))
+	}
+	return fmt.Sprintf("%s %s on %s", e.Actor, e.Kind, e.Platform)
+}
+
+// enumerate joins names like people do, as in "Carlton, Hilary and Ashley"
+func enumerate(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// Content returns the text of the event, like the new topic
+func (e Event) Content() string {
+	return e.Text
+}
+
+// WithContent returns a copy of the event with the given text
+func (e Event) WithContent(content string) Message {
+	e.Text = content
+	return &e
+}
+
+// Author returns the name of the person who made the event happen
+func (e Event) Author() string {
+	return e.Actor
+}
+
+// FromBot returns false, as the events of bots are not read
+func (e Event) FromBot() bool {
+	return false
+}
+
+// SentAt returns the time the event happened
+func (e Event) SentAt() time.Time {
+	return e.Time
+}
+
+// Span returns the span tracing the relay of the event
+func (e Event) Span() *tracing.Span {
+	return e.span
+}
+
+// WithSpan returns a copy of the event carrying the given span
+func (e Event) WithSpan(span *tracing.Span) Message {
+	e.span = span
+	return &e
+}
+
+// NewEvent returns the address of a new Event of the given kind, which
+// happened in the given platform, starting the trace of its relay
+func NewEvent(kind string, platform string, actor string) *Event {
+	return &Event{
+		Kind:     kind,
+		Platform: platform,
+		Actor:    actor,
+		Time:     time.Now(),
+		span:     tracing.StartTrace("relay").SetAttribute("platform", platform).SetAttribute("event", kind),
+	}
+}
+
+// EventOf returns the event m is, if it is one
+func EventOf(m Message) (*Event, bool) {
+	event, ok := m.(*Event)
+	return event, ok
+}
@@ -0,0 +1,49 @@
+package cable
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseEventRelay(t *testing.T) {
+	relay, err := ParseEventRelay("join, Leave", "pin")
+	Nil(t, err)
+	Equal(t, EventKinds{EventJoin: true, EventLeave: true}, relay.Relayed)
+	Equal(t, EventKinds{EventPin: true}, relay.Mirrored)
+	True(t, relay.Reads(EventPin))
+	False(t, relay.Reads(EventTopic))
+
+	relay, err = ParseEventRelay("all", "all")
+	Nil(t, err)
+	Equal(t, EventKinds{EventJoin: true, EventLeave: true, EventTopic: true, EventPin: true}, relay.Relayed)
+	Equal(t, EventKinds{EventTopic: true, EventPin: true}, relay.Mirrored)
+
+	relay, err = ParseEventRelay("", "")
+	Nil(t, err)
+	False(t, relay.Reads(EventJoin), "no events are read by default")
+
+	_, err = ParseEventRelay("join,dance", "")
+	Error(t, err)
+	_, err = ParseEventRelay("", "join")
+	Error(t, err, "people joining cannot be mirrored")
+}
+
+func TestEvent_String(t *testing.T) {
+	Equal(t, "➡️ Will Smith joined on telegram", Event{Kind: EventJoin, Platform: "telegram", Actor: "Will Smith", Members: []string{"Will Smith"}}.String())
+	Equal(t, "➡️ Will Smith added Carlton, Hilary and Ashley on telegram", Event{Kind: EventJoin, Platform: "telegram", Actor: "Will Smith", Members: []string{"Carlton", "Hilary", "Ashley"}}.String())
+	Equal(t, "⬅️ Jazz left on slack", Event{Kind: EventLeave, Platform: "slack", Actor: "Jazz"}.String())
+	Equal(t, "⬅️ Philip removed Jazz on slack", Event{Kind: EventLeave, Platform: "slack", Actor: "Philip", Members: []string{"Jazz"}}.String())
+	Equal(t, "📝 Will set the topic on slack to: Bel-Air", Event{Kind: EventTopic, Platform: "slack", Actor: "Will", Subject: "topic", Text: "Bel-Air"}.String())
+	Equal(t, "📝 Will cleared the topic on slack", Event{Kind: EventTopic, Platform: "slack", Actor: "Will", Subject: "topic"}.String())
+	Equal(t, "📌 Will pinned a message on telegram: chill out, max and relax", Event{Kind: EventPin, Platform: "telegram", Actor: "Will", Text: "chill out, max and relax"}.String())
+	Equal(t, "📌 Will pinned a message on telegram", Event{Kind: EventPin, Platform: "telegram", Actor: "Will"}.String())
+}
+
+func TestEvent_WithContent(t *testing.T) {
+	event := NewEvent(EventTopic, "slack", "Will")
+	event.Subject, event.Text = "topic", "mail me at will@example.com"
+	redacted, ok := EventOf(event.WithContent("mail me at [redacted email]"))
+	True(t, ok)
+	Equal(t, "📝 Will set the topic on slack to: mail me at [redacted email]", redacted.String())
+	Equal(t, "mail me at will@example.com", event.Text, "the original event is left untouched")
+}
@@ -0,0 +1,241 @@
+package cable
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Connection states reported in HealthStatus
+const (
+	// StateIdle is the state of components that do not keep a connection,
+	// like the ones that are only written
+	StateIdle = "idle"
+	// StateConnecting is the state of components establishing their
+	// connection for the first time
+	StateConnecting = "connecting"
+	// StateConnected is the state of components whose connection is alive
+	StateConnected = "connected"
+	// StateDisconnected is the state of components whose connection failed
+	StateDisconnected = "disconnected"
+)
+
+// HealthStatus describes the health of a component
+type HealthStatus struct {
+	// State is the state of the connection of the component
+	State string `json:"state"`
+	// Since is the time the component entered State
+	Since time.Time `json:"since"`
+	// LastRead is the time a message was read successfully for the last time
+	LastRead *time.Time `json:"last_read,omitempty"`
+	// LastWrite is the time a message was written successfully for the last
+	// time
+	LastWrite *time.Time `json:"last_write,omitempty"`
+	// ReadErrors is the number of errors reading messages
+	ReadErrors int `json:"read_errors"`
+	// WriteErrors is the number of errors writing messages
+	WriteErrors int `json:"write_errors"`
+	// LastError is the description of the last error, if any
+	LastError string `json:"last_error,omitempty"`
+}
+
+// HealthReporter is implemented by the components that can report their
+// health, like pumpers
+type HealthReporter interface {
+	Health() HealthStatus
+}
+
+/* Section: HealthTracker */
+
+// HealthTracker keeps the health of a component up to date, and implements
+// HealthReporter. It is safe for concurrent use.
+type HealthTracker struct {
+	mutex  sync.Mutex
+	status HealthStatus
+}
+
+// NewHealthTracker returns the address of a new HealthTracker of an idle
+// component
+func NewHealthTracker() *HealthTracker {
+	return &HealthTracker{status: HealthStatus{State: StateIdle, Since: time.Now()}}
+}
+
+// Health returns the current health of the component
+func (h *HealthTracker) Health() HealthStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.status
+}
+
+// SetConnecting records the component started establishing its connection
+func (h *HealthTracker) SetConnecting() {
+	h.setState(StateConnecting)
+}
+
+// SetConnected records the connection of the component is alive
+func (h *HealthTracker) SetConnected() {
+	h.setState(StateConnected)
+}
+
+// SetDisconnected records the connection of the component failed because of
+// err, which can be nil if unknown
+func (h *HealthTracker) SetDisconnected(err error) {
+	h.setState(StateDisconnected)
+	if err != nil {
+		h.mutex.Lock()
+		h.status.LastError = err.Error()
+		h.mutex.Unlock()
+	}
+}
+
+// RecordRead records a message was read successfully
+func (h *HealthTracker) RecordRead() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	now := time.Now()
+	h.status.LastRead = &now
+}
+
+// RecordWrite records a message was written successfully
+func (h *HealthTracker) RecordWrite() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	now := time.Now()
+	h.status.LastWrite = &now
+}
+
+// RecordReadError records an error reading messages
+func (h *HealthTracker) RecordReadError(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.ReadErrors++
+	h.status.LastError = err.Error()
+}
+
+// RecordWriteError records an error writing messages
+func (h *HealthTracker) RecordWriteError(err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.status.WriteErrors++
+	h.status.LastError = err.Error()
+}
+
+// setState changes the connection state, if different from the current one
+func (h *HealthTracker) setState(state string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.status.State != state {
+		h.status.State = state
+		h.status.Since = time.Now()
+	}
+}
+
+/* Section: HealthChecker */
+
+// HealthChecker aggregates the health of several components to tell whether
+// cable is alive and ready.
+//
+// cable is ready when no component is connecting or disconnected, and it is
+// alive unless a component has been connecting or disconnected for longer
+// than the grace period, which means it is silently broken and cable needs to
+// be restarted.
+type HealthChecker struct {
+	// Grace is the time a component can be connecting or disconnected before
+	// cable is considered not alive
+	Grace      time.Duration
+	mutex      sync.Mutex
+	components map[string]HealthReporter
+}
+
+// NewHealthChecker returns the address of a new HealthChecker with the given
+// grace period
+func NewHealthChecker(grace time.Duration) *HealthChecker {
+	return &HealthChecker{Grace: grace, components: make(map[string]HealthReporter)}
+}
+
+// Register adds a component to the checker
+func (c *HealthChecker) Register(name string, reporter HealthReporter) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.components[name] = reporter
+}
+
+// Unregister removes a component from the checker
+func (c *HealthChecker) Unregister(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.components, name)
+}
+
+// Ready tells whether every component is either connected or idle, returning
+// the health of each of them
+func (c *HealthChecker) Ready() (bool, map[string]HealthStatus) {
+	return c.check(func(status HealthStatus) bool {
+		return status.State == StateConnected || status.State == StateIdle
+	})
+}
+
+// Live tells whether no component has been connecting or disconnected for
+// longer than the grace period, returning the health of each of them
+func (c *HealthChecker) Live() (bool, map[string]HealthStatus) {
+	return c.check(func(status HealthStatus) bool {
+		return status.State == StateConnected || status.State == StateIdle || time.Since(status.Since) <= c.Grace
+	})
+}
+
+// LivenessHandler returns an http.Handler reporting whether cable is alive
+func (c *HealthChecker) LivenessHandler() http.Handler {
+	return healthHandler(c.Live)
+}
+
+// ReadinessHandler returns an http.Handler reporting whether cable is ready
+func (c *HealthChecker) ReadinessHandler() http.Handler {
+	return healthHandler(c.Ready)
+}
+
+// check tells whether every component is healthy according to the given
+// function, returning the health of each of them
+func (c *HealthChecker) check(healthy func(HealthStatus) bool) (bool, map[string]HealthStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ok := true
+	statuses := make(map[string]HealthStatus)
+	for name, reporter := range c.components {
+		status := reporter.Health()
+		statuses[name] = status
+		ok = ok && healthy(status)
+	}
+	return ok, statuses
+}
+
+// healthReport is the JSON document served by the health handlers
+type healthReport struct {
+	Status     string                  `json:"status"`
+	Failing    []string                `json:"failing,omitempty"`
+	Components map[string]HealthStatus `json:"components"`
+}
+
+// healthHandler returns an http.Handler serving the result of check as JSON,
+// with status 200 if healthy, or 503 otherwise
+func healthHandler(check func() (bool, map[string]HealthStatus)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		ok, statuses := check()
+		report := healthReport{Status: "ok", Components: statuses}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			report.Status = "failing"
+			for name, status := range statuses {
+				if status.State == StateConnecting || status.State == StateDisconnected {
+					report.Failing = append(report.Failing, name)
+				}
+			}
+			sort.Strings(report.Failing)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,95 @@
+package cable
+
+import (
+	"encoding/json"
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthTracker(t *testing.T) {
+	tracker := NewHealthTracker()
+	Equal(t, StateIdle, tracker.Health().State)
+
+	tracker.SetConnecting()
+	Equal(t, StateConnecting, tracker.Health().State)
+
+	tracker.SetConnected()
+	since := tracker.Health().Since
+	tracker.SetConnected()
+	Equal(t, since, tracker.Health().Since, "Since does not change if the state does not change")
+
+	tracker.RecordRead()
+	tracker.RecordWrite()
+	tracker.RecordReadError(errors.New("read failed"))
+	tracker.RecordWriteError(errors.New("write failed"))
+	tracker.SetDisconnected(errors.New("connection lost"))
+
+	status := tracker.Health()
+	Equal(t, StateDisconnected, status.State)
+	NotNil(t, status.LastRead)
+	NotNil(t, status.LastWrite)
+	Equal(t, 1, status.ReadErrors)
+	Equal(t, 1, status.WriteErrors)
+	Equal(t, "connection lost", status.LastError)
+}
+
+func TestHealthChecker(t *testing.T) {
+	connected := NewHealthTracker()
+	connected.SetConnected()
+	idle := NewHealthTracker()
+	reconnecting := NewHealthTracker()
+
+	checker := NewHealthChecker(time.Minute)
+	checker.Register("connected", connected)
+	checker.Register("idle", idle)
+	checker.Register("reconnecting", reconnecting)
+
+	ready, statuses := checker.Ready()
+	True(t, ready)
+	Len(t, statuses, 3)
+
+	reconnecting.SetDisconnected(errors.New("connection lost"))
+	ready, _ = checker.Ready()
+	False(t, ready, "not ready while a component is disconnected")
+	live, _ := checker.Live()
+	True(t, live, "alive while a component is disconnected within the grace period")
+
+	checker.Grace = 0
+	time.Sleep(time.Millisecond)
+	live, _ = checker.Live()
+	False(t, live, "not alive when a component is disconnected for longer than the grace period")
+}
+
+func TestHealthChecker_Handlers(t *testing.T) {
+	slack := NewHealthTracker()
+	slack.SetConnected()
+	telegram := NewHealthTracker()
+	telegram.SetConnecting()
+
+	checker := NewHealthChecker(time.Minute)
+	checker.Register("slack", slack)
+	checker.Register("telegram", telegram)
+
+	var report healthReport
+	recorder := httptest.NewRecorder()
+	checker.ReadinessHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+	Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	Nil(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	Equal(t, "failing", report.Status)
+	Equal(t, []string{"telegram"}, report.Failing)
+	Equal(t, StateConnected, report.Components["slack"].State)
+	Equal(t, StateConnecting, report.Components["telegram"].State)
+
+	recorder = httptest.NewRecorder()
+	checker.LivenessHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	Equal(t, http.StatusOK, recorder.Code)
+	report = healthReport{}
+	Nil(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	Equal(t, "ok", report.Status)
+	Empty(t, report.Failing)
+}
@@ -0,0 +1,425 @@
+package cable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/miguelff/cable/cable/tracing"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Direction tells which way messages flow between a Hub and one of its
+// endpoints
+type Direction int
+
+const (
+	// ReadWrite endpoints have their messages relayed to the other endpoints,
+	// and receive the messages relayed from them
+	ReadWrite Direction = iota
+	// ReadOnly endpoints have their messages relayed to the other endpoints,
+	// but nothing is ever written to them
+	ReadOnly
+	// WriteOnly endpoints receive the messages relayed from the other
+	// endpoints, but their own messages are never read
+	WriteOnly
+)
+
+// ParseDirection converts the textual representation of a direction
+// ("read-write", "read-only" or "write-only") into a Direction. An empty
+// string is parsed as ReadWrite
+func ParseDirection(s string) (Direction, error) {
+	switch strings.ToLower(s) {
+	case "", "read-write":
+		return ReadWrite, nil
+	case "read-only":
+		return ReadOnly, nil
+	case "write-only":
+		return WriteOnly, nil
+	}
+	return ReadWrite, fmt.Errorf("unknown direction %q", s)
+}
+
+// String returns the textual representation of a direction
+func (d Direction) String() string {
+	switch d {
+	case ReadOnly:
+		return "read-only"
+	case WriteOnly:
+		return "write-only"
+	}
+	return "read-write"
+}
+
+// Reads tells whether messages of an endpoint with this direction are read
+func (d Direction) Reads() bool {
+	return d != WriteOnly
+}
+
+// Writes tells whether messages are written to an endpoint with this direction
+func (d Direction) Writes() bool {
+	return d != ReadOnly
+}
+
+// Endpoint is a Pumper connected to a Hub
+type Endpoint struct {
+	// Name identifies the endpoint in logs
+	Name string
+	// Platform is the name of the platform of the endpoint, like slack, which
+	// is reported in logs
+	Platform string
+	// Pumper reads and writes the messages of the endpoint
+	Pumper Pumper
+	// Direction tells whether messages are read from, written to, or both,
+	// the endpoint
+	Direction Direction
+	// Inbound is the pipeline processing the messages read from the endpoint,
+	// before relaying them to any other
+	Inbound Pipeline
+	// Outbound is the pipeline processing the messages relayed to the
+	// endpoint, before writing them to its outbox
+	Outbound Pipeline
+}
+
+// Hub connects any number of endpoints, such as the messages arriving at the
+// inbox of one of them are relayed to the outboxes of all the others.
+//
+// Messages are never relayed back to the endpoint they were read from, and
+// the direction of each endpoint is honored: read-only endpoints never get
+// messages written, and write-only endpoints are never read.
+//
+// Messages go through the Inbound pipeline of the endpoint they are read from,
+// and then through the Outbound pipeline of each endpoint they are relayed to.
+// Notices sent by middlewares are written back to the endpoint the message was
+// read from, unless it is read-only. Messages that do not fit in the outbox
+// of an endpoint are kept as its dead letters, rather than stalling the hub.
+//
+// Commands read from an endpoint are run by the hub, which replies to them in
+// that endpoint. They let chat administrators pause the hub, during which
+// messages are read but not relayed.
+//
+// Messages this cable instance wrote, and messages relayed MaxHops times or
+// more, are never relayed, as told by their Provenance. Besides, the hub halts,
+// pausing until resumed, when its LoopDetector tells that a message keeps
+// bouncing between its endpoints.
+//
+// When Batching, consecutive messages read from an endpoint within a window
+// are relayed as a single Combined message.
+//
+// Edits are relayed right away, and only to the endpoints that can edit the
+// messages they wrote. Events, like people joining a chat, are relayed right
+// away too.
+type Hub struct {
+	// Name identifies the hub in logs and metrics
+	Name      string
+	Endpoints []Endpoint
+	// MaxHops is the number of times a message can be relayed before the hub
+	// refuses to relay it further, or unlimited if zero
+	MaxHops int
+	// Loops detects messages bouncing between endpoints, if not nil
+	Loops *LoopDetector
+	// Batching merges the messages read from each endpoint before relaying
+	// them, if not nil
+	Batching *Batching
+	// Batches remembers the batch every message went into, if not nil
+	Batches  *BatchLog
+	stop     chan interface{}
+	stopOnce sync.Once
+	// paused tells whether the hub is paused, until pausedUntil if not zero
+	paused      bool
+	pausedUntil time.Time
+	pauseMutex  sync.Mutex
+}
+
+// NewHub returns the address of a new Hub with the given name connecting the
+// given endpoints
+func NewHub(name string, endpoints ...Endpoint) *Hub {
+	return &Hub{
+		Name:      name,
+		Endpoints: endpoints,
+		MaxHops:   DefaultMaxHops,
+		Loops:     NewLoopDetector(DefaultLoopThreshold, DefaultLoopWindow),
+		Batches:   NewBatchLog(),
+		stop:      make(chan interface{}),
+	}
+}
+
+// Go starts the pumps of every endpoint, and spawns a goroutine per readable
+// endpoint routing its messages to the rest of them
+func (h *Hub) Go() {
+	for _, e := range h.Endpoints {
+		if e.Direction.Reads() {
+			e.Pumper.GoRead()
+		}
+		if e.Direction.Writes() {
+			e.Pumper.GoWrite()
+			outbox := e.Pumper.Outbox()
+			metrics.OutboxDepth.Track(func() float64 { return float64(len(outbox)) }, h.Name, e.Name)
+		}
+	}
+
+	for i, e := range h.Endpoints {
+		if e.Direction.Reads() {
+			if receiver, ok := e.Pumper.(CommandReceiver); ok {
+				i := i
+				receiver.SetCommandHandler(func(c Command) { h.runCommand(i, c) })
+			}
+			go h.route(i)
+		}
+	}
+}
+
+// Pause stops relaying messages for the given duration, or until resumed if
+// zero
+func (h *Hub) Pause(d time.Duration) {
+	h.pauseMutex.Lock()
+	defer h.pauseMutex.Unlock()
+	h.paused = true
+	h.pausedUntil = time.Time{}
+	if d > 0 {
+		h.pausedUntil = time.Now().Add(d)
+	}
+}
+
+// Resume starts relaying messages again
+func (h *Hub) Resume() {
+	h.pauseMutex.Lock()
+	defer h.pauseMutex.Unlock()
+	h.paused = false
+}
+
+// PausedUntil tells whether the hub is paused, and until when, which is the
+// zero time if paused until resumed
+func (h *Hub) PausedUntil() (time.Time, bool) {
+	h.pauseMutex.Lock()
+	defer h.pauseMutex.Unlock()
+	if h.paused && !h.pausedUntil.IsZero() && time.Now().After(h.pausedUntil) {
+		h.paused = false
+	}
+	return h.pausedUntil, h.paused
+}
+
+// route relays the messages arriving at the inbox of the i-th endpoint to
+// the outboxes of the other writable endpoints, batching them first if asked
+// to
+func (h *Hub) route(i int) {
+	source := h.Endpoints[i]
+	ctx := context.Background()
+	if source.Direction.Writes() {
+		ctx = WithNotifier(ctx, func(notice Message) {
+			h.deliver(source, notice)
+		})
+	}
+	pending := &batch{}
+	for {
+		select {
+		case m := <-source.Pumper.Inbox():
+			logger := h.logger(source, "inbound").WithFields(MessageFields(m))
+			logger.Debug("Message read")
+			if _, paused := h.PausedUntil(); paused {
+				logger.Debug("Message dropped, as the bridge is paused")
+				SpanOf(m).SetAttribute("dropped", true).End()
+				metrics.MessagesDropped.Inc(h.Name, source.Name, "inbound")
+				continue
+			}
+			provenance := ProvenanceOf(m)
+			if provenance.Origin == InstanceID || (h.MaxHops > 0 && provenance.Hops >= h.MaxHops) {
+				if provenance.Origin == InstanceID {
+					logger.Debug("Message dropped, as this cable wrote it")
+				} else {
+					logger.WithField("origin", provenance.Origin).WithField("hops", provenance.Hops).Warn("Message dropped, as it was relayed too many times")
+				}
+				SpanOf(m).SetAttribute("dropped", true).End()
+				metrics.MessagesDropped.Inc(h.Name, source.Name, "inbound")
+				continue
+			}
+			bounces, loop := h.Loops.Read(i, loopContent(m))
+			if loop {
+				h.halt(logger.WithField("bounces", bounces))
+				SpanOf(m).SetAttribute("dropped", true).End()
+				metrics.MessagesDropped.Inc(h.Name, source.Name, "inbound")
+				continue
+			}
+			relay := SpanOf(m).SetAttribute("bridge", h.Name).SetAttribute("source", source.Name)
+			inbound := relay.Child("middleware.inbound").SetAttribute("endpoint", source.Name)
+			m, err := source.Inbound.Process(ctx, m)
+			inbound.EndWithError(err)
+			if err != nil {
+				logger.WithError(err).Error("Error processing inbound message")
+			}
+			if m == nil {
+				logger.Debug("Message dropped by the inbound pipeline")
+				metrics.MessagesDropped.Inc(h.Name, source.Name, "inbound")
+				relay.SetAttribute("dropped", true).End()
+				continue
+			}
+
+			if deletion, ok := DeletionOf(m); ok {
+				// deletions are relayed right away too, updating the batch
+				// the message deleted went into, if any
+				if updated, ok := h.Batches.Remove(deletion); ok {
+					m = updated
+				}
+				h.relay(ctx, i, m, relay, bounces)
+				continue
+			}
+			if IsEdit(m) {
+				// edits are relayed right away, updating the batch the
+				// message edited went into, if any
+				if combined, ok := h.Batches.Update(m); ok {
+					m = combined
+				}
+				h.relay(ctx, i, m, relay, bounces)
+				continue
+			}
+			if _, ok := EventOf(m); ok || h.Batching == nil {
+				// events are relayed right away too, as they can be
+				// mirrored in the other chats only on their own
+				h.relay(ctx, i, m, relay, bounces)
+				continue
+			}
+			if !pending.accepts(m, h.Batching) {
+				h.flush(ctx, i, pending)
+				pending = &batch{}
+			}
+			pending.add(m, relay, bounces, h.Batching)
+		case <-pending.deadline:
+			h.flush(ctx, i, pending)
+			pending = &batch{}
+		case <-h.stop:
+			h.flush(ctx, i, pending)
+			return
+		}
+	}
+}
+
+// flush relays the messages of a batch read from the i-th endpoint as a
+// single one, remembering the batch every part went into
+func (h *Hub) flush(ctx context.Context, i int, b *batch) {
+	switch len(b.parts) {
+	case 0:
+		return
+	case 1:
+		h.relay(ctx, i, b.parts[0], b.spans[0], b.bounces)
+		return
+	}
+	for _, span := range b.spans[1:] {
+		span.SetAttribute("batched", true).End()
+	}
+	combined := &Combined{Parts: b.parts}
+	h.Batches.Add(combined)
+	h.logger(h.Endpoints[i], "inbound").WithField("messages", len(b.parts)).Debug("Messages batched")
+	h.relay(ctx, i, combined, b.spans[0], b.bounces)
+}
+
+// relay writes a message read from the i-th endpoint, which bounced the given
+// number of times, to the outboxes of the other writable endpoints, ending
+// the span tracing its relay
+func (h *Hub) relay(ctx context.Context, i int, m Message, relay *tracing.Span, bounces int) {
+	source := h.Endpoints[i]
+	for j, destination := range h.Endpoints {
+		if j == i || destination.Pumper == source.Pumper || !destination.Direction.Writes() {
+			continue
+		}
+		logger := h.logger(destination, "outbound").WithFields(MessageFields(m)).WithField("source", source.Name)
+		if IsEdit(m) && !CanEdit(destination.Pumper) {
+			logger.Debug("Edit dropped, as the endpoint cannot edit messages")
+			continue
+		}
+		if _, ok := DeletionOf(m); ok && !CanDelete(destination.Pumper) {
+			logger.Debug("Deletion dropped, as the endpoint cannot delete messages")
+			continue
+		}
+		outbound := relay.Child("middleware.outbound").SetAttribute("endpoint", destination.Name)
+		out, err := destination.Outbound.Process(ctx, m)
+		outbound.EndWithError(err)
+		if err != nil {
+			logger.WithError(err).Error("Error processing outbound message")
+		}
+		if out == nil {
+			logger.Debug("Message dropped by the outbound pipeline")
+			metrics.MessagesDropped.Inc(h.Name, destination.Name, "outbound")
+			continue
+		}
+		deliver := relay.Child("deliver").SetAttribute("endpoint", destination.Name).SetAttribute("platform", destination.Platform)
+		if !h.deliver(destination, WithSpan(out, deliver)) {
+			continue
+		}
+		h.Loops.Relayed(j, loopContent(out), bounces)
+		logger.Debug("Message relayed")
+		metrics.MessagesRelayed.Inc(h.Name, source.Name, destination.Name)
+	}
+	relay.End()
+}
+
+// deliver writes m to the outbox of an endpoint without blocking, so an
+// endpoint that stopped writing cannot stall the hub, telling whether it was
+// written. Messages that do not fit are kept as dead letters of the endpoint.
+func (h *Hub) deliver(e Endpoint, m Message) bool {
+	select {
+	case e.Pumper.Outbox() <- m:
+		return true
+	default:
+	}
+	h.logger(e, "outbound").WithFields(MessageFields(m)).Warn("Message dropped, as the outbox is full")
+	SpanOf(m).SetAttribute("dropped", true).End()
+	metrics.MessagesDropped.Inc(h.Name, e.Name, "outbound")
+	if reporter, ok := e.Pumper.(DeadLetterReporter); ok {
+		reporter.DeadLetters().Add(m, errors.New("outbox full"))
+	}
+	return false
+}
+
+// halt pauses the hub until resumed, as a message is bouncing between its
+// endpoints, and tells so in every endpoint
+func (h *Hub) halt(logger *log.Entry) {
+	h.Pause(0)
+	h.Loops.Reset()
+	logger.Error("Bridge halted, as a message is bouncing between its endpoints")
+	h.announce(fmt.Sprintf("Bridge %s halted, as a message is bouncing between its endpoints. "+
+		"Check the bots are not relaying their own messages, then resume it with /cable resume", h.Name))
+}
+
+// logger returns a logger with the fields identifying the hub, the endpoint
+// and the direction messages flow through it
+func (h *Hub) logger(e Endpoint, direction string) *log.Entry {
+	return log.WithFields(log.Fields{
+		"bridge":    h.Name,
+		"endpoint":  e.Name,
+		"platform":  e.Platform,
+		"direction": direction,
+	})
+}
+
+// Stop stops the routing goroutines started by Go, and the pumps of every
+// endpoint. Stopping a hub again does nothing.
+func (h *Hub) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+		for _, e := range h.Endpoints {
+			if e.Direction.Reads() {
+				if receiver, ok := e.Pumper.(CommandReceiver); ok {
+					receiver.SetCommandHandler(nil)
+				}
+				e.Pumper.StopRead()
+			}
+			if e.Direction.Writes() {
+				e.Pumper.StopWrite()
+				metrics.OutboxDepth.Delete(h.Name, e.Name)
+			}
+		}
+	})
+}
+
+// loopContent returns the content of m the loops are detected by, which is
+// none for events, as they quote the messages pinned and the topics set
+// rather than bounce
+func loopContent(m Message) string {
+	if _, ok := EventOf(m); ok {
+		return ""
+	}
+	return m.Content()
+}
@@ -0,0 +1,384 @@
+package cable
+
+import (
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/miguelff/cable/cable/tracing"
+	. "github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHub(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+	readOnly := newFakePumper()
+	writeOnly := newFakePumper()
+
+	hub := NewHub("test",
+		Endpoint{Name: "slack", Pumper: slack},
+		Endpoint{Name: "telegram", Pumper: telegram},
+		Endpoint{Name: "read-only", Pumper: readOnly, Direction: ReadOnly},
+		Endpoint{Name: "write-only", Pumper: writeOnly, Direction: WriteOnly},
+	)
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- &fakeMessage{text: "Fed into slack"}
+	Equal(t, "Fed into slack", (<-telegram.Outbox()).String())
+	Equal(t, "Fed into slack", (<-writeOnly.Outbox()).String())
+
+	readOnly.Inbox() <- &fakeMessage{text: "Fed into read-only"}
+	Equal(t, "Fed into read-only", (<-slack.Outbox()).String())
+	Equal(t, "Fed into read-only", (<-telegram.Outbox()).String())
+	Equal(t, "Fed into read-only", (<-writeOnly.Outbox()).String())
+
+	writeOnly.Inbox() <- &fakeMessage{text: "Fed into write-only"}
+
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 1, len(writeOnly.Inbox()), "write-only endpoints are never read")
+	Equal(t, 0, len(slack.Outbox()), "messages are not relayed back to their source")
+	Equal(t, 0, len(telegram.Outbox()))
+	Equal(t, 0, len(readOnly.Outbox()), "read-only endpoints are never written")
+}
+
+func TestHub_Pipelines(t *testing.T) {
+	metrics.DefaultRegistry.Reset()
+	slack := newFakePumper()
+	telegram := newFakePumper()
+	email := newFakePumper()
+
+	hub := NewHub("pipelines",
+		Endpoint{Name: "slack", Pumper: slack, Inbound: Pipeline{Prefix("slack: ")}},
+		Endpoint{Name: "telegram", Pumper: telegram, Outbound: Pipeline{Suffix(" (via cable)")}},
+		Endpoint{Name: "email", Pumper: email, Outbound: Pipeline{DropFromBots()}},
+	)
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- &fakeMessage{text: "Sup Jay!", bot: true}
+	Equal(t, "slack: Sup Jay! (via cable)", (<-telegram.Outbox()).String())
+
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 0, len(email.Outbox()), "the outbound pipeline of email dropped the message")
+	Equal(t, float64(1), metrics.MessagesRelayed.Value("pipelines", "slack", "telegram"))
+	Equal(t, float64(1), metrics.MessagesDropped.Value("pipelines", "email", "outbound"))
+}
+
+func TestHub_FullOutbox(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+	email := newFakePumper()
+	hub := NewHub("full", Endpoint{Name: "slack", Pumper: slack}, Endpoint{Name: "telegram", Pumper: telegram}, Endpoint{Name: "email", Pumper: email})
+	hub.Go()
+
+	for i := 0; i < DefaultBufferSize; i++ {
+		telegram.Outbox() <- &fakeMessage{text: "stuck"}
+	}
+	slack.Inbox() <- &fakeMessage{text: "Sup Jay!"}
+	Equal(t, "Sup Jay!", (<-email.Outbox()).String(), "a full outbox does not stall the rest")
+	Equal(t, 1, telegram.DeadLetters().Len(), "messages that do not fit are kept as dead letters")
+
+	hub.Stop()
+	NotPanics(t, hub.Stop, "stopping a hub again does nothing")
+}
+
+type recordingExporter struct {
+	mutex sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) Export(spans []tracing.SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestHub_Tracing(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracing.Start(exporter)
+	defer tracing.Stop()
+
+	slack := newFakePumper()
+	telegram := newFakePumper()
+	hub := NewHub("tracing",
+		Endpoint{Name: "slack", Platform: "slack", Pumper: slack},
+		Endpoint{Name: "telegram", Platform: "telegram", Pumper: telegram, Outbound: Pipeline{Suffix(" (via cable)")}},
+	)
+	hub.Go()
+	defer hub.Stop()
+
+	root := tracing.StartTrace("relay")
+	slack.Inbox() <- &fakeMessage{text: "Sup Jay!", span: root}
+	out := <-telegram.Outbox()
+	Equal(t, "Sup Jay! (via cable)", out.String())
+	deliver := SpanOf(out)
+	NotNil(t, deliver)
+	deliver.End()
+
+	time.Sleep(50 * time.Millisecond)
+	tracing.Flush()
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+
+	spans := make(map[string]tracing.SpanData)
+	for _, span := range exporter.spans {
+		spans[span.Name] = span
+	}
+	relay := spans["relay"]
+	Equal(t, "tracing", relay.Attributes["bridge"])
+	Equal(t, "slack", relay.Attributes["source"])
+	for _, name := range []string{"middleware.inbound", "middleware.outbound", "deliver"} {
+		Equal(t, relay.SpanID, spans[name].ParentSpanID, name)
+		Equal(t, relay.TraceID, spans[name].TraceID, name)
+	}
+	Equal(t, "telegram", spans["deliver"].Attributes["platform"])
+}
+
+func TestParseDirection(t *testing.T) {
+	for _, d := range []Direction{ReadWrite, ReadOnly, WriteOnly} {
+		parsed, err := ParseDirection(d.String())
+		Nil(t, err)
+		Equal(t, d, parsed)
+	}
+
+	parsed, _ := ParseDirection("")
+	Equal(t, ReadWrite, parsed)
+
+	_, err := ParseDirection("sideways")
+	Error(t, err)
+}
+
+func TestHub_Loops(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+
+	hub := NewHub("test", Endpoint{Name: "slack", Pumper: slack}, Endpoint{Name: "telegram", Pumper: telegram})
+	hub.Go()
+	defer hub.Stop()
+
+	// each endpoint echoes back what is written to it
+	slack.Inbox() <- &fakeMessage{text: "Sup Jay, how are you?"}
+	telegram.Inbox() <- &fakeMessage{text: "will: " + (<-telegram.Outbox()).String()}
+	slack.Inbox() <- &fakeMessage{text: "jazz: " + (<-slack.Outbox()).String()}
+	telegram.Inbox() <- &fakeMessage{text: "will: " + (<-telegram.Outbox()).String()}
+
+	halted := "Bridge test halted, as a message is bouncing between its endpoints. Check the bots are not relaying their own messages, then resume it with /cable resume"
+	Equal(t, halted, (<-slack.Outbox()).String())
+	Equal(t, halted, (<-telegram.Outbox()).String())
+	until, paused := hub.PausedUntil()
+	True(t, paused)
+	True(t, until.IsZero(), "the hub is paused until resumed")
+}
+
+func TestHub_Provenance(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+
+	hub := NewHub("test", Endpoint{Name: "slack", Pumper: slack}, Endpoint{Name: "telegram", Pumper: telegram})
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- &fakeMessage{text: "Written by this cable", provenance: Provenance{Origin: InstanceID, Hops: 1}}
+	slack.Inbox() <- &fakeMessage{text: "Relayed too many times", provenance: Provenance{Origin: "other", Hops: DefaultMaxHops}}
+	slack.Inbox() <- &fakeMessage{text: "Relayed by another cable", provenance: Provenance{Origin: "other", Hops: 1}}
+	Equal(t, "Relayed by another cable", (<-telegram.Outbox()).String())
+
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 0, len(telegram.Outbox()))
+}
+
+func TestHub_Batching(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+
+	hub := NewHub("test", Endpoint{Name: "slack", Pumper: slack}, Endpoint{Name: "telegram", Pumper: telegram})
+	hub.Batching = &Batching{Window: 100 * time.Millisecond, ByAuthor: true}
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- identifiedMessage{fakeMessage{text: "one", author: "jazz"}, "1"}
+	slack.Inbox() <- identifiedMessage{fakeMessage{text: "two", author: "jazz"}, "2"}
+	slack.Inbox() <- identifiedMessage{fakeMessage{text: "three", author: "will"}, "3"}
+	Equal(t, "one\ntwo", (<-telegram.Outbox()).String(), "the batch ends when someone else writes")
+	Equal(t, "three", (<-telegram.Outbox()).String(), "the batch ends after its window")
+	batched, ok := hub.Batches.Get("2")
+	True(t, ok)
+	Equal(t, "1", batched.ID())
+	_, ok = hub.Batches.Get("3")
+	False(t, ok, "single messages are not batched")
+
+}
+
+func TestHub_Batching_All(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+
+	hub := NewHub("test", Endpoint{Name: "slack", Pumper: slack}, Endpoint{Name: "telegram", Pumper: telegram})
+	hub.Batching = &Batching{Window: 100 * time.Millisecond}
+	hub.Go()
+	defer hub.Stop()
+
+	telegram.Inbox() <- &fakeMessage{text: "one", author: "jazz"}
+	telegram.Inbox() <- &fakeMessage{text: "two", author: "will"}
+	Equal(t, "one\ntwo", (<-slack.Outbox()).String())
+	Equal(t, 0, len(slack.Outbox()))
+}
+
+func TestHub_Notices(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+	readOnly := newFakePumper()
+
+	hub := NewHub("test",
+		Endpoint{Name: "slack", Pumper: slack, Inbound: Pipeline{RedactSecrets(RedactionPolicy{})}},
+		Endpoint{Name: "telegram", Pumper: telegram},
+		Endpoint{Name: "read-only", Pumper: readOnly, Direction: ReadOnly, Inbound: Pipeline{RedactSecrets(RedactionPolicy{})}},
+	)
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- &fakeMessage{text: "mail me at will@example.com", author: "freshprince"}
+	Equal(t, "mail me at [redacted email]", (<-telegram.Outbox()).String())
+	Equal(t, "⚠️ @freshprince: your message was relayed with sensitive information redacted (1 email)", (<-slack.Outbox()).String())
+
+	readOnly.Inbox() <- &fakeMessage{text: "mail me at will@example.com", author: "freshprince"}
+	<-slack.Outbox()
+	<-telegram.Outbox()
+
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 0, len(readOnly.Outbox()), "notices are not written to read-only endpoints")
+}
+
+// editMessage is a fake message editing the message read before with its ID
+type editMessage struct {
+	identifiedMessage
+}
+
+func (m editMessage) IsEdit() bool {
+	return true
+}
+
+func (m editMessage) WithSpan(span *tracing.Span) Message {
+	m.span = span
+	return m
+}
+
+// editingPumper is a fake pumper that can edit the messages it wrote
+type editingPumper struct {
+	*fakePumper
+}
+
+func (p editingPumper) CanEdit() bool {
+	return true
+}
+
+func TestHub_Edits(t *testing.T) {
+	slack := newFakePumper()
+	telegram := editingPumper{newFakePumper()}
+	email := newFakePumper()
+
+	hub := NewHub("test",
+		Endpoint{Name: "slack", Pumper: slack},
+		Endpoint{Name: "telegram", Pumper: telegram},
+		Endpoint{Name: "email", Pumper: email, Direction: WriteOnly},
+	)
+	hub.Batching = &Batching{Window: 100 * time.Millisecond, ByAuthor: true}
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- identifiedMessage{fakeMessage{text: "one", author: "jazz"}, "1"}
+	slack.Inbox() <- identifiedMessage{fakeMessage{text: "two", author: "jazz"}, "2"}
+	Equal(t, "one\ntwo", (<-telegram.Outbox()).String())
+	Equal(t, "one\ntwo", (<-email.Outbox()).String())
+
+	slack.Inbox() <- editMessage{identifiedMessage{fakeMessage{text: "two, edited", author: "jazz"}, "2"}}
+	edited := <-telegram.Outbox()
+	Equal(t, "one\ntwo, edited", edited.String(), "edits update the batch the message went into, right away")
+	True(t, IsEdit(edited))
+	Equal(t, "1", IDOf(edited))
+
+	slack.Inbox() <- editMessage{identifiedMessage{fakeMessage{text: "three, edited", author: "will"}, "3"}}
+	edited = <-telegram.Outbox()
+	Equal(t, "three, edited", edited.String())
+	True(t, IsEdit(edited))
+
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 0, len(email.Outbox()), "edits are not relayed to endpoints that cannot edit messages")
+}
+
+type deletingPumper struct {
+	editingPumper
+}
+
+func (p deletingPumper) CanDelete() bool {
+	return true
+}
+
+func TestHub_Deletions(t *testing.T) {
+	slack := newFakePumper()
+	telegram := deletingPumper{editingPumper{newFakePumper()}}
+	email := editingPumper{newFakePumper()}
+
+	hub := NewHub("test",
+		Endpoint{Name: "slack", Pumper: slack},
+		Endpoint{Name: "telegram", Pumper: telegram},
+		Endpoint{Name: "email", Pumper: email, Direction: WriteOnly},
+	)
+	hub.Batching = &Batching{Window: 100 * time.Millisecond, ByAuthor: true}
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- identifiedMessage{fakeMessage{text: "one", author: "jazz"}, "1"}
+	slack.Inbox() <- identifiedMessage{fakeMessage{text: "two", author: "jazz"}, "2"}
+	Equal(t, "one\ntwo", (<-telegram.Outbox()).String())
+	<-email.Outbox()
+
+	slack.Inbox() <- NewDeletion("slack", "", "1")
+	edited := <-telegram.Outbox()
+	Equal(t, "two", edited.String(), "deletions update the batch the message went into, right away")
+	True(t, IsEdit(edited))
+	Equal(t, "1", IDOf(edited))
+	Equal(t, "two", (<-email.Outbox()).String(), "updated batches are edits")
+
+	slack.Inbox() <- NewDeletion("slack", "", "2")
+	deletion, ok := DeletionOf(<-telegram.Outbox())
+	True(t, ok, "deleting every part of a batch deletes it")
+	Equal(t, "1", deletion.ID())
+
+	slack.Inbox() <- NewDeletion("slack", "", "3")
+	deletion, _ = DeletionOf(<-telegram.Outbox())
+	Equal(t, "3", deletion.ID())
+
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 0, len(email.Outbox()), "deletions are not relayed to endpoints that cannot delete messages")
+}
+
+func TestHub_Events(t *testing.T) {
+	slack := newFakePumper()
+	telegram := newFakePumper()
+
+	hub := NewHub("test",
+		Endpoint{Name: "slack", Pumper: slack},
+		Endpoint{Name: "telegram", Pumper: telegram},
+	)
+	hub.Batching = &Batching{Window: 50 * time.Millisecond}
+	hub.Loops = NewLoopDetector(1, time.Minute)
+	hub.Go()
+	defer hub.Stop()
+
+	slack.Inbox() <- &fakeMessage{text: "chill out, max and relax", author: "will"}
+	<-telegram.Outbox()
+
+	pin := NewEvent(EventPin, "telegram", "Will")
+	pin.Text = "chill out, max and relax"
+	telegram.Inbox() <- &fakeMessage{text: "max and relax", author: "will"}
+	telegram.Inbox() <- pin
+	relayed, ok := EventOf(<-slack.Outbox())
+	True(t, ok, "events are relayed right away instead of batched")
+	Equal(t, "📌 Will pinned a message on telegram: chill out, max and relax", relayed.String())
+	Equal(t, "max and relax", (<-slack.Outbox()).String())
+	_, paused := hub.PausedUntil()
+	False(t, paused, "events quoting the messages relayed are no loops")
+}
@@ -0,0 +1,88 @@
+package cable
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/* Section: relaying images, like stickers */
+
+// DefaultConversionTimeout is the time an FFmpegConverter waits for a
+// conversion unless told otherwise
+const DefaultConversionTimeout = 30 * time.Second
+
+// Image is a picture shared in a message, like a sticker
+type Image struct {
+	// Filename is the name of the file, with the extension of its format
+	Filename string
+	// MimeType is the media type of the file, like "image/png"
+	MimeType string
+	// AltText describes the picture, like the emoji a sticker stands for
+	AltText string
+	// Content is the picture
+	Content []byte
+}
+
+// Illustrated is implemented by the messages carrying pictures, which
+// platforms able to upload files share as files along with the text of the
+// message
+type Illustrated interface {
+	// Images returns the pictures carried by the message
+	Images() []Image
+}
+
+// ImagesOf returns the pictures carried by m, which are none if it cannot
+// carry them
+func ImagesOf(m Message) []Image {
+	if illustrated, ok := m.(Illustrated); ok {
+		return illustrated.Images()
+	}
+	return nil
+}
+
+// ImageConverter converts pictures and animations into PNG images, which
+// every platform shows
+type ImageConverter interface {
+	// ToPNG converts the picture in the file with the given name and content
+	// into a PNG image, of the first frame of animations
+	ToPNG(filename string, content []byte) ([]byte, error)
+}
+
+// FFmpegConverter converts pictures and animations, like the WebP and WebM
+// stickers of telegram, into PNG images with ffmpeg
+type FFmpegConverter struct {
+	// FFmpeg is the path of the ffmpeg executable
+	FFmpeg string
+	// Timeout is the time to wait for a conversion, or zero to wait up to
+	// DefaultConversionTimeout
+	Timeout time.Duration
+}
+
+// ToPNG writes the picture to a temporary file, and returns the PNG image
+// ffmpeg converts its first frame into
+func (c *FFmpegConverter) ToPNG(filename string, content []byte) ([]byte, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultConversionTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "cable-image")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input"+filepath.Ext(filename))
+	if err := ioutil.WriteFile(input, content, 0600); err != nil {
+		return nil, err
+	}
+	output := filepath.Join(dir, "output.png")
+	if _, err := run(ctx, c.FFmpeg, "-nostdin", "-loglevel", "error", "-i", input, "-frames:v", "1", "-c:v", "png", output); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(output)
+}
@@ -0,0 +1,45 @@
+package cable
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// illustratedMessage is a fake message carrying pictures
+type illustratedMessage struct {
+	fakeMessage
+	images []Image
+}
+
+func (m *illustratedMessage) Images() []Image {
+	return m.images
+}
+
+func TestImagesOf(t *testing.T) {
+	combined := Combine(
+		&fakeMessage{text: "no images"},
+		&illustratedMessage{images: []Image{{Filename: "sticker.png", AltText: "😂", Content: []byte("PNG")}}},
+	)
+	Equal(t, []Image{{Filename: "sticker.png", AltText: "😂", Content: []byte("PNG")}}, ImagesOf(combined))
+	Nil(t, ImagesOf(&fakeMessage{text: "no images"}))
+}
+
+func TestFFmpegConverter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ffmpeg")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the fake ffmpeg writes its arguments, but the files, followed by the
+	// input, after -i, to the output, the last argument
+	ffmpeg := script(t, dir, "ffmpeg", `for out; do :; done; args=""; for a; do case "$prev" in -i) in="$a";; *) [ "$a" != "$out" ] && args="$args $a";; esac; prev="$a"; done; { echo "$args"; cat "$in"; } > "$out"`)
+	converter := &FFmpegConverter{FFmpeg: ffmpeg}
+	png, err := converter.ToPNG("sticker.webp", []byte("WEBP"))
+	NoError(t, err)
+	Equal(t, " -nostdin -loglevel error -i -frames:v 1 -c:v png\nWEBP", string(png))
+
+	converter = &FFmpegConverter{FFmpeg: script(t, dir, "broken", `echo "Invalid data found when processing input" >&2; exit 1`)}
+	_, err = converter.ToPNG("sticker.tgs", []byte("TGS"))
+	EqualError(t, err, "broken: exit status 1: Invalid data found when processing input")
+}
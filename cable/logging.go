@@ -0,0 +1,78 @@
+package cable
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"time"
+)
+
+// LogMessageBodies tells whether the content of messages is logged along
+// with their metadata. It is false by default, so the conversations relayed
+// by cable do not end up in its logs.
+var LogMessageBodies = false
+
+// Identifiable is implemented by the messages that have an ID in the
+// platform they were read from, like the timestamp of slack messages
+type Identifiable interface {
+	ID() string
+}
+
+// IDOf returns the ID of m in the platform it was read from, which is empty if
+// unknown
+func IDOf(m Message) string {
+	if identifiable, ok := m.(Identifiable); ok {
+		return identifiable.ID()
+	}
+	return ""
+}
+
+// ConfigureLogging sets the level ("debug", "info", "warning", "error"...)
+// and the format ("text" or "json") of the logs written to out. Empty values
+// default to info and text.
+func ConfigureLogging(out io.Writer, level string, format string) error {
+	if level == "" {
+		level = "info"
+	}
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	var formatter log.Formatter
+	switch strings.ToLower(format) {
+	case "", "text":
+		formatter = &log.TextFormatter{}
+	case "json":
+		formatter = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("unknown log format %q, use text or json", format)
+	}
+
+	log.SetOutput(out)
+	log.SetLevel(parsed)
+	log.SetFormatter(formatter)
+	return nil
+}
+
+// MessageFields returns the structured fields describing m in logs: its ID,
+// author, length and latency since it was sent, and its content only if
+// LogMessageBodies is set
+func MessageFields(m Message) log.Fields {
+	fields := log.Fields{
+		"author": m.Author(),
+		"bot":    m.FromBot(),
+		"length": len(m.Content()),
+	}
+	if identifiable, ok := m.(Identifiable); ok {
+		fields["message_id"] = identifiable.ID()
+	}
+	if sentAt := m.SentAt(); !sentAt.IsZero() {
+		fields["latency_ms"] = time.Since(sentAt).Nanoseconds() / int64(time.Millisecond)
+	}
+	if LogMessageBodies {
+		fields["body"] = m.Content()
+	}
+	return fields
+}
@@ -0,0 +1,45 @@
+package cable
+
+import (
+	"bytes"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	. "github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	defer ConfigureLogging(os.Stderr, "info", "text")
+
+	var out bytes.Buffer
+	NoError(t, ConfigureLogging(&out, "warning", "json"))
+	log.Info("not logged")
+	log.WithField("bridge", "default").Warn("logged")
+
+	var entry map[string]interface{}
+	NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	Equal(t, "logged", entry["msg"])
+	Equal(t, "warning", entry["level"])
+	Equal(t, "default", entry["bridge"])
+
+	NoError(t, ConfigureLogging(&out, "", ""))
+	Equal(t, log.InfoLevel, log.GetLevel())
+	Error(t, ConfigureLogging(&out, "loud", "text"))
+	Error(t, ConfigureLogging(&out, "info", "xml"))
+}
+
+func TestMessageFields(t *testing.T) {
+	defer func() { LogMessageBodies = false }()
+
+	m := fakeMessage{text: "a secret", author: "alice"}
+	fields := MessageFields(m)
+	Equal(t, "alice", fields["author"])
+	Equal(t, false, fields["bot"])
+	Equal(t, 8, fields["length"])
+	NotContains(t, fields, "body")
+	NotContains(t, fields, "latency_ms")
+
+	LogMessageBodies = true
+	Equal(t, "a secret", MessageFields(m)["body"])
+}
@@ -0,0 +1,126 @@
+package cable
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLoopThreshold is the number of times a message can bounce back
+	// from the endpoints it was relayed to before a hub halts
+	DefaultLoopThreshold = 3
+	// DefaultLoopWindow is how long a relayed message is remembered to tell
+	// whether it bounces back
+	DefaultLoopWindow = time.Minute
+	// loopMemory is the maximum number of messages remembered per endpoint
+	loopMemory = 100
+	// loopMinLength is the length of the shortest content that can bounce,
+	// as short replies like "ok" or "thanks" are often repeated
+	loopMinLength = 12
+)
+
+// LoopDetector tells when a message keeps bouncing between the endpoints of a
+// hub, like when a bot relays back its own messages because its identity is
+// wrong.
+//
+// It remembers the content relayed to each endpoint. A message read from an
+// endpoint bounced if its content is one relayed to the endpoint in the last
+// Window, maybe labeled with the name of its author, and the number of
+// bounces carries on to the messages it is relayed as. Contents are compared
+// normalized, ignoring case, spacing and markdown emphasis, and the ones
+// shorter than loopMinLength never bounce. Every relayed content can only be
+// bounced once, so people repeating what others say do not make a loop.
+type LoopDetector struct {
+	// Threshold is the number of bounces that make a loop
+	Threshold int
+	// Window is how long a relayed message can take to bounce back
+	Window time.Duration
+	// relayed are the contents recently relayed to each endpoint
+	relayed map[int][]relayedContent
+	mutex   sync.Mutex
+}
+
+// relayedContent is the content of a message relayed to an endpoint
+type relayedContent struct {
+	content string
+	bounces int
+	at      time.Time
+}
+
+// NewLoopDetector returns the address of a new LoopDetector
+func NewLoopDetector(threshold int, window time.Duration) *LoopDetector {
+	return &LoopDetector{
+		Threshold: threshold,
+		Window:    window,
+		relayed:   make(map[int][]relayedContent),
+	}
+}
+
+// Read returns how many times a message read from the given endpoint bounced
+// between endpoints, and whether that makes a loop
+func (d *LoopDetector) Read(endpoint int, content string) (int, bool) {
+	content = normalizeLoopContent(content)
+	if d == nil || TextLength(content) < loopMinLength {
+		return 0, false
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	recent := d.recent(endpoint)
+	for i, r := range recent {
+		if content == r.content || strings.HasSuffix(content, ": "+r.content) {
+			d.relayed[endpoint] = append(recent[:i:i], recent[i+1:]...)
+			bounces := r.bounces + 1
+			return bounces, bounces >= d.Threshold
+		}
+	}
+	return 0, false
+}
+
+// Relayed remembers the content of a message relayed to the given endpoint,
+// which bounced the given number of times
+func (d *LoopDetector) Relayed(endpoint int, content string, bounces int) {
+	content = normalizeLoopContent(content)
+	if d == nil || TextLength(content) < loopMinLength {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	recent := append(d.recent(endpoint), relayedContent{content: content, bounces: bounces, at: time.Now()})
+	if len(recent) > loopMemory {
+		recent = recent[len(recent)-loopMemory:]
+	}
+	d.relayed[endpoint] = recent
+}
+
+// Reset forgets every message relayed
+func (d *LoopDetector) Reset() {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.relayed = make(map[int][]relayedContent)
+}
+
+// recent returns the contents relayed to the given endpoint within the
+// window, forgetting older ones
+func (d *LoopDetector) recent(endpoint int) []relayedContent {
+	recent := d.relayed[endpoint]
+	since := time.Now().Add(-d.Window)
+	for len(recent) > 0 && recent[0].at.Before(since) {
+		recent = recent[1:]
+	}
+	d.relayed[endpoint] = recent
+	return recent
+}
+
+// loopEmphasis removes the markdown emphasis the names of authors are often
+// formatted with, like in "*Will:* Sup Jay!"
+var loopEmphasis = strings.NewReplacer("*", "", "_", "", "~", "", "`", "")
+
+// normalizeLoopContent returns the content compared to tell whether it
+// bounced, in lower case, without emphasis and with single spaces
+func normalizeLoopContent(content string) string {
+	return strings.ToLower(strings.Join(strings.Fields(loopEmphasis.Replace(content)), " "))
+}
@@ -0,0 +1,65 @@
+package cable
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLoopDetector(t *testing.T) {
+	detector := NewLoopDetector(3, time.Minute)
+
+	bounces, loop := detector.Read(0, "Sup Jay, how are you?")
+	Equal(t, 0, bounces, "nothing was relayed to the endpoint")
+	False(t, loop)
+	detector.Relayed(1, "Sup Jay, how are you?", bounces)
+
+	bounces, loop = detector.Read(1, "*Will:* Sup Jay, how are you?")
+	Equal(t, 1, bounces, "the message came back formatted")
+	False(t, loop)
+	detector.Relayed(0, "*Will:* Sup Jay, how are you?", bounces)
+
+	bounces, _ = detector.Read(1, "*Will:* Sup Jay, how are you?")
+	Equal(t, 0, bounces, "contents only bounce once")
+
+	bounces, loop = detector.Read(0, "*Cable:* *Will:* Sup Jay, how are you?")
+	Equal(t, 2, bounces)
+	False(t, loop)
+	detector.Relayed(1, "*Cable:* *Will:* Sup Jay, how are you?", bounces)
+
+	bounces, loop = detector.Read(1, "*Cable:* *Cable:* *Will:* Sup Jay, how are you?")
+	Equal(t, 3, bounces)
+	True(t, loop)
+}
+
+func TestLoopDetector_Content(t *testing.T) {
+	detector := NewLoopDetector(1, time.Minute)
+
+	detector.Relayed(1, "thanks", 0)
+	_, loop := detector.Read(1, "will: thanks")
+	False(t, loop, "short contents are often repeated")
+
+	detector.Relayed(1, "Sup Jay, how are you?", 0)
+	_, loop = detector.Read(1, "Sup Jay, how are you? Fine, thanks")
+	False(t, loop, "quoting a message is no bounce")
+	_, loop = detector.Read(1, "*Will:*   sup jay, HOW are you?")
+	True(t, loop, "contents are compared normalized")
+}
+
+func TestLoopDetector_Window(t *testing.T) {
+	detector := NewLoopDetector(1, 20*time.Millisecond)
+	detector.Relayed(0, "Sup Jay, how are you?", 0)
+	time.Sleep(30 * time.Millisecond)
+	_, loop := detector.Read(0, "Sup Jay, how are you?")
+	False(t, loop, "messages are forgotten after the window")
+
+	detector.Relayed(0, "Sup Jay, how are you?", 0)
+	detector.Reset()
+	_, loop = detector.Read(0, "Sup Jay, how are you?")
+	False(t, loop)
+
+	var nilDetector *LoopDetector
+	nilDetector.Relayed(0, "Sup Jay, how are you?", 0)
+	_, loop = nilDetector.Read(0, "Sup Jay, how are you?")
+	False(t, loop)
+}
@@ -2,7 +2,9 @@ package cable
 
 import (
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable/tracing"
 	"github.com/nlopes/slack"
+	"time"
 )
 
 /* TODO: Make message independent of pumper implementations */
@@ -13,4 +15,101 @@ type Message interface {
 	ToSlack() ([]slack.MsgOption, error)
 	ToTelegram(telegramChatID int64) (telegram.MessageConfig, error)
 	String() string
+	// Content returns the text of the message, as written by its author
+	Content() string
+	// WithContent returns a copy of the message with its text replaced by
+	// content, leaving the original message untouched
+	WithContent(content string) Message
+	// Author returns the user name of the author of the message
+	Author() string
+	// FromBot tells whether the message was written by a bot
+	FromBot() bool
+	// SentAt returns the time the author sent the message, or the zero time if
+	// it is unknown
+	SentAt() time.Time
+}
+
+// Traced is implemented by the messages carrying the span of the operation
+// they are going through along the relay path, like waiting in an outbox
+type Traced interface {
+	// Span returns the span carried by the message, or nil if it is not
+	// traced
+	Span() *tracing.Span
+	// WithSpan returns a copy of the message carrying the given span, leaving
+	// the original message untouched
+	WithSpan(span *tracing.Span) Message
+}
+
+// Edit is implemented by the messages telling the new contents of a message
+// read before, like the new votes of a poll, which have the ID of the message
+// they edit
+type Edit interface {
+	// IsEdit tells whether the message edits the message read before with
+	// its ID, instead of being a new message
+	IsEdit() bool
+}
+
+// IsEdit tells whether m edits a message read before
+func IsEdit(m Message) bool {
+	edit, ok := m.(Edit)
+	return ok && edit.IsEdit()
+}
+
+// SpanOf returns the span carried by m, or nil if it is not traced
+func SpanOf(m Message) *tracing.Span {
+	if traced, ok := m.(Traced); ok {
+		return traced.Span()
+	}
+	return nil
+}
+
+// WithSpan returns a copy of m carrying the given span, or m itself if it
+// cannot be traced
+func WithSpan(m Message, span *tracing.Span) Message {
+	if traced, ok := m.(Traced); ok {
+		return traced.WithSpan(span)
+	}
+	return m
+}
+
+// Texted is implemented by the messages carrying texts besides their content,
+// like the snippets they share, which the middlewares rewriting the content,
+// like the redacting ones, rewrite too
+type Texted interface {
+	// Texts returns the texts carried besides the content
+	Texts() []string
+	// WithTexts returns a copy of the message with the texts carried besides
+	// its content replaced by texts, in the same order, leaving the original
+	// message untouched
+	WithTexts(texts []string) Message
+}
+
+// TextsOf returns the texts m carries besides its content, which are none if
+// it cannot carry them
+func TextsOf(m Message) []string {
+	if texted, ok := m.(Texted); ok {
+		return texted.Texts()
+	}
+	return nil
+}
+
+// RewriteTexts returns a copy of m with its content, and the texts it carries
+// besides it, replaced by the result of rewrite, or m itself if none changes
+func RewriteTexts(m Message, rewrite func(text string) string) Message {
+	content := rewrite(m.Content())
+	changed := content != m.Content()
+	texts := TextsOf(m)
+	rewritten := make([]string, len(texts))
+	for i, text := range texts {
+		rewritten[i] = rewrite(text)
+		changed = changed || rewritten[i] != text
+	}
+	if !changed {
+		return m
+	}
+	m = m.WithContent(content)
+	if texted, ok := m.(Texted); ok && len(texts) > 0 {
+		m = texted.WithTexts(rewritten)
+	}
+	return m
 }
@@ -0,0 +1,65 @@
+package metrics
+
+import "time"
+
+// The metrics below are the ones exposed by cable. Platform labels are the
+// names of the adapters ("slack", "telegram", "email"), while bridge and
+// endpoint labels are the names given to hubs and their endpoints.
+var (
+	// MessagesRead counts the messages read from each platform
+	MessagesRead = NewCounterVec("cable_messages_read_total", "Messages read from each platform.", "platform")
+	// MessagesRelayed counts the messages relayed between the endpoints of
+	// each bridge
+	MessagesRelayed = NewCounterVec("cable_messages_relayed_total", "Messages relayed between the endpoints of a bridge.", "bridge", "from", "to")
+	// MessagesDropped counts the messages dropped by the middlewares of each
+	// bridge endpoint, in each direction ("inbound" or "outbound")
+	MessagesDropped = NewCounterVec("cable_messages_dropped_total", "Messages dropped by the middlewares of a bridge endpoint.", "bridge", "endpoint", "direction")
+	// MessagesFailed counts the messages that could not be written to each
+	// platform
+	MessagesFailed = NewCounterVec("cable_messages_failed_total", "Messages that could not be written to a platform.", "platform")
+	// MessagesCoalesced counts the messages combined into one before being
+	// written to each platform, as too many were queued
+	MessagesCoalesced = NewCounterVec("cable_messages_coalesced_total", "Messages combined into one before being written to a platform.", "platform")
+	// ConversionErrors counts the errors converting messages to the
+	// representation of each platform
+	ConversionErrors = NewCounterVec("cable_conversion_errors_total", "Errors converting messages to the representation of a platform.", "platform")
+	// DeliveryLatency measures the time since a message is sent by its author
+	// until it is written to each platform
+	DeliveryLatency = NewHistogramVec("cable_delivery_latency_seconds", "Time since a message is sent by its author until it is written to a platform.", DefaultBuckets, "platform")
+	// OutboxDepth reports the number of messages waiting in the outbox of
+	// each bridge endpoint
+	OutboxDepth = NewGaugeVec("cable_outbox_depth", "Messages waiting in the outbox of a bridge endpoint.", "bridge", "endpoint")
+	// APICallDuration measures the duration of the calls to the API of each
+	// platform, by API method
+	APICallDuration = NewHistogramVec("cable_api_call_duration_seconds", "Duration of the calls to the API of a platform.", DefaultBuckets, "platform", "method")
+	// RateLimitHits counts the calls to the API of each platform rejected
+	// because of rate limits
+	RateLimitHits = NewCounterVec("cable_rate_limit_hits_total", "Calls to the API of a platform rejected because of rate limits.", "platform", "method")
+	// Reconnects counts the times the connection used to read messages from
+	// each platform was established again after failing
+	Reconnects = NewCounterVec("cable_reconnects_total", "Reconnections of the connection used to read messages from a platform.", "platform")
+)
+
+// ObserveDeliveryLatency measures the delivery latency of a message written to
+// the given platform, unless the time it was sent is unknown
+func ObserveDeliveryLatency(platform string, sentAt time.Time) {
+	if !sentAt.IsZero() {
+		DeliveryLatency.Observe(time.Since(sentAt).Seconds(), platform)
+	}
+}
+
+func init() {
+	DefaultRegistry.Register(
+		MessagesRead,
+		MessagesRelayed,
+		MessagesDropped,
+		MessagesFailed,
+		MessagesCoalesced,
+		ConversionErrors,
+		DeliveryLatency,
+		OutboxDepth,
+		APICallDuration,
+		RateLimitHits,
+		Reconnects,
+	)
+}
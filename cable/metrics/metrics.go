@@ -0,0 +1,333 @@
+// Package metrics implements the subset of Prometheus metric types cable
+// needs (counters, gauges and histograms with labels) and exposes them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets used by the
+// histograms measuring durations
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Collector is implemented by the metrics that can be exposed by a Registry
+type Collector interface {
+	// Collect writes the metric in the Prometheus text exposition format
+	Collect(w io.Writer)
+}
+
+// Registry is a collection of metrics exposed together
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []Collector
+}
+
+// DefaultRegistry is the registry holding cable's metrics
+var DefaultRegistry = &Registry{}
+
+// Register adds the given collectors to the registry
+func (r *Registry) Register(collectors ...Collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// Collect writes every metric in the registry in the Prometheus text
+// exposition format
+func (r *Registry) Collect(w io.Writer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, c := range r.collectors {
+		c.Collect(w)
+	}
+}
+
+// Reset clears the counters and histograms in the registry, so tests count
+// from zero. Gauges are kept, as they report the current state.
+func (r *Registry) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, c := range r.collectors {
+		if resetter, ok := c.(interface{ Reset() }); ok {
+			resetter.Reset()
+		}
+	}
+}
+
+// Handler returns an http.Handler exposing the metrics of the default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		DefaultRegistry.Collect(w)
+	})
+}
+
+/* Section: labelled series */
+
+// labelValueEscaper escapes label values as required by the exposition format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// series is a set of values of a metric, indexed by their label values
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mutex  sync.Mutex
+	values map[string][]string
+}
+
+// newSeries returns the address of a new value of series
+func newSeries(name, help, kind string, labels []string) *series {
+	return &series{name: name, help: help, kind: kind, labels: labels, values: make(map[string][]string)}
+}
+
+// key returns the key used to index the given label values, remembering
+// them for later output. It panics if the number of values does not match the
+// number of labels, which is a programming error.
+func (s *series) key(labelValues []string) string {
+	if len(labelValues) != len(s.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", s.name, len(s.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	if _, ok := s.values[key]; !ok {
+		s.values[key] = labelValues
+	}
+	return key
+}
+
+// sortedKeys returns the keys of the series in a stable order
+func (s *series) sortedKeys() []string {
+	var keys []string
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// header writes the HELP and TYPE lines of the metric
+func (s *series) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", s.name, s.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", s.name, s.kind)
+}
+
+// labelPairs formats the labels of the series with the given key, plus any
+// extra label pairs given, like {platform="slack",le="0.5"}
+func (s *series) labelPairs(key string, extra ...string) string {
+	var pairs []string
+	for i, value := range s.values[key] {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, s.labels[i], labelValueEscaper.Replace(value)))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelValueEscaper.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// formatFloat formats a value as expected by the exposition format
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+/* Section: counters */
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	*series
+	counts map[string]float64
+}
+
+// NewCounterVec returns the address of a new CounterVec with the given labels
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{series: newSeries(name, help, "counter", labels), counts: make(map[string]float64)}
+}
+
+// Inc increments by one the counter with the given label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add increments by delta the counter with the given label values
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[c.key(labelValues)] += delta
+}
+
+// Value returns the current value of the counter with the given label values
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.counts[strings.Join(labelValues, "\xff")]
+}
+
+// Reset removes every counter
+func (c *CounterVec) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values = make(map[string][]string)
+	c.counts = make(map[string]float64)
+}
+
+// Collect writes the counters in the Prometheus text exposition format
+func (c *CounterVec) Collect(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.header(w)
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(key), formatFloat(c.counts[key]))
+	}
+}
+
+/* Section: gauges */
+
+// GaugeVec is a set of gauges partitioned by label values. Gauges are either
+// set explicitly or computed by a function every time they are collected.
+type GaugeVec struct {
+	*series
+	funcs map[string]func() float64
+}
+
+// NewGaugeVec returns the address of a new GaugeVec with the given labels
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{series: newSeries(name, help, "gauge", labels), funcs: make(map[string]func() float64)}
+}
+
+// Set sets the value of the gauge with the given label values
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.Track(func() float64 { return value }, labelValues...)
+}
+
+// Track makes the gauge with the given label values report the result of
+// calling fn every time it is collected
+func (g *GaugeVec) Track(fn func() float64, labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.funcs[g.key(labelValues)] = fn
+}
+
+// Delete removes the gauge with the given label values, which is no longer
+// reported
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	delete(g.funcs, key)
+	delete(g.values, key)
+}
+
+// Value returns the current value of the gauge with the given label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if fn, ok := g.funcs[strings.Join(labelValues, "\xff")]; ok {
+		return fn()
+	}
+	return 0
+}
+
+// Collect writes the gauges in the Prometheus text exposition format
+func (g *GaugeVec) Collect(w io.Writer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.header(w)
+	for _, key := range g.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelPairs(key), formatFloat(g.funcs[key]()))
+	}
+}
+
+/* Section: histograms */
+
+// histogram holds the observations of a single series of a HistogramVec
+type histogram struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	*series
+	bounds     []float64
+	histograms map[string]*histogram
+}
+
+// NewHistogramVec returns the address of a new HistogramVec with the given
+// bucket upper bounds and labels
+func NewHistogramVec(name, help string, bounds []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		series:     newSeries(name, help, "histogram", labels),
+		bounds:     bounds,
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// Observe adds an observation to the histogram with the given label values
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := h.key(labelValues)
+	hist, ok := h.histograms[key]
+	if !ok {
+		hist = &histogram{buckets: make([]uint64, len(h.bounds))}
+		h.histograms[key] = hist
+	}
+	for i, bound := range h.bounds {
+		if value <= bound {
+			hist.buckets[i]++
+		}
+	}
+	hist.sum += value
+	hist.count++
+}
+
+// Count returns the number of observations of the histogram with the given
+// label values
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if hist, ok := h.histograms[strings.Join(labelValues, "\xff")]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+// Reset removes every histogram
+func (h *HistogramVec) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.values = make(map[string][]string)
+	h.histograms = make(map[string]*histogram)
+}
+
+// Collect writes the histograms in the Prometheus text exposition format
+func (h *HistogramVec) Collect(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.header(w)
+	for _, key := range h.sortedKeys() {
+		hist := h.histograms[key]
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", formatFloat(bound)), hist.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(key), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(key), hist.count)
+	}
+}
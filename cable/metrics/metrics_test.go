@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	. "github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry_Collect(t *testing.T) {
+	counter := NewCounterVec("test_messages_total", "Messages.", "platform")
+	counter.Inc("slack")
+	counter.Add(2, "telegram")
+	counter.Inc("slack")
+
+	gauge := NewGaugeVec("test_depth", "Depth.", "endpoint")
+	depth := 3
+	gauge.Track(func() float64 { return float64(depth) }, `sl"ack`)
+	depth = 5
+
+	histogram := NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1})
+	histogram.Observe(0.05)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+
+	registry := &Registry{}
+	registry.Register(counter, gauge, histogram)
+
+	var buf bytes.Buffer
+	registry.Collect(&buf)
+
+	expected := `# HELP test_messages_total Messages.
+# TYPE test_messages_total counter
+test_messages_total{platform="slack"} 2
+test_messages_total{platform="telegram"} 2
+# HELP test_depth Depth.
+# TYPE test_depth gauge
+test_depth{endpoint="sl\"ack"} 5
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{le="0.1"} 1
+test_latency_seconds_bucket{le="1"} 2
+test_latency_seconds_bucket{le="+Inf"} 3
+test_latency_seconds_sum 2.55
+test_latency_seconds_count 3
+`
+	Equal(t, expected, buf.String())
+	Equal(t, float64(2), counter.Value("telegram"))
+	Equal(t, float64(5), gauge.Value(`sl"ack`))
+	Equal(t, uint64(3), histogram.Count())
+}
+
+func TestCounterVec_WrongLabels(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test.", "platform")
+	Panics(t, func() { counter.Inc("slack", "extra") })
+}
+
+func TestHandler(t *testing.T) {
+	MessagesRead.Inc("slack")
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `cable_messages_read_total{platform="slack"}`)
+	Contains(t, recorder.Body.String(), "# TYPE cable_delivery_latency_seconds histogram")
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"net/http"
+	"path"
+	"time"
+)
+
+// Transport is an http.RoundTripper measuring the calls made to the API of a
+// platform. The API method is taken from the last segment of the URL path,
+// which is how both the slack and telegram APIs are laid out.
+type Transport struct {
+	// Platform is the value of the platform label of the metrics
+	Platform string
+	// PollingMethod, when set, is the API method used to poll for messages.
+	// Failed calls to it are counted as reconnections, as clients retry them.
+	PollingMethod string
+	// Base is the RoundTripper making the actual calls. When nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// NewHTTPClient returns an http.Client measuring the calls made to the API of
+// the given platform
+func NewHTTPClient(platform string, pollingMethod string) *http.Client {
+	return &http.Client{Transport: &Transport{Platform: platform, PollingMethod: pollingMethod}}
+}
+
+// RoundTrip makes the call with the base RoundTripper, measuring its duration
+// and whether it was rejected because of rate limits
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	method := path.Base(req.URL.Path)
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	APICallDuration.Observe(time.Since(start).Seconds(), t.Platform, method)
+
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		RateLimitHits.Inc(t.Platform, method)
+	}
+	if method == t.PollingMethod && (err != nil || resp.StatusCode != http.StatusOK) {
+		Reconnects.Inc(t.Platform)
+	}
+	return resp, err
+}
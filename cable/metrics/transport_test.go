@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	DefaultRegistry.Reset()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/chat.postMessage":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/bot123/getUpdates":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient("transport-test", "getUpdates")
+	for _, path := range []string{"/api/chat.postMessage", "/api/users.list", "/bot123/getUpdates"} {
+		resp, err := client.Get(server.URL + path)
+		Nil(t, err)
+		resp.Body.Close()
+	}
+
+	Equal(t, uint64(1), APICallDuration.Count("transport-test", "chat.postMessage"))
+	Equal(t, uint64(1), APICallDuration.Count("transport-test", "users.list"))
+	Equal(t, float64(1), RateLimitHits.Value("transport-test", "chat.postMessage"))
+	Equal(t, float64(0), RateLimitHits.Value("transport-test", "users.list"))
+	Equal(t, float64(1), Reconnects.Value("transport-test"))
+}
@@ -0,0 +1,270 @@
+package cable
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Middleware processes a message on its way from the inbox of an endpoint to
+// the outbox of another. It returns the message to relay, which can be the
+// same message, a transformed copy of it, or nil to drop it.
+//
+// Returning an error other than ErrStopPropagation drops the message.
+type Middleware func(ctx context.Context, m Message) (Message, error)
+
+// ErrStopPropagation can be returned by a middleware, together with a
+// message, to relay that message right away, skipping the rest of middlewares
+// in the pipeline
+var ErrStopPropagation = errors.New("stop propagation")
+
+// Pipeline is a chain of middlewares, applied in order
+type Pipeline []Middleware
+
+// Process runs the message through the middlewares of the pipeline, returning
+// the message to relay, or nil if it has to be dropped
+func (p Pipeline) Process(ctx context.Context, m Message) (Message, error) {
+	for _, middleware := range p {
+		var err error
+		m, err = middleware(ctx, m)
+		if err == ErrStopPropagation {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			return nil, nil
+		}
+	}
+	return m, nil
+}
+
+/* Section: built-in middlewares */
+
+// Common patterns of sensitive information, to use with Redact
+var (
+	// EmailPattern matches email addresses
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// PhonePattern matches phone numbers with an international prefix, like
+	// +34 600 123 456, or grouped like national ones, like (555) 123-4567, so
+	// dates and IP addresses are not taken for them
+	PhonePattern = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,4}|(?:\(\d{3}\)[ .-]?|\b\d{3}[ -])\d{3}[ -]\d{4})\b`)
+	// TokenPattern matches slack tokens, AWS access keys and telegram bot
+	// tokens
+	TokenPattern = regexp.MustCompile(`\b(xox[abprs]-[A-Za-z0-9-]+|AKIA[0-9A-Z]{16}|\d{6,10}:[A-Za-z0-9_-]{35})\b`)
+)
+
+// DropMatching returns a middleware dropping the messages whose content
+// matches the given regular expression
+func DropMatching(re *regexp.Regexp) Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		if re.MatchString(m.Content()) {
+			return nil, nil
+		}
+		return m, nil
+	}
+}
+
+// DropFromUsers returns a middleware dropping the messages written by any of
+// the given users
+func DropFromUsers(users ...string) Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		for _, user := range users {
+			if m.Author() == user {
+				return nil, nil
+			}
+		}
+		return m, nil
+	}
+}
+
+// DropFromBots returns a middleware dropping the messages written by bots
+func DropFromBots() Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		if m.FromBot() {
+			return nil, nil
+		}
+		return m, nil
+	}
+}
+
+// Redact returns a middleware replacing the parts of the content matching any
+// of the given regular expressions with "[redacted]", and the same parts of
+// the rest of texts the messages carry, like their snippets
+func Redact(patterns ...*regexp.Regexp) Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		return RewriteTexts(m, func(text string) string {
+			for _, re := range patterns {
+				text = re.ReplaceAllString(text, "[redacted]")
+			}
+			return text
+		}), nil
+	}
+}
+
+// Rewrite returns a middleware replacing the parts of the content matching
+// the given regular expression with replacement, which can reference
+// submatches as in regexp.Regexp.ReplaceAllString. The rest of texts the
+// messages carry, like their snippets, are rewritten too.
+func Rewrite(re *regexp.Regexp, replacement string) Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		return RewriteTexts(m, func(text string) string {
+			return re.ReplaceAllString(text, replacement)
+		}), nil
+	}
+}
+
+// Prefix returns a middleware prepending prefix to the content of messages
+func Prefix(prefix string) Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		return m.WithContent(prefix + m.Content()), nil
+	}
+}
+
+// Suffix returns a middleware appending suffix to the content of messages
+func Suffix(suffix string) Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		return m.WithContent(m.Content() + suffix), nil
+	}
+}
+
+// StopPropagationIfMatching returns a middleware relaying right away the
+// messages whose content matches the given regular expression, skipping the
+// rest of middlewares in the pipeline
+func StopPropagationIfMatching(re *regexp.Regexp) Middleware {
+	return func(_ context.Context, m Message) (Message, error) {
+		if re.MatchString(m.Content()) {
+			return m, ErrStopPropagation
+		}
+		return m, nil
+	}
+}
+
+/* Section: middleware registry */
+
+// MiddlewareFactory builds a middleware given the argument written next to
+// its name in a pipeline specification
+type MiddlewareFactory func(arg string) (Middleware, error)
+
+// middlewareFactories holds the middlewares that can be referenced by name
+// in a pipeline specification
+var middlewareFactories = map[string]MiddlewareFactory{
+	"drop-matching": regexpFactory(DropMatching),
+	"drop-users": func(arg string) (Middleware, error) {
+		return DropFromUsers(strings.Split(arg, ",")...), nil
+	},
+	"drop-bots": func(string) (Middleware, error) {
+		return DropFromBots(), nil
+	},
+	"redact": regexpFactory(func(re *regexp.Regexp) Middleware {
+		return Redact(re)
+	}),
+	"redact-emails": func(string) (Middleware, error) {
+		return Redact(EmailPattern), nil
+	},
+	"redact-phones": func(string) (Middleware, error) {
+		return Redact(PhonePattern), nil
+	},
+	"redact-tokens": func(string) (Middleware, error) {
+		return Redact(TokenPattern), nil
+	},
+	"rewrite": func(arg string) (Middleware, error) {
+		parts := strings.SplitN(arg, "=>", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("rewrite expects an argument like pattern=>replacement")
+		}
+		re, err := regexp.Compile(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		return Rewrite(re, parts[1]), nil
+	},
+	"prefix": func(arg string) (Middleware, error) {
+		return Prefix(arg), nil
+	},
+	"suffix": func(arg string) (Middleware, error) {
+		return Suffix(arg), nil
+	},
+	"stop-if-matching": regexpFactory(StopPropagationIfMatching),
+	"redact-secrets": func(arg string) (Middleware, error) {
+		policy, err := parseRedactionPolicy(arg)
+		if err != nil {
+			return nil, err
+		}
+		return RedactSecrets(policy), nil
+	},
+}
+
+// middlewareFactoriesMutex controls access to middlewareFactories
+var middlewareFactoriesMutex sync.RWMutex
+
+// RegisterMiddleware makes a custom middleware available by name in pipeline
+// specifications. Registering a middleware with the name of an existing one
+// replaces it.
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	middlewareFactoriesMutex.Lock()
+	defer middlewareFactoriesMutex.Unlock()
+	middlewareFactories[name] = factory
+}
+
+// ParsePipeline builds a pipeline from its specification: a list of
+// middlewares separated by semicolons, each of them written as its name,
+// optionally followed by a colon and its argument. For instance:
+//
+//	drop-bots;redact-emails;drop-matching:^!;prefix:[slack] ;rewrite:foo=>bar
+func ParsePipeline(spec string) (Pipeline, error) {
+	middlewareFactoriesMutex.RLock()
+	defer middlewareFactoriesMutex.RUnlock()
+
+	var pipeline Pipeline
+	for _, item := range strings.Split(spec, ";") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		arg := ""
+		if len(parts) == 2 {
+			arg = parts[1]
+		}
+
+		factory, ok := middlewareFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q, available ones are: %s", name, strings.Join(middlewareNames(), ", "))
+		}
+		middleware, err := factory(arg)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %q: %v", name, err)
+		}
+		pipeline = append(pipeline, middleware)
+	}
+	return pipeline, nil
+}
+
+// middlewareNames returns the sorted names of the registered middlewares
+func middlewareNames() []string {
+	var names []string
+	for name := range middlewareFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// regexpFactory adapts a function building a middleware given a regular
+// expression into a MiddlewareFactory compiling its argument
+func regexpFactory(build func(*regexp.Regexp) Middleware) MiddlewareFactory {
+	return func(arg string) (Middleware, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return build(re), nil
+	}
+}
@@ -0,0 +1,95 @@
+package cable
+
+import (
+	"context"
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/nlopes/slack"
+	"time"
+)
+
+// Notice is a message written by cable itself, for instance to let the author
+// of a message know it was modified or blocked before being relayed
+type Notice struct {
+	// Text is the content of the notice
+	Text string
+	// About is the message the notice refers to, if any
+	About Message
+	// Plain notices are written as they are, without the warning sign nor
+	// mentioning the author of the message they refer to, like the replies to
+	// commands
+	Plain bool
+}
+
+// ToSlack converts the notice into a plain slack message
+func (n Notice) ToSlack() ([]slack.MsgOption, error) {
+	return []slack.MsgOption{slack.MsgOptionText(n.String(), false)}, nil
+}
+
+// ToTelegram converts the notice into a plain telegram message
+func (n Notice) ToTelegram(telegramChatID int64) (telegram.MessageConfig, error) {
+	return telegram.MessageConfig{
+		BaseChat: telegram.BaseChat{
+			ChatID: telegramChatID,
+		},
+		Text: n.String(),
+	}, nil
+}
+
+// String returns the text of the notice, addressed to the author of the
+// message it refers to unless plain
+func (n Notice) String() string {
+	if n.Plain {
+		return n.Text
+	}
+	if n.About != nil && n.About.Author() != "" {
+		return fmt.Sprintf("⚠️ @%s: %s", n.About.Author(), n.Text)
+	}
+	return fmt.Sprintf("⚠️ %s", n.Text)
+}
+
+// Content returns the text of the notice
+func (n Notice) Content() string {
+	return n.Text
+}
+
+// WithContent returns a copy of the notice with the given text
+func (n Notice) WithContent(content string) Message {
+	n.Text = content
+	return &n
+}
+
+// Author returns "cable", as notices are written by cable itself
+func (n Notice) Author() string {
+	return "cable"
+}
+
+// FromBot returns true, as notices are written by cable itself
+func (n Notice) FromBot() bool {
+	return true
+}
+
+// SentAt returns the zero time, as notices are not sent by any author
+func (n Notice) SentAt() time.Time {
+	return time.Time{}
+}
+
+/* Section: notifying the source of a message */
+
+// notifierKey is the key of the notifier function in a context
+type notifierKey struct{}
+
+// WithNotifier returns a copy of ctx carrying the function used by Notify to
+// deliver notices to the endpoint the message being processed was read from
+func WithNotifier(ctx context.Context, notify func(Message)) context.Context {
+	return context.WithValue(ctx, notifierKey{}, notify)
+}
+
+// Notify sends a notice about the given message back to the endpoint it was
+// read from. It does nothing if ctx carries no notifier, which is the case
+// when the source endpoint cannot be written.
+func Notify(ctx context.Context, text string, about Message) {
+	if notify, ok := ctx.Value(notifierKey{}).(func(Message)); ok {
+		notify(&Notice{Text: text, About: about})
+	}
+}
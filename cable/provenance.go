@@ -0,0 +1,104 @@
+package cable
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+const (
+	// DefaultMaxHops is the number of times a message can be relayed, by any
+	// number of cable instances and bridges chained, before hubs refuse to
+	// relay it further
+	DefaultMaxHops = 3
+	// sentLogSize is the number of messages a SentLog remembers
+	sentLogSize = 1000
+)
+
+// InstanceID identifies this cable process as the origin of the messages it
+// writes to the platforms
+var InstanceID = newInstanceID()
+
+// newInstanceID returns a random identifier
+func newInstanceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// Provenance tells which cable instance wrote a message to a platform, and how
+// many times it was relayed until then. Messages written by people have no
+// provenance.
+type Provenance struct {
+	// Origin is the InstanceID of the cable instance that wrote the message,
+	// or empty if a person did
+	Origin string `json:"origin"`
+	// Hops is the number of times the message was relayed
+	Hops int `json:"hops"`
+}
+
+// Relayed returns the provenance of a message relayed by this instance after
+// being read with the given provenance
+func (p Provenance) Relayed() Provenance {
+	return Provenance{Origin: InstanceID, Hops: p.Hops + 1}
+}
+
+// Provenanced is implemented by the messages that can tell whether a cable
+// instance wrote them
+type Provenanced interface {
+	// Provenance returns the provenance of the message
+	Provenance() Provenance
+}
+
+// ProvenanceOf returns the provenance of m, which is empty if unknown
+func ProvenanceOf(m Message) Provenance {
+	if provenanced, ok := m.(Provenanced); ok {
+		return provenanced.Provenance()
+	}
+	return Provenance{}
+}
+
+// SentLog remembers the provenance of the last messages written to a
+// platform by their IDs, to tell them apart when the platform reads them back
+type SentLog struct {
+	ids         []string
+	provenances map[string]Provenance
+	mutex       sync.Mutex
+}
+
+// NewSentLog returns the address of a new, empty SentLog
+func NewSentLog() *SentLog {
+	return &SentLog{provenances: make(map[string]Provenance)}
+}
+
+// Add remembers the provenance of the message written with the given ID,
+// forgetting the oldest message if full. Nothing is remembered by a nil log.
+func (l *SentLog) Add(id string, p Provenance) {
+	if l == nil || id == "" {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.provenances[id]; !ok {
+		l.ids = append(l.ids, id)
+	}
+	l.provenances[id] = p
+	if len(l.ids) > sentLogSize {
+		delete(l.provenances, l.ids[0])
+		l.ids = l.ids[1:]
+	}
+}
+
+// Get returns the provenance of the message written with the given ID, and
+// whether it was written at all
+func (l *SentLog) Get(id string) (Provenance, bool) {
+	if l == nil {
+		return Provenance{}, false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	p, ok := l.provenances[id]
+	return p, ok
+}
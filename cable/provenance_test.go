@@ -0,0 +1,41 @@
+package cable
+
+import (
+	"fmt"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestProvenance_Relayed(t *testing.T) {
+	Equal(t, Provenance{Origin: InstanceID, Hops: 1}, Provenance{}.Relayed())
+	Equal(t, Provenance{Origin: InstanceID, Hops: 3}, Provenance{Origin: "other", Hops: 2}.Relayed())
+	NotEmpty(t, InstanceID)
+}
+
+func TestProvenanceOf(t *testing.T) {
+	Equal(t, Provenance{Origin: "other", Hops: 2}, ProvenanceOf(&fakeMessage{provenance: Provenance{Origin: "other", Hops: 2}}))
+	Equal(t, Provenance{}, ProvenanceOf(&Notice{Text: "unknown"}))
+}
+
+func TestSentLog(t *testing.T) {
+	log := NewSentLog()
+	log.Add("1", Provenance{Origin: InstanceID, Hops: 1})
+	p, ok := log.Get("1")
+	True(t, ok)
+	Equal(t, 1, p.Hops)
+	_, ok = log.Get("2")
+	False(t, ok)
+
+	for i := 2; i <= sentLogSize+1; i++ {
+		log.Add(fmt.Sprint(i), Provenance{})
+	}
+	_, ok = log.Get("1")
+	False(t, ok, "the oldest message is forgotten")
+	_, ok = log.Get(fmt.Sprint(sentLogSize + 1))
+	True(t, ok)
+
+	var nilLog *SentLog
+	nilLog.Add("1", Provenance{})
+	_, ok = nilLog.Get("1")
+	False(t, ok)
+}
@@ -1,6 +1,8 @@
 package cable
 
-import log "github.com/sirupsen/logrus"
+import (
+	"sync"
+)
 
 // DefaultBufferSize is the number of messages that can be enqueued in the inbox and
 // outbox channels
@@ -35,14 +37,47 @@ type WritePumper interface {
 	Outbox() chan Message
 }
 
+// Editor is implemented by the write pumpers that can edit the messages they
+// wrote, so edits are relayed to them
+type Editor interface {
+	// CanEdit tells whether the messages written can be edited
+	CanEdit() bool
+}
+
+// CanEdit tells whether the messages written by p can be edited
+func CanEdit(p WritePumper) bool {
+	editor, ok := p.(Editor)
+	return ok && editor.CanEdit()
+}
+
+// Deleter is implemented by the write pumpers that can delete the messages
+// they wrote, so deletions are relayed to them
+type Deleter interface {
+	// CanDelete tells whether the messages written can be deleted
+	CanDelete() bool
+}
+
+// CanDelete tells whether the messages written by p can be deleted
+func CanDelete(p WritePumper) bool {
+	deleter, ok := p.(Deleter)
+	return ok && deleter.CanDelete()
+}
+
 // Pump is a struct that describes an entity with an inbox and
 // and outbox channel of Messages, and their companion stop channels
-// to let the pump know when to stop reading or writing
+// to let the pump know when to stop reading or writing. The pump also tracks
+// the health of the pumper it is embedded in, through its HealthTracker, and
+// keeps the messages it failed to write in a DeadLetterQueue.
 type Pump struct {
+	*HealthTracker
 	InboxCh      chan Message
 	ReadStopper  chan interface{}
 	OutboxCh     chan Message
 	WriteStopper chan interface{}
+	deadLetters  *DeadLetterQueue
+	// commandHandler handles the commands intercepted by the pumper
+	commandHandler      func(Command)
+	commandHandlerMutex sync.RWMutex
 }
 
 // Inbox returns the inbox channel of the pump
@@ -51,9 +86,37 @@ func (p *Pump) Inbox() chan Message {
 }
 
 // StopRead writes to the ReadStopper synchronization channel, thus indicating
-// the pumper to stop reading
+// the pumper to stop reading. It does not wait for the pumper to stop, so it
+// can be called while handling a command read by the pumper.
 func (p *Pump) StopRead() {
-	p.ReadStopper <- true
+	signal(p.ReadStopper)
+}
+
+// DeadLetters returns the queue of messages the pump failed to write
+func (p *Pump) DeadLetters() *DeadLetterQueue {
+	return p.deadLetters
+}
+
+// SetCommandHandler sets the function handling the commands intercepted by
+// the pumper, or stops intercepting them if nil
+func (p *Pump) SetCommandHandler(handler func(Command)) {
+	p.commandHandlerMutex.Lock()
+	defer p.commandHandlerMutex.Unlock()
+	p.commandHandler = handler
+}
+
+// HandleCommand hands the command over to the command handler, returning
+// false if there is none, in which case the message of the command should be
+// relayed as any other
+func (p *Pump) HandleCommand(c Command) bool {
+	p.commandHandlerMutex.RLock()
+	handler := p.commandHandler
+	p.commandHandlerMutex.RUnlock()
+	if handler == nil {
+		return false
+	}
+	handler(c)
+	return true
 }
 
 // Outbox returns the outbox channel of the pump
@@ -62,29 +125,50 @@ func (p *Pump) Outbox() chan Message {
 }
 
 // StopWrite writes to the WriteStopper synchronization channel, thus indicating
-// the pumper to stop writing
+// the pumper to stop writing. It does not wait for the pumper to stop.
 func (p *Pump) StopWrite() {
-	p.WriteStopper <- true
+	signal(p.WriteStopper)
+}
+
+// signal writes to a stopper channel unless a previous signal is still
+// pending, so it never blocks
+func signal(stopper chan interface{}) {
+	select {
+	case stopper <- true:
+	default:
+	}
 }
 
 // NewPump returns the address of a new value of the Pump struct with
-// InboxCh and OutboxCh as buffered channels of size DefaultBufferSize
+// InboxCh and OutboxCh as buffered channels of size DefaultBufferSize, and
+// stopper channels holding a pending signal
 func NewPump() *Pump {
 	return &Pump{
-		InboxCh:      make(chan Message, DefaultBufferSize),
-		ReadStopper:  make(chan interface{}),
-		OutboxCh:     make(chan Message, DefaultBufferSize),
-		WriteStopper: make(chan interface{}),
+		HealthTracker: NewHealthTracker(),
+		InboxCh:       make(chan Message, DefaultBufferSize),
+		ReadStopper:   make(chan interface{}, 1),
+		OutboxCh:      make(chan Message, DefaultBufferSize),
+		WriteStopper:  make(chan interface{}, 1),
+		deadLetters:   NewDeadLetterQueue(DefaultDeadLetterCapacity),
 	}
 }
 
 // BidirectionalPumpConnection defines a connection between two
 // pumpers such as the messages arriving at the inbox of one of them
-// are relayed to the outbox of the other and vice-versa
+// are relayed to the outbox of the other and vice-versa.
+//
+// It is a Hub of the two pumpers, so messages are relayed the same way, like
+// never relaying those this cable wrote nor those bouncing between them.
 type BidirectionalPumpConnection struct {
 	Left  Pumper
 	Right Pumper
-	stop  chan interface{}
+	// LeftToRight is the pipeline processing the messages relayed from the
+	// left pumper to the right one
+	LeftToRight Pipeline
+	// RightToLeft is the pipeline processing the messages relayed from the
+	// right pumper to the left one
+	RightToLeft Pipeline
+	hub         *Hub
 }
 
 // NewBidirectionalPumpConnection returns the address of a new
@@ -93,40 +177,21 @@ func NewBidirectionalPumpConnection(left Pumper, right Pumper) *BidirectionalPum
 	return &BidirectionalPumpConnection{
 		Left:  left,
 		Right: right,
-		stop:  make(chan interface{}),
+		hub:   NewHub("bidirectional"),
 	}
 }
 
-// Go spawns a goroutine for routing messages from each of the edges to the
+// Go spawns the goroutines routing messages from each of the edges to the
 // other
 func (c BidirectionalPumpConnection) Go() {
-	go func() {
-		c.Left.GoRead()
-		c.Left.GoWrite()
-		c.Right.GoRead()
-		c.Right.GoWrite()
-
-		for {
-			select {
-			case m := <-c.Left.Inbox():
-				log.Debugf("[%+v]: %s", c.Left, m)
-				c.Right.Outbox() <- m
-			case m := <-c.Right.Inbox():
-				log.Debugf("[%+v]: %s", c.Right, m)
-				c.Left.Outbox() <- m
-			case <-c.stop:
-				c.Left.StopRead()
-				c.Left.StopWrite()
-				c.Right.StopRead()
-				c.Right.StopWrite()
-				return
-			}
-		}
-	}()
-
-}
-
-// Stop stops the goroutine started by Go
+	c.hub.Endpoints = []Endpoint{
+		{Name: "left", Pumper: c.Left, Inbound: c.LeftToRight},
+		{Name: "right", Pumper: c.Right, Inbound: c.RightToLeft},
+	}
+	c.hub.Go()
+}
+
+// Stop stops the goroutines started by Go
 func (c BidirectionalPumpConnection) Stop() {
-	c.stop <- true
+	c.hub.Stop()
 }
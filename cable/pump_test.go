@@ -3,9 +3,11 @@ package cable
 import (
 	"fmt"
 	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable/tracing"
 	slackAPI "github.com/nlopes/slack"
 	. "github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 /* fake Pumper */
@@ -18,14 +20,24 @@ func newFakePumper() *fakePumper {
 	return &fakePumper{NewPump()}
 }
 
-func (*fakePumper) GoRead() {}
+func (p *fakePumper) GoRead() {
+	go func() { <-p.ReadStopper }()
+}
 
-func (*fakePumper) GoWrite() {}
+func (p *fakePumper) GoWrite() {
+	go func() { <-p.WriteStopper }()
+}
 
 /* fake Message */
 
 type fakeMessage struct {
-	text string
+	text       string
+	author     string
+	bot        bool
+	span       *tracing.Span
+	provenance Provenance
+	// texts are carried besides the text, like snippets
+	texts []string
 }
 
 func (fm fakeMessage) ToSlack() ([]slackAPI.MsgOption, error) {
@@ -40,6 +52,68 @@ func (fm fakeMessage) String() string {
 	return fm.text
 }
 
+func (fm fakeMessage) Content() string {
+	return fm.text
+}
+
+func (fm fakeMessage) WithContent(content string) Message {
+	fm.text = content
+	return &fm
+}
+
+func (fm fakeMessage) Texts() []string {
+	return fm.texts
+}
+
+func (fm fakeMessage) WithTexts(texts []string) Message {
+	fm.texts = texts
+	return &fm
+}
+
+func (fm fakeMessage) Author() string {
+	return fm.author
+}
+
+func (fm fakeMessage) FromBot() bool {
+	return fm.bot
+}
+
+func (fm fakeMessage) SentAt() time.Time {
+	return time.Time{}
+}
+
+func (fm fakeMessage) Span() *tracing.Span {
+	return fm.span
+}
+
+func (fm fakeMessage) WithSpan(span *tracing.Span) Message {
+	fm.span = span
+	return &fm
+}
+
+func (fm fakeMessage) Provenance() Provenance {
+	return fm.provenance
+}
+
+func TestPump_Stop_DoesNotBlock(t *testing.T) {
+	p := NewPump()
+	stopped := make(chan bool)
+	go func() {
+		// nothing reads the stoppers, like pumpers busy handling a command
+		p.StopRead()
+		p.StopRead()
+		p.StopWrite()
+		stopped <- true
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		Fail(t, "stopping the pump blocked")
+	}
+	NotNil(t, <-p.ReadStopper, "the signal is kept until the pumper reads it")
+}
+
 func TestBidirectionalPumpConnection(t *testing.T) {
 	left := newFakePumper()
 	right := newFakePumper()
@@ -54,3 +128,38 @@ func TestBidirectionalPumpConnection(t *testing.T) {
 	Equal(t, "Fed into left", (<-right.Outbox()).String())
 	Equal(t, "Fed into right", (<-left.Outbox()).String())
 }
+
+func TestBidirectionalPumpConnection_Pipelines(t *testing.T) {
+	left := newFakePumper()
+	right := newFakePumper()
+
+	bidi := NewBidirectionalPumpConnection(left, right)
+	bidi.LeftToRight = Pipeline{Prefix("left: ")}
+	bidi.RightToLeft = Pipeline{DropFromBots()}
+	bidi.Go()
+	defer bidi.Stop()
+
+	right.Inbox() <- &fakeMessage{text: "Dropped", bot: true}
+	left.Inbox() <- &fakeMessage{text: "Fed into left"}
+	right.Inbox() <- &fakeMessage{text: "Fed into right"}
+
+	Equal(t, "left: Fed into left", (<-right.Outbox()).String())
+	Equal(t, "Fed into right", (<-left.Outbox()).String())
+}
+
+func TestBidirectionalPumpConnection_Provenance(t *testing.T) {
+	left := newFakePumper()
+	right := newFakePumper()
+
+	bidi := NewBidirectionalPumpConnection(left, right)
+	bidi.Go()
+	defer bidi.Stop()
+
+	left.Inbox() <- &fakeMessage{text: "Written by this cable", provenance: Provenance{Origin: InstanceID, Hops: 1}}
+	left.Inbox() <- &fakeMessage{text: "Relayed too many times", provenance: Provenance{Origin: "other", Hops: DefaultMaxHops}}
+	left.Inbox() <- &fakeMessage{text: "Relayed by another cable", provenance: Provenance{Origin: "other", Hops: 1}}
+	Equal(t, "Relayed by another cable", (<-right.Outbox()).String())
+
+	time.Sleep(50 * time.Millisecond)
+	Equal(t, 0, len(right.Outbox()))
+}
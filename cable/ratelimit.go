@@ -0,0 +1,274 @@
+package cable
+
+import (
+	"errors"
+	"github.com/miguelff/cable/cable/metrics"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+/* Section: rate limiting the writes to the platforms */
+
+// RateLimitRetries is the number of times a message rejected because of rate
+// limits is written again
+const RateLimitRetries = 3
+
+// ErrStopped is returned when a write pump is stopped while waiting to write
+// a message
+var ErrStopped = errors.New("stopped while waiting to write the message")
+
+// RateLimiter is a token bucket allowing an event every interval on average,
+// and bursts of up to burst events. Events over the limit are not rejected,
+// but told how long to wait, so they queue.
+type RateLimiter struct {
+	every time.Duration
+	burst int
+	// tat is the theoretical arrival time of the next event if the bucket
+	// was never full, which tells the tokens left: the bucket is full while
+	// it is not after now
+	tat   time.Time
+	mutex sync.Mutex
+}
+
+// NewRateLimiter returns the address of a new RateLimiter allowing an event
+// every interval, in bursts of up to burst events
+func NewRateLimiter(every time.Duration, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{every: every, burst: burst}
+}
+
+// Reserve takes a token for an event, returning how long to wait before it
+// happens
+func (l *RateLimiter) Reserve() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if l.tat.Before(now) {
+		l.tat = now
+	}
+	wait := l.tat.Add(-time.Duration(l.burst-1) * l.every).Sub(now)
+	l.tat = l.tat.Add(l.every)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// Penalize makes the events wait at least the given duration, like when the
+// platform tells to retry later
+func (l *RateLimiter) Penalize(d time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	earliest := time.Now().Add(d + time.Duration(l.burst-1)*l.every)
+	if l.tat.Before(earliest) {
+		l.tat = earliest
+	}
+}
+
+// rateLimiters holds the rate limiters shared by key
+var (
+	rateLimiters      = make(map[string]*RateLimiter)
+	rateLimitersMutex sync.Mutex
+)
+
+// SharedRateLimiter returns the rate limiter of the given key, creating it
+// with the given limits the first time, so every pumper writing to the same
+// chat, or with the same bot, shares its limits
+func SharedRateLimiter(key string, every time.Duration, burst int) *RateLimiter {
+	rateLimitersMutex.Lock()
+	defer rateLimitersMutex.Unlock()
+	limiter, ok := rateLimiters[key]
+	if !ok {
+		limiter = NewRateLimiter(every, burst)
+		rateLimiters[key] = limiter
+	}
+	return limiter
+}
+
+// Throttle paces the messages written by a write pump with rate limiters.
+// When the messages queued in the outbox exceed CoalesceBacklog, they are
+// combined into a single message, so the chat catches up.
+type Throttle struct {
+	// Platform is the name of the platform written to, which is reported in
+	// logs and metrics
+	Platform string
+	// Limiters are the rate limiters every message written has to get a
+	// token from
+	Limiters []*RateLimiter
+	// CoalesceBacklog is the number of messages queued in the outbox above
+	// which they are combined, or zero to never combine them
+	CoalesceBacklog int
+	// Batches remembers the messages combined, so their edits update the
+	// combined message written, if not nil
+	Batches *BatchLog
+}
+
+// Next waits until the message taken from the outbox can be written,
+// returning it, or a Combined message with the ones queued after it if the
+// backlog is over CoalesceBacklog. The edits and deletions queued are applied
+// to the messages combined they change, and queued again otherwise, and the
+// ones of messages combined before update the Combined message written for
+// them. It returns false if stopped meanwhile.
+func (t *Throttle) Next(m Message, outbox chan Message, stop chan interface{}) (Message, bool) {
+	if t == nil {
+		return m, true
+	}
+	if !t.Wait(stop) {
+		return nil, false
+	}
+
+	if deletion, ok := DeletionOf(m); ok {
+		if updated, ok := t.Batches.Remove(deletion); ok {
+			return updated, true
+		}
+		return m, true
+	}
+	if IsEdit(m) {
+		if combined, ok := t.Batches.Update(m); ok {
+			return combined, true
+		}
+		return m, true
+	}
+	if t.CoalesceBacklog <= 0 || len(outbox) <= t.CoalesceBacklog {
+		return m, true
+	}
+	parts := []Message{m}
+	var changes []Message
+	for len(outbox) > 0 {
+		queued := <-outbox
+		_, deletion := DeletionOf(queued)
+		switch {
+		case deletion:
+			var removed bool
+			if parts, removed = remove(parts, queued); !removed {
+				changes = append(changes, queued)
+				continue
+			}
+		case IsEdit(queued):
+			if !replace(parts, queued) {
+				changes = append(changes, queued)
+				continue
+			}
+		default:
+			parts = append(parts, queued)
+		}
+		SpanOf(queued).SetAttribute("coalesced", true).End()
+	}
+	for _, change := range changes {
+		// changes of messages written before are written after the combined
+		// message, as they cannot be combined with new ones
+		select {
+		case outbox <- change:
+		default:
+			SpanOf(change).SetAttribute("dropped", true).End()
+			log.WithFields(MessageFields(change)).WithField("platform", t.Platform).Warn("Edit or deletion dropped, as the outbox is full")
+		}
+	}
+	combined := Combine(parts...)
+	if c, ok := combined.(*Combined); ok {
+		t.Batches.Add(c)
+	}
+	metrics.MessagesCoalesced.Add(float64(len(parts)), t.Platform)
+	log.WithFields(log.Fields{"platform": t.Platform, "messages": len(parts)}).Info("Messages coalesced, as too many were queued")
+	return combined, true
+}
+
+// replace replaces the content of the part edited by an edit with the
+// edited one, telling whether any part was edited
+func replace(parts []Message, edit Message) bool {
+	id := IDOf(edit)
+	for i, part := range parts {
+		if id != "" && IDOf(part) == id {
+			parts[i] = part.WithContent(edit.Content())
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the part deleted by a deletion, telling whether any part
+// was deleted. The first part is kept, as the message combined is written
+// for it, so its deletion is written after it instead.
+func remove(parts []Message, deletion Message) ([]Message, bool) {
+	id := IDOf(deletion)
+	for i, part := range parts[1:] {
+		if id != "" && IDOf(part) == id {
+			return append(parts[:i+1], parts[i+2:]...), true
+		}
+	}
+	return parts, false
+}
+
+// Wait waits until another message can be written, like the parts of a long
+// message. It returns false if stopped meanwhile.
+func (t *Throttle) Wait(stop chan interface{}) bool {
+	if t == nil {
+		return true
+	}
+	var wait time.Duration
+	for _, limiter := range t.Limiters {
+		if d := limiter.Reserve(); d > wait {
+			wait = d
+		}
+	}
+	return sleep(wait, stop)
+}
+
+// Backoff makes the following messages wait the given duration, as told by
+// the platform rejecting a message because of rate limits, and waits for it.
+// It returns false if stopped meanwhile.
+func (t *Throttle) Backoff(d time.Duration, stop chan interface{}) bool {
+	if t != nil {
+		for _, limiter := range t.Limiters {
+			limiter.Penalize(d)
+		}
+	}
+	return sleep(d, stop)
+}
+
+// Write calls write, calling it again up to RateLimitRetries times while the
+// platform rejects it because of rate limits, after the time it tells, as
+// returned by retryAfter. It returns ErrStopped if stopped meanwhile.
+func (t *Throttle) Write(write func() error, retryAfter func(error) (time.Duration, bool), stop chan interface{}) error {
+	err := write()
+	for attempt := 1; attempt <= RateLimitRetries && err != nil; attempt++ {
+		d, limited := retryAfter(err)
+		if !limited {
+			break
+		}
+		platform := ""
+		if t != nil {
+			platform = t.Platform
+		}
+		log.WithFields(log.Fields{"platform": platform, "retry_after": d.String(), "attempt": attempt}).WithError(err).Warn("Rate limit exceeded, writing the message again later")
+		if !t.Backoff(d, stop) {
+			return ErrStopped
+		}
+		err = write()
+	}
+	return err
+}
+
+// sleep waits the given duration, returning false if stopped meanwhile
+func sleep(d time.Duration, stop chan interface{}) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
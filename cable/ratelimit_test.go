@@ -0,0 +1,127 @@
+package cable
+
+import (
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(time.Second, 2)
+	Equal(t, time.Duration(0), limiter.Reserve())
+	Equal(t, time.Duration(0), limiter.Reserve(), "bursts are allowed")
+	wait := limiter.Reserve()
+	True(t, wait > 900*time.Millisecond && wait <= time.Second, wait.String())
+	wait = limiter.Reserve()
+	True(t, wait > 1900*time.Millisecond && wait <= 2*time.Second, "events over the limit queue")
+
+	limiter = NewRateLimiter(time.Millisecond, 1)
+	limiter.Penalize(time.Second)
+	wait = limiter.Reserve()
+	True(t, wait > 900*time.Millisecond && wait <= time.Second, wait.String())
+
+	var nilLimiter *RateLimiter
+	Equal(t, time.Duration(0), nilLimiter.Reserve())
+}
+
+func TestSharedRateLimiter(t *testing.T) {
+	limiter := SharedRateLimiter("test/shared", time.Second, 1)
+	True(t, limiter == SharedRateLimiter("test/shared", time.Minute, 5), "limiters are shared by key")
+	True(t, limiter != SharedRateLimiter("test/other", time.Second, 1))
+}
+
+func TestThrottle_Next(t *testing.T) {
+	outbox := make(chan Message, 10)
+	stop := make(chan interface{})
+	throttle := &Throttle{Platform: "test", Limiters: []*RateLimiter{NewRateLimiter(50*time.Millisecond, 1)}, CoalesceBacklog: 2, Batches: NewBatchLog()}
+
+	start := time.Now()
+	m, ok := throttle.Next(&fakeMessage{text: "first"}, outbox, stop)
+	True(t, ok)
+	Equal(t, "first", m.String())
+	m, _ = throttle.Next(&fakeMessage{text: "second"}, outbox, stop)
+	Equal(t, "second", m.String())
+	True(t, time.Since(start) >= 50*time.Millisecond, "messages are paced")
+
+	outbox <- &fakeMessage{text: "fourth"}
+	outbox <- &fakeMessage{text: "fifth"}
+	m, _ = throttle.Next(&fakeMessage{text: "third"}, outbox, stop)
+	Equal(t, "third", m.String(), "the backlog is not over the threshold")
+
+	outbox <- &fakeMessage{text: "sixth"}
+	m, _ = throttle.Next(<-outbox, outbox, stop)
+	Equal(t, "fourth", m.String())
+	outbox <- &fakeMessage{text: "seventh"}
+	outbox <- &fakeMessage{text: "eighth"}
+	m, _ = throttle.Next(<-outbox, outbox, stop)
+	Equal(t, "fifth\nsixth\nseventh\neighth", m.String(), "the backlog is combined")
+	Equal(t, 0, len(outbox))
+
+	outbox <- editMessage{identifiedMessage{fakeMessage{text: "first, edited"}, "1"}}
+	outbox <- identifiedMessage{fakeMessage{text: "tenth"}, "10"}
+	outbox <- editMessage{identifiedMessage{fakeMessage{text: "tenth, edited"}, "10"}}
+	outbox <- identifiedMessage{fakeMessage{text: "eleventh"}, "11"}
+	m, _ = throttle.Next(&fakeMessage{text: "ninth"}, outbox, stop)
+	Equal(t, "ninth\ntenth, edited\neleventh", m.String(), "edits of the messages combined are applied")
+	Equal(t, 1, len(outbox))
+	m, _ = throttle.Next(<-outbox, outbox, stop)
+	Equal(t, "first, edited", m.String(), "edits of the messages written before are queued again")
+
+	m, _ = throttle.Next(editMessage{identifiedMessage{fakeMessage{text: "eleventh, edited"}, "11"}}, outbox, stop)
+	True(t, IsEdit(m))
+	Equal(t, "ninth\ntenth, edited\neleventh, edited", m.String(), "edits update the combined message")
+
+	outbox <- identifiedMessage{fakeMessage{text: "thirteenth"}, "13"}
+	outbox <- NewDeletion("test", "", "13")
+	outbox <- NewDeletion("test", "", "11")
+	m, _ = throttle.Next(identifiedMessage{fakeMessage{text: "twelfth"}, "12"}, outbox, stop)
+	Equal(t, "twelfth", m.String(), "deletions of the messages combined are applied")
+	Equal(t, 1, len(outbox))
+	m, _ = throttle.Next(<-outbox, outbox, stop)
+	True(t, IsEdit(m))
+	Equal(t, "ninth\ntenth, edited", m.String(), "deletions update the combined message")
+
+	go func() { stop <- true }()
+	throttle.Limiters[0].Penalize(time.Minute)
+	_, ok = throttle.Next(&fakeMessage{text: "ninth"}, outbox, stop)
+	False(t, ok, "stopped while waiting")
+
+	var nilThrottle *Throttle
+	m, ok = nilThrottle.Next(&fakeMessage{text: "unlimited"}, outbox, stop)
+	True(t, ok)
+	Equal(t, "unlimited", m.String())
+}
+
+func TestThrottle_Write(t *testing.T) {
+	limited := errors.New("rate limited")
+	retryAfter := func(err error) (time.Duration, bool) { return time.Millisecond, err == limited }
+	throttle := &Throttle{Platform: "test", Limiters: []*RateLimiter{NewRateLimiter(time.Millisecond, 1)}}
+	stop := make(chan interface{})
+
+	attempts := 0
+	err := throttle.Write(func() error {
+		attempts++
+		if attempts < 3 {
+			return limited
+		}
+		return nil
+	}, retryAfter, stop)
+	NoError(t, err)
+	Equal(t, 3, attempts)
+
+	attempts = 0
+	err = throttle.Write(func() error { attempts++; return limited }, retryAfter, stop)
+	Equal(t, limited, err)
+	Equal(t, RateLimitRetries+1, attempts, "retries are limited")
+
+	attempts = 0
+	failed := errors.New("failed")
+	err = throttle.Write(func() error { attempts++; return failed }, retryAfter, stop)
+	Equal(t, failed, err)
+	Equal(t, 1, attempts, "other errors are not retried")
+
+	go func() { stop <- true }()
+	err = throttle.Write(func() error { return limited }, func(error) (time.Duration, bool) { return time.Minute, true }, stop)
+	Equal(t, ErrStopped, err)
+}
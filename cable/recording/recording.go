@@ -0,0 +1,172 @@
+// Package recording captures the raw events cable reads from slack and
+// telegram to a JSONL file, one entry per line, so they can be replayed later
+// through the same bridges to reproduce how they were relayed.
+//
+// Sensitive information in the texts of the events is redacted with the
+// built-in detectors of cable.RedactSecrets before it is written, unless the
+// recorder is created without redaction.
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/miguelff/cable/cable"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is an event recorded
+type Entry struct {
+	// Time is the time the event was read
+	Time time.Time `json:"time"`
+	// Platform is the platform the event was read from, like "slack"
+	Platform string `json:"platform"`
+	// Type is the kind of event within the platform, like "message"
+	Type string `json:"type"`
+	// Event is the event as the API of the platform encodes it
+	Event json.RawMessage `json:"event"`
+}
+
+// redactedFields are the names of the fields of events whose values are
+// texts written by people, which are redacted
+var redactedFields = map[string]bool{
+	"text":     true,
+	"fallback": true,
+	"pretext":  true,
+	"title":    true,
+	"caption":  true,
+	"value":    true,
+}
+
+// Recorder writes events to a JSONL stream. It is safe for concurrent use,
+// and every method is a no-op on a nil recorder, so callers do not need to
+// check whether recording is enabled.
+type Recorder struct {
+	mutex     sync.Mutex
+	out       io.Writer
+	redaction *cable.RedactionPolicy
+}
+
+// NewRecorder returns the address of a new Recorder writing to out, redacting
+// the texts of the events if redact is set
+func NewRecorder(out io.Writer, redact bool) *Recorder {
+	r := &Recorder{out: out}
+	if redact {
+		r.redaction = &cable.RedactionPolicy{}
+	}
+	return r
+}
+
+// Create returns the address of a new Recorder appending to the file at path,
+// which is created if it does not exist
+func Create(path string, redact bool) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f, redact), nil
+}
+
+// Record writes an event read from the given platform
+func (r *Recorder) Record(platform string, typ string, event interface{}) error {
+	if r == nil {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if r.redaction != nil {
+		if data, err = r.redact(data); err != nil {
+			return err
+		}
+	}
+	line, err := json.Marshal(Entry{Time: time.Now().UTC(), Platform: platform, Type: typ, Event: data})
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, err = r.out.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the stream written, if it can be closed
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if closer, ok := r.out.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// redact returns the encoded event with the values of its redacted fields
+// redacted, at any depth
+func (r *Recorder) redact(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// numbers are kept as written, as IDs may not fit in a float64
+	decoder.UseNumber()
+	var event interface{}
+	if err := decoder.Decode(&event); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.redactValue("", event))
+}
+
+// redactValue redacts value, which is the value of the field with the given
+// name, or of an element of it
+func (r *Recorder) redactValue(field string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = r.redactValue(k, e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = r.redactValue(field, e)
+		}
+	case string:
+		if redactedFields[field] {
+			redacted, _, _ := r.redaction.Redact(v)
+			return redacted
+		}
+	}
+	return value
+}
+
+// Read reads the entries of a recording
+func Read(in io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ReadFile reads the entries of the recording at path
+func ReadFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
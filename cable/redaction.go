@@ -0,0 +1,228 @@
+package cable
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Detector finds a kind of sensitive information, like secrets or personally
+// identifiable information, in the content of messages
+type Detector struct {
+	// Name identifies the kind of information found by the detector
+	Name string
+	// Pattern matches the candidates of sensitive information
+	Pattern *regexp.Regexp
+	// Validate, when set, tells whether a match of Pattern is actually
+	// sensitive information, to discard false positives
+	Validate func(match string) bool
+}
+
+// DefaultDetectors are the built-in detectors, in the order they are applied
+var DefaultDetectors = []Detector{
+	{Name: "private-key", Pattern: regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?(-----END [A-Z ]*PRIVATE KEY-----|$)`)},
+	{Name: "aws-access-key", Pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "aws-secret-key", Pattern: regexp.MustCompile(`(?i)aws_?secret_?(access_?)?key\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`)},
+	{Name: "gcp-api-key", Pattern: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{Name: "github-token", Pattern: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{Name: "slack-token", Pattern: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{Name: "telegram-token", Pattern: regexp.MustCompile(`\b\d{6,10}:[A-Za-z0-9_-]{35}\b`)},
+	{Name: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)},
+	{Name: "credit-card", Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), Validate: luhn},
+	{Name: "email", Pattern: EmailPattern},
+	{Name: "phone", Pattern: PhonePattern},
+}
+
+// RedactionPolicy configures RedactSecrets
+type RedactionPolicy struct {
+	// Detectors are the detectors applied, in order. When empty,
+	// DefaultDetectors are used.
+	Detectors []Detector
+	// Block holds the names of the detectors whose findings block the whole
+	// message instead of just redacting them
+	Block map[string]bool
+	// Allow holds patterns of values that are never redacted, like the email
+	// addresses of the company domain
+	Allow []*regexp.Regexp
+	// Quiet disables notifying the author when their message is redacted or
+	// blocked
+	Quiet bool
+}
+
+// RedactSecrets returns a middleware redacting the sensitive information found
+// in messages by the detectors of the policy, replacing it with a
+// "[redacted <detector>]" placeholder. Their content is redacted, and the rest
+// of texts they carry too, like their snippets. Messages containing
+// information found by blocking detectors are dropped altogether.
+//
+// Unless the policy is quiet, the author of the message gets a notice on the
+// platform the message was written in, telling what was redacted or blocked.
+func RedactSecrets(policy RedactionPolicy) Middleware {
+	return func(ctx context.Context, m Message) (Message, error) {
+		found := make(map[string]int)
+		var blocked []string
+		redacted := RewriteTexts(m, func(text string) string {
+			text, foundInText, blockedInText := policy.Redact(text)
+			for name, n := range foundInText {
+				found[name] += n
+			}
+			blocked = append(blocked, blockedInText...)
+			return text
+		})
+
+		if len(blocked) > 0 {
+			if !policy.Quiet {
+				Notify(ctx, fmt.Sprintf("your message was not relayed because it contains sensitive information (%s)", describe(found)), m)
+			}
+			return nil, nil
+		}
+		if len(found) == 0 {
+			return m, nil
+		}
+		if !policy.Quiet {
+			Notify(ctx, fmt.Sprintf("your message was relayed with sensitive information redacted (%s)", describe(found)), m)
+		}
+		return redacted, nil
+	}
+}
+
+// Redact replaces the sensitive information found in content by the detectors
+// of the policy with "[redacted <detector>]" placeholders. It returns the
+// redacted content, the number of findings of each detector, and the names of
+// the blocking detectors that found something.
+func (policy RedactionPolicy) Redact(content string) (string, map[string]int, []string) {
+	detectors := policy.Detectors
+	if len(detectors) == 0 {
+		detectors = DefaultDetectors
+	}
+
+	found := make(map[string]int)
+	var blocked []string
+	for _, detector := range detectors {
+		content = detector.Pattern.ReplaceAllStringFunc(content, func(match string) string {
+			if (detector.Validate != nil && !detector.Validate(match)) || allowed(policy.Allow, match) {
+				return match
+			}
+			if policy.Block[detector.Name] {
+				blocked = append(blocked, detector.Name)
+			}
+			found[detector.Name]++
+			return fmt.Sprintf("[redacted %s]", detector.Name)
+		})
+	}
+	return content, found, blocked
+}
+
+// parseRedactionPolicy parses the argument of the redact-secrets middleware in
+// a pipeline specification: a list of space separated options among:
+//
+//	detectors=<names>  applies only the built-in detectors named, comma separated
+//	block=<names>      blocks messages with findings of the detectors named
+//	allow=<regexp>     never redacts values matching the expression (repeatable)
+//	quiet              does not notify authors
+func parseRedactionPolicy(arg string) (RedactionPolicy, error) {
+	policy := RedactionPolicy{Block: make(map[string]bool)}
+
+	for _, option := range strings.Fields(arg) {
+		parts := strings.SplitN(option, "=", 2)
+		key, value := parts[0], ""
+		if len(parts) == 2 {
+			value = parts[1]
+		}
+
+		switch key {
+		case "detectors":
+			for _, name := range strings.Split(value, ",") {
+				detector, ok := findDetector(name)
+				if !ok {
+					return policy, fmt.Errorf("unknown detector %q", name)
+				}
+				policy.Detectors = append(policy.Detectors, detector)
+			}
+		case "block":
+			for _, name := range strings.Split(value, ",") {
+				if _, ok := findDetector(name); !ok {
+					return policy, fmt.Errorf("unknown detector %q", name)
+				}
+				policy.Block[name] = true
+			}
+		case "allow":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return policy, err
+			}
+			policy.Allow = append(policy.Allow, re)
+		case "quiet":
+			policy.Quiet = true
+		default:
+			return policy, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return policy, nil
+}
+
+// findDetector returns the built-in detector with the given name
+func findDetector(name string) (Detector, bool) {
+	for _, detector := range DefaultDetectors {
+		if detector.Name == name {
+			return detector, true
+		}
+	}
+	return Detector{}, false
+}
+
+// allowed tells whether value matches any of the allowed patterns
+func allowed(allow []*regexp.Regexp, value string) bool {
+	for _, re := range allow {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// describe returns a human readable summary of the findings of each detector,
+// like "1 email, 2 phone"
+func describe(found map[string]int) string {
+	var names []string
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%d %s", found[name], name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// luhn tells whether the digits in s pass the Luhn checksum used by credit
+// card numbers
+func luhn(s string) bool {
+	var digits []int
+	for _, r := range s {
+		if d, err := strconv.Atoi(string(r)); err == nil {
+			digits = append(digits, d)
+		}
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if (len(digits)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,131 @@
+package replay
+
+import (
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	s "github.com/miguelff/cable/cable/slack"
+	t "github.com/miguelff/cable/cable/telegram"
+	"github.com/nlopes/slack"
+	"strconv"
+)
+
+/* Section: fake slack API */
+
+// fakeSlackAPI implements slack.API serving recorded events and users, and
+// recording the messages posted
+type fakeSlackAPI struct {
+	events chan slack.RTMEvent
+	users  s.UserMap
+	calls  *callLog
+}
+
+func (api *fakeSlackAPI) IncomingEvents() <-chan slack.RTMEvent {
+	return api.events
+}
+
+func (api *fakeSlackAPI) PostMessage(channelID string, options ...slack.MsgOption) (string, string, error) {
+	_, values, err := slack.UnsafeApplyMsgOptions("", channelID, options...)
+	if err != nil {
+		return "", "", err
+	}
+	params := make(map[string]string)
+	for k := range values {
+		if k != "token" {
+			params[k] = values.Get(k)
+		}
+	}
+	api.calls.add("slack chat.postMessage", params)
+	return channelID, "", nil
+}
+
+func (api *fakeSlackAPI) GetUsers() s.UserMap {
+	return api.users
+}
+
+func (api *fakeSlackAPI) GetUsersInConversation(channelID string) ([]string, error) {
+	return nil, nil
+}
+
+/* Section: fake telegram API */
+
+// fakeTelegramAPI implements telegram.API serving recorded updates, and
+// recording the messages sent
+type fakeTelegramAPI struct {
+	updates chan t.Update
+	calls   *callLog
+}
+
+func (api *fakeTelegramAPI) GetUpdatesChan(config telegram.UpdateConfig) (telegram.UpdatesChannel, error) {
+	return nil, fmt.Errorf("recorded updates are read with ReadUpdates")
+}
+
+func (api *fakeTelegramAPI) ReadUpdates(config telegram.UpdateConfig) (<-chan t.Update, error) {
+	return api.updates, nil
+}
+
+func (api *fakeTelegramAPI) Send(c telegram.Chattable) (telegram.Message, error) {
+	msg, ok := c.(telegram.MessageConfig)
+	if !ok {
+		api.calls.add(fmt.Sprintf("telegram %T", c), nil)
+		return telegram.Message{}, nil
+	}
+	params := map[string]string{
+		"chat_id": strconv.FormatInt(msg.ChatID, 10),
+		"text":    msg.Text,
+	}
+	if msg.ParseMode != "" {
+		params["parse_mode"] = msg.ParseMode
+	}
+	if msg.ReplyToMessageID != 0 {
+		params["reply_to_message_id"] = strconv.Itoa(msg.ReplyToMessageID)
+	}
+	if msg.DisableWebPagePreview {
+		params["disable_web_page_preview"] = "true"
+	}
+	api.calls.add("telegram sendMessage", params)
+	return telegram.Message{Chat: &telegram.Chat{ID: msg.ChatID}, Text: msg.Text}, nil
+}
+
+func (api *fakeTelegramAPI) GetChatAdministrators(config telegram.ChatConfig) ([]telegram.ChatMember, error) {
+	return nil, nil
+}
+
+func (api *fakeTelegramAPI) GetInviteLink(config telegram.ChatConfig) (string, error) {
+	return "", nil
+}
+
+/* Section: fake writer */
+
+// fakeWriter is a pumper of a platform without a fake API, which reads
+// nothing and records the messages written to it
+type fakeWriter struct {
+	*cable.Pump
+	calls    *callLog
+	platform string
+	channel  string
+}
+
+// newFakeWriter returns the address of a new fakeWriter
+func newFakeWriter(calls *callLog, platform string, channel string) *fakeWriter {
+	return &fakeWriter{Pump: cable.NewPump(), calls: calls, platform: platform, channel: channel}
+}
+
+// GoRead waits until stopped, as there is nothing to read
+func (w *fakeWriter) GoRead() {
+	go func() { <-w.ReadStopper }()
+}
+
+// GoWrite records the messages arriving at the outbox until stopped
+func (w *fakeWriter) GoWrite() {
+	go func() {
+		for {
+			select {
+			case m := <-w.Outbox():
+				w.calls.add(fmt.Sprintf("%s send", w.platform), map[string]string{"to": w.channel, "message": m.String()})
+			case <-w.WriteStopper:
+				return
+			}
+		}
+	}()
+}
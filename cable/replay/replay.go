@@ -0,0 +1,186 @@
+// Package replay feeds the events of a recording through a bridge, as if they
+// were read again from slack and telegram, and writes the API calls the bridge
+// makes to relay them.
+//
+// Endpoints read from fake APIs serving the recorded events, and write to fake
+// APIs recording the calls, so the messages go through the real pumpers and
+// middleware pipelines without network access. The calls written for a
+// recording are stable, which makes recordings usable as golden files.
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/admin"
+	"github.com/miguelff/cable/cable/recording"
+	s "github.com/miguelff/cable/cable/slack"
+	t "github.com/miguelff/cable/cable/telegram"
+	"github.com/nlopes/slack"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSettle is the default time waited for the calls made to relay an
+// event
+const DefaultSettle = 200 * time.Millisecond
+
+// Options configures a replay
+type Options struct {
+	// Bridge is the bridge the events are fed through. Its endpoints read the
+	// events of their channels, as in the live bridge.
+	Bridge admin.BridgeSpec
+	// SlackBotUserID is the ID of the slack bot, whose messages are ignored
+	SlackBotUserID string
+	// TelegramBotUserID is the ID of the telegram bot, whose messages are
+	// ignored
+	TelegramBotUserID int
+	// Settle is the time without calls after which the event fed is
+	// considered relayed, and the next one is fed. Defaults to DefaultSettle.
+	Settle time.Duration
+}
+
+// Run feeds the entries through the bridge in order, writing to out each
+// event fed followed by the calls made to relay it, sorted
+func Run(entries []recording.Entry, options Options, out io.Writer) error {
+	settle := options.Settle
+	if settle == 0 {
+		settle = DefaultSettle
+	}
+
+	calls := newCallLog()
+	slackAPI := &fakeSlackAPI{events: make(chan slack.RTMEvent), users: make(s.UserMap), calls: calls}
+	telegramAPI := &fakeTelegramAPI{updates: make(chan t.Update), calls: calls}
+	for _, entry := range entries {
+		if entry.Platform == "slack" && entry.Type == "users" {
+			var users []slack.User
+			if err := json.Unmarshal(entry.Event, &users); err != nil {
+				return fmt.Errorf("slack users recorded at %s: %v", entry.Time, err)
+			}
+			for _, u := range users {
+				slackAPI.users[u.ID] = u
+			}
+		}
+	}
+
+	builders := map[string]admin.Builder{
+		"slack": func(spec admin.EndpointSpec) (cable.Pumper, error) {
+			return s.NewSlackWithAPI(slackAPI, spec.Channel, options.SlackBotUserID), nil
+		},
+		"telegram": func(spec admin.EndpointSpec) (cable.Pumper, error) {
+			chatID, err := strconv.ParseInt(spec.Channel, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			return t.NewTelegramWithAPI(telegramAPI, chatID, options.TelegramBotUserID), nil
+		},
+		"email": func(spec admin.EndpointSpec) (cable.Pumper, error) {
+			return newFakeWriter(calls, "email", spec.Channel), nil
+		},
+	}
+	// closing the fake APIs ends the goroutines reading from them, once the
+	// bridge is deleted
+	defer close(slackAPI.events)
+	defer close(telegramAPI.updates)
+	manager := admin.NewManager(discardStore{}, nil, builders)
+	bridge := options.Bridge
+	bridge.Paused = false
+	if err := manager.Create(bridge); err != nil {
+		return err
+	}
+	defer manager.Delete(bridge.Name)
+
+	for _, entry := range entries {
+		switch {
+		case entry.Platform == "slack" && entry.Type == "message":
+			var ev s.MessageEvent
+			if err := json.Unmarshal(entry.Event, &ev); err != nil {
+				return fmt.Errorf("slack message recorded at %s: %v", entry.Time, err)
+			}
+			fmt.Fprintf(out, "slack message %s in %s: %q\n", ev.Timestamp, ev.Channel, ev.Text)
+			slackAPI.events <- slack.RTMEvent{Type: "message", Data: &ev}
+		case entry.Platform == "telegram" && entry.Type == "update":
+			var update t.Update
+			if err := json.Unmarshal(entry.Event, &update); err != nil {
+				return fmt.Errorf("telegram update recorded at %s: %v", entry.Time, err)
+			}
+			if update.Message != nil && update.Message.Chat != nil {
+				fmt.Fprintf(out, "telegram update %d in %d: %q\n", update.UpdateID, update.Message.Chat.ID, update.Message.Text)
+			} else {
+				fmt.Fprintf(out, "telegram update %d\n", update.UpdateID)
+			}
+			telegramAPI.updates <- update
+		default:
+			continue
+		}
+
+		made := calls.settle(settle)
+		sort.Strings(made)
+		for _, call := range made {
+			fmt.Fprintf(out, "  -> %s\n", call)
+		}
+	}
+	return nil
+}
+
+// callLog keeps the calls made to the fake APIs
+type callLog struct {
+	mutex   sync.Mutex
+	calls   []string
+	changed chan struct{}
+}
+
+// newCallLog returns the address of a new, empty callLog
+func newCallLog() *callLog {
+	return &callLog{changed: make(chan struct{})}
+}
+
+// add records a call, made with the method of an API and the given
+// parameters
+func (l *callLog) add(method string, params map[string]string) {
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	call := []string{method}
+	for _, k := range keys {
+		call = append(call, fmt.Sprintf("%s=%q", k, params[k]))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.calls = append(l.calls, strings.Join(call, " "))
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// settle waits until no call is made for the given time, returning the calls
+// made since the last time it was called
+func (l *callLog) settle(quiet time.Duration) []string {
+	for {
+		l.mutex.Lock()
+		changed := l.changed
+		l.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(quiet):
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			calls := l.calls
+			l.calls = nil
+			return calls
+		}
+	}
+}
+
+// discardStore is an admin.Store not storing anything, as replayed bridges
+// are not meant to last
+type discardStore struct{}
+
+func (discardStore) Load() ([]admin.BridgeSpec, error) { return nil, nil }
+func (discardStore) Save([]admin.BridgeSpec) error     { return nil }
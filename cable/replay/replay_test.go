@@ -0,0 +1,50 @@
+package replay
+
+import (
+	"bytes"
+	"flag"
+	"github.com/miguelff/cable/cable/admin"
+	"github.com/miguelff/cable/cable/recording"
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the replays")
+
+func TestRun_Golden(t *testing.T) {
+	entries, err := recording.ReadFile("testdata/bridge.jsonl")
+	Nil(t, err)
+
+	options := Options{
+		Bridge: admin.BridgeSpec{
+			Name: "replay",
+			Endpoints: []admin.EndpointSpec{
+				{Platform: "slack", Channel: "C0CABLE"},
+				{Platform: "telegram", Channel: "-1001234"},
+				{Platform: "email", Channel: "team@example.com", Direction: "write-only"},
+			},
+		},
+		SlackBotUserID:    "B0CABLE",
+		TelegramBotUserID: 1000,
+		Settle:            50 * time.Millisecond,
+	}
+	var out bytes.Buffer
+	Nil(t, Run(entries, options, &out))
+
+	if *update {
+		Nil(t, ioutil.WriteFile("testdata/bridge.golden", out.Bytes(), 0644))
+	}
+	golden, err := ioutil.ReadFile("testdata/bridge.golden")
+	Nil(t, err)
+	Equal(t, string(golden), out.String())
+}
+
+func TestRun_UnknownPlatform(t *testing.T) {
+	options := Options{Bridge: admin.BridgeSpec{
+		Name:      "replay",
+		Endpoints: []admin.EndpointSpec{{Platform: "slack", Channel: "C0CABLE"}, {Platform: "irc", Channel: "#cable"}},
+	}}
+	Error(t, Run(nil, options, ioutil.Discard))
+}
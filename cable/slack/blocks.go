@@ -0,0 +1,442 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kyokomi/emoji"
+	"github.com/miguelff/cable/cable"
+	"github.com/nlopes/slack"
+	"html"
+	"regexp"
+	"strings"
+)
+
+/* Section: rendering slack layouts into telegram HTML */
+
+// Block is a Block Kit block of a slack message. Only the parts rendered in
+// other platforms are read.
+type Block struct {
+	// Type is the type of the block, like "section" or "actions"
+	Type string `json:"type"`
+	// Text is the text of section and header blocks, and Fields the ones
+	// section blocks show in columns
+	Text   *TextObject  `json:"text,omitempty"`
+	Fields []TextObject `json:"fields,omitempty"`
+	// Accessory is the element shown next to the text of a section block
+	Accessory *BlockElement `json:"accessory,omitempty"`
+	// Elements are the texts and images of context blocks, and the buttons
+	// of actions blocks
+	Elements []BlockElement `json:"elements,omitempty"`
+	// ImageURL, AltText and Title are the image of image blocks, its
+	// description and its title
+	ImageURL string      `json:"image_url,omitempty"`
+	AltText  string      `json:"alt_text,omitempty"`
+	Title    *TextObject `json:"title,omitempty"`
+}
+
+// TextObject is a text in a block, formatted with slack markdown if its type
+// is "mrkdwn", or not if it is "plain_text"
+type TextObject struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// BlockElement is an element of a block, like a text, an image or a button
+type BlockElement struct {
+	// Type is the type of the element, like "mrkdwn", "image" or "button"
+	Type string
+	// Text is the text of text elements, or the label of buttons
+	Text TextObject
+	// URL is the URL buttons link to
+	URL string
+	// ImageURL and AltText are the image of image elements, and its
+	// description
+	ImageURL string
+	AltText  string
+}
+
+// UnmarshalJSON reads an element, whose text is a string in text elements,
+// and a text object in buttons
+func (e *BlockElement) UnmarshalJSON(data []byte) error {
+	var element struct {
+		Type     string          `json:"type"`
+		Text     json.RawMessage `json:"text"`
+		URL      string          `json:"url"`
+		ImageURL string          `json:"image_url"`
+		AltText  string          `json:"alt_text"`
+	}
+	if err := json.Unmarshal(data, &element); err != nil {
+		return err
+	}
+	*e = BlockElement{Type: element.Type, URL: element.URL, ImageURL: element.ImageURL, AltText: element.AltText}
+	if len(element.Text) == 0 {
+		return nil
+	}
+	if element.Text[0] == '"' {
+		e.Text.Type = element.Type
+		return json.Unmarshal(element.Text, &e.Text.Text)
+	}
+	return json.Unmarshal(element.Text, &e.Text)
+}
+
+var (
+	// slackControl matches the control sequences of slack markdown, like
+	// links and mentions, with their target and optional label
+	slackControl = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+	// slackEmphasis are the emphasis markers of slack markdown, and the
+	// telegram HTML tags they are rendered with
+	slackEmphasis = []struct {
+		marker *regexp.Regexp
+		tag    string
+	}{
+		{regexp.MustCompile(`\*([^*\n]+)\*`), "b"},
+		{regexp.MustCompile(`\b_([^_\n]+)_\b`), "i"},
+		{regexp.MustCompile(`~([^~\n]+)~`), "s"},
+	}
+)
+
+// laidOut tells whether the slack message is laid out with blocks or
+// attachments. The rich text blocks slack adds to the messages people write
+// are not taken into account, as their text already tells the same.
+func (sm Message) laidOut() bool {
+	return len(sm.Attachments) > 0 || sm.laidOutWithBlocks()
+}
+
+// telegramHTML renders the slack message into telegram HTML, returning the
+// snippets of the code blocks too long to be relayed as text. Messages laid
+// out with blocks are rendered from them, as their text is just what slack
+// notifies, and otherwise from their text followed by their attachments.
+func (sm Message) telegramHTML() (string, []cable.Snippet) {
+	r := &htmlRenderer{users: sm.Users, emoji: sm.Emoji}
+	var parts []string
+	if sm.laidOutWithBlocks() {
+		for _, block := range sm.blocks {
+			parts = appendNonEmpty(parts, r.block(block))
+		}
+	} else {
+		parts = appendNonEmpty(parts, r.markdown(sm.Content()))
+		for _, attachment := range sm.Attachments {
+			parts = appendNonEmpty(parts, r.attachment(attachment))
+		}
+	}
+	return strings.Join(parts, "\n"), r.snippets
+}
+
+// laidOutWithBlocks tells whether the slack message has blocks other than
+// rich text ones
+func (sm Message) laidOutWithBlocks() bool {
+	for _, block := range sm.blocks {
+		if block.Type != "rich_text" {
+			return true
+		}
+	}
+	return false
+}
+
+// layoutTexts returns the addresses of the texts shown by the blocks and
+// attachments the slack message is laid out with, in the order they are
+// rendered
+func (sm *Message) layoutTexts() []*string {
+	var texts []*string
+	for i := range sm.blocks {
+		block := &sm.blocks[i]
+		if block.Text != nil {
+			texts = append(texts, &block.Text.Text)
+		}
+		for j := range block.Fields {
+			texts = append(texts, &block.Fields[j].Text)
+		}
+		if block.Accessory != nil {
+			texts = append(texts, &block.Accessory.Text.Text)
+		}
+		for j := range block.Elements {
+			texts = append(texts, &block.Elements[j].Text.Text, &block.Elements[j].AltText)
+		}
+		texts = append(texts, &block.AltText)
+		if block.Title != nil {
+			texts = append(texts, &block.Title.Text)
+		}
+	}
+	if sm.MessageEvent == nil {
+		return texts
+	}
+	for i := range sm.Attachments {
+		attachment := &sm.Attachments[i]
+		texts = append(texts, &attachment.Pretext, &attachment.AuthorName, &attachment.Title, &attachment.Text)
+		for j := range attachment.Fields {
+			texts = append(texts, &attachment.Fields[j].Title, &attachment.Fields[j].Value)
+		}
+		for j := range attachment.Actions {
+			texts = append(texts, &attachment.Actions[j].Text)
+		}
+		texts = append(texts, &attachment.Footer, &attachment.Fallback)
+	}
+	return texts
+}
+
+// copyLayout makes the slack message lay out with a copy of its blocks and
+// attachments, so changing their texts leaves the original untouched
+func (sm *Message) copyLayout() {
+	blocks := make([]Block, len(sm.blocks))
+	for i, block := range sm.blocks {
+		if block.Text != nil {
+			text := *block.Text
+			block.Text = &text
+		}
+		block.Fields = append([]TextObject(nil), block.Fields...)
+		if block.Accessory != nil {
+			accessory := *block.Accessory
+			block.Accessory = &accessory
+		}
+		block.Elements = append([]BlockElement(nil), block.Elements...)
+		if block.Title != nil {
+			title := *block.Title
+			block.Title = &title
+		}
+		blocks[i] = block
+	}
+	if sm.blocks != nil {
+		sm.blocks = blocks
+	}
+	if sm.MessageEvent == nil || sm.Attachments == nil {
+		return
+	}
+	ev := *sm.MessageEvent
+	ev.Attachments = make([]slack.Attachment, len(sm.Attachments))
+	for i, attachment := range sm.Attachments {
+		attachment.Fields = append([]slack.AttachmentField(nil), attachment.Fields...)
+		attachment.Actions = append([]slack.AttachmentAction(nil), attachment.Actions...)
+		ev.Attachments[i] = attachment
+	}
+	sm.MessageEvent = &ev
+}
+
+// appendNonEmpty appends the given text to texts, unless it is empty
+func appendNonEmpty(texts []string, text string) []string {
+	if strings.TrimSpace(text) == "" {
+		return texts
+	}
+	return append(texts, text)
+}
+
+// htmlRenderer renders the parts of slack messages into telegram HTML,
+// collecting the snippets their code blocks too long to be relayed as text
+// are shared as
+type htmlRenderer struct {
+	users    UserMap
+	emoji    EmojiMap
+	snippets []cable.Snippet
+}
+
+// block renders a Block Kit block. Blocks that cannot be shown in telegram,
+// like inputs, are left out.
+func (r *htmlRenderer) block(block Block) string {
+	var lines []string
+	switch block.Type {
+	case "header":
+		if block.Text != nil {
+			lines = append(lines, "<b>"+r.text(*block.Text)+"</b>")
+		}
+	case "section":
+		if block.Text != nil {
+			lines = appendNonEmpty(lines, r.text(*block.Text))
+		}
+		for _, field := range block.Fields {
+			lines = appendNonEmpty(lines, r.text(field))
+		}
+		if block.Accessory != nil {
+			lines = appendNonEmpty(lines, r.button(*block.Accessory))
+		}
+	case "context":
+		var elements []string
+		for _, element := range block.Elements {
+			if element.Type == "mrkdwn" || element.Type == "plain_text" {
+				elements = appendNonEmpty(elements, r.text(element.Text))
+			}
+		}
+		if len(elements) > 0 {
+			lines = append(lines, "<i>"+strings.Join(elements, " · ")+"</i>")
+		}
+	case "actions":
+		var buttons []string
+		for _, element := range block.Elements {
+			buttons = appendNonEmpty(buttons, r.button(element))
+		}
+		lines = appendNonEmpty(lines, strings.Join(buttons, " | "))
+	case "image":
+		label := block.AltText
+		if block.Title != nil && block.Title.Text != "" {
+			label = block.Title.Text
+		}
+		lines = append(lines, link(block.ImageURL, label))
+	case "divider":
+		lines = append(lines, "———")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// button renders a button as a link to its URL. Buttons without one, which
+// need slack to interact with, are left out.
+func (r *htmlRenderer) button(element BlockElement) string {
+	if element.Type != "button" || element.URL == "" {
+		return ""
+	}
+	return link(element.URL, element.Text.Text)
+}
+
+// attachment renders a legacy attachment: its pretext, author, title, text,
+// fields, buttons, image and footer, or its fallback text if it has none
+func (r *htmlRenderer) attachment(attachment slack.Attachment) string {
+	var lines []string
+	lines = appendNonEmpty(lines, r.markdown(attachment.Pretext))
+	if attachment.AuthorName != "" {
+		lines = append(lines, "<b>"+linkOrText(attachment.AuthorLink, attachment.AuthorName)+"</b>")
+	}
+	if attachment.Title != "" {
+		lines = append(lines, "<b>"+linkOrText(attachment.TitleLink, attachment.Title)+"</b>")
+	}
+	lines = appendNonEmpty(lines, r.markdown(attachment.Text))
+	for _, field := range attachment.Fields {
+		if field.Title == "" {
+			lines = appendNonEmpty(lines, r.markdown(field.Value))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("<b>%s:</b> %s", html.EscapeString(unescape(field.Title)), r.markdown(field.Value)))
+	}
+	var buttons []string
+	for _, action := range attachment.Actions {
+		if action.Type == "button" && action.URL != "" {
+			buttons = append(buttons, link(action.URL, action.Text))
+		}
+	}
+	lines = appendNonEmpty(lines, strings.Join(buttons, " | "))
+	if attachment.ImageURL != "" {
+		lines = append(lines, link(attachment.ImageURL, attachment.ImageURL))
+	}
+	if attachment.Footer != "" {
+		lines = append(lines, "<i>"+r.markdown(attachment.Footer)+"</i>")
+	}
+	if len(lines) == 0 {
+		lines = appendNonEmpty(lines, r.markdown(attachment.Fallback))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// text renders a text object
+func (r *htmlRenderer) text(text TextObject) string {
+	if text.Type == "plain_text" {
+		return r.emoji.render(unescape(text.Text), emojiLink, func(text string) string {
+			return html.EscapeString(emoji.Sprint(text))
+		})
+	}
+	return r.markdown(text.Text)
+}
+
+// markdown renders a text formatted with slack markdown. Code blocks keep
+// their language, and the ones too long to be relayed as text are replaced by
+// a reference to the snippet they are shared as.
+func (r *htmlRenderer) markdown(text string) string {
+	var b strings.Builder
+	for _, fragment := range cable.Fragments(text) {
+		code := unescape(fragment.Text)
+		switch {
+		case fragment.Block && cable.TextLength(code) > cable.TelegramTextLimit:
+			snippet := cable.Snippet{
+				Filename: cable.SnippetFilename(fmt.Sprintf("snippet-%d", len(r.snippets)+1), fragment.Language),
+				Language: fragment.Language,
+				Content:  code,
+			}
+			r.snippets = append(r.snippets, snippet)
+			fmt.Fprintf(&b, "📎 %s", html.EscapeString(snippet.Filename))
+		case fragment.Block && fragment.Language != "":
+			fmt.Fprintf(&b, "<pre><code class=\"language-%s\">%s</code></pre>", fragment.Language, html.EscapeString(code))
+		case fragment.Block:
+			fmt.Fprintf(&b, "<pre>%s</pre>", html.EscapeString(code))
+		case fragment.Code:
+			fmt.Fprintf(&b, "<code>%s</code>", html.EscapeString(code))
+		default:
+			b.WriteString(r.inline(fragment.Text))
+		}
+	}
+	return b.String()
+}
+
+// inline renders a text formatted with slack markdown without code: its
+// links, mentions, emphasis and emoji
+func (r *htmlRenderer) inline(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, match := range slackControl.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(r.emoji.render(text[last:match[0]], emojiLink, emphasize))
+		target, label := text[match[2]:match[3]], ""
+		if match[4] >= 0 {
+			label = text[match[4]:match[5]]
+		}
+		b.WriteString(r.control(target, label))
+		last = match[1]
+	}
+	b.WriteString(r.emoji.render(text[last:], emojiLink, emphasize))
+	return b.String()
+}
+
+// control renders a control sequence of slack markdown: mentions of users,
+// channels and groups are rendered as the names they are shown with, and
+// links as such
+func (r *htmlRenderer) control(target string, label string) string {
+	switch {
+	case strings.HasPrefix(target, "@"):
+		if user, ok := r.users[target[1:]]; ok {
+			return "@" + html.EscapeString(user.Name)
+		}
+		if label != "" {
+			return "@" + html.EscapeString(unescape(strings.TrimPrefix(label, "@")))
+		}
+		return html.EscapeString(target)
+	case strings.HasPrefix(target, "#"):
+		if label != "" {
+			return "#" + html.EscapeString(unescape(label))
+		}
+		return html.EscapeString(target)
+	case strings.HasPrefix(target, "!"):
+		if label != "" {
+			return html.EscapeString(unescape(label))
+		}
+		return "@" + html.EscapeString(strings.SplitN(target[1:], "^", 2)[0])
+	}
+	if label == "" {
+		label = target
+	}
+	return link(target, label)
+}
+
+// emphasize renders a text formatted with slack markdown without code nor
+// control sequences, escaping it for telegram HTML
+func emphasize(text string) string {
+	text = html.EscapeString(emoji.Sprint(unescape(text)))
+	for _, emphasis := range slackEmphasis {
+		text = emphasis.marker.ReplaceAllString(text, "<"+emphasis.tag+">$1</"+emphasis.tag+">")
+	}
+	return text
+}
+
+// link renders a link to the given URL, both escaped by slack
+func link(url string, label string) string {
+	if label == "" {
+		label = url
+	}
+	return fmt.Sprintf("<a href=\"%s\">%s</a>", html.EscapeString(unescape(url)), html.EscapeString(unescape(label)))
+}
+
+// emojiLink renders a link to the image of a custom emoji, which telegram
+// previews
+func emojiLink(name string, url string) string {
+	return link(url, ":"+name+":")
+}
+
+// linkOrText renders a link to the given URL, or just the label if empty
+func linkOrText(url string, label string) string {
+	if url == "" {
+		return html.EscapeString(unescape(label))
+	}
+	return link(url, label)
+}
@@ -0,0 +1,241 @@
+package slack
+
+import (
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/miguelff/cable/cable/recording"
+	"github.com/nlopes/slack"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+/* Section: connections shared by every Slack pumper using the same token */
+
+// adapters holds the API adapter of each token and Web API, so every Slack
+// pumper using the same token shares a single RTM connection
+var (
+	adapters      = make(map[string]*APIAdapter)
+	adaptersMutex sync.Mutex
+)
+
+// sharedAdapter returns the API adapter of the given token, sending its
+// requests to the Web API served at apiURL, or slack.com if empty, creating
+// it if needed
+func sharedAdapter(token string, apiURL string) (*APIAdapter, error) {
+	adaptersMutex.Lock()
+	defer adaptersMutex.Unlock()
+	key := apiURL + " " + token
+	if adapter, ok := adapters[key]; ok {
+		return adapter, nil
+	}
+	client, err := newHTTPClient(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	adapter := &APIAdapter{Client: slack.New(token, slack.OptionHTTPClient(client)), Token: token, HTTPClient: client}
+	adapters[key] = adapter
+	return adapter, nil
+}
+
+// newHTTPClient returns the HTTP client of the slack clients, which sends
+// the requests to the Web API served at apiURL, like the one of an emulator,
+// or slack.com if empty
+func newHTTPClient(apiURL string) (*http.Client, error) {
+	client := metrics.NewHTTPClient("slack", "")
+	if apiURL == "" {
+		return client, nil
+	}
+	u, err := url.Parse(strings.TrimSuffix(apiURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = &baseURLTransport{Base: client.Transport, URL: u}
+	return client, nil
+}
+
+// baseURLTransport is an http.RoundTripper sending the requests to the Web API
+// served at URL, as the slack client always sends them to slack.com/api
+type baseURLTransport struct {
+	Base http.RoundTripper
+	URL  *url.URL
+}
+
+// RoundTrip rewrites the URL of the requests to the Web API and forwards them
+// to the Base transport, like the rest of requests
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "slack.com" || !strings.HasPrefix(req.URL.Path, "/api/") {
+		return t.Base.RoundTrip(req)
+	}
+	rewritten := new(http.Request)
+	*rewritten = *req
+	u := *req.URL
+	u.Scheme = t.URL.Scheme
+	u.Host = t.URL.Host
+	u.Path = t.URL.Path + strings.TrimPrefix(req.URL.Path, "/api")
+	rewritten.URL = &u
+	rewritten.Host = t.URL.Host
+	return t.Base.RoundTrip(rewritten)
+}
+
+// recorder records the events read from slack, if set
+var recorder *recording.Recorder
+
+// SetRecorder makes every slack connection record the message events it reads,
+// and the users of the workspace, with the given recorder. It has to be called
+// before creating any Slack pumper.
+func SetRecorder(r *recording.Recorder) {
+	recorder = r
+}
+
+// record records an event read from slack, logging why it cannot be recorded
+func record(typ string, event interface{}) {
+	if err := recorder.Record("slack", typ, event); err != nil {
+		log.WithField("platform", "slack").WithError(err).Warn("Cannot record event")
+	}
+}
+
+// subscription is the channel of events delivered to a subscriber, and the
+// channel closed when it unsubscribes
+type subscription struct {
+	events chan slack.RTMEvent
+	done   chan struct{}
+}
+
+// eventsFanOut reads the incoming events of a client once, delivering them to
+// any number of subscribers
+type eventsFanOut struct {
+	mutex         sync.Mutex
+	subscriptions map[*subscription]bool
+	// lastConnectionEvent is the last event about the state of the
+	// connection, delivered to new subscribers so they know it
+	lastConnectionEvent *slack.RTMEvent
+	// stop is closed when the last subscriber unsubscribes
+	stop chan struct{}
+}
+
+// fanOuts holds the fan out of the events of each client
+var (
+	fanOuts      = make(map[API]*eventsFanOut)
+	fanOutsMutex sync.Mutex
+)
+
+// subscribe returns a channel receiving the incoming events of the client,
+// and the function to call to stop receiving them.
+//
+// The first subscription to a client spawns a goroutine reading its events
+// and delivering them to every subscriber, which disconnects the client, if
+// it can, once the last subscriber unsubscribes.
+func subscribe(client API) (<-chan slack.RTMEvent, func()) {
+	fanOutsMutex.Lock()
+	defer fanOutsMutex.Unlock()
+	fanOut, ok := fanOuts[client]
+	if !ok {
+		fanOut = &eventsFanOut{subscriptions: make(map[*subscription]bool), stop: make(chan struct{})}
+		fanOuts[client] = fanOut
+		go fanOut.broadcast(client, client.IncomingEvents())
+	}
+
+	sub := &subscription{events: make(chan slack.RTMEvent, 1), done: make(chan struct{})}
+	fanOut.mutex.Lock()
+	fanOut.subscriptions[sub] = true
+	if fanOut.lastConnectionEvent != nil {
+		sub.events <- *fanOut.lastConnectionEvent
+	}
+	fanOut.mutex.Unlock()
+
+	unsubscribe := func() {
+		fanOutsMutex.Lock()
+		defer fanOutsMutex.Unlock()
+		fanOut.mutex.Lock()
+		defer fanOut.mutex.Unlock()
+		if !fanOut.subscriptions[sub] {
+			return
+		}
+		delete(fanOut.subscriptions, sub)
+		close(sub.done)
+		if len(fanOut.subscriptions) == 0 {
+			delete(fanOuts, client)
+			close(fanOut.stop)
+		}
+	}
+	return sub.events, unsubscribe
+}
+
+// broadcast delivers every event to the current subscribers, waiting for
+// each of them to receive it unless they unsubscribe meanwhile, until the
+// last of them unsubscribes
+func (f *eventsFanOut) broadcast(client API, events <-chan slack.RTMEvent) {
+	for {
+		select {
+		case ev := <-events:
+			f.deliver(ev)
+		case <-f.stop:
+			f.disconnect(client, events)
+			return
+		}
+	}
+}
+
+// deliver delivers an event to the current subscribers
+func (f *eventsFanOut) deliver(ev slack.RTMEvent) {
+	f.mutex.Lock()
+	switch data := ev.Data.(type) {
+	case *slack.ConnectedEvent:
+		if data.ConnectionCount > 1 {
+			metrics.Reconnects.Inc("slack")
+		}
+		f.lastConnectionEvent = &ev
+	case *slack.DisconnectedEvent, *slack.ConnectionErrorEvent, *slack.InvalidAuthEvent:
+		f.lastConnectionEvent = &ev
+	case *MessageEvent, *slack.MessageEvent:
+		record("message", data)
+	}
+	var subscriptions []*subscription
+	for sub := range f.subscriptions {
+		subscriptions = append(subscriptions, sub)
+	}
+	f.mutex.Unlock()
+
+	for _, sub := range subscriptions {
+		select {
+		case sub.events <- ev:
+		case <-sub.done:
+		}
+	}
+}
+
+// disconnect disconnects the client, if it can, reading its events until it
+// tells the connection is closed, as the client blocks until they are read
+func (f *eventsFanOut) disconnect(client API, events <-chan slack.RTMEvent) {
+	disconnecter, ok := client.(Disconnecter)
+	if !ok {
+		return
+	}
+	f.mutex.Lock()
+	last := f.lastConnectionEvent
+	f.mutex.Unlock()
+	if last != nil {
+		if _, ok := last.Data.(*slack.InvalidAuthEvent); ok {
+			// the client gave up connecting already
+			return
+		}
+	}
+
+	go func() {
+		if err := disconnecter.Disconnect(); err != nil {
+			log.WithField("platform", "slack").WithError(err).Debug("Disconnecting from slack")
+		}
+	}()
+	for ev := range events {
+		switch data := ev.Data.(type) {
+		case *slack.DisconnectedEvent:
+			if data.Intentional {
+				return
+			}
+		case *slack.InvalidAuthEvent:
+			return
+		}
+	}
+}
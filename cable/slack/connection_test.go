@@ -0,0 +1,92 @@
+package slack
+
+import (
+	"bytes"
+	"github.com/miguelff/cable/cable/recording"
+	api "github.com/nlopes/slack"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	events := make(chan api.RTMEvent)
+	client := &fakeSlackAPI{rtmEvents: events}
+
+	first, unsubscribeFirst := subscribe(client)
+	second, unsubscribeSecond := subscribe(client)
+	defer unsubscribeSecond()
+
+	go func() { events <- api.RTMEvent{Type: "connected", Data: &api.ConnectedEvent{ConnectionCount: 1}} }()
+	Equal(t, "connected", (<-first).Type)
+	Equal(t, "connected", (<-second).Type)
+
+	late, unsubscribeLate := subscribe(client)
+	defer unsubscribeLate()
+	Equal(t, "connected", (<-late).Type, "new subscribers get the state of the connection")
+
+	unsubscribeFirst()
+	go func() { events <- createSlackUserUpdate(slackChannelID, "Sup Jay!") }()
+	received := make(map[string]string)
+	for len(received) < 2 {
+		select {
+		case ev := <-second:
+			received["second"] = ev.Data.(*api.MessageEvent).Text
+		case ev := <-late:
+			received["late"] = ev.Data.(*api.MessageEvent).Text
+		}
+	}
+	Equal(t, map[string]string{"second": "Sup Jay!", "late": "Sup Jay!"}, received, "unsubscribed pumpers do not block the rest")
+}
+
+func TestSubscribe_Recording(t *testing.T) {
+	var out bytes.Buffer
+	SetRecorder(recording.NewRecorder(&out, true))
+	defer SetRecorder(nil)
+
+	events := make(chan api.RTMEvent)
+	received, unsubscribe := subscribe(&fakeSlackAPI{rtmEvents: events})
+	defer unsubscribe()
+
+	go func() {
+		events <- api.RTMEvent{Type: "connected", Data: &api.ConnectedEvent{ConnectionCount: 1}}
+		events <- createSlackUserUpdate(unknownSlackChannelID, "write me at will@example.com")
+	}()
+	<-received
+	<-received
+
+	entries, err := recording.Read(&out)
+	Nil(t, err)
+	Equal(t, 1, len(entries), "only messages are recorded, in any channel")
+	Equal(t, "message", entries[0].Type)
+	Contains(t, string(entries[0].Event), `"text":"write me at [redacted email]"`)
+}
+
+func TestSubscribe_Disconnect(t *testing.T) {
+	events := make(chan api.RTMEvent)
+	client := &disconnectingSlackAPI{fakeSlackAPI: &fakeSlackAPI{rtmEvents: events}, disconnected: make(chan bool, 1)}
+
+	_, unsubscribeFirst := subscribe(client)
+	_, unsubscribeSecond := subscribe(client)
+	unsubscribeFirst()
+	select {
+	case <-client.disconnected:
+		Fail(t, "disconnected while someone still reads")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unsubscribeSecond()
+	select {
+	case <-client.disconnected:
+	case <-time.After(time.Second):
+		Fail(t, "not disconnected after the last subscriber left")
+	}
+	// the fan out reads the events telling about the disconnection
+	events <- api.RTMEvent{Type: "disconnected", Data: &api.DisconnectedEvent{Intentional: true}}
+
+	_, unsubscribe := subscribe(client)
+	defer unsubscribe()
+	fanOutsMutex.Lock()
+	defer fanOutsMutex.Unlock()
+	NotNil(t, fanOuts[client], "subscribing again connects again")
+}
@@ -0,0 +1,70 @@
+package slack
+
+import (
+	"github.com/nlopes/slack"
+	"sort"
+)
+
+/* Section: discovering the IDs needed to configure cable */
+
+// Channel is a slack channel the bot can see
+type Channel struct {
+	// ID is the ID of the channel, like the one in SLACK_RELAYED_CHANNEL
+	ID string `json:"id"`
+	// Name is the name of the channel, without the leading #
+	Name string `json:"name"`
+	// Private tells whether the channel is private
+	Private bool `json:"private"`
+	// Member tells whether the bot is a member of the channel, which it has to
+	// be to relay its messages
+	Member bool `json:"member"`
+}
+
+// Discovery is what the bot of a token knows about itself and the channels of
+// its workspace
+type Discovery struct {
+	// UserID is the ID of the user of the bot
+	UserID string `json:"user_id"`
+	// BotID is the ID of the bot, like the one in SLACK_BOT_USER_ID, which is
+	// set in the messages it posts
+	BotID string `json:"bot_id"`
+	// Channels are the channels the bot can see, sorted by name
+	Channels []Channel `json:"channels"`
+}
+
+// Discover returns the identity of the bot of the given token, and the
+// channels it can see, calling auth.test, users.info and conversations.list
+func Discover(token string, apiURL string) (Discovery, error) {
+	var discovery Discovery
+	httpClient, err := newHTTPClient(apiURL)
+	if err != nil {
+		return discovery, err
+	}
+	client := slack.New(token, slack.OptionHTTPClient(httpClient))
+
+	discovery.UserID, discovery.BotID, err = identify(client)
+	if err != nil {
+		return discovery, err
+	}
+
+	params := &slack.GetConversationsParameters{
+		Types:           []string{"public_channel", "private_channel"},
+		ExcludeArchived: "true",
+		Limit:           1000,
+	}
+	for {
+		page, cursor, err := client.GetConversations(params)
+		if err != nil {
+			return discovery, err
+		}
+		for _, c := range page {
+			discovery.Channels = append(discovery.Channels, Channel{ID: c.ID, Name: c.Name, Private: c.IsPrivate, Member: c.IsMember})
+		}
+		if cursor == "" {
+			break
+		}
+		params.Cursor = cursor
+	}
+	sort.Slice(discovery.Channels, func(i, j int) bool { return discovery.Channels[i].Name < discovery.Channels[j].Name })
+	return discovery, nil
+}
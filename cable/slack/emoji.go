@@ -0,0 +1,114 @@
+package slack
+
+import (
+	"github.com/kyokomi/emoji"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// emojiCacheTTL is the time the custom emoji of the workspace are cached
+	// for, as they seldom change
+	emojiCacheTTL = 10 * time.Minute
+	// maxEmojiAliases is the number of aliases followed to resolve a custom
+	// emoji, which stops cycles
+	maxEmojiAliases = 5
+)
+
+/* Section: relaying the custom emoji of the workspace */
+
+// emojiCode matches the codes emoji are written with in slack, like
+// :partyparrot:, with their name
+var emojiCode = regexp.MustCompile(`:([a-z0-9_+'-]+):`)
+
+// EmojiMap holds the custom emoji of a slack workspace by name: the URL of
+// their image, or "alias:" followed by the name of the emoji they stand for
+type EmojiMap map[string]string
+
+// EmojiLister is implemented by the clients that can list the custom emoji
+// of the workspace
+type EmojiLister interface {
+	// GetEmoji returns the custom emoji of the workspace
+	GetEmoji() EmojiMap
+}
+
+// GetEmoji returns the custom emoji of the workspace, calling emoji.list, and
+// caches them for emojiCacheTTL. No custom emoji are returned if they cannot
+// be listed, like when the bot lacks the emoji:read scope.
+func (adapter *APIAdapter) GetEmoji() EmojiMap {
+	adapter.cacheMutex.Lock()
+	defer adapter.cacheMutex.Unlock()
+	if time.Now().Before(adapter.emojiExpiry) {
+		return adapter.emojiCache
+	}
+	custom, err := adapter.Client.GetEmoji()
+	if err != nil {
+		log.WithField("platform", "slack").WithError(err).Warn("Cannot list custom emoji")
+	}
+	adapter.emojiCache = EmojiMap(custom)
+	adapter.emojiExpiry = time.Now().Add(emojiCacheTTL)
+	return adapter.emojiCache
+}
+
+// customEmoji returns the custom emoji of the workspace, which are none if
+// the client cannot list them
+func (s *Slack) customEmoji() EmojiMap {
+	if lister, ok := s.client.(EmojiLister); ok {
+		return lister.GetEmoji()
+	}
+	return nil
+}
+
+// resolve returns the URL of the image of the custom emoji with the given
+// name, or the name of the standard emoji it stands for, which is the name
+// itself if it is not a custom emoji
+func (custom EmojiMap) resolve(name string) (url string, standard string) {
+	for i := 0; i < maxEmojiAliases; i++ {
+		value, ok := custom[name]
+		if !ok {
+			return "", name
+		}
+		if !strings.HasPrefix(value, "alias:") {
+			return value, ""
+		}
+		name = strings.TrimPrefix(value, "alias:")
+	}
+	return "", name
+}
+
+// render renders a text with emoji codes: the custom emoji with an image with
+// the image function, and the rest of the text, where aliases of standard
+// emoji are replaced by their codes, with the text function
+func (custom EmojiMap) render(text string, image func(name string, url string) string, rest func(string) string) string {
+	text = emojiCode.ReplaceAllStringFunc(text, func(code string) string {
+		if _, standard := custom.resolve(strings.Trim(code, ":")); standard != "" {
+			return ":" + standard + ":"
+		}
+		return code
+	})
+	var b strings.Builder
+	last := 0
+	for _, match := range emojiCode.FindAllStringSubmatchIndex(text, -1) {
+		name := text[match[2]:match[3]]
+		if url, _ := custom.resolve(name); url != "" {
+			b.WriteString(rest(text[last:match[0]]))
+			b.WriteString(image(name, url))
+			last = match[1]
+		}
+	}
+	b.WriteString(rest(text[last:]))
+	return b.String()
+}
+
+// markdownEmoji renders the emoji in a text for telegram markdown: standard
+// emoji as such, and custom ones as links to their image, which telegram
+// previews
+func (custom EmojiMap) markdownEmoji(text string) string {
+	return custom.render(text, func(name string, url string) string {
+		return "[:" + name + ":](" + url + ")"
+	}, func(text string) string {
+		return emoji.Sprint(text)
+	})
+}
@@ -0,0 +1,65 @@
+package slack
+
+import (
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	api "github.com/nlopes/slack"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var customEmoji = EmojiMap{
+	"partyparrot": "https://emoji.slack-edge.com/T1/partyparrot/1.gif",
+	"party_blob":  "alias:partyparrot",
+	"yes":         "alias:thumbsup",
+	"loop":        "alias:loop",
+}
+
+func TestEmojiMap_Resolve(t *testing.T) {
+	url, standard := customEmoji.resolve("party_blob")
+	Equal(t, "https://emoji.slack-edge.com/T1/partyparrot/1.gif", url, "aliases of custom emoji are resolved")
+	Equal(t, "", standard)
+	url, standard = customEmoji.resolve("yes")
+	Equal(t, "", url)
+	Equal(t, "thumbsup", standard)
+	_, standard = customEmoji.resolve("smile")
+	Equal(t, "smile", standard, "standard emoji are not custom")
+	_, standard = customEmoji.resolve("loop")
+	Equal(t, "loop", standard, "cycles of aliases are stopped")
+}
+
+func TestSlackMessage_ToTelegram_CustomEmoji(t *testing.T) {
+	msg := createSlackMessage("Shipped :partyparrot: :yes: :smile: :party_blob: `:partyparrot:`", "STRGRID")
+	msg.Emoji = customEmoji
+
+	actual, _ := msg.ToTelegram(123)
+	Equal(t, telegram.ModeMarkdown, actual.ParseMode)
+	Equal(t, "*Stranger:* Shipped [:partyparrot:](https://emoji.slack-edge.com/T1/partyparrot/1.gif) 👍  😄  "+
+		"[:party_blob:](https://emoji.slack-edge.com/T1/partyparrot/1.gif) `:partyparrot:`", actual.Text, "emoji in code are not rendered")
+	Equal(t, customEmoji, msg.WithContent("Shipped").(*Message).Emoji, "custom emoji are kept when the text changes")
+
+	msg.blocks = []Block{
+		{Type: "header", Text: &TextObject{Type: "plain_text", Text: "Deploy :partyparrot: & :yes:"}},
+		{Type: "section", Text: &TextObject{Type: "mrkdwn", Text: "*Done* :partyparrot: by <@UWILL>"}},
+	}
+	actual, _ = msg.ToTelegram(123)
+	Equal(t, telegram.ModeHTML, actual.ParseMode)
+	Equal(t, "<b>Stranger:</b> <b>Deploy <a href=\"https://emoji.slack-edge.com/T1/partyparrot/1.gif\">:partyparrot:</a> &amp; 👍 </b>\n"+
+		"<b>Done</b> <a href=\"https://emoji.slack-edge.com/T1/partyparrot/1.gif\">:partyparrot:</a> by @UWILL", actual.Text)
+}
+
+func TestSlack_GoRead_CustomEmoji(t *testing.T) {
+	updates := make(chan api.RTMEvent, 1)
+	updates <- createSlackUserUpdate(slackChannelID, ":partyparrot:")
+
+	fakeSlack := NewSlackWithAPI(&emojiSlackAPI{fakeSlackAPI: &fakeSlackAPI{rtmEvents: updates}, emoji: customEmoji}, slackChannelID, slackBotID)
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	select {
+	case m := <-fakeSlack.Inbox():
+		Equal(t, customEmoji, m.(*Message).Emoji)
+	case <-time.After(time.Second):
+		Fail(t, "timeout while processing the Read Pump")
+	}
+}
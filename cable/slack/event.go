@@ -0,0 +1,190 @@
+package slack
+
+import (
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/nlopes/slack"
+	log "github.com/sirupsen/logrus"
+	"html"
+	"regexp"
+)
+
+/* Section: relaying membership and channel-metadata events */
+
+// timestampPattern matches the timestamps identifying slack messages
+var timestampPattern = regexp.MustCompile(`^\d+\.\d+$`)
+
+// Pinner is implemented by the clients that can pin messages
+type Pinner interface {
+	// AddPin pins the given item to a channel
+	AddPin(channel string, item slack.ItemRef) error
+}
+
+// TopicSetter is implemented by the clients that can set the topic of a
+// channel
+type TopicSetter interface {
+	// SetTopicOfConversation sets the topic of the channel with the given ID
+	SetTopicOfConversation(channelID string, topic string) (*slack.Channel, error)
+}
+
+// AddPin pins the given item to a channel with the Client
+func (adapter *APIAdapter) AddPin(channel string, item slack.ItemRef) error {
+	return adapter.Client.AddPin(channel, item)
+}
+
+// SetTopicOfConversation sets the topic of a channel with the Client
+func (adapter *APIAdapter) SetTopicOfConversation(channelID string, topic string) (*slack.Channel, error) {
+	return adapter.Client.SetTopicOfConversation(channelID, topic)
+}
+
+// SetEventRelay makes the pumper read the kinds of events relay reads, and
+// mirror the ones it mirrors when written: pins are mirrored pinning the
+// copy of the message pinned, and topics setting the topic of the channel
+func (s *Slack) SetEventRelay(relay cable.EventRelay) {
+	s.events = relay
+}
+
+// readEvent pushes the event told by a message event of the relayed channel
+// to the inbox, like people joining it, if its kind is read, telling whether
+// the message event told an event instead of a message. The messages telling
+// about pins are dropped, as pin_added events tell about them.
+func (s *Slack) readEvent(ev *slack.MessageEvent) bool {
+	var event *cable.Event
+	switch ev.SubType {
+	case "channel_join", "group_join":
+		event = cable.NewEvent(cable.EventJoin, "slack", s.name(ev.User))
+		if ev.Inviter != "" {
+			event.Actor = s.name(ev.Inviter)
+			event.Members = []string{s.name(ev.User)}
+		}
+	case "channel_leave", "group_leave":
+		event = cable.NewEvent(cable.EventLeave, "slack", s.name(ev.User))
+	case "channel_topic", "group_topic":
+		event = cable.NewEvent(cable.EventTopic, "slack", s.name(ev.User))
+		event.Subject, event.Text = "topic", html.UnescapeString(ev.Topic)
+	case "channel_purpose", "group_purpose":
+		event = cable.NewEvent(cable.EventTopic, "slack", s.name(ev.User))
+		event.Subject, event.Text = "purpose", html.UnescapeString(ev.Purpose)
+	case "channel_name", "group_name":
+		event = cable.NewEvent(cable.EventTopic, "slack", s.name(ev.User))
+		event.Subject, event.Text = "name", ev.Name
+	case "pinned_item":
+		return true
+	default:
+		return false
+	}
+	s.pushEvent(event)
+	return true
+}
+
+// readPin pushes a pin of a message in the relayed channel to the inbox, if
+// pins are read, unless the bot itself pinned it
+func (s *Slack) readPin(ev *slack.PinAddedEvent) {
+	if ev.Channel != s.relayedChannelID || (s.userID != "" && ev.User == s.userID) || ev.Item.Message == nil {
+		return
+	}
+	event := cable.NewEvent(cable.EventPin, "slack", s.name(ev.User))
+	event.Text = html.UnescapeString(ev.Item.Message.Text)
+	event.Pinned = ev.Item.Message.Timestamp
+	event.PinnedSource, _ = s.parts.Source(event.Pinned)
+	s.pushEvent(event)
+}
+
+// pushEvent pushes an event read to the inbox, after the messages read
+// before, if its kind is read, ending its trace otherwise
+func (s *Slack) pushEvent(event *cable.Event) {
+	if !s.events.Reads(event.Kind) {
+		event.Span().SetAttribute("dropped", true).End()
+		return
+	}
+	metrics.MessagesRead.Inc("slack")
+	s.RecordRead()
+	s.downloads <- event
+}
+
+// name returns the name of the user with the given ID, which is the ID
+// itself if unknown
+func (s *Slack) name(userID string) string {
+	user, ok := s.GetIdentities()[userID]
+	switch {
+	case !ok:
+		return userID
+	case user.RealName != "":
+		return user.RealName
+	}
+	return user.Name
+}
+
+// writeEvent mirrors an event written in the relayed channel if its kind is
+// mirrored, telling whether its notice has to be posted instead, which is
+// the case when it is not mirrored and its kind is relayed
+func (s *Slack) writeEvent(event *cable.Event) bool {
+	deliver := event.Span()
+	logger := log.WithFields(cable.MessageFields(event)).WithField("platform", "slack").WithField("event", event.Kind)
+	if s.events.Mirrored[event.Kind] {
+		call := deliver.Child("slack.mirror")
+		mirrored, err := s.mirror(event)
+		call.EndWithError(err)
+		if err != nil {
+			logger.WithError(err).Warn("Slack error mirroring event")
+		}
+		if mirrored {
+			deliver.End()
+			s.RecordWrite()
+			logger.Debug("Event mirrored")
+			return false
+		}
+	}
+	if !s.events.Relayed[event.Kind] {
+		deliver.SetAttribute("dropped", true).End()
+		logger.Debug("Event dropped, as it cannot be mirrored")
+		return false
+	}
+	return true
+}
+
+// mirror pins the copy of the message an event pinned, or sets the topic of
+// the relayed channel to the one an event set, telling whether it did. Only
+// the topics of channels and the titles of chats are mirrored.
+func (s *Slack) mirror(event *cable.Event) (bool, error) {
+	var write func() error
+	switch event.Kind {
+	case cable.EventPin:
+		pinner, ok := s.client.(Pinner)
+		timestamp := s.pinned(event)
+		if !ok || timestamp == "" {
+			return false, nil
+		}
+		write = func() error {
+			return pinner.AddPin(s.relayedChannelID, slack.NewRefToMessage(s.relayedChannelID, timestamp))
+		}
+	case cable.EventTopic:
+		setter, ok := s.client.(TopicSetter)
+		if !ok || (event.Subject != "topic" && event.Subject != "title") {
+			return false, nil
+		}
+		write = func() error {
+			_, err := setter.SetTopicOfConversation(s.relayedChannelID, event.Text)
+			return err
+		}
+	default:
+		return false, nil
+	}
+	if err := s.throttle.Write(write, retryAfter, s.WriteStopper); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pinned returns the timestamp of the message of the relayed channel the
+// message an event pinned stands for: the one posted for it, or the one it
+// was relayed from. It is empty if none.
+func (s *Slack) pinned(event *cable.Event) string {
+	if timestamps := s.parts.Get(event.Pinned); len(timestamps) > 0 {
+		return timestamps[0]
+	}
+	if timestampPattern.MatchString(event.PinnedSource) {
+		return event.PinnedSource
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package slack
+
+import (
+	"github.com/miguelff/cable/cable"
+	api "github.com/nlopes/slack"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSlack_GoRead_Events(t *testing.T) {
+	subtype := func(subtype string, user string, edit func(*api.Msg)) api.RTMEvent {
+		msg := api.Msg{SubType: subtype, User: user, Channel: slackChannelID, Text: "system message"}
+		if edit != nil {
+			edit(&msg)
+		}
+		return api.RTMEvent{Data: &api.MessageEvent{Msg: msg}}
+	}
+	pinned := &api.Message{Msg: api.Msg{Text: "Lunch &amp; learn", Timestamp: "2.000"}}
+	updates := make(chan api.RTMEvent, 10)
+	updates <- subtype("channel_join", "CARLTON", func(m *api.Msg) { m.Inviter = slackUserID })
+	updates <- subtype("channel_leave", slackUserID, nil) // dropped, as leaves are not read
+	updates <- subtype("channel_topic", slackUserID, func(m *api.Msg) { m.Topic = "Bel-Air &amp; beyond" })
+	updates <- subtype("pinned_item", slackUserID, nil) // dropped, as pin_added tells about it
+	updates <- api.RTMEvent{Data: &api.PinAddedEvent{User: slackUserID, Channel: unknownSlackChannelID, Item: api.Item{Message: pinned}}}
+	updates <- api.RTMEvent{Data: &api.PinAddedEvent{User: "UBOT", Channel: slackChannelID, Item: api.Item{Message: pinned}}}
+	updates <- api.RTMEvent{Data: &api.PinAddedEvent{User: slackUserID, Channel: slackChannelID, Item: api.Item{Message: pinned}}}
+	updates <- createSlackUserUpdate(slackChannelID, "Sup Jay!")
+
+	users := UserMap{
+		slackUserID: createSlackUser(slackUserID, "Will Smith", "freshprince"),
+		"CARLTON":   createSlackUser("CARLTON", "", "carlton"),
+	}
+	fake := &identifiedSlackAPI{fakeSlackAPI: &fakeSlackAPI{rtmEvents: updates, users: users}, userID: "UBOT", botID: slackBotID}
+	fakeSlack := NewSlackWithAPI(fake, slackChannelID, "")
+	relay, _ := cable.ParseEventRelay("join,topic", "pin")
+	fakeSlack.SetEventRelay(relay)
+	fakeSlack.parts.Add("7", "2.000")
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	var read []cable.Message
+	for len(read) < 4 {
+		select {
+		case m := <-fakeSlack.Inbox():
+			read = append(read, m)
+		case <-time.After(time.Second):
+			Fail(t, "timeout while processing the Read Pump")
+			return
+		}
+	}
+	Equal(t, "➡️ Will Smith added carlton on slack", read[0].String())
+	Equal(t, "📝 Will Smith set the topic on slack to: Bel-Air & beyond", read[1].String())
+	pin, ok := cable.EventOf(read[2])
+	if True(t, ok) {
+		Equal(t, "📌 Will Smith pinned a message on slack: Lunch & learn", pin.String())
+		Equal(t, "2.000", pin.Pinned)
+		Equal(t, "7", pin.PinnedSource, "pins of messages posted by cable tell the message they were relayed from")
+	}
+	Equal(t, "Sup Jay!", read[3].Content())
+}
+
+func TestSlack_GoWrite_Events(t *testing.T) {
+	client := &pinningSlackAPI{updatingSlackAPI: &updatingSlackAPI{fakeSlackAPI: &fakeSlackAPI{}, updates: make(map[string][]api.MsgOption)}}
+	fakeSlack := NewSlackWithAPI(client, slackChannelID, slackBotID)
+	relay, _ := cable.ParseEventRelay("pin", "pin,topic")
+	fakeSlack.SetEventRelay(relay)
+	fakeSlack.GoWrite()
+	defer fakeSlack.StopWrite()
+
+	event := func(kind string, edit func(*cable.Event)) *cable.Event {
+		e := cable.NewEvent(kind, "telegram", "Will Smith")
+		edit(e)
+		return e
+	}
+	fakeSlack.Outbox() <- editMessage{Message: createTelegramMessage("Lunch?", "Will", "Smith", "freshprince"), id: "42"}
+	fakeSlack.Outbox() <- event(cable.EventPin, func(e *cable.Event) { e.Pinned = "42" })
+	fakeSlack.Outbox() <- event(cable.EventPin, func(e *cable.Event) { e.Pinned, e.PinnedSource = "43", "123.456" })
+	fakeSlack.Outbox() <- event(cable.EventPin, func(e *cable.Event) { e.Pinned, e.Text = "44", "Unknown" })
+	fakeSlack.Outbox() <- event(cable.EventTopic, func(e *cable.Event) { e.Subject, e.Text = "title", "Bel-Air" })
+	fakeSlack.Outbox() <- event(cable.EventJoin, func(e *cable.Event) {})
+	fakeSlack.Outbox() <- editMessage{Message: createTelegramMessage("Done", "Will", "Smith", "freshprince"), id: "45"}
+	deadline := time.Now().Add(time.Second)
+	for len(fakeSlack.parts.Get("45")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	Equal(t, []string{"1.000", "123.456"}, client.pins, "the copies of the messages pinned are pinned")
+	Equal(t, []string{"Bel-Air"}, client.topics)
+	Equal(t, 3, client.posted, "the notices of the pins not mirrored are posted, and the events not relayed dropped")
+}
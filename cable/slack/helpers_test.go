@@ -2,9 +2,15 @@ package slack
 
 import (
 	"encoding/json"
+	"fmt"
 	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
 	"github.com/miguelff/cable/cable/telegram"
+	"github.com/miguelff/cable/cable/tracing"
 	slackAPI "github.com/nlopes/slack"
+	"sync"
+	"testing"
+	"time"
 )
 
 /* Constants used in tests */
@@ -23,6 +29,9 @@ type fakeSlackAPI struct {
 	rtmEvents chan slackAPI.RTMEvent
 	sent      []slackAPI.MsgOption
 	users     UserMap
+	members   []string
+	// mutex guards what is sent, as the write pump sends it concurrently
+	mutex sync.Mutex
 }
 
 func (api *fakeSlackAPI) IncomingEvents() <-chan slackAPI.RTMEvent {
@@ -30,14 +39,202 @@ func (api *fakeSlackAPI) IncomingEvents() <-chan slackAPI.RTMEvent {
 }
 
 func (api *fakeSlackAPI) PostMessage(channelID string, options ...slackAPI.MsgOption) (string, string, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
 	api.sent = append(api.sent, options...)
 	return "", "", nil
 }
 
+// Sent returns a copy of the options of the messages posted
+func (api *fakeSlackAPI) Sent() []slackAPI.MsgOption {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return append([]slackAPI.MsgOption(nil), api.sent...)
+}
+
 func (api *fakeSlackAPI) GetUsers() UserMap {
 	return api.users
 }
 
+func (api *fakeSlackAPI) GetUsersInConversation(channelID string) ([]string, error) {
+	return api.members, nil
+}
+
+// uploadingSlackAPI is a fake Slack API that uploads files
+type uploadingSlackAPI struct {
+	*fakeSlackAPI
+	uploads []slackAPI.FileUploadParameters
+}
+
+func (api *uploadingSlackAPI) UploadFile(params slackAPI.FileUploadParameters) (*slackAPI.File, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.uploads = append(api.uploads, params)
+	return &slackAPI.File{ID: "FILE"}, nil
+}
+
+// Uploads returns a copy of the parameters of the files uploaded
+func (api *uploadingSlackAPI) Uploads() []slackAPI.FileUploadParameters {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return append([]slackAPI.FileUploadParameters(nil), api.uploads...)
+}
+
+// updatingSlackAPI is a fake Slack API that posts messages with consecutive
+// timestamps, and edits them
+type updatingSlackAPI struct {
+	*fakeSlackAPI
+	posted  int
+	updates map[string][]slackAPI.MsgOption
+	mutex   sync.Mutex
+}
+
+func (api *updatingSlackAPI) PostMessage(channelID string, options ...slackAPI.MsgOption) (string, string, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.posted++
+	return channelID, fmt.Sprintf("%d.000", api.posted), nil
+}
+
+func (api *updatingSlackAPI) UpdateMessage(channelID string, timestamp string, options ...slackAPI.MsgOption) (string, string, string, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.updates[timestamp] = options
+	return channelID, timestamp, "", nil
+}
+
+// deletingSlackAPI is a fake Slack API that posts and edits messages like
+// updatingSlackAPI, and deletes them
+type deletingSlackAPI struct {
+	*updatingSlackAPI
+	deleted []string
+}
+
+func (api *deletingSlackAPI) DeleteMessage(channelID string, timestamp string) (string, string, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.deleted = append(api.deleted, timestamp)
+	return channelID, timestamp, nil
+}
+
+// pinningSlackAPI is a fake Slack API that posts messages like
+// updatingSlackAPI, pins them and sets the topic of the channel
+type pinningSlackAPI struct {
+	*updatingSlackAPI
+	pins   []string
+	topics []string
+}
+
+func (api *pinningSlackAPI) AddPin(channel string, item slackAPI.ItemRef) error {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.pins = append(api.pins, item.Timestamp)
+	return nil
+}
+
+func (api *pinningSlackAPI) SetTopicOfConversation(channelID string, topic string) (*slackAPI.Channel, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.topics = append(api.topics, topic)
+	return &slackAPI.Channel{}, nil
+}
+
+// editMessage is a fake message with an ID, which edits the message read
+// before with it if edit is set
+type editMessage struct {
+	cable.Message
+	id   string
+	edit bool
+}
+
+func (m editMessage) ID() string {
+	return m.id
+}
+
+func (m editMessage) IsEdit() bool {
+	return m.edit
+}
+
+// fileMessage is a fake message carrying recordings and pictures
+type fileMessage struct {
+	cable.Message
+	audio  []cable.Audio
+	images []cable.Image
+}
+
+func (m fileMessage) Audio() []cable.Audio {
+	return m.audio
+}
+
+func (m fileMessage) Images() []cable.Image {
+	return m.images
+}
+
+// emojiSlackAPI is a fake Slack API that lists custom emoji
+type emojiSlackAPI struct {
+	*fakeSlackAPI
+	emoji EmojiMap
+}
+
+func (api *emojiSlackAPI) GetEmoji() EmojiMap {
+	return api.emoji
+}
+
+// downloadingSlackAPI is a fake Slack API that downloads files, failing for
+// the unknown ones, once released if release is not nil
+type downloadingSlackAPI struct {
+	*fakeSlackAPI
+	files   map[string]string
+	release chan struct{}
+}
+
+func (api *downloadingSlackAPI) DownloadFile(url string) ([]byte, error) {
+	if api.release != nil {
+		<-api.release
+	}
+	content, ok := api.files[url]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", url)
+	}
+	return []byte(content), nil
+}
+
+// identifiedSlackAPI is a fake Slack API that discovers the identity of the
+// bot
+type identifiedSlackAPI struct {
+	*fakeSlackAPI
+	userID, botID string
+}
+
+func (api *identifiedSlackAPI) Identity() (string, string, error) {
+	return api.userID, api.botID, nil
+}
+
+/* fake tracing exporter */
+
+type recordingExporter struct {
+	mutex sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) Export(spans []tracing.SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+// names returns the names of the spans exported, in the order they ended
+func (e *recordingExporter) names() []string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	var names []string
+	for _, span := range e.spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
 /* factories */
 
 // createTelegramMessage is a factory of cable.Message for the tests below
@@ -127,3 +324,27 @@ func asSlackJSONMessage(slackMessages slackAPI.MsgOption) slackJSONMessage {
 	_ = json.Unmarshal([]byte(serializedAttachments), &jsonMessages)
 	return jsonMessages[0]
 }
+
+// waitForState waits up to a second for the health of the reporter to reach
+// the given state, failing the test otherwise
+func waitForState(t *testing.T, reporter cable.HealthReporter, state string) {
+	deadline := time.Now().Add(time.Second)
+	for reporter.Health().State != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for state %s, got %s", state, reporter.Health().State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/* fake Slack API that can be disconnected */
+
+type disconnectingSlackAPI struct {
+	*fakeSlackAPI
+	disconnected chan bool
+}
+
+func (api *disconnectingSlackAPI) Disconnect() error {
+	api.disconnected <- true
+	return nil
+}
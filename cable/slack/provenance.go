@@ -0,0 +1,52 @@
+package slack
+
+import (
+	"encoding/json"
+	"github.com/miguelff/cable/cable"
+	"github.com/nlopes/slack"
+)
+
+/* Section: provenance of the messages posted by cable */
+
+// metadataEventType is the event type of the metadata cable posts messages
+// with
+const metadataEventType = "cable_relayed"
+
+// Metadata is the metadata of a slack message, which cable posts messages
+// with to tell their provenance to any cable instance reading them
+type Metadata struct {
+	EventType    string          `json:"event_type"`
+	EventPayload json.RawMessage `json:"event_payload"`
+}
+
+// MessageEvent is a slack message event along with its metadata and its
+// blocks, which the slack client does not read. It is the data of the message
+// events read from the RTM API.
+type MessageEvent struct {
+	slack.MessageEvent
+	Metadata *Metadata `json:"metadata,omitempty"`
+	Blocks   []Block   `json:"blocks,omitempty"`
+}
+
+func init() {
+	slack.EventMapping["message"] = MessageEvent{}
+}
+
+// metadataOf returns the metadata of a message with the given provenance
+func metadataOf(provenance cable.Provenance) Metadata {
+	payload, _ := json.Marshal(provenance)
+	return Metadata{EventType: metadataEventType, EventPayload: payload}
+}
+
+// provenance returns the provenance told by the metadata, which is empty
+// unless cable posted the message
+func (m *Metadata) provenance() cable.Provenance {
+	var provenance cable.Provenance
+	if m == nil || m.EventType != metadataEventType {
+		return provenance
+	}
+	if err := json.Unmarshal(m.EventPayload, &provenance); err != nil {
+		return cable.Provenance{}
+	}
+	return provenance
+}
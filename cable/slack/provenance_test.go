@@ -0,0 +1,49 @@
+package slack
+
+import (
+	"encoding/json"
+	"github.com/miguelff/cable/cable"
+	api "github.com/nlopes/slack"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMetadata_Provenance(t *testing.T) {
+	provenance := cable.Provenance{Origin: "other", Hops: 2}
+	metadata := metadataOf(provenance)
+	Equal(t, provenance, metadata.provenance())
+
+	var nilMetadata *Metadata
+	Equal(t, cable.Provenance{}, nilMetadata.provenance())
+	other := &Metadata{EventType: "task_created", EventPayload: json.RawMessage(`{"origin":"other"}`)}
+	Equal(t, cable.Provenance{}, other.provenance(), "metadata of other apps is ignored")
+}
+
+func TestMessageEvent_Unmarshal(t *testing.T) {
+	var ev MessageEvent
+	NoError(t, json.Unmarshal([]byte(`{"type":"message","channel":"CHANNEL","text":"Sup Jay!","metadata":{"event_type":"cable_relayed","event_payload":{"origin":"other","hops":1}}}`), &ev))
+	Equal(t, "Sup Jay!", ev.Text)
+	Equal(t, cable.Provenance{Origin: "other", Hops: 1}, ev.Metadata.provenance())
+}
+
+func TestSlack_GoRead_Provenance(t *testing.T) {
+	relayed := createSlackUserUpdate(slackChannelID, "Sup Jay!")
+	relayed.Data = &MessageEvent{MessageEvent: *relayed.Data.(*api.MessageEvent), Metadata: &Metadata{
+		EventType:    metadataEventType,
+		EventPayload: json.RawMessage(`{"origin":"other","hops":2}`),
+	}}
+	updates := make(chan api.RTMEvent, 1)
+	updates <- relayed
+
+	fakeSlack := NewSlackWithAPI(&fakeSlackAPI{rtmEvents: updates}, slackChannelID, slackBotID)
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	select {
+	case m := <-fakeSlack.Inbox():
+		Equal(t, cable.Provenance{Origin: "other", Hops: 2}, cable.ProvenanceOf(m))
+	case <-time.After(time.Second):
+		Fail(t, "timeout while processing the Read Pump")
+	}
+}
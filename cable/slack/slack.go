@@ -1,16 +1,46 @@
 package slack
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/kyokomi/emoji"
 	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/miguelff/cable/cable/tracing"
 	"github.com/nlopes/slack"
 	log "github.com/sirupsen/logrus"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// postMessageInterval and postMessageBurst are the pace messages are
+	// posted to a channel at, as slack allows about one message per second,
+	// and short bursts
+	postMessageInterval = time.Second
+	postMessageBurst    = 3
+	// uploadCommentLength is the length of the start of the messages
+	// uploaded as text files shown along with them
+	uploadCommentLength = 1000
+	// maxSnippetSize is the maximum size of the snippets downloaded from
+	// slack, which is as much as slack allows to paste
+	maxSnippetSize = 1 << 20
+	// downloadQueueSize is the number of messages read waiting for their
+	// snippets to be downloaded, above which the events wait to be read
+	downloadQueueSize = 50
+)
+
 /* Section: Slack API interface and its slack.Client adapter */
 
 // UserMap is a collection of slack Users indexed by their ID, which is a string
@@ -26,41 +56,263 @@ type API interface {
 	// GetUsers retrieves information about the Users in the slack workspace the
 	// app is connected to
 	GetUsers() UserMap
+	// GetUsersInConversation returns the IDs of the members of a channel
+	GetUsersInConversation(channelID string) ([]string, error)
 }
 
 // APIAdapter Adapts an api.Client to conform to the API interface
 type APIAdapter struct {
 	// Client is the adapted Client
 	Client *slack.Client
+	// Token is the token of the Client, which is needed to post messages
+	// with metadata
+	Token string
+	// HTTPClient makes the requests the Client cannot make
+	HTTPClient *http.Client
 	// RTMEvents is a local reference to the channels of events coming from slack
 	RTMEvents chan slack.RTMEvent
+	// rtm is the connection to the RTM API, if open
+	rtm *slack.RTM
+	// rtmMutex controls access to the connection by multiple goroutines
+	rtmMutex sync.Mutex
 	// userIdentitiesCache is a local cache of the list of Users
 	// in the workspace slack is installed in
 	userIdentitiesCache UserMap
+	// emojiCache is a local cache of the custom emoji of the workspace,
+	// until emojiExpiry
+	emojiCache  EmojiMap
+	emojiExpiry time.Time
 	// cache mutex controls access to the cache by multiple goroutines
 	cacheMutex sync.Mutex
+	// userID and botID are the identity of the bot, once discovered
+	userID, botID string
+	// identityMutex controls access to the identity by multiple goroutines
+	identityMutex sync.Mutex
+}
+
+// Identifier is implemented by the clients that can discover the identity of
+// the bot they act on behalf of
+type Identifier interface {
+	// Identity returns the ID of the user of the bot, and the ID of the bot,
+	// which is set in the messages it posts
+	Identity() (userID string, botID string, err error)
+}
+
+// MetadataPoster is implemented by the clients that can post messages with
+// metadata
+type MetadataPoster interface {
+	// PostMessageWithMetadata posts a message like PostMessage, along with the
+	// given metadata
+	PostMessageWithMetadata(channelID string, metadata Metadata, options ...slack.MsgOption) (string, string, error)
+}
+
+// MessageUpdater is implemented by the clients that can edit the messages
+// posted
+type MessageUpdater interface {
+	// UpdateMessage replaces the message with the given timestamp
+	UpdateMessage(channelID string, timestamp string, options ...slack.MsgOption) (string, string, string, error)
+}
+
+// MessageDeleter is implemented by the clients that can delete the messages
+// posted
+type MessageDeleter interface {
+	// DeleteMessage deletes the message with the given timestamp
+	DeleteMessage(channelID string, timestamp string) (string, string, error)
+}
+
+// FileUploader is implemented by the clients that can upload files
+type FileUploader interface {
+	// UploadFile uploads a file, sharing it in the channels of params
+	UploadFile(params slack.FileUploadParameters) (*slack.File, error)
+}
+
+// FileDeleter is implemented by the clients that can delete the files
+// uploaded
+type FileDeleter interface {
+	// DeleteFile deletes the file with the given ID
+	DeleteFile(fileID string) error
+}
+
+// Disconnecter is implemented by the clients that can close their connection
+// to slack
+type Disconnecter interface {
+	// Disconnect closes the connection the incoming events are read from,
+	// which tells it with an intentional DisconnectedEvent
+	Disconnect() error
+}
+
+// FileDownloader is implemented by the clients that can download the files
+// shared in slack
+type FileDownloader interface {
+	// DownloadFile downloads the contents of the private file at the given URL
+	DownloadFile(url string) ([]byte, error)
 }
 
 // IncomingEvents returns the channel of RTMEvents managed by the slack's API
 // Client.
 //
-// When called for the first time, it lazily spawns a goroutine to manage the
-// connection to the RTM api, caching locally a reference to the channel of
+// When called with no connection open, it lazily spawns a goroutine to manage
+// the connection to the RTM api, caching locally a reference to the channel of
 // updates.
 func (adapter *APIAdapter) IncomingEvents() <-chan slack.RTMEvent {
-	if adapter.RTMEvents == nil {
-		rtm := adapter.Client.NewRTM()
-		go rtm.ManageConnection()
-		adapter.RTMEvents = rtm.IncomingEvents
+	adapter.rtmMutex.Lock()
+	defer adapter.rtmMutex.Unlock()
+	if adapter.rtm == nil {
+		adapter.rtm = adapter.Client.NewRTM()
+		go adapter.rtm.ManageConnection()
+		adapter.RTMEvents = adapter.rtm.IncomingEvents
 	}
 	return adapter.RTMEvents
 }
 
+// Disconnect closes the connection to the RTM api, if open, so the next call
+// to IncomingEvents opens a new one
+func (adapter *APIAdapter) Disconnect() error {
+	adapter.rtmMutex.Lock()
+	rtm := adapter.rtm
+	adapter.rtm, adapter.RTMEvents = nil, nil
+	adapter.rtmMutex.Unlock()
+	if rtm == nil {
+		return nil
+	}
+	return rtm.Disconnect()
+}
+
 // PostMessage forwards the call to the adapted Client's PostMessage method
 func (adapter *APIAdapter) PostMessage(channelID string, options ...slack.MsgOption) (string, string, error) {
 	return adapter.Client.PostMessage(channelID, options...)
 }
 
+// UpdateMessage replaces the message with the given timestamp in a slack
+// channel
+func (adapter *APIAdapter) UpdateMessage(channelID string, timestamp string, options ...slack.MsgOption) (string, string, string, error) {
+	return adapter.Client.UpdateMessage(channelID, timestamp, options...)
+}
+
+// DeleteMessage deletes the message with the given timestamp in a slack
+// channel
+func (adapter *APIAdapter) DeleteMessage(channelID string, timestamp string) (string, string, error) {
+	return adapter.Client.DeleteMessage(channelID, timestamp)
+}
+
+// PostMessageWithMetadata posts a message like PostMessage, along with the
+// given metadata, which the Client cannot send
+func (adapter *APIAdapter) PostMessageWithMetadata(channelID string, metadata Metadata, options ...slack.MsgOption) (string, string, error) {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return "", "", err
+	}
+	endpoint, values, err := slack.UnsafeApplyMsgOptions(adapter.Token, channelID, slack.MsgOptionPost(), slack.MsgOptionCompose(options...))
+	if err != nil {
+		return "", "", err
+	}
+	values.Set("metadata", string(encoded))
+
+	resp, err := adapter.HTTPClient.PostForm(endpoint, values)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retry, err := strconv.ParseInt(resp.Header.Get("Retry-After"), 10, 64)
+		if err != nil {
+			retry = 1
+		}
+		return "", "", &slack.RateLimitedError{RetryAfter: time.Duration(retry) * time.Second}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("slack server error: %s", resp.Status)
+	}
+	var response struct {
+		slack.SlackResponse
+		Channel   string `json:"channel"`
+		Timestamp string `json:"ts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", "", err
+	}
+	if !response.Ok {
+		return "", "", errors.New(response.Error)
+	}
+	return response.Channel, response.Timestamp, nil
+}
+
+// Identity returns the identity of the bot, calling auth.test and users.info
+// the first time
+func (adapter *APIAdapter) Identity() (string, string, error) {
+	adapter.identityMutex.Lock()
+	defer adapter.identityMutex.Unlock()
+	if adapter.userID == "" {
+		userID, botID, err := identify(adapter.Client)
+		if err != nil {
+			return "", "", err
+		}
+		adapter.userID, adapter.botID = userID, botID
+	}
+	return adapter.userID, adapter.botID, nil
+}
+
+// identify returns the ID of the user of the bot of the client, calling
+// auth.test, and the ID of the bot, calling users.info
+func identify(client *slack.Client) (string, string, error) {
+	auth, err := client.AuthTest()
+	if err != nil {
+		return "", "", err
+	}
+	user, err := client.GetUserInfo(auth.UserID)
+	if err != nil {
+		return "", "", err
+	}
+	return auth.UserID, user.Profile.BotID, nil
+}
+
+// UploadFile uploads a file with the Client
+func (adapter *APIAdapter) UploadFile(params slack.FileUploadParameters) (*slack.File, error) {
+	return adapter.Client.UploadFile(params)
+}
+
+// DeleteFile forwards the call to the adapted Client's DeleteFile method
+func (adapter *APIAdapter) DeleteFile(fileID string) error {
+	return adapter.Client.DeleteFile(fileID)
+}
+
+// DownloadFile downloads the contents of the private file at the given URL,
+// which the Client cannot do, authenticating with its token
+func (adapter *APIAdapter) DownloadFile(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+adapter.Token)
+	resp, err := adapter.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("slack server error: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxSnippetSize))
+}
+
+// GetUsersInConversation returns the IDs of every member of the channel,
+// going through all the pages of results
+func (adapter *APIAdapter) GetUsersInConversation(channelID string) ([]string, error) {
+	var members []string
+	params := &slack.GetUsersInConversationParameters{ChannelID: channelID, Limit: 1000}
+	for {
+		page, cursor, err := adapter.Client.GetUsersInConversation(params)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if cursor == "" {
+			return members, nil
+		}
+		params.Cursor = cursor
+	}
+}
+
 // GetUsers returns the user information from slack and caches it locally for
 // a minute
 func (adapter *APIAdapter) GetUsers() UserMap {
@@ -84,7 +336,7 @@ func (adapter *APIAdapter) GetUsers() UserMap {
 
 	users, err := adapter.Client.GetUsers()
 	if err != nil {
-		log.Errorln("Cannot get user identities", err)
+		log.WithField("platform", "slack").WithError(err).Error("Cannot get user identities")
 	}
 	res := make(UserMap)
 	for _, u := range users {
@@ -92,6 +344,7 @@ func (adapter *APIAdapter) GetUsers() UserMap {
 	}
 
 	if err == nil {
+		record("users", recordedUsers(res))
 		log.Debugln("Setting Users cache...")
 		adapter.cacheMutex.Lock()
 		adapter.userIdentitiesCache = res
@@ -101,6 +354,25 @@ func (adapter *APIAdapter) GetUsers() UserMap {
 	return res
 }
 
+// recordedUsers returns the users with just the information needed to relay
+// their messages, which is what gets recorded of them
+func recordedUsers(users UserMap) []slack.User {
+	var recorded []slack.User
+	for _, u := range users {
+		recorded = append(recorded, slack.User{
+			ID:       u.ID,
+			Name:     u.Name,
+			RealName: u.RealName,
+			Deleted:  u.Deleted,
+			IsBot:    u.IsBot,
+			IsAdmin:  u.IsAdmin,
+			IsOwner:  u.IsOwner,
+		})
+	}
+	sort.Slice(recorded, func(i, j int) bool { return recorded[i].ID < recorded[j].ID })
+	return recorded
+}
+
 /* Section: Slack type implementing GoRead() and GoWrite() */
 
 // Slack adapts the Telegram API creating a Pump of messages
@@ -114,43 +386,215 @@ type Slack struct {
 	// relayed to
 	relayedChannelID string
 	// botUserID is the id of the slack installed in the organization, which is
-	// used to discard messages looped back by the own bot
+	// used to discard messages looped back by the own bot. It is discovered
+	// when reading if empty.
 	botUserID string
+	// userID is the id of the user of the bot, which is also used to discard
+	// its own messages. It is discovered when reading.
+	userID string
+	// sent are the messages posted to the relayed channel, by timestamp
+	sent *cable.SentLog
+	// throttle paces the messages posted to the relayed channel
+	throttle *cable.Throttle
+	// parts are the timestamps of the messages posted for every message
+	// written, by its ID, as long messages are posted in several parts
+	parts *cable.PartLog
+	// maxParts is the number of parts above which long messages are uploaded
+	// as a text file instead, or zero to always split them
+	maxParts int
+	// format is the format messages are laid out in, which is Block Kit
+	// unless cable.SlackFormatAttachments
+	format string
+	// events tells the kinds of events read, and the ones mirrored when
+	// written
+	events cable.EventRelay
+	// downloads are the messages read waiting for their snippets to be
+	// downloaded, along with everything else read, so it is relayed in the
+	// order it was read. It is made when reading starts.
+	downloads chan cable.Message
+}
+
+// NewSlack returns the address of a new value of Slack, sending its requests
+// to the Web API served at apiURL, like the one of an emulator, or slack.com
+// if empty. The botUserID overrides the ID of the bot discovered with the
+// token, if not empty.
+//
+// Messages are posted to the relayed channel at the pace slack allows, which
+// is shared with every Slack posting to the same channel.
+func NewSlack(token string, apiURL string, relayedChannel string, botUserID string) *Slack {
+	adapter, err := sharedAdapter(token, apiURL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s := NewSlackWithAPI(adapter, relayedChannel, botUserID)
+	s.throttle.Limiters = []*cable.RateLimiter{
+		cable.SharedRateLimiter("slack/"+token+"/"+relayedChannel, postMessageInterval, postMessageBurst),
+	}
+	return s
 }
 
-// NewSlack returns the address of a new value of Slack
-func NewSlack(token string, relayedChannel string, botUserID string) *Slack {
+// NewSlackWithAPI returns the address of a new value of Slack using the given
+// client, like a fake one replaying recorded events
+func NewSlackWithAPI(client API, relayedChannel string, botUserID string) *Slack {
 	return &Slack{
 		Pump:             cable.NewPump(),
-		client:           &APIAdapter{Client: slack.New(token)},
+		client:           client,
 		relayedChannelID: relayedChannel,
 		botUserID:        botUserID,
+		sent:             cable.NewSentLog(),
+		throttle:         &cable.Throttle{Platform: "slack", Batches: cable.NewBatchLog()},
+		parts:            cable.NewPartLog(),
 	}
 }
 
+// SetCoalesceBacklog makes the messages queued to be posted combine into one
+// when more than backlog are waiting, or never if zero
+func (s *Slack) SetCoalesceBacklog(backlog int) {
+	s.throttle.CoalesceBacklog = backlog
+}
+
+// SetMaxParts makes the messages too long for slack be uploaded as a text file
+// when they would be split in more than maxParts, or never if zero. Messages
+// are split regardless if the client cannot upload files.
+func (s *Slack) SetMaxParts(maxParts int) {
+	s.maxParts = maxParts
+}
+
+// SetMessageFormat makes the messages be laid out with Block Kit if format is
+// cable.SlackFormatBlocks, the default, or with legacy attachments if
+// cable.SlackFormatAttachments
+func (s *Slack) SetMessageFormat(format string) {
+	s.format = format
+}
+
+// CanEdit tells whether the client can edit the messages posted, so edits are
+// relayed to the pumper
+func (s *Slack) CanEdit() bool {
+	_, ok := s.client.(MessageUpdater)
+	return ok
+}
+
+// CanDelete tells whether the client can delete the messages posted, so
+// deletions are relayed to the pumper
+func (s *Slack) CanDelete() bool {
+	_, ok := s.client.(MessageDeleter)
+	return ok
+}
+
 // GetIdentities returns the user information from slack and caches it locally
 // for a minute
 func (s *Slack) GetIdentities() UserMap {
 	return s.client.GetUsers()
 }
 
+// Participants returns the names of the people in the relayed channel,
+// leaving out bots
+func (s *Slack) Participants() ([]string, error) {
+	members, err := s.client.GetUsersInConversation(s.relayedChannelID)
+	if err != nil {
+		return nil, err
+	}
+	identities := s.GetIdentities()
+	var participants []string
+	for _, id := range members {
+		user, ok := identities[id]
+		if !ok {
+			participants = append(participants, id)
+		} else if !user.IsBot && !user.Deleted {
+			participants = append(participants, user.Name)
+		}
+	}
+	return participants, nil
+}
+
+// Link returns a link opening the relayed channel in slack
+func (s *Slack) Link() (string, error) {
+	return "https://slack.com/app_redirect?channel=" + s.relayedChannelID, nil
+}
+
+// identify discovers the identity of the bot, if the client can, so its own
+// messages are not relayed back. A configured bot ID takes precedence.
+func (s *Slack) identify() {
+	identifier, ok := s.client.(Identifier)
+	if !ok {
+		return
+	}
+	logger := log.WithField("platform", "slack")
+	userID, botID, err := identifier.Identity()
+	if err != nil {
+		logger.WithError(err).Warn("Cannot discover the identity of the bot, its own messages might be relayed back")
+		return
+	}
+	s.userID = userID
+	if s.botUserID == "" {
+		s.botUserID = botID
+	} else if s.botUserID != botID {
+		logger.WithField("bot_id", botID).Warnf("SLACK_BOT_USER_ID=%s overrides the ID of the bot", s.botUserID)
+	}
+	logger.WithField("user_id", s.userID).WithField("bot_id", s.botUserID).Debug("Bot identified")
+}
+
+// isOwn tells whether a message was posted by the bot itself
+func (s *Slack) isOwn(ev *slack.MessageEvent) bool {
+	return (s.botUserID != "" && ev.BotID == s.botUserID) || (s.userID != "" && ev.User == s.userID)
+}
+
+// mention returns the mention of the bot commands can start with instead of
+// cable.CommandPrefix, which slack would take for a slash command, or none if
+// the bot is not identified
+func (s *Slack) mention() string {
+	if s.userID == "" {
+		return ""
+	}
+	return "<@" + s.userID + ">"
+}
+
+// isAdmin tells whether the user with the given ID is an admin or owner of
+// the workspace
+func (s *Slack) isAdmin(userID string) bool {
+	user, ok := s.GetIdentities()[userID]
+	return ok && (user.IsAdmin || user.IsOwner)
+}
+
 // GoRead makes slack listening for messages in a different goroutine.
-// Those messages will be pushed to the InboxCh of the Pump.
+// Those messages will be pushed to the InboxCh of the Pump once another
+// goroutine downloads their snippets, except for commands, which are handed
+// over to the command handler of the Pump.
 //
 // The goroutine can be stopped by feeding ReadStopper synchronization channel
 // which can be done by calling StopRead() - a method coming from Pump and
 // which is accessed directly through the Slack value.
 func (s *Slack) GoRead() {
+	s.SetConnecting()
+	events, unsubscribe := subscribe(s.client)
+	s.downloads = make(chan cable.Message, downloadQueueSize)
 	go func() {
+		defer unsubscribe()
+		done := make(chan struct{})
+		defer close(done)
+		go s.goDownload(s.downloads, done)
+		s.identify()
 		for {
 			select {
-			case msg := <-s.client.IncomingEvents():
+			case msg := <-events:
 				switch ev := msg.Data.(type) {
+				case *slack.ConnectedEvent:
+					s.SetConnected()
+				case *slack.DisconnectedEvent:
+					s.SetDisconnected(nil)
+				case *slack.ConnectionErrorEvent:
+					s.RecordReadError(ev)
+					s.SetDisconnected(ev)
+				case *slack.InvalidAuthEvent:
+					s.SetDisconnected(errors.New("invalid authentication"))
+				case *slack.RTMError:
+					s.RecordReadError(ev)
+				case *MessageEvent:
+					s.read(ev)
 				case *slack.MessageEvent:
-					if ev.Channel != s.relayedChannelID || ev.BotID == s.botUserID {
-						continue
-					}
-					s.Inbox() <- &Message{ev, s.GetIdentities()}
+					s.read(&MessageEvent{MessageEvent: *ev})
+				case *slack.PinAddedEvent:
+					s.readPin(ev)
 				}
 			case <-s.ReadStopper:
 				return
@@ -159,6 +603,127 @@ func (s *Slack) GoRead() {
 	}()
 }
 
+// read pushes a message event of the relayed channel to the inbox, or hands
+// it over to the command handler, unless the bot itself posted it. Messages
+// get the provenance told by their metadata, or the one they were posted with
+// if this cable posted them. Message events telling about events, like people
+// joining the channel, are read as events instead, and the ones telling about
+// messages changed or deleted as edits or deletions of them.
+func (s *Slack) read(event *MessageEvent) {
+	ev := &event.MessageEvent
+	if ev.SubType == "message_deleted" {
+		s.readDeletion(ev)
+		return
+	}
+	edit := ev.SubType == "message_changed"
+	if edit {
+		if ev = edited(ev); ev == nil {
+			return
+		}
+	}
+	if ev.Channel != s.relayedChannelID || s.isOwn(ev) || (!edit && s.readEvent(ev)) {
+		return
+	}
+	provenance := event.Metadata.provenance()
+	if p, ok := s.sent.Get(ev.Timestamp); ok {
+		provenance = p
+	}
+	metrics.MessagesRead.Inc("slack")
+	s.RecordRead()
+	span := tracing.StartTrace("relay").SetAttribute("platform", "slack").SetAttribute("message_id", ev.Timestamp)
+	users := span.Child("slack.users.list")
+	message := &Message{MessageEvent: ev, Users: s.GetIdentities(), Emoji: s.customEmoji(), span: span, provenance: provenance, edit: edit}
+	if !edit {
+		message.blocks = event.Blocks
+	}
+	users.End()
+	if command, ok := cable.ParseMentionCommand(message, s.mention()); ok && !edit {
+		command.FromAdmin = s.isAdmin(ev.User)
+		if s.HandleCommand(command) {
+			return
+		}
+	}
+	s.downloads <- message
+}
+
+// edited returns the message event of the new version of the message a
+// message_changed event tells about, which carries the timestamp of the
+// message changed, or nil if it was not edited, like when slack unfurls the
+// links in it
+func edited(ev *slack.MessageEvent) *slack.MessageEvent {
+	if ev.SubMessage == nil || ev.SubMessage.Edited == nil {
+		return nil
+	}
+	edit := &slack.MessageEvent{Msg: *ev.SubMessage}
+	edit.Channel = ev.Channel
+	return edit
+}
+
+// readDeletion pushes the deletion of a message of the relayed channel to the
+// inbox, unless this cable posted the message, as the copies of the messages
+// relayed do not delete their originals
+func (s *Slack) readDeletion(ev *slack.MessageEvent) {
+	if ev.Channel != s.relayedChannelID || ev.DeletedTimestamp == "" {
+		return
+	}
+	if _, ok := s.sent.Get(ev.DeletedTimestamp); ok {
+		return
+	}
+	metrics.MessagesRead.Inc("slack")
+	s.RecordRead()
+	s.downloads <- cable.NewDeletion("slack", "", ev.DeletedTimestamp)
+}
+
+// goDownload downloads the snippets of the messages read, pushing them to the
+// inbox in the order they were read, until done is closed
+func (s *Slack) goDownload(read chan cable.Message, done chan struct{}) {
+	for {
+		select {
+		case m := <-read:
+			if message, ok := m.(*Message); ok {
+				message.files = s.snippets(message.MessageEvent, message.span)
+			}
+			select {
+			case s.Inbox() <- m:
+			case <-done:
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// snippets returns the snippets shared in a message event, downloading their
+// contents if the client can, or taking their preview otherwise
+func (s *Slack) snippets(ev *slack.MessageEvent, span *tracing.Span) []cable.Snippet {
+	var snippets []cable.Snippet
+	downloader, canDownload := s.client.(FileDownloader)
+	for _, file := range ev.Files {
+		if file.Mode != "snippet" {
+			continue
+		}
+		content := file.Preview
+		if canDownload {
+			download := span.Child("slack.files.download")
+			data, err := downloader.DownloadFile(file.URLPrivateDownload)
+			download.EndWithError(err)
+			if err != nil {
+				log.WithError(err).WithField("file", file.ID).Warn("Slack error downloading snippet, relaying its preview")
+			} else {
+				content = string(data)
+			}
+		}
+		language := cable.Language(file.Filetype)
+		snippets = append(snippets, cable.Snippet{
+			Filename: cable.SnippetFilename(file.Name, language),
+			Language: language,
+			Content:  content,
+		})
+	}
+	return snippets
+}
+
 // GoWrite spawns a goroutine that takes care of delivering to slack the
 // messages arriving at the OutboxCh of the Pump.
 //
@@ -170,14 +735,45 @@ func (s *Slack) GoWrite() {
 		for {
 			select {
 			case msg := <-s.Outbox():
-				msgOptions, err := msg.ToSlack()
+				msg, ok := s.throttle.Next(msg, s.Outbox(), s.WriteStopper)
+				if !ok {
+					return
+				}
+				deliver := cable.SpanOf(msg)
+				deliver.ChildSince("outbox", deliver.StartTime()).End()
+				if event, ok := cable.EventOf(msg); ok && !s.writeEvent(event) {
+					continue
+				}
+				convert := deliver.Child("slack.convert")
+				msgOptions, err := s.render(msg)
+				convert.EndWithError(err)
 				if err != nil {
-					log.Errorln("Slack error converting message to Client representation: ", err)
+					deliver.EndWithError(err)
+					log.WithFields(cable.MessageFields(msg)).WithField("platform", "slack").WithError(err).Error("Slack error converting message to Client representation")
+					metrics.ConversionErrors.Inc("slack")
+					s.RecordWriteError(err)
+					s.DeadLetters().Add(msg, err)
+					continue
+				}
+				call := deliver.Child("slack.chat.postMessage")
+				timestamps, err := s.write(msg, msgOptions)
+				call.EndWithError(err)
+				deliver.EndWithError(err)
+				if err == cable.ErrStopped {
+					s.DeadLetters().Add(msg, err)
+					return
 				}
-				_, _, err = s.client.PostMessage(s.relayedChannelID, msgOptions...)
 				if err != nil {
-					log.Errorln("Slack error writing message: ", err)
+					log.WithFields(cable.MessageFields(msg)).WithField("platform", "slack").WithError(err).Error("Slack error writing message")
+					metrics.MessagesFailed.Inc("slack")
+					s.RecordWriteError(err)
+					s.DeadLetters().Add(msg, err)
+					continue
 				}
+				s.parts.Add(cable.IDOf(msg), timestamps...)
+				s.RecordWrite()
+				metrics.ObserveDeliveryLatency("slack", msg.SentAt())
+				log.WithFields(cable.MessageFields(msg)).WithField("platform", "slack").Debug("Message written")
 			case <-s.WriteStopper:
 				return
 			}
@@ -185,6 +781,277 @@ func (s *Slack) GoWrite() {
 	}()
 }
 
+// render converts a message into slack messages, laid out in the format of
+// the pumper
+func (s *Slack) render(m cable.Message) ([]slack.MsgOption, error) {
+	if s.format == cable.SlackFormatAttachments {
+		return m.ToSlack()
+	}
+	return cable.ToSlackBlocks(m)
+}
+
+// retryAfter tells whether slack rejected a message because of rate limits,
+// and how long to wait before posting it again
+func retryAfter(err error) (time.Duration, bool) {
+	if limited, ok := err.(*slack.RateLimitedError); ok {
+		return limited.RetryAfter, true
+	}
+	return 0, false
+}
+
+// write posts a message to the relayed channel, split in as many parts as
+// needed to fit the length slack allows, or uploaded as a text file if more
+// than maxParts, returning the timestamps of the messages posted. Edits update
+// the messages posted before instead, and deletions delete them.
+func (s *Slack) write(m cable.Message, options []slack.MsgOption) ([]string, error) {
+	if deletion, ok := cable.DeletionOf(m); ok {
+		return nil, s.delete(deletion)
+	}
+	provenance := cable.ProvenanceOf(m).Relayed()
+	_, values, err := slack.UnsafeApplyMsgOptions("", "", options...)
+	if err != nil {
+		return nil, err
+	}
+	if cable.IsEdit(m) {
+		return s.edit(m, values)
+	}
+	// slack formats text like the markdown of telegram
+	text := values.Get("text")
+	if values.Get("blocks") != "" && cable.TextLength(text) > cable.SlackTextLimit {
+		// the text of messages laid out with blocks is only shown in
+		// notifications, so it is truncated instead of split
+		text = cable.TruncateText(text, cable.SlackTextLimit, telegram.ModeMarkdown)
+		if options, err = partOf(values, text, true); err != nil {
+			return nil, err
+		}
+	}
+	parts := cable.SplitText(text, cable.SlackTextLimit, telegram.ModeMarkdown)
+	uploader, canUpload := s.client.(FileUploader)
+	if canUpload && s.maxParts > 0 && len(parts) > s.maxParts {
+		var file *slack.File
+		err := s.throttle.Write(func() (err error) {
+			file, err = uploader.UploadFile(slack.FileUploadParameters{
+				Content:         m.String(),
+				Filename:        cable.UploadFileName,
+				InitialComment:  cable.TruncateText(text, uploadCommentLength, telegram.ModeMarkdown),
+				Channels:        []string{s.relayedChannelID},
+				ThreadTimestamp: values.Get("thread_ts"),
+			})
+			return err
+		}, retryAfter, s.WriteStopper)
+		if err != nil {
+			return nil, err
+		}
+		return []string{file.ID}, nil
+	}
+
+	var timestamps []string
+	for i, part := range parts {
+		partOptions := options
+		if len(parts) > 1 {
+			if i > 0 && !s.throttle.Wait(s.WriteStopper) {
+				return timestamps, cable.ErrStopped
+			}
+			partOptions, err = partOf(values, part, i == len(parts)-1)
+			if err != nil {
+				return timestamps, err
+			}
+		}
+		var ts string
+		err = s.throttle.Write(func() (err error) {
+			ts, err = s.post(provenance, partOptions...)
+			return err
+		}, retryAfter, s.WriteStopper)
+		if err != nil {
+			return timestamps, err
+		}
+		s.sent.Add(ts, provenance)
+		timestamps = append(timestamps, ts)
+	}
+	s.upload(m, values.Get("thread_ts"))
+	return timestamps, nil
+}
+
+// upload uploads the recordings and pictures carried by the message posted,
+// like voice messages and stickers, to the relayed channel. Files that cannot
+// be uploaded are dropped, as the text of the message is already posted.
+func (s *Slack) upload(m cable.Message, threadTimestamp string) {
+	var files []slack.FileUploadParameters
+	for _, recording := range cable.AudioOf(m) {
+		files = append(files, slack.FileUploadParameters{Reader: bytes.NewReader(recording.Content), Filename: recording.Filename})
+	}
+	for _, image := range cable.ImagesOf(m) {
+		files = append(files, slack.FileUploadParameters{Reader: bytes.NewReader(image.Content), Filename: image.Filename, Title: image.AltText})
+	}
+	uploader, ok := s.client.(FileUploader)
+	if !ok && len(files) > 0 {
+		log.WithFields(cable.MessageFields(m)).WithField("platform", "slack").Debug("Files dropped, as the client cannot upload them")
+		return
+	}
+	for _, file := range files {
+		file.Channels = []string{s.relayedChannelID}
+		file.ThreadTimestamp = threadTimestamp
+		err := s.throttle.Write(func() error {
+			_, err := uploader.UploadFile(file)
+			return err
+		}, retryAfter, s.WriteStopper)
+		if err != nil {
+			log.WithFields(cable.MessageFields(m)).WithField("platform", "slack").WithField("filename", file.Filename).WithError(err).Warn("Slack error uploading file")
+		}
+	}
+}
+
+// edit updates the messages posted for the message m edits with its new
+// text, split again in as many parts as needed, returning the timestamps of
+// the messages posted for it. Parts are posted when the text grows, and the
+// ones left over deleted when it shrinks. Edits of messages not posted by this
+// pumper, like the ones posted before cable started or uploaded as files, are
+// dropped.
+func (s *Slack) edit(m cable.Message, values url.Values) ([]string, error) {
+	timestamps := s.parts.Get(cable.IDOf(m))
+	updater, ok := s.client.(MessageUpdater)
+	if !ok || len(timestamps) == 0 || !timestampPattern.MatchString(timestamps[0]) {
+		log.WithFields(cable.MessageFields(m)).WithField("platform", "slack").Debug("Edit dropped, as the message edited was not posted")
+		return nil, nil
+	}
+	provenance := cable.ProvenanceOf(m).Relayed()
+	text := values.Get("text")
+	parts := cable.SplitText(text, cable.SlackTextLimit, telegram.ModeMarkdown)
+	if values.Get("blocks") != "" {
+		parts = []string{cable.TruncateText(text, cable.SlackTextLimit, telegram.ModeMarkdown)}
+	}
+
+	var edited []string
+	for i, part := range parts {
+		if i > 0 && !s.throttle.Wait(s.WriteStopper) {
+			return edited, cable.ErrStopped
+		}
+		options, err := partOf(values, part, i == len(parts)-1)
+		if err != nil {
+			return edited, err
+		}
+		if i >= len(timestamps) {
+			var ts string
+			err = s.throttle.Write(func() (err error) {
+				ts, err = s.post(provenance, options...)
+				return err
+			}, retryAfter, s.WriteStopper)
+			if err != nil {
+				return edited, err
+			}
+			s.sent.Add(ts, provenance)
+			edited = append(edited, ts)
+			continue
+		}
+		err = s.throttle.Write(func() error {
+			_, _, _, err := updater.UpdateMessage(s.relayedChannelID, timestamps[i], options...)
+			return err
+		}, retryAfter, s.WriteStopper)
+		if err != nil {
+			return edited, err
+		}
+		edited = append(edited, timestamps[i])
+	}
+	if len(timestamps) > len(parts) {
+		left, err := s.remove(timestamps[len(parts):])
+		edited = append(edited, left...)
+		if err != nil {
+			return edited, err
+		}
+	}
+	return edited, nil
+}
+
+// delete deletes the messages posted for the message a deletion deletes,
+// forgetting them. Deletions of messages not posted by this pumper are
+// dropped.
+func (s *Slack) delete(deletion *cable.Deletion) error {
+	timestamps := s.parts.Get(deletion.ID())
+	if len(timestamps) == 0 {
+		log.WithFields(cable.MessageFields(deletion)).WithField("platform", "slack").Debug("Deletion dropped, as the message deleted was not posted")
+		return nil
+	}
+	left, err := s.remove(timestamps)
+	if err != nil {
+		s.parts.Add(deletion.ID(), left...)
+		return err
+	}
+	s.parts.Remove(deletion.ID())
+	return nil
+}
+
+// remove deletes the messages with the given timestamps, and the files
+// uploaded with the given IDs instead, returning the ones left, which are all
+// of them if the client cannot delete messages
+func (s *Slack) remove(timestamps []string) ([]string, error) {
+	deleter, ok := s.client.(MessageDeleter)
+	if !ok {
+		return timestamps, nil
+	}
+	for i, ts := range timestamps {
+		if i > 0 && !s.throttle.Wait(s.WriteStopper) {
+			return timestamps[i:], cable.ErrStopped
+		}
+		err := s.throttle.Write(func() error {
+			if !timestampPattern.MatchString(ts) {
+				return s.deleteFile(ts)
+			}
+			_, _, err := deleter.DeleteMessage(s.relayedChannelID, ts)
+			return err
+		}, retryAfter, s.WriteStopper)
+		if err != nil {
+			return timestamps[i:], err
+		}
+	}
+	return nil, nil
+}
+
+// deleteFile deletes the file uploaded with the given ID, if the client can
+func (s *Slack) deleteFile(id string) error {
+	deleter, ok := s.client.(FileDeleter)
+	if !ok {
+		log.WithField("platform", "slack").WithField("file", id).Debug("File left, as the client cannot delete files")
+		return nil
+	}
+	return deleter.DeleteFile(id)
+}
+
+// partOf returns the options posting a part of the message with the given
+// values, which carries the attachments and blocks of the message if it is
+// the last one
+func partOf(values url.Values, text string, last bool) ([]slack.MsgOption, error) {
+	options := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if ts := values.Get("thread_ts"); ts != "" {
+		options = append(options, slack.MsgOptionTS(ts))
+	}
+	if encoded := values.Get("attachments"); encoded != "" && last {
+		var attachments []slack.Attachment
+		if err := json.Unmarshal([]byte(encoded), &attachments); err != nil {
+			return nil, err
+		}
+		options = append(options, slack.MsgOptionAttachments(attachments...))
+	}
+	if encoded := values.Get("blocks"); encoded != "" && last {
+		var blocks []cable.SlackBlock
+		if err := json.Unmarshal([]byte(encoded), &blocks); err != nil {
+			return nil, err
+		}
+		options = append(options, cable.MsgOptionBlocks(blocks...))
+	}
+	return options, nil
+}
+
+// post posts a message to the relayed channel, with the metadata telling its
+// provenance if the client can, returning its timestamp
+func (s *Slack) post(provenance cable.Provenance, options ...slack.MsgOption) (string, error) {
+	if poster, ok := s.client.(MetadataPoster); ok {
+		_, ts, err := poster.PostMessageWithMetadata(s.relayedChannelID, metadataOf(provenance), options...)
+		return ts, err
+	}
+	_, ts, err := s.client.PostMessage(s.relayedChannelID, options...)
+	return ts, err
+}
+
 /* Section: Slack message */
 
 // Message wraps a message event from slack and implements the Message
@@ -192,6 +1059,16 @@ func (s *Slack) GoWrite() {
 type Message struct {
 	*slack.MessageEvent
 	Users UserMap
+	// Emoji are the custom emoji of the workspace
+	Emoji      EmojiMap
+	span       *tracing.Span
+	provenance cable.Provenance
+	// files are the snippets shared in the message
+	files []cable.Snippet
+	// blocks are the Block Kit blocks the message is laid out with, if any
+	blocks []Block
+	// edit tells whether the message is a new version of one already read
+	edit bool
 }
 
 // ToSlack is a no-op that returns an error, as we don't want to re-send
@@ -201,33 +1078,194 @@ func (sm Message) ToSlack() ([]slack.MsgOption, error) {
 }
 
 // ToTelegram converts a received slack message into a proper representation in
-// telegram
+// telegram. Code blocks keep their language, and the ones too long to be
+// relayed as text are replaced by a reference to the snippet they are shared
+// as. Messages laid out with blocks or attachments, like the ones posted by
+// integrations, are rendered into telegram HTML.
 func (sm Message) ToTelegram(telegramChatID int64) (telegram.MessageConfig, error) {
-	var text string
-
-	if user, ok := sm.Users[sm.User]; ok {
-		text = fmt.Sprintf("*%s (%s):* %s", user.RealName, user.Name, sm.Text)
-	} else {
-		text = fmt.Sprintf("*Stranger:* %s", sm.Text)
-	}
-
-	return telegram.MessageConfig{
+	msg := telegram.MessageConfig{
 		BaseChat: telegram.BaseChat{
 			ChatID:           telegramChatID,
 			ReplyToMessageID: 0,
 		},
-		Text:                  emoji.Sprint(text),
 		DisableWebPagePreview: false,
-		ParseMode:             telegram.ModeMarkdown,
-	}, nil
+	}
+	if sm.laidOut() {
+		content, _ := sm.telegramHTML()
+		msg.Text = fmt.Sprintf("<b>%s:</b> %s", html.EscapeString(emoji.Sprint(sm.signature())), content)
+		msg.ParseMode = telegram.ModeHTML
+		return msg, nil
+	}
+
+	content, _ := telegramContent(sm.Content(), sm.Emoji)
+	msg.Text = emoji.Sprint(fmt.Sprintf("*%s:* ", sm.signature())) + content
+	msg.ParseMode = telegram.ModeMarkdown
+	return msg, nil
+}
+
+// signature returns the name the author of the message is presented with
+func (sm Message) signature() string {
+	if user, ok := sm.Users[sm.User]; ok {
+		return fmt.Sprintf("%s (%s)", user.RealName, user.Name)
+	}
+	return "Stranger"
+}
+
+// telegramContent converts the text of a slack message into telegram
+// markdown, returning the snippets of the code blocks too long to be relayed
+// as text. Emoji are rendered, but not in code, whose entities are unescaped,
+// and custom ones are linked to their image.
+func telegramContent(content string, custom EmojiMap) (string, []cable.Snippet) {
+	var text strings.Builder
+	var snippets []cable.Snippet
+	for _, fragment := range cable.Fragments(content) {
+		code := unescape(fragment.Text)
+		switch {
+		case fragment.Block && cable.TextLength(code) > cable.TelegramTextLimit:
+			snippet := cable.Snippet{
+				Filename: cable.SnippetFilename(fmt.Sprintf("snippet-%d", len(snippets)+1), fragment.Language),
+				Language: fragment.Language,
+				Content:  code,
+			}
+			snippets = append(snippets, snippet)
+			fmt.Fprintf(&text, "📎 %s", snippet.Filename)
+		case fragment.Block:
+			fmt.Fprintf(&text, "```%s\n%s\n```", fragment.Language, code)
+		case fragment.Code:
+			fmt.Fprintf(&text, "`%s`", code)
+		default:
+			text.WriteString(custom.markdownEmoji(fragment.Text))
+		}
+	}
+	return text.String(), snippets
+}
+
+// unescape replaces the entities slack escapes in the text of messages by the
+// characters they stand for
+func unescape(text string) string {
+	return strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
 }
 
 // String returns a human readable representation of a slack message for
 // debugging purposes
 func (sm Message) String() string {
 	if user, ok := sm.Users[sm.User]; ok {
-		return fmt.Sprintf("%s: %s", user.Name, sm.Text)
+		return fmt.Sprintf("%s: %s", user.Name, sm.Content())
+
+	}
+	return fmt.Sprintf("Stranger: %s", sm.Content())
+}
+
+// Content returns the text of the slack message
+func (sm Message) Content() string {
+	return sm.MessageEvent.Text
+}
+
+// ID returns the timestamp of the slack message, which identifies it in its
+// channel
+func (sm Message) ID() string {
+	return sm.MessageEvent.Timestamp
+}
+
+// WithContent returns a copy of the slack message with the given text
+func (sm Message) WithContent(content string) cable.Message {
+	ev := *sm.MessageEvent
+	ev.Text = content
+	return &Message{MessageEvent: &ev, Users: sm.Users, Emoji: sm.Emoji, span: sm.span, provenance: sm.provenance, files: sm.files, blocks: sm.blocks, edit: sm.edit}
+}
+
+// Texts returns the contents of the snippets shared in the slack message, and
+// the texts of the blocks and attachments it is laid out with
+func (sm Message) Texts() []string {
+	var texts []string
+	for _, text := range sm.texts() {
+		texts = append(texts, *text)
+	}
+	return texts
+}
+
+// WithTexts returns a copy of the slack message with the contents of the
+// snippets shared, and the texts of its layout, replaced by texts
+func (sm Message) WithTexts(texts []string) cable.Message {
+	sm.files = append([]cable.Snippet(nil), sm.files...)
+	sm.copyLayout()
+	for i, text := range sm.texts() {
+		if i < len(texts) {
+			*text = texts[i]
+		}
+	}
+	return &sm
+}
+
+// texts returns the addresses of the contents of the snippets shared in the
+// slack message, and of the texts of its layout
+func (sm *Message) texts() []*string {
+	var texts []*string
+	for i := range sm.files {
+		texts = append(texts, &sm.files[i].Content)
+	}
+	return append(texts, sm.layoutTexts()...)
+}
+
+// IsEdit tells whether the slack message is a new version of one already
+// read, which keeps its timestamp
+func (sm Message) IsEdit() bool {
+	return sm.edit
+}
+
+// Snippets returns the snippets shared in the slack message, and the ones
+// its code blocks too long to be relayed as text are shared as
+func (sm Message) Snippets() []cable.Snippet {
+	var snippets []cable.Snippet
+	if sm.laidOut() {
+		_, snippets = sm.telegramHTML()
+	} else {
+		_, snippets = telegramContent(sm.Content(), sm.Emoji)
+	}
+	return append(append([]cable.Snippet(nil), sm.files...), snippets...)
+}
+
+// Provenance returns the provenance of the slack message, told by its
+// metadata
+func (sm Message) Provenance() cable.Provenance {
+	return sm.provenance
+}
+
+// Span returns the span carried by the slack message
+func (sm Message) Span() *tracing.Span {
+	return sm.span
+}
+
+// WithSpan returns a copy of the slack message carrying the given span
+func (sm Message) WithSpan(span *tracing.Span) cable.Message {
+	sm.span = span
+	return &sm
+}
+
+// Author returns the slack user name of the author of the message, or the
+// user ID if the author is not known
+func (sm Message) Author() string {
+	if user, ok := sm.Users[sm.User]; ok {
+		return user.Name
+	}
+	if sm.Username != "" {
+		return sm.Username
+	}
+	return sm.User
+}
+
+// FromBot tells whether the slack message was posted by a bot
+func (sm Message) FromBot() bool {
+	return sm.BotID != "" || sm.SubType == "bot_message"
+}
 
+// SentAt returns the time the slack message was posted, which is encoded in
+// its timestamp
+func (sm Message) SentAt() time.Time {
+	ts, err := strconv.ParseFloat(sm.Timestamp, 64)
+	if err != nil {
+		return time.Time{}
 	}
-	return fmt.Sprintf("Stranger: %s", sm.Text)
+	seconds := int64(ts)
+	return time.Unix(seconds, int64((ts-float64(seconds))*1e9))
 }
@@ -1,10 +1,18 @@
 package slack
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/tracing"
 	api "github.com/nlopes/slack"
 	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 )
@@ -37,36 +45,253 @@ func TestSlack_GoRead(t *testing.T) {
 	}
 
 	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
 
-	// wait for the pump to to process the channel up to 1 second, or timeout
-	timeout := time.NewTimer(1 * time.Second)
+	// wait for the pump to read the messages selected up to 1 second, or timeout
+	var inbox []cable.Message
+	timeout := time.After(time.Second)
 WAIT:
-	for {
+	for len(inbox) < 2 {
 		select {
-		case <-timeout.C:
+		case <-timeout:
 			Fail(t, "timeout while processing the Read Pump")
 			break WAIT
-		default:
-			if len(updatesCh) == 0 {
-				close(fakeSlack.Inbox())
-				break WAIT
-			}
-			time.Sleep(10 * time.Millisecond)
+		case message := <-fakeSlack.Inbox():
+			inbox = append(inbox, message)
 		}
 	}
-
-	fakeSlack.StopRead()
-
-	var inbox []cable.Message
-	for message := range fakeSlack.Inbox() {
-		inbox = append(inbox, message)
+	for len(updatesCh) > 0 {
+		time.Sleep(10 * time.Millisecond)
 	}
+	time.Sleep(10 * time.Millisecond)
+	Equal(t, 0, len(fakeSlack.Inbox()), "the other messages are discarded")
 
 	Equal(t, 2, len(inbox))
 	Equal(t, "freshprince: Sup Jay!", inbox[0].String())
 	Equal(t, "freshprince: Uncle Phil, you here?", inbox[1].String())
 }
 
+func TestSlack_GoRead_Snippets(t *testing.T) {
+	update := createSlackUserUpdate(slackChannelID, "Here you go")
+	update.Data.(*api.MessageEvent).Files = []api.File{
+		{ID: "F1", Name: "hello", Filetype: "python", Mode: "snippet", URLPrivateDownload: "https://files/F1", Preview: "print("},
+		{ID: "F2", Name: "broken.rb", Filetype: "ruby", Mode: "snippet", URLPrivateDownload: "https://files/F2", Preview: "puts 'hi'"},
+		{ID: "F3", Name: "cat.png", Filetype: "png", Mode: "hosted"},
+	}
+	updatesCh := make(chan api.RTMEvent, 1)
+	updatesCh <- update
+
+	fakeSlack := NewSlackWithAPI(&downloadingSlackAPI{
+		fakeSlackAPI: &fakeSlackAPI{rtmEvents: updatesCh, users: make(UserMap)},
+		files:        map[string]string{"https://files/F1": "print('hello')\n"},
+	}, slackChannelID, slackBotID)
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	select {
+	case m := <-fakeSlack.Inbox():
+		Equal(t, []cable.Snippet{
+			{Filename: "hello.py", Language: "python", Content: "print('hello')\n"},
+			{Filename: "broken.rb", Language: "ruby", Content: "puts 'hi'"},
+		}, cable.SnippetsOf(m), "snippets are downloaded, or their preview relayed")
+		Equal(t, cable.SnippetsOf(m), cable.SnippetsOf(m.WithContent("Here")))
+
+		redacted, err := cable.Redact(regexp.MustCompile(`hello`))(context.Background(), m)
+		NoError(t, err)
+		Equal(t, "print('[redacted]')\n", cable.SnippetsOf(redacted)[0].Content, "middlewares see the snippets")
+		Equal(t, "print('hello')\n", cable.SnippetsOf(m)[0].Content, "the message read is untouched")
+	case <-time.After(time.Second):
+		Fail(t, "the message was not read")
+	}
+}
+
+func TestSlack_GoRead_SlowSnippets(t *testing.T) {
+	withSnippet := createSlackUserUpdate(slackChannelID, "Here you go")
+	withSnippet.Data.(*api.MessageEvent).Files = []api.File{
+		{ID: "F1", Name: "hello", Filetype: "python", Mode: "snippet", URLPrivateDownload: "https://files/F1"},
+	}
+	updatesCh := make(chan api.RTMEvent, 2)
+	updatesCh <- withSnippet
+	updatesCh <- createSlackUserUpdate(slackChannelID, "Sup Jay!")
+
+	release := make(chan struct{})
+	fakeSlack := NewSlackWithAPI(&downloadingSlackAPI{
+		fakeSlackAPI: &fakeSlackAPI{rtmEvents: updatesCh, users: make(UserMap)},
+		files:        map[string]string{"https://files/F1": "print('hello')\n"},
+		release:      release,
+	}, slackChannelID, slackBotID)
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	for start := time.Now(); len(updatesCh) > 0 && time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+	}
+	Equal(t, 0, len(updatesCh), "events are read while the snippets are downloaded")
+	Equal(t, 0, len(fakeSlack.Inbox()))
+
+	close(release)
+	for _, content := range []string{"Here you go", "Sup Jay!"} {
+		select {
+		case m := <-fakeSlack.Inbox():
+			Equal(t, content, m.Content(), "messages are relayed in the order they were read")
+		case <-time.After(time.Second):
+			Fail(t, "the message was not read")
+		}
+	}
+}
+
+func TestSlack_GoRead_DiscoveredIdentity(t *testing.T) {
+	byBotUser := createSlackUserUpdate(slackChannelID, "Hey Hey!")
+	byBotUser.Data.(*api.MessageEvent).User = "UBOT"
+	updates := make(chan api.RTMEvent, 3)
+	updates <- createSlackBotUpdate(slackChannelID, "Hey!") // discarded, as the bot ID is discovered
+	updates <- byBotUser                                    // discarded, as the user of the bot is discovered
+	updates <- createSlackUserUpdate(slackChannelID, "Sup Jay!")
+
+	fake := &identifiedSlackAPI{fakeSlackAPI: &fakeSlackAPI{rtmEvents: updates}, userID: "UBOT", botID: slackBotID}
+	fakeSlack := NewSlackWithAPI(fake, slackChannelID, "")
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	select {
+	case m := <-fakeSlack.Inbox():
+		Equal(t, "Sup Jay!", m.Content())
+	case <-time.After(time.Second):
+		Fail(t, "timeout while processing the Read Pump")
+	}
+	Equal(t, 0, len(fakeSlack.Inbox()))
+}
+
+func TestSlack_GoRead_Health(t *testing.T) {
+	updatesCh := make(chan api.RTMEvent, 2)
+	fakeSlack := &Slack{
+		relayedChannelID: slackChannelID,
+		botUserID:        slackBotID,
+		client:           &fakeSlackAPI{rtmEvents: updatesCh},
+		Pump:             cable.NewPump(),
+	}
+
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+	Equal(t, cable.StateConnecting, fakeSlack.Health().State)
+
+	updatesCh <- api.RTMEvent{Type: "connected", Data: &api.ConnectedEvent{ConnectionCount: 1}}
+	waitForState(t, fakeSlack.Pump, cable.StateConnected)
+
+	updatesCh <- api.RTMEvent{Type: "connection_error", Data: &api.ConnectionErrorEvent{ErrorObj: errors.New("connection reset")}}
+	waitForState(t, fakeSlack.Pump, cable.StateDisconnected)
+	Equal(t, 1, fakeSlack.Health().ReadErrors)
+	Equal(t, "connection reset", fakeSlack.Health().LastError)
+}
+
+func TestSlack_GoRead_Commands(t *testing.T) {
+	updatesCh := make(chan api.RTMEvent, 2)
+	admin := createSlackUser(slackUserID, "Will Smith", "freshprince")
+	admin.IsAdmin = true
+	fakeSlack := &Slack{
+		relayedChannelID: slackChannelID,
+		botUserID:        slackBotID,
+		userID:           "UCABLE",
+		client:           &fakeSlackAPI{rtmEvents: updatesCh, users: UserMap{slackUserID: admin}},
+		Pump:             cable.NewPump(),
+	}
+
+	commands := make(chan cable.Command, 1)
+	fakeSlack.SetCommandHandler(func(c cable.Command) { commands <- c })
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	updatesCh <- createSlackUserUpdate(slackChannelID, "/cable pause 1h")
+	command := <-commands
+	Equal(t, "pause", command.Name)
+	True(t, command.FromAdmin)
+
+	updatesCh <- createSlackUserUpdate(slackChannelID, "<@UCABLE> status")
+	Equal(t, "status", (<-commands).Name, "commands can mention the bot, as slack keeps the ones with a slash")
+
+	updatesCh <- createSlackUserUpdate(slackChannelID, "Sup Jay!")
+	Equal(t, "freshprince: Sup Jay!", (<-fakeSlack.Inbox()).String())
+	Equal(t, 0, len(fakeSlack.Inbox()), "commands are not relayed")
+}
+
+func TestSlack_GoRead_Edits(t *testing.T) {
+	updatesCh := make(chan api.RTMEvent, 2)
+	fakeSlack := &Slack{
+		relayedChannelID: slackChannelID,
+		botUserID:        slackBotID,
+		client:           &fakeSlackAPI{rtmEvents: updatesCh, users: UserMap{slackUserID: createSlackUser(slackUserID, "Will Smith", "freshprince")}},
+		Pump:             cable.NewPump(),
+	}
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	changed := func(text string, edited bool) api.RTMEvent {
+		message := &api.Msg{User: slackUserID, Text: text, Timestamp: "1561975000.000100"}
+		if edited {
+			message.Edited = &api.Edited{User: slackUserID, Timestamp: "1561975100.000000"}
+		}
+		ev := &api.MessageEvent{Msg: api.Msg{Channel: slackChannelID, SubType: "message_changed", Timestamp: "1561975100.000200"}, SubMessage: message}
+		return api.RTMEvent{Data: ev}
+	}
+	updatesCh <- changed("Sup Jay! https://example.com", false)
+	updatesCh <- changed("Sup Jazz!", true)
+
+	select {
+	case m := <-fakeSlack.Inbox():
+		True(t, cable.IsEdit(m))
+		Equal(t, "1561975000.000100", cable.IDOf(m), "edits carry the timestamp of the message edited")
+		Equal(t, "freshprince: Sup Jazz!", m.String())
+	case <-time.After(time.Second):
+		Fail(t, "the edit was not read")
+	}
+	Equal(t, 0, len(fakeSlack.Inbox()), "changes other than edits, like unfurling links, are discarded")
+}
+
+func TestSlack_GoRead_Deletions(t *testing.T) {
+	updatesCh := make(chan api.RTMEvent, 2)
+	fakeSlack := NewSlackWithAPI(&fakeSlackAPI{rtmEvents: updatesCh}, slackChannelID, slackBotID)
+	fakeSlack.sent.Add("1561975200.000100", cable.Provenance{})
+	fakeSlack.GoRead()
+	defer fakeSlack.StopRead()
+
+	deleted := func(timestamp string) api.RTMEvent {
+		return api.RTMEvent{Data: &api.MessageEvent{Msg: api.Msg{Channel: slackChannelID, SubType: "message_deleted", DeletedTimestamp: timestamp}}}
+	}
+	updatesCh <- deleted("1561975200.000100")
+	updatesCh <- deleted("1561975000.000100")
+
+	select {
+	case m := <-fakeSlack.Inbox():
+		deletion, ok := cable.DeletionOf(m)
+		if True(t, ok) {
+			Equal(t, "1561975000.000100", deletion.ID(), "deletions carry the timestamp of the message deleted")
+			Equal(t, "slack", deletion.Platform)
+		}
+	case <-time.After(time.Second):
+		Fail(t, "the deletion was not read")
+	}
+	Equal(t, 0, len(fakeSlack.Inbox()), "deletions of the messages posted by this cable are discarded")
+}
+
+func TestSlack_Participants(t *testing.T) {
+	bot := createSlackUser(slackBotID, "Cable", "cable")
+	bot.IsBot = true
+	fakeSlack := &Slack{
+		relayedChannelID: slackChannelID,
+		client: &fakeSlackAPI{
+			users:   UserMap{slackUserID: createSlackUser(slackUserID, "Will Smith", "freshprince"), slackBotID: bot},
+			members: []string{slackUserID, slackBotID},
+		},
+		Pump: cable.NewPump(),
+	}
+
+	participants, err := fakeSlack.Participants()
+	Nil(t, err)
+	Equal(t, []string{"freshprince"}, participants, "bots are left out")
+
+	link, _ := fakeSlack.Link()
+	Equal(t, "https://slack.com/app_redirect?channel="+slackChannelID, link)
+}
+
 func TestSlack_GoWrite(t *testing.T) {
 	client := &fakeSlackAPI{}
 
@@ -75,6 +300,7 @@ func TestSlack_GoWrite(t *testing.T) {
 		botUserID:        slackBotID,
 		client:           client,
 		Pump:             cable.NewPump(),
+		format:           cable.SlackFormatAttachments,
 	}
 
 	fakeSlack.Outbox() <- createTelegramMessage("Sup Jay!", "Will", "Smith", "freshprince")
@@ -101,15 +327,130 @@ WAIT:
 
 	fakeSlack.StopWrite()
 
-	Equal(t, 2, len(client.sent))
+	sent := client.Sent()
+	Equal(t, 2, len(sent))
 
-	first := asSlackJSONMessage(client.sent[0])
+	first := asSlackJSONMessage(sent[0])
 	Equal(t, "Sup Jay!", first.Text)
 
-	second := asSlackJSONMessage(client.sent[1])
+	second := asSlackJSONMessage(sent[1])
 	Equal(t, ":clap: Psss!", second.Text)
 }
 
+func TestSlack_GoWrite_Blocks(t *testing.T) {
+	client := &fakeSlackAPI{}
+	fakeSlack := NewSlackWithAPI(client, slackChannelID, slackBotID)
+	fakeSlack.GoWrite()
+	defer fakeSlack.StopWrite()
+
+	fakeSlack.Outbox() <- createTelegramMessage("Sup Jay!", "Will", "Smith", "freshprince")
+	fakeSlack.Outbox() <- &cable.Notice{Text: "cable is paused", Plain: true}
+	deadline := time.Now().Add(time.Second)
+	for len(client.Sent()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := client.Sent()
+	Equal(t, 3, len(sent))
+
+	_, values, _ := api.UnsafeApplyMsgOptions("", "", sent[:2]...)
+	Equal(t, "Will Smith (freshprince): Sup Jay!", values.Get("text"))
+	Equal(t, `[{"type":"context","elements":[{"type":"mrkdwn","text":"*Will Smith (freshprince)*"}]},{"type":"section","text":{"type":"mrkdwn","text":"Sup Jay!"}}]`, values.Get("blocks"))
+	Empty(t, values.Get("attachments"))
+
+	_, values, _ = api.UnsafeApplyMsgOptions("", "", sent[2:]...)
+	Equal(t, "cable is paused", values.Get("text"), "messages without blocks are posted as they are")
+	Empty(t, values.Get("blocks"))
+}
+
+func TestSlack_GoWrite_LongMessages(t *testing.T) {
+	client := &uploadingSlackAPI{fakeSlackAPI: &fakeSlackAPI{}}
+	fakeSlack := NewSlackWithAPI(client.fakeSlackAPI, slackChannelID, slackBotID)
+	fakeSlack.GoWrite()
+	defer fakeSlack.StopWrite()
+
+	long := strings.Repeat("All work and no play makes Jack a dull boy.\n\n", 1000)
+	fakeSlack.Outbox() <- &cable.Notice{Text: long, Plain: true}
+	deadline := time.Now().Add(time.Second)
+	for len(client.Sent()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	Equal(t, 2, len(client.Sent()))
+	for i, option := range client.Sent() {
+		_, values, _ := api.UnsafeApplyMsgOptions("", "", option)
+		True(t, cable.TextLength(values.Get("text")) <= cable.SlackTextLimit)
+		True(t, strings.HasPrefix(values.Get("text"), fmt.Sprintf("(%d/2) All work", i+1)))
+	}
+
+	uploader := NewSlackWithAPI(client, slackChannelID, slackBotID)
+	uploader.SetMaxParts(1)
+	uploader.GoWrite()
+	defer uploader.StopWrite()
+	uploader.Outbox() <- &cable.Notice{Text: long, Plain: true}
+	deadline = time.Now().Add(time.Second)
+	for len(client.Uploads()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	uploads := client.Uploads()
+	Equal(t, 1, len(uploads))
+	Equal(t, long, uploads[0].Content)
+	Equal(t, "message.txt", uploads[0].Filename)
+	Equal(t, []string{slackChannelID}, uploads[0].Channels)
+	True(t, strings.HasSuffix(uploads[0].InitialComment, "…"))
+	Equal(t, 2, len(client.Sent()), "nothing is posted")
+}
+
+func TestSlack_GoWrite_Files(t *testing.T) {
+	client := &uploadingSlackAPI{fakeSlackAPI: &fakeSlackAPI{}}
+	fakeSlack := NewSlackWithAPI(client, slackChannelID, slackBotID)
+	fakeSlack.GoWrite()
+	defer fakeSlack.StopWrite()
+
+	fakeSlack.Outbox() <- fileMessage{
+		Message: &cable.Notice{Text: "🎤 Voice message (0:05)", Plain: true},
+		audio:   []cable.Audio{{Filename: "voice.ogg", MimeType: "audio/ogg", Content: []byte("OGG")}},
+		images:  []cable.Image{{Filename: "sticker.png", MimeType: "image/png", AltText: "😂", Content: []byte("PNG")}},
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(client.Uploads()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	uploads := client.Uploads()
+	Equal(t, 1, len(client.Sent()), "the text is posted")
+	Equal(t, 2, len(uploads), "the recording and the picture are uploaded")
+	Equal(t, "voice.ogg", uploads[0].Filename)
+	Equal(t, []string{slackChannelID}, uploads[0].Channels)
+	content, _ := ioutil.ReadAll(uploads[0].Reader)
+	Equal(t, "OGG", string(content))
+	Equal(t, "sticker.png", uploads[1].Filename)
+	Equal(t, "😂", uploads[1].Title)
+	content, _ = ioutil.ReadAll(uploads[1].Reader)
+	Equal(t, "PNG", string(content))
+}
+
+func TestSlack_GoWrite_Tracing(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracing.Start(exporter)
+	defer tracing.Stop()
+
+	fakeSlack := &Slack{
+		relayedChannelID: slackChannelID,
+		client:           &fakeSlackAPI{},
+		Pump:             cable.NewPump(),
+	}
+	fakeSlack.GoWrite()
+	defer fakeSlack.StopWrite()
+
+	deliver := tracing.StartTrace("relay").Child("deliver")
+	fakeSlack.Outbox() <- cable.WithSpan(createTelegramMessage("Sup Jay!", "Will", "Smith", "freshprince"), deliver)
+
+	var names []string
+	for start := time.Now(); time.Since(start) < time.Second && len(names) < 4; time.Sleep(10 * time.Millisecond) {
+		tracing.Flush()
+		names = exporter.names()
+	}
+	Equal(t, []string{"outbox", "slack.convert", "slack.chat.postMessage", "deliver"}, names)
+}
+
 func TestSlackMessage_String_KnownUser(t *testing.T) {
 	user := api.User{ID: slackUserID, RealName: "Will Smith", Name: "freshprince"}
 	msg := createSlackMessage("Sup Jay!", slackUserID, user)
@@ -166,3 +507,125 @@ func TestSlackMessage_ToTelegram_Stranger(t *testing.T) {
 	actual, _ := msg.ToTelegram(telegramChatID)
 	Equal(t, expected, actual)
 }
+
+func TestSlackMessage_ToTelegram_Code(t *testing.T) {
+	msg := createSlackMessage("Try `a &lt; b` :boom:\n```python\nif a &gt; b:\n    print(\":boom:\")\n```", "STRGRID")
+	actual, _ := msg.ToTelegram(123)
+	Equal(t, "*Stranger:* Try `a < b` 💥 \n```python\nif a > b:\n    print(\":boom:\")\n```", actual.Text, "code keeps its language, and is not rendered")
+	Empty(t, msg.Snippets())
+
+	long := strings.Repeat("fmt.Println(\"All work and no play\")\n", 200)
+	msg = createSlackMessage("Look:\n```go\n"+long+"```", "STRGRID")
+	actual, _ = msg.ToTelegram(123)
+	Equal(t, "*Stranger:* Look:\n📎 snippet-1.go", actual.Text, "long code blocks are shared as snippets")
+	Equal(t, []cable.Snippet{{Filename: "snippet-1.go", Language: "go", Content: strings.TrimSuffix(long, "\n")}}, msg.Snippets())
+}
+
+func TestSlackMessage_WithContent(t *testing.T) {
+	user := api.User{ID: slackUserID, RealName: "Will Smith", Name: "freshprince"}
+	msg := createSlackMessage("Sup Jay!", slackUserID, user)
+
+	edited := msg.WithContent("Sup Jazz!")
+	Equal(t, "freshprince: Sup Jazz!", edited.String())
+	Equal(t, "Sup Jay!", msg.Content())
+}
+
+func TestSlackMessage_Author(t *testing.T) {
+	user := api.User{ID: slackUserID, RealName: "Will Smith", Name: "freshprince"}
+	Equal(t, "freshprince", createSlackMessage("Sup Jay!", slackUserID, user).Author())
+	Equal(t, unknownSlackUSerID, createSlackMessage("Sup Jay!", unknownSlackUSerID, user).Author())
+}
+
+func TestSlackMessage_FromBot(t *testing.T) {
+	msg := createSlackMessage("Sup Jay!", slackUserID)
+	False(t, msg.FromBot())
+
+	msg.BotID = slackBotID
+	True(t, msg.FromBot())
+}
+
+func TestSlackMessage_SentAt(t *testing.T) {
+	msg := createSlackMessage("Sup Jay!", slackUserID)
+	True(t, msg.SentAt().IsZero())
+
+	msg.Timestamp = "1561975000.500000"
+	Equal(t, time.Unix(1561975000, 500000000), msg.SentAt())
+}
+
+func TestSlackMessage_ID(t *testing.T) {
+	msg := createSlackMessage("Sup Jay!", slackUserID)
+	msg.Timestamp = "1561975000.500000"
+	Equal(t, "1561975000.500000", msg.ID())
+}
+
+func TestSlack_GoWrite_Edits(t *testing.T) {
+	client := &updatingSlackAPI{fakeSlackAPI: &fakeSlackAPI{}, updates: make(map[string][]api.MsgOption)}
+	fakeSlack := NewSlackWithAPI(client, slackChannelID, slackBotID)
+	True(t, fakeSlack.CanEdit())
+	False(t, NewSlackWithAPI(&fakeSlackAPI{}, slackChannelID, slackBotID).CanEdit())
+	fakeSlack.GoWrite()
+	defer fakeSlack.StopWrite()
+
+	fakeSlack.Outbox() <- editMessage{Message: createTelegramMessage("Lunch?", "Will", "Smith", "freshprince"), id: "1"}
+	fakeSlack.Outbox() <- editMessage{Message: createTelegramMessage("Lunch? Closed", "Will", "Smith", "freshprince"), id: "1", edit: true}
+	fakeSlack.Outbox() <- editMessage{Message: createTelegramMessage("Unknown", "Will", "Smith", "freshprince"), id: "2", edit: true}
+	deadline := time.Now().Add(time.Second)
+	for len(fakeSlack.parts.Get("1")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	Equal(t, 1, client.posted, "edits are not posted")
+	if Len(t, client.updates, 1) {
+		_, values, _ := api.UnsafeApplyMsgOptions("", "", client.updates["1.000"]...)
+		Contains(t, values.Get("attachments"), "Lunch? Closed")
+	}
+	Equal(t, []string{"1.000"}, fakeSlack.parts.Get("1"))
+	Empty(t, fakeSlack.parts.Get("2"), "edits of messages not posted are dropped")
+}
+
+func TestSlack_GoWrite_EditsOfLongMessages(t *testing.T) {
+	client := &deletingSlackAPI{updatingSlackAPI: &updatingSlackAPI{fakeSlackAPI: &fakeSlackAPI{}, updates: make(map[string][]api.MsgOption)}}
+	fakeSlack := NewSlackWithAPI(client, slackChannelID, slackBotID)
+	True(t, fakeSlack.CanDelete())
+	False(t, NewSlackWithAPI(client.updatingSlackAPI, slackChannelID, slackBotID).CanDelete())
+	fakeSlack.GoWrite()
+	defer fakeSlack.StopWrite()
+
+	written := func(id string, parts ...string) {
+		deadline := time.Now().Add(time.Second)
+		for !reflect.DeepEqual(parts, fakeSlack.parts.Get(id)) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		Equal(t, parts, fakeSlack.parts.Get(id))
+	}
+	long := strings.Repeat("All work and no play makes Jack a dull boy.\n\n", 1000)
+	fakeSlack.Outbox() <- editMessage{Message: &cable.Notice{Text: long, Plain: true}, id: "1"}
+	written("1", "1.000", "2.000")
+
+	fakeSlack.Outbox() <- editMessage{Message: &cable.Notice{Text: "All work", Plain: true}, id: "1", edit: true}
+	written("1", "1.000")
+	client.mutex.Lock()
+	_, values, _ := api.UnsafeApplyMsgOptions("", "", client.updates["1.000"]...)
+	Equal(t, "All work", values.Get("text"))
+	Equal(t, []string{"2.000"}, client.deleted, "the parts left over are deleted")
+	client.mutex.Unlock()
+
+	fakeSlack.Outbox() <- editMessage{Message: &cable.Notice{Text: long, Plain: true}, id: "1", edit: true}
+	written("1", "1.000", "3.000")
+	client.mutex.Lock()
+	_, values, _ = api.UnsafeApplyMsgOptions("", "", client.updates["1.000"]...)
+	True(t, strings.HasPrefix(values.Get("text"), "(1/2) All work"))
+	Equal(t, 3, client.posted, "the parts missing are posted")
+	client.mutex.Unlock()
+
+	fakeSlack.Outbox() <- cable.NewDeletion("telegram", "Will", "1")
+	fakeSlack.Outbox() <- cable.NewDeletion("telegram", "Will", "2")
+	written("1")
+	time.Sleep(50 * time.Millisecond)
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	Equal(t, []string{"2.000", "1.000", "3.000"}, client.deleted, "every part is deleted, and deletions of messages not posted are dropped")
+}
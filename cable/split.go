@@ -0,0 +1,383 @@
+package cable
+
+import (
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+/* Section: splitting the messages too long for a platform */
+
+const (
+	// TelegramTextLimit is the maximum length of the text of a telegram
+	// message
+	TelegramTextLimit = 4096
+	// TelegramCaptionLimit is the maximum length of the caption of a file
+	// sent to telegram
+	TelegramCaptionLimit = 1024
+	// SlackTextLimit is the maximum length of the text of a slack message
+	SlackTextLimit = 40000
+	// UploadFileName is the name of the text files messages too long to be
+	// split are uploaded as
+	UploadFileName = "message.txt"
+	// partLogSize is the number of messages a PartLog remembers
+	partLogSize = 1000
+	// ellipsis ends the texts truncated
+	ellipsis = "…"
+)
+
+var (
+	// markdownLink matches a markdown link, or a slack link, at the start of
+	// a text, which cannot be split
+	markdownLink = regexp.MustCompile(`^(\[[^\]\n]*\]\([^)\n]*\)|<[^>\n]*>)`)
+	// markdownFence matches the start of a markdown code block, along with the
+	// line telling its language, which telegram takes as such
+	markdownFence = regexp.MustCompile("^```[^`\\s]*\n")
+	// htmlTag matches an HTML tag at the start of a text
+	htmlTag = regexp.MustCompile(`^<(/?)([a-zA-Z]+)[^>]*>`)
+	// htmlEntity matches an HTML entity at the start of a text
+	htmlEntity = regexp.MustCompile(`^&#?[a-zA-Z0-9]+;`)
+)
+
+// TextLength returns the length of text as the platforms count it, which is
+// the number of UTF-16 code units
+func TextLength(text string) int {
+	length := 0
+	for _, r := range text {
+		length += runeLength(r)
+	}
+	return length
+}
+
+// runeLength returns the number of UTF-16 code units encoding r
+func runeLength(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// SplitText breaks a text longer than limit into parts no longer than it,
+// numbered like "(1/3) ". Parts end at paragraphs, lines or words when
+// possible, and formatting entities of the given parse mode
+// (telegram.ModeMarkdown, telegram.ModeHTML, or none) are never corrupted:
+// the ones open where a part ends are closed at its end, and opened again at
+// the start of the next part. Links are never split. Parts are not numbered if
+// the numbering does not fit.
+func SplitText(text string, limit int, parseMode string) []string {
+	if TextLength(text) <= limit {
+		return []string{text}
+	}
+	reserve := len("(1/2) ")
+	for {
+		if reserve >= limit {
+			return splitText(text, limit, parseMode)
+		}
+		parts := splitText(text, limit-reserve, parseMode)
+		numbering := len(fmt.Sprintf("(%d/%d) ", len(parts), len(parts)))
+		if numbering <= reserve {
+			for i := range parts {
+				parts[i] = fmt.Sprintf("(%d/%d) ", i+1, len(parts)) + parts[i]
+			}
+			return parts
+		}
+		reserve = numbering
+	}
+}
+
+// ChunkText breaks a text longer than limit into chunks no longer than it,
+// like SplitText, without numbering them
+func ChunkText(text string, limit int, parseMode string) []string {
+	return splitText(text, limit, parseMode)
+}
+
+// TruncateText returns the start of a text longer than limit, ending with an
+// ellipsis, with the formatting entities of the given parse mode open where it
+// ends closed
+func TruncateText(text string, limit int, parseMode string) string {
+	if TextLength(text) <= limit {
+		return text
+	}
+	head, _ := cutText(text, limit-TextLength(ellipsis), parseMode)
+	return head + ellipsis
+}
+
+// splitText breaks text into parts no longer than limit
+func splitText(text string, limit int, parseMode string) []string {
+	var parts []string
+	for TextLength(text) > limit {
+		var head string
+		head, text = cutText(text, limit, parseMode)
+		parts = append(parts, head)
+	}
+	return append(parts, text)
+}
+
+// separator is what separates a text at a boundary
+type separator int
+
+const (
+	nothing separator = iota
+	space
+	line
+	paragraph
+)
+
+// entity is a formatting entity, like bold text, open at some point of a text
+type entity struct {
+	// name identifies the entity, like the marker or the tag opening it
+	name string
+	// open and close are the texts opening and closing the entity
+	open, close string
+}
+
+// boundary is a position of a text where it can be cut
+type boundary struct {
+	// separator is what separates the text at the position: a paragraph, a
+	// line, a space, or nothing
+	separator separator
+	// at is the offset of the position, and next the offset where the rest of
+	// the text starts after skipping the separator
+	at, next int
+	// clean tells whether no formatting entity is open at the position
+	clean bool
+	// open are the formatting entities open at the position
+	open []entity
+}
+
+// cutText cuts the longest start of text no longer than limit at the best
+// boundary, returning it with the formatting entities open closed, and the
+// rest of the text with them opened again
+func cutText(text string, limit int, parseMode string) (string, string) {
+	var boundaries []*boundary
+	var open []entity
+	length := 0
+	closing := func() int {
+		n := 0
+		for _, e := range open {
+			n += TextLength(e.close)
+		}
+		return n
+	}
+
+	for i := 0; i < len(text); {
+		if length+closing() > limit {
+			break
+		}
+		if i > 0 {
+			b := &boundary{separator: nothing, at: i, next: i, clean: len(open) == 0, open: append([]entity(nil), open...)}
+			switch {
+			case strings.HasPrefix(text[i:], "\n\n"):
+				b.separator, b.next = paragraph, i+2
+			case text[i] == '\n':
+				b.separator, b.next = line, i+1
+			case text[i] == ' ':
+				b.separator, b.next = space, i+1
+			}
+			boundaries = append(boundaries, b)
+		}
+
+		// tokens that cannot be split are skipped as a whole, and formatting
+		// markers open or close entities
+		token := ""
+		switch parseMode {
+		case telegram.ModeMarkdown:
+			token, open = scanMarkdown(text[i:], open)
+		case telegram.ModeHTML:
+			token, open = scanHTML(text[i:], open)
+		}
+		if token == "" {
+			_, size := utf8.DecodeRuneInString(text[i:])
+			token = text[i : i+size]
+		}
+		length += TextLength(token)
+		i += len(token)
+	}
+
+	cut := bestBoundary(boundaries)
+	if cut == nil {
+		// not even the first token fits, so its first character is cut
+		// regardless
+		_, size := utf8.DecodeRuneInString(text)
+		cut = &boundary{at: size, next: size}
+	}
+
+	head, rest := text[:cut.at], text[cut.next:]
+	for i := len(cut.open) - 1; i >= 0; i-- {
+		head += cut.open[i].close
+	}
+	for i := len(cut.open) - 1; i >= 0; i-- {
+		rest = cut.open[i].open + rest
+	}
+	return head, rest
+}
+
+// bestBoundary returns the last clean boundary past half of the longest part
+// possible with the largest separator, or the last one with the largest
+// separator if none is clean, or the last boundary otherwise
+func bestBoundary(boundaries []*boundary) *boundary {
+	if len(boundaries) == 0 {
+		return nil
+	}
+	last := boundaries[len(boundaries)-1]
+	for _, clean := range []bool{true, false} {
+		for _, s := range []separator{paragraph, line, space} {
+			for i := len(boundaries) - 1; i >= 0 && boundaries[i].at >= last.at/2; i-- {
+				if b := boundaries[i]; b.separator == s && (b.clean || !clean) {
+					return b
+				}
+			}
+		}
+	}
+	return last
+}
+
+// scanMarkdown returns the markdown token at the start of text, opening or
+// closing the given entities, or an empty token if it is plain text
+func scanMarkdown(text string, open []entity) (string, []entity) {
+	inside := ""
+	if len(open) > 0 {
+		inside = open[len(open)-1].name
+	}
+	switch {
+	case inside == "```":
+		if strings.HasPrefix(text, "```") {
+			return "```", open[:len(open)-1]
+		}
+		return "", open
+	case inside == "`":
+		if strings.HasPrefix(text, "`") {
+			return "`", open[:len(open)-1]
+		}
+		return "", open
+	case strings.HasPrefix(text, "```"):
+		// code blocks are opened again with their language line, or an empty
+		// one, so that their first line is not taken as the language
+		fence := markdownFence.FindString(text)
+		if fence == "" {
+			return "```", append(open, entity{name: "```", open: "```\n", close: "```"})
+		}
+		return fence, append(open, entity{name: "```", open: fence, close: "```"})
+	case strings.HasPrefix(text, "`"):
+		return "`", append(open, entity{name: "`", open: "`", close: "`"})
+	}
+	if link := markdownLink.FindString(text); link != "" {
+		return link, open
+	}
+	if marker := text[:1]; marker == "*" || marker == "_" {
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i].name == marker {
+				return marker, append(open[:i:i], open[i+1:]...)
+			}
+		}
+		return marker, append(open, entity{name: marker, open: marker, close: marker})
+	}
+	return "", open
+}
+
+// scanHTML returns the HTML tag or entity at the start of text, opening or
+// closing the given entities, or an empty token if it is plain text
+func scanHTML(text string, open []entity) (string, []entity) {
+	if e := htmlEntity.FindString(text); e != "" {
+		return e, open
+	}
+	match := htmlTag.FindStringSubmatch(text)
+	if match == nil {
+		return "", open
+	}
+	tag, closing, name := match[0], match[1] == "/", strings.ToLower(match[2])
+	if !closing {
+		return tag, append(open, entity{name: name, open: tag, close: "</" + name + ">"})
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		if open[i].name == name {
+			return tag, append(open[:i:i], open[i+1:]...)
+		}
+	}
+	return tag, open
+}
+
+// PartLog remembers the IDs of the messages written for every message
+// relayed, by the ID of the message, as long messages are written in several
+// parts, so an edit or deletion can reach all of them
+type PartLog struct {
+	ids   []string
+	parts map[string][]string
+	mutex sync.Mutex
+}
+
+// NewPartLog returns the address of a new, empty PartLog
+func NewPartLog() *PartLog {
+	return &PartLog{parts: make(map[string][]string)}
+}
+
+// Add remembers the IDs of the messages written for the message with the
+// given ID, forgetting the oldest message if full. Nothing is remembered by a
+// nil log, nor for messages without any written.
+func (l *PartLog) Add(id string, partIDs ...string) {
+	if l == nil || id == "" || len(partIDs) == 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.parts[id]; !ok {
+		l.ids = append(l.ids, id)
+	}
+	l.parts[id] = append([]string(nil), partIDs...)
+	if len(l.ids) > partLogSize {
+		delete(l.parts, l.ids[0])
+		l.ids = l.ids[1:]
+	}
+}
+
+// Get returns the IDs of the messages written for the message with the given
+// ID, which are none if unknown
+func (l *PartLog) Get(id string) []string {
+	if l == nil {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.parts[id]
+}
+
+// Remove forgets the messages written for the message with the given ID, as
+// they were deleted
+func (l *PartLog) Remove(id string) {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.parts[id]; !ok {
+		return
+	}
+	delete(l.parts, id)
+	for i, logged := range l.ids {
+		if logged == id {
+			l.ids = append(l.ids[:i], l.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// Source returns the ID of the message one of whose parts was written with
+// the given ID, if known
+func (l *PartLog) Source(partID string) (string, bool) {
+	if l == nil || partID == "" {
+		return "", false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for id, parts := range l.parts {
+		for _, part := range parts {
+			if part == partID {
+				return id, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,92 @@
+package cable
+
+import (
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	. "github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestTextLength(t *testing.T) {
+	Equal(t, 5, TextLength("hello"))
+	Equal(t, 4, TextLength("añó!"))
+	Equal(t, 2, TextLength("😀"), "characters out of the basic plane count twice")
+}
+
+func TestSplitText(t *testing.T) {
+	Equal(t, []string{"short"}, SplitText("short", 10, ""))
+
+	paragraphs := strings.Repeat("a", 30) + "\n\n" + strings.Repeat("b", 30) + "\n\n" + strings.Repeat("c", 30)
+	Equal(t, []string{
+		"(1/3) " + strings.Repeat("a", 30),
+		"(2/3) " + strings.Repeat("b", 30),
+		"(3/3) " + strings.Repeat("c", 30),
+	}, SplitText(paragraphs, 40, ""), "parts end at paragraphs")
+
+	Equal(t, []string{"(1/3) one", "(2/3) two", "(3/3) three"}, SplitText("one two three", 12, ""), "parts end at words")
+	Equal(t, []string{"(1/3) abcd", "(2/3) efgh", "(3/3) ijkl"}, SplitText("abcdefghijkl", 10, ""), "words are cut if too long")
+
+	for _, part := range SplitText(strings.Repeat("word ", 3000), TelegramTextLimit, "") {
+		True(t, TextLength(part) <= TelegramTextLimit)
+	}
+	parts := SplitText(strings.Repeat("x", 200), 20, "")
+	Equal(t, 17, len(parts))
+	Equal(t, "(17/17) xxxxxxxx", parts[16], "the numbering fits")
+	for _, part := range parts {
+		True(t, TextLength(part) <= 20)
+	}
+	Equal(t, []string{"ab", "cd"}, SplitText("abcd", 2, ""), "parts are not numbered if it does not fit")
+}
+
+func TestSplitText_Markdown(t *testing.T) {
+	Equal(t, []string{"(1/2) *bold and*", "(2/2) *very bold*"},
+		SplitText("*bold and very bold*", 18, telegram.ModeMarkdown), "open entities are closed and opened again")
+
+	code := "Look:\n```\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```\nDone"
+	parts := SplitText(code, 40, telegram.ModeMarkdown)
+	Equal(t, "(1/2) Look:\n```\nfunc main() {```", parts[0])
+	Equal(t, "(2/2) ```\n\tfmt.Println(\"hi\")\n}\n```\nDone", parts[1], "the first line is not taken as the language")
+	for _, part := range parts {
+		Equal(t, 0, strings.Count(part, "```")%2, "code blocks are not corrupted in %q", part)
+		True(t, TextLength(part) <= 40)
+	}
+
+	parts = SplitText("```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```", 40, telegram.ModeMarkdown)
+	Equal(t, []string{"(1/2) ```go\nfunc main() {```", "(2/2) ```go\n\tfmt.Println(\"hi\")\n}\n```"}, parts, "code blocks keep their language")
+
+	parts = SplitText("read the [docs](https://example.com) before asking", 36, telegram.ModeMarkdown)
+	Equal(t, []string{"(1/3) read the", "(2/3) [docs](https://example.com)", "(3/3) before asking"}, parts, "links are not split")
+}
+
+func TestSplitText_HTML(t *testing.T) {
+	parts := SplitText("<b>bold &amp; <i>italic text</i></b>", 30, telegram.ModeHTML)
+	Equal(t, []string{"(1/3) <b>bold &amp;</b>", "(2/3) <b><i>italic</i></b>", "(3/3) <b><i>text</i></b>"}, parts)
+}
+
+func TestTruncateText(t *testing.T) {
+	Equal(t, "short", TruncateText("short", 10, ""))
+	Equal(t, "*one*…", TruncateText("*one two three*", 7, telegram.ModeMarkdown))
+}
+
+func TestPartLog(t *testing.T) {
+	log := NewPartLog()
+	log.Add("1", "10", "11")
+	Equal(t, []string{"10", "11"}, log.Get("1"))
+	Nil(t, log.Get("2"))
+	source, ok := log.Source("11")
+	True(t, ok)
+	Equal(t, "1", source)
+	_, ok = log.Source("12")
+	False(t, ok)
+
+	for i := 0; i <= partLogSize; i++ {
+		log.Add(strings.Repeat("x", i+1), "part")
+	}
+	Nil(t, log.Get("1"), "the oldest messages are forgotten")
+
+	var nilLog *PartLog
+	nilLog.Add("1", "10")
+	Nil(t, nilLog.Get("1"))
+	_, ok = nilLog.Source("10")
+	False(t, ok)
+}
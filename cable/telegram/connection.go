@@ -0,0 +1,185 @@
+package telegram
+
+import (
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/miguelff/cable/cable/recording"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+/* Section: connections shared by every Telegram pumper using the same token */
+
+// bot is a telegram bot, and the health of its polling for updates
+type bot struct {
+	api    *APIAdapter
+	health *cable.HealthTracker
+}
+
+// bots holds the bot of each token and Bot API. Telegram rejects concurrent
+// calls to getUpdates for the same bot, so every Telegram pumper using the
+// same token shares it.
+var (
+	bots      = make(map[string]*bot)
+	botsMutex sync.Mutex
+)
+
+// sharedBot returns the bot of the given token, sending its requests to the
+// Bot API served at apiURL, or api.telegram.org if empty, creating it if
+// needed
+func sharedBot(token string, apiURL string, debug bool) (*bot, error) {
+	botsMutex.Lock()
+	defer botsMutex.Unlock()
+	key := apiURL + " " + token
+	if b, ok := bots[key]; ok {
+		return b, nil
+	}
+
+	health := cable.NewHealthTracker()
+	client, err := newHTTPClient(apiURL)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = &pollingHealthTransport{Base: client.Transport, Health: health}
+
+	api, err := telegram.NewBotAPIWithClient(token, client)
+	if err != nil {
+		return nil, err
+	}
+	api.Debug = debug
+	b := &bot{api: &APIAdapter{BotAPI: api}, health: health}
+	bots[key] = b
+	return b, nil
+}
+
+// newHTTPClient returns the HTTP client of the telegram clients, which sends
+// the requests to the Bot API served at apiURL, like the one of an emulator,
+// or api.telegram.org if empty
+func newHTTPClient(apiURL string) (*http.Client, error) {
+	client := metrics.NewHTTPClient("telegram", "getUpdates")
+	if apiURL == "" {
+		return client, nil
+	}
+	u, err := url.Parse(strings.TrimSuffix(apiURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	client.Transport = &baseURLTransport{Base: client.Transport, URL: u}
+	return client, nil
+}
+
+// baseURLTransport is an http.RoundTripper sending the requests to the Bot API
+// served at URL, as the telegram client always sends them to api.telegram.org
+type baseURLTransport struct {
+	Base http.RoundTripper
+	URL  *url.URL
+}
+
+// RoundTrip rewrites the URL of the request and forwards it to the Base
+// transport
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rewritten := new(http.Request)
+	*rewritten = *req
+	u := *req.URL
+	u.Scheme = t.URL.Scheme
+	u.Host = t.URL.Host
+	u.Path = t.URL.Path + req.URL.Path
+	rewritten.URL = &u
+	rewritten.Host = t.URL.Host
+	return t.Base.RoundTrip(rewritten)
+}
+
+// recorder records the updates read from telegram, if set
+var recorder *recording.Recorder
+
+// SetRecorder makes every bot record the updates it reads with the given
+// recorder. It has to be called before creating any Telegram pumper.
+func SetRecorder(r *recording.Recorder) {
+	recorder = r
+}
+
+// subscription is the channel of updates delivered to a subscriber, and the
+// channel closed when it unsubscribes
+type subscription struct {
+	updates chan Update
+	done    chan struct{}
+}
+
+// updatesFanOut polls for the updates of a client once, delivering them to
+// any number of subscribers
+type updatesFanOut struct {
+	mutex         sync.Mutex
+	subscriptions map[*subscription]bool
+}
+
+// fanOuts holds the fan out of the updates of each client
+var (
+	fanOuts      = make(map[API]*updatesFanOut)
+	fanOutsMutex sync.Mutex
+)
+
+// subscribe returns a channel receiving the updates of the client, and the
+// function to call to stop receiving them.
+//
+// The first subscription to a client starts polling for updates with the
+// given config, spawning a goroutine delivering them to every subscriber.
+func subscribe(client API, config telegram.UpdateConfig) (<-chan Update, func(), error) {
+	fanOutsMutex.Lock()
+	fanOut, ok := fanOuts[client]
+	if !ok {
+		updates, err := readUpdates(client, config)
+		if err != nil {
+			fanOutsMutex.Unlock()
+			return nil, nil, err
+		}
+		fanOut = &updatesFanOut{subscriptions: make(map[*subscription]bool)}
+		fanOuts[client] = fanOut
+		go fanOut.broadcast(updates)
+	}
+	fanOutsMutex.Unlock()
+
+	sub := &subscription{updates: make(chan Update), done: make(chan struct{})}
+	fanOut.mutex.Lock()
+	fanOut.subscriptions[sub] = true
+	fanOut.mutex.Unlock()
+
+	unsubscribe := func() {
+		fanOut.mutex.Lock()
+		defer fanOut.mutex.Unlock()
+		if fanOut.subscriptions[sub] {
+			delete(fanOut.subscriptions, sub)
+			close(sub.done)
+		}
+	}
+	return sub.updates, unsubscribe, nil
+}
+
+// broadcast delivers every update to the current subscribers, waiting for
+// each of them to receive it unless they unsubscribe meanwhile
+func (f *updatesFanOut) broadcast(updates <-chan Update) {
+	for update := range updates {
+		if err := recorder.Record("telegram", "update", update); err != nil {
+			log.WithField("platform", "telegram").WithError(err).Warn("Cannot record update")
+		}
+		f.mutex.Lock()
+		var subscriptions []*subscription
+		for sub := range f.subscriptions {
+			subscriptions = append(subscriptions, sub)
+		}
+		f.mutex.Unlock()
+
+		if len(subscriptions) == 0 {
+			log.WithFields(log.Fields{"platform": "telegram", "update_id": update.UpdateID}).Debug("Telegram update discarded, as no pumper is reading")
+		}
+		for _, sub := range subscriptions {
+			select {
+			case sub.updates <- update:
+			case <-sub.done:
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package telegram
+
+import (
+	"bytes"
+	"errors"
+	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/recording"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	updates := make(chan telegramAPI.Update)
+	client := &fakeTelegramAPI{updatesChannel: updates}
+
+	first, unsubscribeFirst, err := subscribe(client, telegramAPI.NewUpdate(0))
+	Nil(t, err)
+	second, unsubscribeSecond, err := subscribe(client, telegramAPI.NewUpdate(0))
+	Nil(t, err)
+	defer unsubscribeSecond()
+
+	go func() { updates <- createTelegramUserUpdate(telegramChatID, "Sup Jay!") }()
+	received := make(map[string]string)
+	for len(received) < 2 {
+		select {
+		case update := <-first:
+			received["first"] = update.Message.Text
+		case update := <-second:
+			received["second"] = update.Message.Text
+		}
+	}
+	Equal(t, map[string]string{"first": "Sup Jay!", "second": "Sup Jay!"}, received)
+
+	unsubscribeFirst()
+	go func() { updates <- createTelegramUserUpdate(telegramChatID, "Uncle Phil, you here?") }()
+	Equal(t, "Uncle Phil, you here?", (<-second).Message.Text, "unsubscribed pumpers do not block the rest")
+}
+
+func TestSubscribe_Recording(t *testing.T) {
+	var out bytes.Buffer
+	SetRecorder(recording.NewRecorder(&out, true))
+	defer SetRecorder(nil)
+
+	updates := make(chan telegramAPI.Update)
+	received, unsubscribe, err := subscribe(&fakeTelegramAPI{updatesChannel: updates}, telegramAPI.NewUpdate(0))
+	Nil(t, err)
+	defer unsubscribe()
+
+	go func() { updates <- createTelegramUserUpdate(telegramChatID, "call me at +1 415 555 0100") }()
+	<-received
+
+	entries, err := recording.Read(&out)
+	Nil(t, err)
+	Equal(t, 1, len(entries))
+	Equal(t, "telegram", entries[0].Platform)
+	Equal(t, "update", entries[0].Type)
+	Contains(t, string(entries[0].Event), `"text":"call me at [redacted phone]"`)
+}
+
+func TestTelegram_Health(t *testing.T) {
+	connection := cable.NewHealthTracker()
+	fakeTelegram := &Telegram{Pump: cable.NewPump(), connection: connection}
+	Equal(t, cable.StateIdle, fakeTelegram.Health().State, "pumpers not reading are idle")
+
+	fakeTelegram.SetConnecting()
+	Equal(t, cable.StateConnecting, fakeTelegram.Health().State)
+
+	connection.RecordReadError(errors.New("getUpdates returned 409 Conflict"))
+	connection.SetDisconnected(nil)
+	health := fakeTelegram.Health()
+	Equal(t, cable.StateDisconnected, health.State, "reading pumpers report the state of the shared polling")
+	Equal(t, 1, health.ReadErrors)
+	Equal(t, "getUpdates returned 409 Conflict", health.LastError)
+}
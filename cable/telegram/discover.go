@@ -0,0 +1,72 @@
+package telegram
+
+import (
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sort"
+)
+
+/* Section: discovering the IDs needed to configure cable */
+
+// Chat is a telegram chat the bot has seen
+type Chat struct {
+	// ID is the ID of the chat, like the one in TELEGRAM_RELAYED_CHANNEL
+	ID int64 `json:"id"`
+	// Title is the title of the chat, or the name of the user in private
+	// chats
+	Title string `json:"title"`
+	// Type is the type of chat, like "group" or "supergroup"
+	Type string `json:"type"`
+}
+
+// Discovery is what the bot of a token knows about itself and the chats it
+// has seen
+type Discovery struct {
+	// BotUserID is the ID of the bot, like the one in TELEGRAM_BOT_USER_ID
+	BotUserID int `json:"bot_user_id"`
+	// BotUserName is the user name of the bot
+	BotUserName string `json:"bot_user_name"`
+	// Chats are the chats of the pending updates of the bot, sorted by ID
+	Chats []Chat `json:"chats"`
+}
+
+// Discover returns the identity of the bot of the given token, calling getMe,
+// and the chats of its pending updates, calling getUpdates. Telegram keeps
+// updates for a day, so writing a message in a chat makes it show up.
+//
+// The updates are not confirmed, so cable still reads them afterwards, but
+// telegram rejects the call while another process is polling for updates,
+// like a running cable.
+func Discover(token string, apiURL string) (Discovery, error) {
+	var discovery Discovery
+	client, err := newHTTPClient(apiURL)
+	if err != nil {
+		return discovery, err
+	}
+	api, err := telegram.NewBotAPIWithClient(token, client)
+	if err != nil {
+		return discovery, err
+	}
+	discovery.BotUserID = api.Self.ID
+	discovery.BotUserName = api.Self.UserName
+
+	updates, err := api.GetUpdates(telegram.NewUpdate(0))
+	if err != nil {
+		return discovery, err
+	}
+	seen := make(map[int64]bool)
+	for _, update := range updates {
+		for _, msg := range []*telegram.Message{update.Message, update.EditedMessage, update.ChannelPost, update.EditedChannelPost} {
+			if msg == nil || msg.Chat == nil || seen[msg.Chat.ID] {
+				continue
+			}
+			seen[msg.Chat.ID] = true
+			chat := Chat{ID: msg.Chat.ID, Title: msg.Chat.Title, Type: msg.Chat.Type}
+			if chat.Title == "" {
+				chat.Title = msg.Chat.UserName
+			}
+			discovery.Chats = append(discovery.Chats, chat)
+		}
+	}
+	sort.Slice(discovery.Chats, func(i, j int) bool { return discovery.Chats[i].ID < discovery.Chats[j].ID })
+	return discovery, nil
+}
@@ -0,0 +1,145 @@
+package telegram
+
+import (
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/metrics"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+/* Section: relaying membership and chat-metadata events */
+
+// Pinner is implemented by the clients that can pin messages
+type Pinner interface {
+	// PinChatMessage pins a message of a chat
+	PinChatMessage(config telegram.PinChatMessageConfig) (telegram.APIResponse, error)
+}
+
+// TitleSetter is implemented by the clients that can set the title of a chat
+type TitleSetter interface {
+	// SetChatTitle sets the title of a chat
+	SetChatTitle(config telegram.SetChatTitleConfig) (telegram.APIResponse, error)
+}
+
+// SetEventRelay makes the pumper read the kinds of events relay reads, and
+// mirror the ones it mirrors when written: pins are mirrored pinning the
+// copy of the message pinned, and topics setting the title of the chat
+func (t *Telegram) SetEventRelay(relay cable.EventRelay) {
+	t.events = relay
+}
+
+// readEvent returns the event told by a service message of the relayed chat,
+// like people joining it, if its kind is read, or nil otherwise, telling
+// whether the message told an event
+func (t *Telegram) readEvent(msg *telegram.Message) (*cable.Event, bool) {
+	var event *cable.Event
+	actor := authorName(msg.From)
+	switch {
+	case msg.NewChatMembers != nil:
+		event = cable.NewEvent(cable.EventJoin, "telegram", actor)
+		for _, member := range *msg.NewChatMembers {
+			member := member
+			event.Members = append(event.Members, authorName(&member))
+		}
+	case msg.LeftChatMember != nil:
+		event = cable.NewEvent(cable.EventLeave, "telegram", actor)
+		event.Members = []string{authorName(msg.LeftChatMember)}
+	case msg.NewChatTitle != "":
+		event = cable.NewEvent(cable.EventTopic, "telegram", actor)
+		event.Subject, event.Text = "title", msg.NewChatTitle
+	case msg.PinnedMessage != nil:
+		event = cable.NewEvent(cable.EventPin, "telegram", actor)
+		event.Text = msg.PinnedMessage.Text
+		if event.Text == "" {
+			event.Text = msg.PinnedMessage.Caption
+		}
+		event.Pinned = strconv.Itoa(msg.PinnedMessage.MessageID)
+		event.PinnedSource, _ = t.parts.Source(event.Pinned)
+	default:
+		return nil, false
+	}
+	event.Time = msg.Time()
+	if !t.events.Reads(event.Kind) {
+		event.Span().SetAttribute("dropped", true).End()
+		return nil, true
+	}
+	metrics.MessagesRead.Inc("telegram")
+	t.RecordRead()
+	return event, true
+}
+
+// writeEvent mirrors an event written in the relayed chat if its kind is
+// mirrored, telling whether its notice has to be sent instead, which is the
+// case when it is not mirrored and its kind is relayed
+func (t *Telegram) writeEvent(event *cable.Event) bool {
+	deliver := event.Span()
+	logger := log.WithFields(cable.MessageFields(event)).WithField("platform", "telegram").WithField("event", event.Kind)
+	if t.events.Mirrored[event.Kind] {
+		call := deliver.Child("telegram.mirror")
+		mirrored, err := t.mirror(event)
+		call.EndWithError(err)
+		if err != nil {
+			logger.WithError(err).Warn("Telegram error mirroring event")
+		}
+		if mirrored {
+			deliver.End()
+			t.RecordWrite()
+			logger.Debug("Event mirrored")
+			return false
+		}
+	}
+	if !t.events.Relayed[event.Kind] {
+		deliver.SetAttribute("dropped", true).End()
+		logger.Debug("Event dropped, as it cannot be mirrored")
+		return false
+	}
+	return true
+}
+
+// mirror pins the copy of the message an event pinned, or sets the title of
+// the relayed chat to the topic or title an event set, telling whether it
+// did. Chats cannot be left without title, so cleared topics are not
+// mirrored.
+func (t *Telegram) mirror(event *cable.Event) (bool, error) {
+	var write func() error
+	switch event.Kind {
+	case cable.EventPin:
+		pinner, ok := t.client.(Pinner)
+		id, found := t.pinned(event)
+		if !ok || !found {
+			return false, nil
+		}
+		write = func() error {
+			_, err := pinner.PinChatMessage(telegram.PinChatMessageConfig{ChatID: t.relayedChatID, MessageID: id, DisableNotification: true})
+			return err
+		}
+	case cable.EventTopic:
+		setter, ok := t.client.(TitleSetter)
+		if !ok || event.Text == "" || (event.Subject != "topic" && event.Subject != "title") {
+			return false, nil
+		}
+		write = func() error {
+			_, err := setter.SetChatTitle(telegram.SetChatTitleConfig{ChatID: t.relayedChatID, Title: event.Text})
+			return err
+		}
+	default:
+		return false, nil
+	}
+	if err := t.throttle.Write(write, retryAfter, t.WriteStopper); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pinned returns the ID of the message of the relayed chat the message an
+// event pinned stands for: the one sent for it, or the one it was relayed
+// from, if any
+func (t *Telegram) pinned(event *cable.Event) (int, bool) {
+	candidate := event.PinnedSource
+	if ids := t.parts.Get(event.Pinned); len(ids) > 0 {
+		candidate = ids[0]
+	}
+	id, err := strconv.Atoi(candidate)
+	return id, err == nil
+}
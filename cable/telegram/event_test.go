@@ -0,0 +1,91 @@
+package telegram
+
+import (
+	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestTelegram_GoRead_Events(t *testing.T) {
+	service := func(from int, edit func(*telegramAPI.Message)) Update {
+		update := createTelegramUserUpdate(telegramChatID, "")
+		update.Message.From.ID = from
+		edit(update.Message)
+		return Update{Update: update}
+	}
+	pinned := &telegramAPI.Message{MessageID: 9, Caption: "Lunch menu"}
+	updates := make(chan Update, 10)
+	updates <- service(telegramUserID, func(m *telegramAPI.Message) {
+		m.NewChatMembers = &[]telegramAPI.User{{FirstName: "Carlton", LastName: "Banks"}, {UserName: "hilary"}}
+	})
+	updates <- service(telegramUserID, func(m *telegramAPI.Message) { m.LeftChatMember = m.From }) // dropped, as leaves are not read
+	updates <- service(telegramUserID, func(m *telegramAPI.Message) { m.NewChatTitle = "Bel-Air" })
+	updates <- service(telegramBotID, func(m *telegramAPI.Message) { m.PinnedMessage = pinned }) // dropped, as the bot pinned it
+	updates <- service(telegramUserID, func(m *telegramAPI.Message) { m.PinnedMessage = pinned })
+	updates <- Update{Update: createTelegramUserUpdate(telegramChatID, "Sup Jay!")}
+
+	fakeTelegram := NewTelegramWithAPI(&updatesTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{}, updates: updates}, telegramChatID, telegramBotID)
+	relay, _ := cable.ParseEventRelay("join,topic", "pin")
+	fakeTelegram.SetEventRelay(relay)
+	fakeTelegram.parts.Add("1.000", "9")
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	var inbox []cable.Message
+	for len(inbox) < 4 {
+		select {
+		case m := <-fakeTelegram.Inbox():
+			inbox = append(inbox, m)
+		case <-time.After(time.Second):
+			FailNow(t, "the events were not read")
+		}
+	}
+	Equal(t, "➡️ freshprince added Carlton Banks and hilary on telegram", inbox[0].String())
+	Equal(t, "📝 freshprince set the title on telegram to: Bel-Air", inbox[1].String())
+	pin, ok := cable.EventOf(inbox[2])
+	if True(t, ok) {
+		Equal(t, "📌 freshprince pinned a message on telegram: Lunch menu", pin.String())
+		Equal(t, "9", pin.Pinned)
+		Equal(t, "1.000", pin.PinnedSource, "pins of messages sent by cable tell the message they were relayed from")
+	}
+	Equal(t, "Sup Jay!", inbox[3].Content())
+}
+
+func TestTelegram_GoWrite_Events(t *testing.T) {
+	client := &pinningTelegramAPI{editingTelegramAPI: &editingTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{}}}
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	relay, _ := cable.ParseEventRelay("pin", "pin,topic")
+	fakeTelegram.SetEventRelay(relay)
+	fakeTelegram.GoWrite()
+	defer fakeTelegram.StopWrite()
+
+	event := func(kind string, edit func(*cable.Event)) *cable.Event {
+		e := cable.NewEvent(kind, "slack", "Will Smith")
+		edit(e)
+		return e
+	}
+	lunch := createSlackMessage("Lunch?", "WILL")
+	lunch.Timestamp = "1.000"
+	fakeTelegram.Outbox() <- lunch
+	fakeTelegram.Outbox() <- event(cable.EventPin, func(e *cable.Event) { e.Pinned = "1.000" })
+	fakeTelegram.Outbox() <- event(cable.EventPin, func(e *cable.Event) { e.Pinned, e.PinnedSource = "2.000", "7" })
+	fakeTelegram.Outbox() <- event(cable.EventPin, func(e *cable.Event) { e.Pinned, e.Text = "3.000", "Unknown" })
+	fakeTelegram.Outbox() <- event(cable.EventTopic, func(e *cable.Event) { e.Subject, e.Text = "topic", "Bel-Air" })
+	fakeTelegram.Outbox() <- event(cable.EventTopic, func(e *cable.Event) { e.Subject = "topic" })
+	fakeTelegram.Outbox() <- event(cable.EventJoin, func(e *cable.Event) {})
+	done := createSlackMessage("Done", "WILL")
+	done.Timestamp = "4.000"
+	fakeTelegram.Outbox() <- done
+	deadline := time.Now().Add(time.Second)
+	for len(fakeTelegram.parts.Get("4.000")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	Equal(t, []int{1, 7}, client.pins, "the copies of the messages pinned are pinned")
+	Equal(t, []string{"Bel-Air"}, client.titles, "chats cannot be left without title")
+	Equal(t, 3, client.sentCount, "the notices of the pins not mirrored are sent, and the events not relayed dropped")
+}
@@ -2,9 +2,13 @@ package telegram
 
 import (
 	"encoding/json"
+	"fmt"
 	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
 	"github.com/miguelff/cable/cable/slack"
 	slackAPI "github.com/nlopes/slack"
+	"net/http"
+	"sync"
 )
 
 /* Constants used in tests */
@@ -21,6 +25,9 @@ const (
 type fakeTelegramAPI struct {
 	updatesChannel telegramAPI.UpdatesChannel
 	sent           []telegramAPI.Chattable
+	administrators []telegramAPI.ChatMember
+	// mutex guards what is sent, as the write pump sends it concurrently
+	mutex sync.Mutex
 }
 
 func (api *fakeTelegramAPI) GetUpdatesChan(config telegramAPI.UpdateConfig) (telegramAPI.UpdatesChannel, error) {
@@ -28,10 +35,166 @@ func (api *fakeTelegramAPI) GetUpdatesChan(config telegramAPI.UpdateConfig) (tel
 }
 
 func (api *fakeTelegramAPI) Send(c telegramAPI.Chattable) (telegramAPI.Message, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
 	api.sent = append(api.sent, c)
 	return telegramAPI.Message{}, nil
 }
 
+// Sent returns a copy of the messages sent
+func (api *fakeTelegramAPI) Sent() []telegramAPI.Chattable {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return append([]telegramAPI.Chattable(nil), api.sent...)
+}
+
+func (api *fakeTelegramAPI) GetChatAdministrators(config telegramAPI.ChatConfig) ([]telegramAPI.ChatMember, error) {
+	return api.administrators, nil
+}
+
+func (api *fakeTelegramAPI) GetInviteLink(config telegramAPI.ChatConfig) (string, error) {
+	return fmt.Sprintf("https://t.me/joinchat/%d", config.ChatID), nil
+}
+
+// mediaTelegramAPI is a fake Telegram API that gets profile photos, and links
+// files
+type mediaTelegramAPI struct {
+	*fakeTelegramAPI
+	avatars map[int]string
+	lookups int
+	files   map[string]string
+}
+
+func (api *mediaTelegramAPI) GetUserProfilePhotos(config telegramAPI.UserProfilePhotosConfig) (telegramAPI.UserProfilePhotos, error) {
+	api.lookups++
+	fileID, ok := api.avatars[config.UserID]
+	if !ok {
+		return telegramAPI.UserProfilePhotos{}, nil
+	}
+	return telegramAPI.UserProfilePhotos{TotalCount: 1, Photos: [][]telegramAPI.PhotoSize{{{FileID: fileID, Width: 160}, {FileID: fileID + "-big", Width: 640}}}}, nil
+}
+
+func (api *mediaTelegramAPI) GetFileDirectURL(fileID string) (string, error) {
+	fileURL, ok := api.files[fileID]
+	if !ok {
+		return "", fmt.Errorf("file %s not found", fileID)
+	}
+	return fileURL, nil
+}
+
+// updatesTelegramAPI is a fake Telegram API that reads the parts of updates
+// the telegram client does not read
+type updatesTelegramAPI struct {
+	*fakeTelegramAPI
+	updates chan Update
+}
+
+func (api *updatesTelegramAPI) ReadUpdates(config telegramAPI.UpdateConfig) (<-chan Update, error) {
+	return api.updates, nil
+}
+
+// downloadingTelegramAPI is a fake Telegram API that downloads files,
+// failing for the unknown ones
+type downloadingTelegramAPI struct {
+	*fakeTelegramAPI
+	files map[string]string
+}
+
+func (api *downloadingTelegramAPI) DownloadFile(fileID string) ([]byte, error) {
+	content, ok := api.files[fileID]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", fileID)
+	}
+	return []byte(content), nil
+}
+
+// emojiTelegramAPI is a fake Telegram API that gets the stickers of the
+// custom emoji it knows, recording the IDs asked for
+type emojiTelegramAPI struct {
+	*fakeTelegramAPI
+	stickers map[string]CustomEmoji
+	asked    [][]string
+}
+
+func (api *emojiTelegramAPI) GetCustomEmojiStickers(ids []string) ([]CustomEmoji, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.asked = append(api.asked, ids)
+	var stickers []CustomEmoji
+	for _, id := range ids {
+		if sticker, ok := api.stickers[id]; ok {
+			stickers = append(stickers, sticker)
+		}
+	}
+	return stickers, nil
+}
+
+// editingTelegramAPI is a fake Telegram API that sends messages with
+// consecutive IDs, and edits their texts and captions
+type editingTelegramAPI struct {
+	*fakeTelegramAPI
+	sentCount int
+	edits     map[int]string
+	captions  map[int]string
+}
+
+func (api *editingTelegramAPI) Send(c telegramAPI.Chattable) (telegramAPI.Message, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	if edit, ok := c.(telegramAPI.EditMessageTextConfig); ok {
+		if api.edits == nil {
+			api.edits = make(map[int]string)
+		}
+		api.edits[edit.MessageID] = edit.Text
+		return telegramAPI.Message{MessageID: edit.MessageID}, nil
+	}
+	if edit, ok := c.(telegramAPI.EditMessageCaptionConfig); ok {
+		if api.captions == nil {
+			api.captions = make(map[int]string)
+		}
+		api.captions[edit.MessageID] = edit.Caption
+		return telegramAPI.Message{MessageID: edit.MessageID}, nil
+	}
+	api.sentCount++
+	return telegramAPI.Message{MessageID: api.sentCount}, nil
+}
+
+// deletingTelegramAPI is a fake Telegram API that sends and edits messages
+// like editingTelegramAPI, and deletes them
+type deletingTelegramAPI struct {
+	*editingTelegramAPI
+	deleted []int
+}
+
+func (api *deletingTelegramAPI) DeleteMessage(config telegramAPI.DeleteMessageConfig) (telegramAPI.APIResponse, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.deleted = append(api.deleted, config.MessageID)
+	return telegramAPI.APIResponse{Ok: true}, nil
+}
+
+// pinningTelegramAPI is a fake Telegram API that sends messages like
+// editingTelegramAPI, pins them and sets the title of the chat
+type pinningTelegramAPI struct {
+	*editingTelegramAPI
+	pins   []int
+	titles []string
+}
+
+func (api *pinningTelegramAPI) PinChatMessage(config telegramAPI.PinChatMessageConfig) (telegramAPI.APIResponse, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.pins = append(api.pins, config.MessageID)
+	return telegramAPI.APIResponse{Ok: true}, nil
+}
+
+func (api *pinningTelegramAPI) SetChatTitle(config telegramAPI.SetChatTitleConfig) (telegramAPI.APIResponse, error) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	api.titles = append(api.titles, config.Title)
+	return telegramAPI.APIResponse{Ok: true}, nil
+}
+
 /* factories */
 
 // createUpdate creates a message update as if it was written in the
@@ -97,12 +260,29 @@ func createSlackMessage(text string, authorID string, worksSpaceUsers ...slackAP
 	}
 }
 
+// edit returns the slack message as an edit of the message posted with the
+// given timestamp, as if read from slack
+func edit(m slack.Message, timestamp string) cable.Message {
+	m.Timestamp = timestamp
+	return slackEdit{m}
+}
+
+// slackEdit is a slack message edited
+type slackEdit struct {
+	slack.Message
+}
+
+func (m slackEdit) IsEdit() bool {
+	return true
+}
+
 // slackJSONMessage is a struct used to decode slack.MsgOption
 // values for easier management in tests
 type slackJSONMessage struct {
-	Fallback   string `json:"fallback"`
-	AuthorName string `json:"author_name"`
-	Text       string `json:"text"`
+	Fallback   string   `json:"fallback"`
+	AuthorName string   `json:"author_name"`
+	Text       string   `json:"text"`
+	MarkdownIn []string `json:"mrkdwn_in"`
 }
 
 // asSlackJSONMessage converts slack.MsgOption as slackJSONMessage that are
@@ -122,3 +302,11 @@ func asSlackJSONMessage(slackMessages slackAPI.MsgOption) slackJSONMessage {
 	_ = json.Unmarshal([]byte(serializedAttachments), &jsonMessages)
 	return jsonMessages[0]
 }
+
+/* fake http transport */
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
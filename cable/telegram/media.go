@@ -0,0 +1,116 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+)
+
+/* Section: serving the files shared in telegram */
+
+// MediaPath is the path the files shared in telegram are served at by
+// MediaHandler, followed by their ID
+const MediaPath = "/media/telegram/"
+
+// signatureParam is the query parameter of the links to the files served
+// carrying their signature
+const signatureParam = "sig"
+
+// FileLinker is implemented by the clients that can tell the URL a file
+// shared in telegram is downloaded from
+type FileLinker interface {
+	// GetFileDirectURL returns the URL the file with the given ID is
+	// downloaded from, which contains the token of the bot
+	GetFileDirectURL(fileID string) (string, error)
+}
+
+// ProfilePhotoGetter is implemented by the clients that can get the profile
+// photos of telegram users
+type ProfilePhotoGetter interface {
+	// GetUserProfilePhotos returns the profile photos of a user, the newest
+	// first
+	GetUserProfilePhotos(config telegram.UserProfilePhotosConfig) (telegram.UserProfilePhotos, error)
+}
+
+// MediaHandler returns a handler serving the files shared in telegram, like
+// photos and profile photos, at MediaPath followed by their ID. Files are
+// downloaded by the bot of the given token, so that the token, which is part
+// of the URLs telegram serves files at, is not disclosed to other platforms.
+// Only the files linked by the messages relayed are served, as their links
+// are signed with a key derived from the token, so the handler cannot be used
+// to download any other file the bot can. The bot sends its requests to the
+// Bot API served at apiURL, or api.telegram.org if empty.
+func MediaHandler(token string, apiURL string) http.Handler {
+	key := signingKey(token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := sharedBot(token, apiURL, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		serveFile(w, r, b.api, b.api.Client, key)
+	})
+}
+
+// signingKey returns the key the links to the files served are signed with,
+// derived from the token of the bot, which the signatures do not disclose
+func signingKey(token string) []byte {
+	key := sha256.Sum256([]byte("cable media links " + token))
+	return key[:]
+}
+
+// sign returns the signature of the link to the file with the given ID
+func sign(key []byte, fileID string) string {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(fileID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// serveFile writes the file with the ID in the path of the request, which is
+// downloaded with the given client from the URL the linker tells, if the
+// request carries its signature with the given key
+func serveFile(w http.ResponseWriter, r *http.Request, linker FileLinker, client *http.Client, key []byte) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	fileID := strings.TrimPrefix(r.URL.Path, MediaPath)
+	if fileID == "" || strings.Contains(fileID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	signature := r.URL.Query().Get(signatureParam)
+	if !hmac.Equal([]byte(signature), []byte(sign(key, fileID))) {
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	fileURL, err := linker.GetFileDirectURL(fileID)
+	if err != nil {
+		log.WithField("platform", "telegram").WithField("file_id", fileID).WithError(err).Debug("Telegram file not found")
+		http.NotFound(w, r)
+		return
+	}
+
+	resp, err := client.Get(fileURL)
+	if err != nil {
+		http.Error(w, "cannot download the file", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		http.Error(w, "cannot download the file", http.StatusBadGateway)
+		return
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	// file IDs always identify the same contents, which only the clients
+	// they are linked to may keep, as they may be private
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	_, _ = io.Copy(w, resp.Body)
+}
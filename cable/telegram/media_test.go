@@ -0,0 +1,50 @@
+package telegram
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeFile(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/file/botTOKEN/photos/file_1.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write([]byte("JPEG"))
+	}))
+	defer files.Close()
+	linker := &mediaTelegramAPI{files: map[string]string{
+		"PHOTO":  files.URL + "/file/botTOKEN/photos/file_1.jpg",
+		"BROKEN": files.URL + "/file/botTOKEN/photos/missing.jpg",
+	}}
+
+	key := signingKey("TOKEN")
+	serve := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		serveFile(recorder, httptest.NewRequest(method, path, nil), linker, files.Client(), key)
+		return recorder
+	}
+	signed := func(fileID string) string {
+		return MediaPath + fileID + "?sig=" + sign(key, fileID)
+	}
+
+	response := serve(http.MethodGet, signed("PHOTO"))
+	Equal(t, http.StatusOK, response.Code)
+	Equal(t, "image/jpeg", response.Header().Get("Content-Type"))
+	Equal(t, "private, max-age=86400", response.Header().Get("Cache-Control"))
+	Equal(t, "JPEG", response.Body.String())
+	NotContains(t, response.Body.String(), "TOKEN")
+
+	Equal(t, http.StatusForbidden, serve(http.MethodGet, MediaPath+"PHOTO").Code, "only the files linked are served")
+	Equal(t, http.StatusForbidden, serve(http.MethodGet, MediaPath+"PHOTO?sig="+sign(key, "BROKEN")).Code)
+	Equal(t, http.StatusForbidden, serve(http.MethodGet, MediaPath+"PHOTO?sig="+sign(signingKey("OTHER"), "PHOTO")).Code)
+	Equal(t, http.StatusNotFound, serve(http.MethodGet, signed("UNKNOWN")).Code)
+	Equal(t, http.StatusNotFound, serve(http.MethodGet, MediaPath).Code)
+	Equal(t, http.StatusBadGateway, serve(http.MethodGet, signed("BROKEN")).Code)
+	Equal(t, http.StatusMethodNotAllowed, serve(http.MethodPost, signed("PHOTO")).Code)
+	NotContains(t, sign(key, "PHOTO"), "TOKEN")
+}
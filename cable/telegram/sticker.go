@@ -0,0 +1,200 @@
+package telegram
+
+import (
+	"encoding/json"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"sync"
+)
+
+// stickerCacheSize is the number of converted stickers cached
+const stickerCacheSize = 200
+
+/* Section: relaying stickers */
+
+// SetImageConverter makes the pumper convert the stickers read into images
+// with the given converter, so platforms able to upload files can share
+// them, or not to convert them if nil
+func (t *Telegram) SetImageConverter(converter cable.ImageConverter) {
+	t.converter = converter
+}
+
+// readSticker converts the sticker shared in the message, if any, into an
+// image. Animated stickers are converted from their thumbnail, as ffmpeg
+// cannot render them.
+func (t *Telegram) readSticker(message *Message) {
+	sticker := message.Message.Sticker
+	downloader, ok := t.client.(FileDownloader)
+	if sticker == nil || t.converter == nil || !ok {
+		return
+	}
+	fileID, filename := sticker.FileID, "sticker.webp"
+	if message.extras != nil && message.extras.Sticker != nil {
+		switch {
+		case message.extras.Sticker.IsAnimated && sticker.Thumbnail != nil:
+			fileID, filename = sticker.Thumbnail.FileID, "thumbnail.webp"
+		case message.extras.Sticker.IsAnimated:
+			return
+		case message.extras.Sticker.IsVideo:
+			filename = "sticker.webm"
+		}
+	}
+	if image, ok := t.stickers.Get(fileID); ok {
+		message.image = &image
+		return
+	}
+
+	fields := log.Fields{"platform": "telegram", "file_id": fileID}
+	content, err := downloader.DownloadFile(fileID)
+	if err != nil {
+		log.WithFields(cable.MessageFields(message)).WithFields(fields).WithError(err).Warn("Telegram error downloading sticker")
+		return
+	}
+	png, err := t.converter.ToPNG(filename, content)
+	if err != nil {
+		log.WithFields(cable.MessageFields(message)).WithFields(fields).WithError(err).Warn("Cannot convert sticker")
+		return
+	}
+	image := cable.Image{Filename: "sticker.png", MimeType: "image/png", AltText: sticker.Emoji, Content: png}
+	t.stickers.Add(fileID, image)
+	message.image = &image
+}
+
+// stickerCache holds the images stickers are converted into, by the ID of
+// their file, as the same stickers are shared again and again
+type stickerCache struct {
+	ids    []string
+	images map[string]cable.Image
+	mutex  sync.Mutex
+}
+
+// newStickerCache returns the address of a new, empty stickerCache
+func newStickerCache() *stickerCache {
+	return &stickerCache{images: make(map[string]cable.Image)}
+}
+
+// Add caches the image a sticker is converted into, forgetting the oldest
+// one if full
+func (c *stickerCache) Add(fileID string, image cable.Image) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.images[fileID]; !ok {
+		c.ids = append(c.ids, fileID)
+	}
+	c.images[fileID] = image
+	if len(c.ids) > stickerCacheSize {
+		delete(c.images, c.ids[0])
+		c.ids = c.ids[1:]
+	}
+}
+
+// Get returns the image the sticker with the given file ID was converted
+// into, if cached
+func (c *stickerCache) Get(fileID string) (cable.Image, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	image, ok := c.images[fileID]
+	return image, ok
+}
+
+/* Section: relaying custom emoji */
+
+// CustomEmoji is the sticker a custom emoji is shown as
+type CustomEmoji struct {
+	StickerFormat
+	CustomEmojiID string              `json:"custom_emoji_id"`
+	FileID        string              `json:"file_id"`
+	Thumbnail     *telegram.PhotoSize `json:"thumbnail,omitempty"`
+}
+
+// image returns the ID of the file of the image the custom emoji is shown
+// as, which is the thumbnail of the animated ones, or empty if it has none
+func (e CustomEmoji) image() string {
+	switch {
+	case (e.IsAnimated || e.IsVideo) && e.Thumbnail != nil:
+		return e.Thumbnail.FileID
+	case e.IsAnimated:
+		return ""
+	}
+	return e.FileID
+}
+
+// CustomEmojiGetter is implemented by the clients that can get the stickers
+// custom emoji are shown as
+type CustomEmojiGetter interface {
+	// GetCustomEmojiStickers returns the stickers of the custom emoji with
+	// the given IDs
+	GetCustomEmojiStickers(ids []string) ([]CustomEmoji, error)
+}
+
+// GetCustomEmojiStickers returns the stickers of the custom emoji with the
+// given IDs, which the telegram client cannot get
+func (adapter *APIAdapter) GetCustomEmojiStickers(ids []string) ([]CustomEmoji, error) {
+	encoded, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := adapter.MakeRequest("getCustomEmojiStickers", url.Values{"custom_emoji_ids": {string(encoded)}})
+	if err != nil {
+		return nil, err
+	}
+	var stickers []CustomEmoji
+	err = json.Unmarshal(resp.Result, &stickers)
+	return stickers, err
+}
+
+// readCustomEmoji links the custom emoji in the text of the message to their
+// image, if files are served, so slack shows them rather than the standard
+// emoji telegram shows in their place. Their images are looked up once per
+// custom emoji.
+func (t *Telegram) readCustomEmoji(message *Message) {
+	getter, ok := t.client.(CustomEmojiGetter)
+	msg := message.Message
+	if !ok || message.mediaURL == "" || message.extras == nil || !hasCustomEmoji(message.extras.Entities) ||
+		msg.Entities == nil || len(*msg.Entities) != len(message.extras.Entities) {
+		return
+	}
+	images := t.customEmojiImages(getter, message.extras.Entities)
+	entities := append([]telegram.MessageEntity(nil), *msg.Entities...)
+	for i, extras := range message.extras.Entities {
+		if fileID := images[extras.CustomEmojiID]; fileID != "" && entities[i].Type == "custom_emoji" {
+			entities[i].URL = message.mediaLink(fileID)
+		}
+	}
+	linked := *msg
+	linked.Entities = &entities
+	message.Update.Message = &linked
+}
+
+// customEmojiImages returns the IDs of the files of the images of the custom
+// emoji of the entities, by their ID, getting the ones not known yet
+func (t *Telegram) customEmojiImages(getter CustomEmojiGetter, entities []EntityExtras) map[string]string {
+	t.customEmojiMutex.Lock()
+	defer t.customEmojiMutex.Unlock()
+	var unknown []string
+	for _, entity := range entities {
+		if _, ok := t.customEmoji[entity.CustomEmojiID]; entity.CustomEmojiID != "" && !ok {
+			unknown = append(unknown, entity.CustomEmojiID)
+		}
+	}
+	if len(unknown) > 0 {
+		stickers, err := getter.GetCustomEmojiStickers(unknown)
+		if err != nil {
+			log.WithField("platform", "telegram").WithError(err).Warn("Telegram error getting custom emoji")
+		} else {
+			for _, id := range unknown {
+				t.customEmoji[id] = ""
+			}
+			for _, sticker := range stickers {
+				t.customEmoji[sticker.CustomEmojiID] = sticker.image()
+			}
+		}
+	}
+	images := make(map[string]string)
+	for _, entity := range entities {
+		images[entity.CustomEmojiID] = t.customEmoji[entity.CustomEmojiID]
+	}
+	return images
+}
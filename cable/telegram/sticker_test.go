@@ -0,0 +1,139 @@
+package telegram
+
+import (
+	"errors"
+	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	. "github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// converterFunc is a fake image converter converting with a function
+type converterFunc func(filename string, content []byte) ([]byte, error)
+
+func (f converterFunc) ToPNG(filename string, content []byte) ([]byte, error) {
+	return f(filename, content)
+}
+
+func TestTelegram_ReadSticker(t *testing.T) {
+	client := &downloadingTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{}, files: map[string]string{
+		"STATIC": "WEBP",
+		"THUMB":  "THUMBNAIL",
+		"VIDEO":  "WEBM",
+	}}
+	var conversions []string
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	fakeTelegram.SetImageConverter(converterFunc(func(filename string, content []byte) ([]byte, error) {
+		conversions = append(conversions, filename)
+		if string(content) == "WEBM" {
+			return nil, errors.New("unsupported codec")
+		}
+		return []byte("PNG of " + string(content)), nil
+	}))
+	sticker := func(fileID string, format *StickerFormat) *Message {
+		m := createTelegramMessage("", "Will", "Smith", "freshprince")
+		m.Message.Sticker = &telegramAPI.Sticker{FileID: fileID, Emoji: "😂", Thumbnail: &telegramAPI.PhotoSize{FileID: "THUMB"}}
+		if format != nil {
+			m.extras = &MessageExtras{Sticker: format}
+		}
+		return &m
+	}
+
+	static := sticker("STATIC", nil)
+	fakeTelegram.readSticker(static)
+	Equal(t, []cable.Image{{Filename: "sticker.png", MimeType: "image/png", AltText: "😂", Content: []byte("PNG of WEBP")}}, cable.ImagesOf(static))
+	Equal(t, "😂 Sticker", static.Content())
+	Equal(t, cable.ImagesOf(static), cable.ImagesOf(static.WithContent("😂 Sticker")), "images are kept when the text changes")
+
+	again := sticker("STATIC", nil)
+	fakeTelegram.readSticker(again)
+	Equal(t, cable.ImagesOf(static), cable.ImagesOf(again))
+	Equal(t, []string{"sticker.webp"}, conversions, "converted stickers are cached")
+
+	animated := sticker("TGS", &StickerFormat{IsAnimated: true})
+	fakeTelegram.readSticker(animated)
+	Equal(t, "PNG of THUMBNAIL", string(cable.ImagesOf(animated)[0].Content), "animated stickers are converted from their thumbnail")
+
+	video := sticker("VIDEO", &StickerFormat{IsVideo: true})
+	fakeTelegram.readSticker(video)
+	Nil(t, cable.ImagesOf(video), "stickers that cannot be converted are relayed without image")
+	Equal(t, []string{"sticker.webp", "thumbnail.webp", "sticker.webm"}, conversions)
+}
+
+func TestTelegram_GoRead_Stickers(t *testing.T) {
+	sticker := createTelegramUserUpdate(telegramChatID, "")
+	sticker.Message.Sticker = &telegramAPI.Sticker{FileID: "STATIC", Emoji: "😂"}
+	updates := make(chan Update)
+	api := &fakeTelegramAPI{}
+	client := struct {
+		*updatesTelegramAPI
+		FileDownloader
+	}{&updatesTelegramAPI{fakeTelegramAPI: api, updates: updates}, &downloadingTelegramAPI{fakeTelegramAPI: api, files: map[string]string{"STATIC": "WEBP"}}}
+	converting, converted := make(chan bool), make(chan bool)
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	fakeTelegram.SetImageConverter(converterFunc(func(filename string, content []byte) ([]byte, error) {
+		converting <- true
+		<-converted
+		return []byte("PNG"), nil
+	}))
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	updates <- Update{Update: sticker}
+	<-converting
+	select {
+	case updates <- Update{Update: createTelegramUserUpdate(telegramChatID, "next")}:
+	case <-time.After(time.Second):
+		FailNow(t, "updates are not read while a sticker is converted")
+	}
+	close(converted)
+
+	var inbox []cable.Message
+	for len(inbox) < 2 {
+		select {
+		case m := <-fakeTelegram.Inbox():
+			inbox = append(inbox, m)
+		case <-time.After(time.Second):
+			FailNow(t, "the messages were not read")
+		}
+	}
+	Equal(t, "PNG", string(cable.ImagesOf(inbox[0])[0].Content))
+	Equal(t, "next", inbox[1].Content(), "messages are relayed in the order they are read")
+}
+
+func TestTelegram_ReadCustomEmoji(t *testing.T) {
+	client := &emojiTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{}, stickers: map[string]CustomEmoji{
+		"STATIC":   {CustomEmojiID: "STATIC", FileID: "WEBP"},
+		"ANIMATED": {CustomEmojiID: "ANIMATED", FileID: "TGS", StickerFormat: StickerFormat{IsAnimated: true}, Thumbnail: &telegramAPI.PhotoSize{FileID: "THUMB"}},
+	}}
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	fakeTelegram.SetMediaURL("https://cable.example.com/")
+	emoji := func() *Message {
+		m := createTelegramMessage("🎉 party 🔥 😀", "Will", "Smith", "freshprince")
+		m.Message.Entities = &[]telegramAPI.MessageEntity{{Type: "custom_emoji", Offset: 0, Length: 2}, {Type: "custom_emoji", Offset: 9, Length: 2}, {Type: "custom_emoji", Offset: 12, Length: 2}}
+		m.extras = &MessageExtras{Entities: []EntityExtras{{CustomEmojiID: "STATIC"}, {CustomEmojiID: "ANIMATED"}, {CustomEmojiID: "UNKNOWN"}}}
+		m.mediaURL, m.mediaKey = fakeTelegram.mediaURL, fakeTelegram.mediaKey
+		return &m
+	}
+
+	message := emoji()
+	fakeTelegram.readCustomEmoji(message)
+	options, err := message.ToSlack()
+	NoError(t, err)
+	text := asSlackJSONMessage(options[0]).Text
+	True(t, strings.HasPrefix(text, "<https://cable.example.com"+MediaPath+"WEBP?sig="), text)
+	Contains(t, text, MediaPath+"THUMB?sig=", "animated custom emoji are linked to their thumbnail")
+	True(t, strings.HasSuffix(text, "|🔥> 😀"), "custom emoji without image are kept as their standard emoji: %s", text)
+
+	fakeTelegram.readCustomEmoji(emoji())
+	Equal(t, [][]string{{"STATIC", "ANIMATED", "UNKNOWN"}}, client.asked, "custom emoji are looked up once")
+
+	unserved := emoji()
+	unserved.mediaURL = ""
+	fakeTelegram.readCustomEmoji(unserved)
+	options, err = unserved.ToSlack()
+	NoError(t, err)
+	Equal(t, "🎉 party 🔥 😀", asSlackJSONMessage(options[0]).Text, "custom emoji are not linked unless files are served")
+}
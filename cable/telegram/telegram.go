@@ -4,9 +4,17 @@ import (
 	"fmt"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/miguelff/cable/cable/tracing"
 	"github.com/nlopes/slack"
 	log "github.com/sirupsen/logrus"
+	"net/http"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
 )
 
 const (
@@ -14,6 +22,25 @@ const (
 	// read pump. Timing out implies resetting the connection with the
 	// server, which can help in case the connection died.
 	readTimeoutSecs = 60
+	// sendInterval and sendBurst are the pace messages are sent to a chat at,
+	// as telegram allows about one message per second, and short bursts
+	sendInterval = time.Second
+	sendBurst    = 3
+	// groupSendInterval and groupSendBurst are the pace messages are sent to
+	// a group at, as telegram allows 20 messages per minute: a burst plus the
+	// messages allowed in the rest of the minute add up to 20
+	groupSendInterval = 4 * time.Second
+	groupSendBurst    = 5
+	// botSendInterval and botSendBurst are the pace a bot sends messages at,
+	// as telegram allows 30 messages per second
+	botSendInterval = time.Second / 30
+	botSendBurst    = 30
+	// quoteLength is the length of the start of the messages replied to
+	// quoted in slack
+	quoteLength = 200
+	// downloadQueueSize is the number of messages read waiting for their
+	// media to be downloaded, above which the updates wait to be read
+	downloadQueueSize = 50
 )
 
 /* Section: Telegram API interface */
@@ -23,6 +50,15 @@ const (
 type API interface {
 	GetUpdatesChan(config telegram.UpdateConfig) (telegram.UpdatesChannel, error)
 	Send(c telegram.Chattable) (telegram.Message, error)
+	GetChatAdministrators(config telegram.ChatConfig) ([]telegram.ChatMember, error)
+	GetInviteLink(config telegram.ChatConfig) (string, error)
+}
+
+// MessageDeleter is implemented by the clients that can delete the messages
+// sent, which Send cannot, as telegram answers with no message
+type MessageDeleter interface {
+	// DeleteMessage deletes the message of config
+	DeleteMessage(config telegram.DeleteMessageConfig) (telegram.APIResponse, error)
 }
 
 /* Section: Telegram type implementing GoRead and GoWrite */
@@ -40,50 +76,255 @@ type Telegram struct {
 	// botUserID is the id of the telegram app installed, which is used to
 	// discard messages looped back by the own bot
 	botUserID int
+	// connection is the health of the polling for updates of the bot, if
+	// known
+	connection *cable.HealthTracker
+	// sent are the messages sent to the relayed chat, by ID
+	sent *cable.SentLog
+	// throttle paces the messages sent to the relayed chat
+	throttle *cable.Throttle
+	// parts are the IDs of the messages sent for every message written, by
+	// its ID, as long messages are sent in several parts, and texts the IDs
+	// of the ones among them with its text, which edits edit
+	parts *cable.PartLog
+	texts *cable.PartLog
+	// maxParts is the number of parts above which long messages are sent as
+	// a text file instead, or zero to always split them
+	maxParts int
+	// mediaURL is the public base URL the files shared in telegram are served
+	// at by MediaHandler, or empty if they are not, and mediaKey the key
+	// their links are signed with
+	mediaURL string
+	mediaKey []byte
+	// avatars are the IDs of the files of the profile photos of the authors
+	// of the messages read, by user ID, which are empty if they have none
+	avatars      map[int]string
+	avatarsMutex sync.Mutex
+	// polls are the messages the polls read were sent in, so the updates of
+	// their votes are relayed as edits of them
+	polls *pollLog
+	// transcriber transcribes the voice messages read, if set
+	transcriber cable.Transcriber
+	// converter converts the stickers read into images, if set, and
+	// stickers caches the images they are converted into
+	converter cable.ImageConverter
+	stickers  *stickerCache
+	// customEmoji are the IDs of the files of the images of the custom emoji
+	// read, by their ID, which are empty if they have none to show
+	customEmoji      map[string]string
+	customEmojiMutex sync.Mutex
+	// events tells the kinds of events read, and the ones mirrored when
+	// written
+	events cable.EventRelay
 }
 
-// NewTelegram returns the address of a new value of Telegram
-func NewTelegram(token string, relayedChannel int64, BotUserID int, debug bool) *Telegram {
-	bot, err := telegram.NewBotAPI(token)
+// NewTelegram returns the address of a new value of Telegram, sending its
+// requests to the Bot API served at apiURL, like the one of an emulator, or
+// api.telegram.org if empty. The ID of the bot is the one getMe returns for
+// the token, unless BotUserID overrides it.
+func NewTelegram(token string, apiURL string, relayedChannel int64, BotUserID int, debug bool) *Telegram {
+	b, err := sharedBot(token, apiURL, debug)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	bot.Debug = debug
+	if BotUserID == 0 {
+		BotUserID = b.api.Self.ID
+	} else if BotUserID != b.api.Self.ID {
+		log.WithField("platform", "telegram").WithField("bot_id", b.api.Self.ID).Warnf("TELEGRAM_BOT_USER_ID=%d overrides the ID of the bot", BotUserID)
+	}
+	t := NewTelegramWithAPI(b.api, relayedChannel, BotUserID)
+	t.connection = b.health
+	t.mediaKey = signingKey(token)
+	t.throttle.Limiters = []*cable.RateLimiter{
+		cable.SharedRateLimiter("telegram/"+token, botSendInterval, botSendBurst),
+		cable.SharedRateLimiter(fmt.Sprintf("telegram/%s/%d", token, relayedChannel), sendInterval, sendBurst),
+	}
+	if relayedChannel < 0 {
+		t.throttle.Limiters = append(t.throttle.Limiters,
+			cable.SharedRateLimiter(fmt.Sprintf("telegram/%s/%d/group", token, relayedChannel), groupSendInterval, groupSendBurst))
+	}
+	return t
+}
+
+// NewTelegramWithAPI returns the address of a new value of Telegram using the
+// given client, like a fake one replaying recorded updates
+func NewTelegramWithAPI(client API, relayedChannel int64, botUserID int) *Telegram {
 	return &Telegram{
 		Pump:          cable.NewPump(),
-		client:        bot,
+		client:        client,
 		relayedChatID: relayedChannel,
-		botUserID:     BotUserID,
+		botUserID:     botUserID,
+		sent:          cable.NewSentLog(),
+		throttle:      &cable.Throttle{Platform: "telegram", Batches: cable.NewBatchLog()},
+		parts:         cable.NewPartLog(),
+		texts:         cable.NewPartLog(),
+		avatars:       make(map[int]string),
+		polls:         newPollLog(),
+		stickers:      newStickerCache(),
+		customEmoji:   make(map[string]string),
+	}
+}
+
+// SetCoalesceBacklog makes the messages queued to be sent combine into one
+// when more than backlog are waiting, or never if zero
+func (t *Telegram) SetCoalesceBacklog(backlog int) {
+	t.throttle.CoalesceBacklog = backlog
+}
+
+// CanEdit tells the messages sent can be edited, so edits are relayed to the
+// pumper
+func (t *Telegram) CanEdit() bool {
+	return true
+}
+
+// CanDelete tells whether the client can delete the messages sent, so
+// deletions are relayed to the pumper
+func (t *Telegram) CanDelete() bool {
+	_, ok := t.client.(MessageDeleter)
+	return ok
+}
+
+// SetMaxParts makes the messages too long for telegram be sent as a text file
+// when they would be split in more than maxParts, or never if zero
+func (t *Telegram) SetMaxParts(maxParts int) {
+	t.maxParts = maxParts
+}
+
+// SetMediaURL makes the messages read link their photos, and the profile
+// photos of their authors, to the public base URL of a server serving the
+// files shared in telegram with MediaHandler, so that other platforms can
+// show them. Photos are not linked if empty.
+func (t *Telegram) SetMediaURL(mediaURL string) {
+	t.mediaURL = strings.TrimSuffix(mediaURL, "/")
+}
+
+// avatar returns the ID of the file of the current profile photo of a user,
+// which is empty if the user has none or the client cannot get it. Profile
+// photos are looked up once per user.
+func (t *Telegram) avatar(user *telegram.User) string {
+	getter, ok := t.client.(ProfilePhotoGetter)
+	if !ok || user == nil {
+		return ""
+	}
+	t.avatarsMutex.Lock()
+	defer t.avatarsMutex.Unlock()
+	if fileID, ok := t.avatars[user.ID]; ok {
+		return fileID
 	}
+	photos, err := getter.GetUserProfilePhotos(telegram.UserProfilePhotosConfig{UserID: user.ID, Limit: 1})
+	if err != nil {
+		log.WithField("platform", "telegram").WithField("user_id", user.ID).WithError(err).Warn("Telegram error getting profile photo")
+		return ""
+	}
+	fileID := ""
+	if len(photos.Photos) > 0 && len(photos.Photos[0]) > 0 {
+		// the smallest size is enough for an avatar
+		fileID = photos.Photos[0][0].FileID
+	}
+	t.avatars[user.ID] = fileID
+	return fileID
+}
+
+// Health returns the health of the pumper. While reading, the state of its
+// connection is the state of the polling for updates of its bot, which is
+// shared by every pumper using the same token.
+func (t *Telegram) Health() cable.HealthStatus {
+	status := t.Pump.Health()
+	if t.connection == nil || status.State == cable.StateIdle {
+		return status
+	}
+	connection := t.connection.Health()
+	if connection.State != cable.StateIdle {
+		status.State, status.Since = connection.State, connection.Since
+	}
+	status.ReadErrors += connection.ReadErrors
+	if connection.LastError != "" && status.LastError == "" {
+		status.LastError = connection.LastError
+	}
+	return status
+}
+
+// Participants returns the names of the administrators of the relayed chat,
+// as bots cannot list the rest of members
+func (t *Telegram) Participants() ([]string, error) {
+	administrators, err := t.client.GetChatAdministrators(telegram.ChatConfig{ChatID: t.relayedChatID})
+	if err != nil {
+		return nil, err
+	}
+	var participants []string
+	for _, member := range administrators {
+		if member.User == nil || member.User.IsBot {
+			continue
+		}
+		if member.User.UserName != "" {
+			participants = append(participants, member.User.UserName)
+		} else {
+			participants = append(participants, member.User.FirstName)
+		}
+	}
+	return participants, nil
+}
+
+// Link returns the invite link of the relayed chat, which requires the bot
+// to be an administrator allowed to invite users
+func (t *Telegram) Link() (string, error) {
+	return t.client.GetInviteLink(telegram.ChatConfig{ChatID: t.relayedChatID})
+}
+
+// isAdmin tells whether the user with the given ID is an administrator of the
+// relayed chat
+func (t *Telegram) isAdmin(userID int) bool {
+	administrators, err := t.client.GetChatAdministrators(telegram.ChatConfig{ChatID: t.relayedChatID})
+	if err != nil {
+		log.WithField("platform", "telegram").WithError(err).Error("Telegram error getting chat administrators")
+		return false
+	}
+	for _, member := range administrators {
+		if member.User != nil && member.User.ID == userID {
+			return true
+		}
+	}
+	return false
 }
 
 // GoRead makes telegram listen for messages in a different goroutine.
-// Those messages will be pushed to the InboxCh of the Pump.
+// Those messages will be pushed to the InboxCh of the Pump once another
+// goroutine downloads and converts their media, except for commands, which are handed over
+// to the command handler of the Pump.
 //
 // The goroutine can be stopped by feeding ReadStopper synchronization channel
 // which can be done by calling StopRead() - a method coming from Pump and
 // which is accessed directly through the Telegram value.
 func (t *Telegram) GoRead() {
+	t.SetConnecting()
 	u := telegram.NewUpdate(0)
 	u.Timeout = readTimeoutSecs
 
-	updates, err := t.client.GetUpdatesChan(u)
+	updates, unsubscribe, err := subscribe(t.client, u)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	go func() {
+		defer unsubscribe()
+		done := make(chan struct{})
+		defer close(done)
+		downloads := make(chan cable.Message, downloadQueueSize)
+		go t.goDownload(downloads, done)
 		for {
+			var message cable.Message
 			select {
 			case ev := <-updates:
-				if ev.Message == nil {
+				if message = t.read(ev); message == nil {
 					continue
 				}
-				msg := ev.Message
-				if msg.Chat == nil || msg.Chat.ID != t.relayedChatID || msg.From.ID == t.botUserID {
-					continue
-				}
-				t.Inbox() <- &Message{ev}
+			case <-t.ReadStopper:
+				return
+			}
+			// everything read waits in the same queue, so it is relayed in
+			// the order it was read
+			select {
+			case downloads <- message:
 			case <-t.ReadStopper:
 				return
 			}
@@ -91,6 +332,90 @@ func (t *Telegram) GoRead() {
 	}()
 }
 
+// read returns the message to relay for an update, which is nil if there is
+// none, like when the update is a command, which is handed over to the
+// command handler instead
+func (t *Telegram) read(ev Update) cable.Message {
+	if ev.Poll != nil {
+		if edit := t.readPoll(*ev.Poll); edit != nil {
+			return edit
+		}
+		return nil
+	}
+	if ev.EditedMessage != nil {
+		if edit := t.readEdit(ev.Update); edit != nil {
+			return edit
+		}
+		return nil
+	}
+	msg := ev.Message
+	if msg == nil || msg.Chat == nil || msg.Chat.ID != t.relayedChatID || msg.From.ID == t.botUserID {
+		return nil
+	}
+	if event, ok := t.readEvent(msg); ok {
+		if event != nil {
+			return event
+		}
+		return nil
+	}
+	metrics.MessagesRead.Inc("telegram")
+	t.RecordRead()
+	span := tracing.StartTrace("relay").SetAttribute("platform", "telegram").SetAttribute("message_id", msg.MessageID)
+	message := &Message{Update: ev.Update, extras: ev.Extras, span: span}
+	if t.mediaURL != "" {
+		message.mediaURL, message.mediaKey = t.mediaURL, t.mediaKey
+		message.avatarID = t.avatar(msg.From)
+	}
+	if ev.Extras != nil && ev.Extras.Poll != nil {
+		t.polls.Add(ev.Extras.Poll.ID, message)
+	}
+	if provenance, ok := t.sent.Get(strconv.Itoa(msg.MessageID)); ok {
+		message.provenance = provenance
+	}
+	if command, ok := cable.ParseCommand(message); ok {
+		command.FromAdmin = t.isAdmin(msg.From.ID)
+		if t.HandleCommand(command) {
+			return nil
+		}
+	}
+	return message
+}
+
+// readEdit returns a message of the relayed chat edited as an edit of it,
+// unless the bot itself sent it, in which case it returns nil
+func (t *Telegram) readEdit(update telegram.Update) *Message {
+	msg := update.EditedMessage
+	if msg.Chat == nil || msg.Chat.ID != t.relayedChatID || msg.From == nil || msg.From.ID == t.botUserID {
+		return nil
+	}
+	metrics.MessagesRead.Inc("telegram")
+	t.RecordRead()
+	update.Message, update.EditedMessage = msg, nil
+	span := tracing.StartTrace("relay").SetAttribute("platform", "telegram").SetAttribute("message_id", msg.MessageID)
+	message := &Message{Update: update, span: span, edit: true}
+	if t.mediaURL != "" {
+		message.mediaURL, message.mediaKey = t.mediaURL, t.mediaKey
+		message.avatarID = t.avatar(msg.From)
+	}
+	return message
+}
+
+// readPoll returns the new state of a poll read as an edit of the message
+// it was sent in, if it was sent in the relayed chat, or nil otherwise
+func (t *Telegram) readPoll(poll Poll) *Message {
+	original, ok := t.polls.Get(poll.ID)
+	if !ok {
+		return nil
+	}
+	extras := *original.extras
+	extras.Poll = &poll
+	edit := *original
+	edit.extras = &extras
+	edit.edit = true
+	edit.span = tracing.StartTrace("relay").SetAttribute("platform", "telegram").SetAttribute("message_id", original.Message.MessageID)
+	return &edit
+}
+
 // GoWrite spawns a goroutine that takes care of delivering to telegram the
 // messages arriving at the OutboxCh of the Pump.
 //
@@ -102,14 +427,45 @@ func (t *Telegram) GoWrite() {
 		for {
 			select {
 			case m := <-t.Outbox():
+				m, ok := t.throttle.Next(m, t.Outbox(), t.WriteStopper)
+				if !ok {
+					return
+				}
+				deliver := cable.SpanOf(m)
+				deliver.ChildSince("outbox", deliver.StartTime()).End()
+				if event, ok := cable.EventOf(m); ok && !t.writeEvent(event) {
+					continue
+				}
+				convert := deliver.Child("telegram.convert")
 				msg, err := m.ToTelegram(t.relayedChatID)
+				convert.EndWithError(err)
 				if err != nil {
-					log.Errorln("Telegram error converting message to telegram representation: ", err)
+					deliver.EndWithError(err)
+					log.WithFields(cable.MessageFields(m)).WithField("platform", "telegram").WithError(err).Error("Telegram error converting message to telegram representation")
+					metrics.ConversionErrors.Inc("telegram")
+					t.RecordWriteError(err)
+					t.DeadLetters().Add(m, err)
+					continue
+				}
+				call := deliver.Child("telegram.sendMessage")
+				ids, err := t.send(m, msg)
+				call.EndWithError(err)
+				deliver.EndWithError(err)
+				if err == cable.ErrStopped {
+					t.DeadLetters().Add(m, err)
+					return
 				}
-				_, err = t.client.Send(msg)
 				if err != nil {
-					log.Errorln("Telegram error writing message: ", err)
+					log.WithFields(cable.MessageFields(m)).WithField("platform", "telegram").WithError(err).Error("Telegram error writing message")
+					metrics.MessagesFailed.Inc("telegram")
+					t.RecordWriteError(err)
+					t.DeadLetters().Add(m, err)
+					continue
 				}
+				t.parts.Add(cable.IDOf(m), ids...)
+				t.RecordWrite()
+				metrics.ObserveDeliveryLatency("telegram", m.SentAt())
+				log.WithFields(cable.MessageFields(m)).WithField("platform", "telegram").Debug("Message written")
 			case <-t.WriteStopper:
 				return
 			}
@@ -117,41 +473,481 @@ func (t *Telegram) GoWrite() {
 	}()
 }
 
+// send sends a message to the relayed chat, split in as many parts as needed
+// to fit the length telegram allows, or as a text file if more than maxParts,
+// followed by its snippets as documents, returning the IDs of the messages
+// sent. Edits edit the messages sent before instead, and deletions delete
+// them.
+func (t *Telegram) send(m cable.Message, msg telegram.MessageConfig) ([]string, error) {
+	if deletion, ok := cable.DeletionOf(m); ok {
+		return nil, t.delete(deletion)
+	}
+	if cable.IsEdit(m) {
+		return t.edit(m, msg)
+	}
+	provenance := cable.ProvenanceOf(m).Relayed()
+	parts := cable.SplitText(msg.Text, cable.TelegramTextLimit, msg.ParseMode)
+	snippets := cable.SnippetsOf(m)
+	// the text goes as the caption of the first snippet if it fits
+	captioned := len(snippets) > 0 && cable.TextLength(msg.Text) <= cable.TelegramCaptionLimit
+	var chattables []telegram.Chattable
+	switch {
+	case t.maxParts > 0 && len(parts) > t.maxParts:
+		file := telegram.NewDocumentUpload(t.relayedChatID, telegram.FileBytes{Name: cable.UploadFileName, Bytes: []byte(m.String())})
+		file.Caption = cable.TruncateText(msg.Text, cable.TelegramCaptionLimit, msg.ParseMode)
+		file.ParseMode = msg.ParseMode
+		file.ReplyToMessageID = msg.ReplyToMessageID
+		chattables = append(chattables, file)
+		captioned = false
+	case captioned:
+	default:
+		for i, part := range parts {
+			partMsg := msg
+			partMsg.Text = part
+			if i > 0 {
+				partMsg.ReplyToMessageID = 0
+			}
+			chattables = append(chattables, partMsg)
+		}
+	}
+	for i, snippet := range snippets {
+		document := telegram.NewDocumentUpload(t.relayedChatID, telegram.FileBytes{Name: snippet.Filename, Bytes: []byte(snippet.Content)})
+		if i == 0 && captioned {
+			document.Caption = msg.Text
+			document.ParseMode = msg.ParseMode
+			document.ReplyToMessageID = msg.ReplyToMessageID
+		}
+		chattables = append(chattables, document)
+	}
+
+	var ids, texts []string
+	for i, chattable := range chattables {
+		if i > 0 && !t.throttle.Wait(t.WriteStopper) {
+			return ids, cable.ErrStopped
+		}
+		var sent telegram.Message
+		err := t.throttle.Write(func() (err error) {
+			sent, err = t.client.Send(chattable)
+			return err
+		}, retryAfter, t.WriteStopper)
+		if err != nil {
+			return ids, err
+		}
+		id := strconv.Itoa(sent.MessageID)
+		t.sent.Add(id, provenance)
+		ids = append(ids, id)
+		if _, ok := chattable.(telegram.MessageConfig); ok {
+			texts = append(texts, id)
+		}
+	}
+	t.texts.Add(cable.IDOf(m), texts...)
+	return ids, nil
+}
+
+// edit edits the messages sent for the message m edits with its new text,
+// split again in as many parts as needed, returning the IDs of the messages
+// sent for it. Parts are sent when the text grows, and the ones left over
+// deleted when it shrinks, while the caption is edited instead if the text
+// was sent as one. Edits of messages not sent by this pumper, like the ones
+// sent before cable started, are dropped.
+func (t *Telegram) edit(m cable.Message, msg telegram.MessageConfig) ([]string, error) {
+	ids := t.parts.Get(cable.IDOf(m))
+	if len(ids) == 0 {
+		log.WithFields(cable.MessageFields(m)).WithField("platform", "telegram").Debug("Edit dropped, as the message edited was not sent")
+		return nil, nil
+	}
+	texts := t.texts.Get(cable.IDOf(m))
+	if len(texts) == 0 {
+		return ids, t.editCaption(ids[0], msg)
+	}
+	provenance := cable.ProvenanceOf(m).Relayed()
+	parts := cable.SplitText(msg.Text, cable.TelegramTextLimit, msg.ParseMode)
+
+	var edited []string
+	for i, part := range parts {
+		if i > 0 && !t.throttle.Wait(t.WriteStopper) {
+			return ids, cable.ErrStopped
+		}
+		if i >= len(texts) {
+			partMsg := msg
+			partMsg.Text = part
+			partMsg.ReplyToMessageID = 0
+			var sent telegram.Message
+			err := t.throttle.Write(func() (err error) {
+				sent, err = t.client.Send(partMsg)
+				return err
+			}, retryAfter, t.WriteStopper)
+			if err != nil {
+				return ids, err
+			}
+			id := strconv.Itoa(sent.MessageID)
+			t.sent.Add(id, provenance)
+			edited = append(edited, id)
+			continue
+		}
+		id, err := strconv.Atoi(texts[i])
+		if err != nil {
+			return ids, err
+		}
+		edit := telegram.NewEditMessageText(t.relayedChatID, id, part)
+		edit.ParseMode = msg.ParseMode
+		edit.DisableWebPagePreview = msg.DisableWebPagePreview
+		err = t.throttle.Write(func() error {
+			_, err := t.client.Send(edit)
+			return err
+		}, retryAfter, t.WriteStopper)
+		if err != nil && !notModified(err) {
+			return ids, err
+		}
+		edited = append(edited, texts[i])
+	}
+	if len(texts) > len(parts) {
+		left, err := t.remove(texts[len(parts):])
+		edited = append(edited, left...)
+		if err != nil {
+			return ids, err
+		}
+	}
+	t.texts.Add(cable.IDOf(m), edited...)
+	return append(edited, without(ids, texts)...), nil
+}
+
+// editCaption edits the caption of the document with the given ID, which
+// the text of a message was sent as, truncated to the length telegram allows
+func (t *Telegram) editCaption(documentID string, msg telegram.MessageConfig) error {
+	id, err := strconv.Atoi(documentID)
+	if err != nil {
+		return err
+	}
+	edit := telegram.NewEditMessageCaption(t.relayedChatID, id, cable.TruncateText(msg.Text, cable.TelegramCaptionLimit, msg.ParseMode))
+	edit.ParseMode = msg.ParseMode
+	err = t.throttle.Write(func() error {
+		_, err := t.client.Send(edit)
+		return err
+	}, retryAfter, t.WriteStopper)
+	if err != nil && !notModified(err) {
+		return err
+	}
+	return nil
+}
+
+// without returns the IDs not among the excluded ones
+func without(ids []string, excluded []string) []string {
+	kept := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !includes(excluded, id) {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// includes tells whether the IDs include id
+func includes(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// delete deletes the messages sent for the message a deletion deletes,
+// forgetting them. Deletions of messages not sent by this pumper are
+// dropped.
+func (t *Telegram) delete(deletion *cable.Deletion) error {
+	ids := t.parts.Get(deletion.ID())
+	if len(ids) == 0 {
+		log.WithFields(cable.MessageFields(deletion)).WithField("platform", "telegram").Debug("Deletion dropped, as the message deleted was not sent")
+		return nil
+	}
+	left, err := t.remove(ids)
+	if err != nil {
+		t.parts.Add(deletion.ID(), left...)
+		return err
+	}
+	t.parts.Remove(deletion.ID())
+	t.texts.Remove(deletion.ID())
+	return nil
+}
+
+// remove deletes the messages with the given IDs, returning the ones left,
+// which are all of them if the client cannot delete messages
+func (t *Telegram) remove(ids []string) ([]string, error) {
+	deleter, ok := t.client.(MessageDeleter)
+	if !ok {
+		return ids, nil
+	}
+	for i, id := range ids {
+		if i > 0 && !t.throttle.Wait(t.WriteStopper) {
+			return ids[i:], cable.ErrStopped
+		}
+		messageID, err := strconv.Atoi(id)
+		if err != nil {
+			return ids[i:], err
+		}
+		err = t.throttle.Write(func() error {
+			_, err := deleter.DeleteMessage(telegram.DeleteMessageConfig{ChatID: t.relayedChatID, MessageID: messageID})
+			return err
+		}, retryAfter, t.WriteStopper)
+		if err != nil {
+			return ids[i:], err
+		}
+	}
+	return nil, nil
+}
+
+// notModified tells whether telegram rejected an edit because it left the
+// message as it was, which is no failure
+func notModified(err error) bool {
+	return strings.Contains(err.Error(), "message is not modified")
+}
+
+// retryAfter tells whether telegram rejected a message because of rate
+// limits, and how long to wait before sending it again
+func retryAfter(err error) (time.Duration, bool) {
+	if e, ok := err.(telegram.Error); ok && e.RetryAfter > 0 {
+		return time.Duration(e.RetryAfter) * time.Second, true
+	}
+	return 0, false
+}
+
+// pollingHealthTransport is an http.RoundTripper keeping the health of the
+// read pump up to date with the result of polling for updates, as the
+// telegram client retries failed polls without letting us know
+type pollingHealthTransport struct {
+	// Base is the RoundTripper making the actual calls
+	Base http.RoundTripper
+	// Health is the tracker of the read pump
+	Health *cable.HealthTracker
+}
+
+// RoundTrip makes the call with the base RoundTripper, updating the health of
+// the read pump if the call polls for updates
+func (t *pollingHealthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if path.Base(req.URL.Path) != "getUpdates" {
+		return resp, err
+	}
+
+	switch {
+	case err != nil:
+		t.Health.RecordReadError(err)
+		t.Health.SetDisconnected(err)
+	case resp.StatusCode != http.StatusOK:
+		err := fmt.Errorf("getUpdates returned %s", resp.Status)
+		t.Health.RecordReadError(err)
+		t.Health.SetDisconnected(err)
+	default:
+		t.Health.SetConnected()
+	}
+	return resp, err
+}
+
 /* Telegram message */
 
 // Message wraps a telegram update and implements the Message Interface
 type Message struct {
 	telegram.Update
+	span       *tracing.Span
+	provenance cable.Provenance
+	// mediaURL is the public base URL the files shared in telegram are served
+	// at, or empty if they are not, and mediaKey the key their links are
+	// signed with
+	mediaURL string
+	mediaKey []byte
+	// avatarID is the ID of the file of the profile photo of the author, if
+	// known
+	avatarID string
+	// extras are the parts of the message the telegram client does not read,
+	// like polls and dice, if it has any
+	extras *MessageExtras
+	// edit tells whether the message is a new version of one already read,
+	// like a poll whose votes changed
+	edit bool
+	// audio is the recording shared in the message, if downloaded
+	audio *cable.Audio
+	// transcribing tells whether the recording is being transcribed, and
+	// transcript is its transcription once done
+	transcribing bool
+	transcript   string
+	// image is the image the sticker shared in the message is converted
+	// into, if converted
+	image *cable.Image
 }
 
 // ToSlack converts a received telegram message into a proper representation in
-// slack
+// slack, as a legacy attachment
 func (tm Message) ToSlack() ([]slack.MsgOption, error) {
-	var authorName []string
+	text, hasCode := slackText(tm.Content(), tm.Message.Entities)
+	attachment := slack.Attachment{
+		Fallback:   tm.Content(),
+		AuthorName: authorName(tm.Message.From),
+		Text:       text,
+		ImageURL:   tm.photoURL(),
+	}
+	if hasCode {
+		attachment.MarkdownIn = []string{"text"}
+	}
+
+	return []slack.MsgOption{slack.MsgOptionAttachments(attachment)}, nil
+}
 
-	if firstName := tm.Update.Message.From.FirstName; firstName != "" {
-		authorName = append(authorName, firstName)
+// ToSlackBlocks converts a received telegram message into a representation in
+// slack laid out with Block Kit: a context block with the author and their
+// profile photo, the message replied to quoted, the text in sections, and the
+// photo
+func (tm Message) ToSlackBlocks() ([]slack.MsgOption, error) {
+	author := authorName(tm.Message.From)
+	var context []cable.SlackBlockElement
+	if avatar := tm.mediaLink(tm.avatarID); avatar != "" {
+		context = append(context, cable.ImageElement(avatar, author))
 	}
+	context = append(context, cable.MarkdownElement("*"+escape(author)+"*"))
+	blocks := []cable.SlackBlock{cable.ContextBlock(context...)}
 
-	if lastName := tm.Update.Message.From.LastName; lastName != "" {
-		authorName = append(authorName, lastName)
+	if reply := tm.Message.ReplyToMessage; reply != nil {
+		replied := reply.Text
+		if replied == "" {
+			replied = reply.Caption
+		}
+		replied = cable.TruncateText(replied, quoteLength, "")
+		blocks = append(blocks, cable.SectionBlock(quote(fmt.Sprintf("*%s*: %s", escape(authorName(reply.From)), escape(replied)))))
 	}
 
-	if userName := tm.Update.Message.From.UserName; userName != "" {
-		if len(authorName) > 0 {
-			authorName = append(authorName, fmt.Sprintf("(%s)", userName))
+	text, _ := slackText(tm.Content(), tm.Message.Entities)
+	for _, chunk := range cable.ChunkText(text, cable.SlackSectionLimit, telegram.ModeMarkdown) {
+		if strings.TrimSpace(chunk) != "" {
+			blocks = append(blocks, cable.SectionBlock(chunk))
+		}
+	}
+	if photo := tm.photoURL(); photo != "" {
+		blocks = append(blocks, cable.ImageBlock(photo, "photo"))
+	}
+
+	fallback := fmt.Sprintf("%s: %s", author, tm.Content())
+	return []slack.MsgOption{slack.MsgOptionText(fallback, true), cable.MsgOptionBlocks(blocks...)}, nil
+}
+
+// authorName returns the name of a telegram user as shown in slack, like
+// "Jeffrey Townes (Jazz)"
+func authorName(user *telegram.User) string {
+	if user == nil {
+		return ""
+	}
+	var name []string
+
+	if firstName := user.FirstName; firstName != "" {
+		name = append(name, firstName)
+	}
+
+	if lastName := user.LastName; lastName != "" {
+		name = append(name, lastName)
+	}
+
+	if userName := user.UserName; userName != "" {
+		if len(name) > 0 {
+			name = append(name, fmt.Sprintf("(%s)", userName))
 		} else {
-			authorName = append(authorName, userName)
+			name = append(name, userName)
 		}
 	}
+	return strings.Join(name, " ")
+}
 
-	attachment := slack.Attachment{
-		Fallback:   tm.Message.Text,
-		AuthorName: strings.Join(authorName, " "),
-		Text:       tm.Message.Text,
+// quote quotes every line of a text in slack markdown
+func quote(text string) string {
+	return "> " + strings.Replace(text, "\n", "\n> ", -1)
+}
+
+// photoURL returns the URL the largest size of the photo of the message is
+// served at, which is empty if it has none or files are not served
+func (tm Message) photoURL() string {
+	if tm.Message.Photo == nil || len(*tm.Message.Photo) == 0 {
+		return ""
 	}
+	sizes := *tm.Message.Photo
+	return tm.mediaLink(sizes[len(sizes)-1].FileID)
+}
 
-	return []slack.MsgOption{slack.MsgOptionAttachments(attachment)}, nil
+// mediaLink returns the signed URL the file with the given ID is served at,
+// which is empty if files are not served
+func (tm Message) mediaLink(fileID string) string {
+	if tm.mediaURL == "" || fileID == "" {
+		return ""
+	}
+	return tm.mediaURL + MediaPath + fileID + "?" + signatureParam + "=" + sign(tm.mediaKey, fileID)
+}
+
+// slackText converts the text of a telegram message into slack markdown,
+// turning its code and pre entities into code spans and blocks, and its
+// custom emoji linked to their image into links, and tells whether it has
+// any code. Offsets of entities count UTF-16 code units.
+func slackText(text string, entities *[]telegram.MessageEntity) (string, bool) {
+	if entities == nil {
+		return text, false
+	}
+	units := utf16.Encode([]rune(text))
+	decode := func(from, to int) string {
+		return string(utf16.Decode(units[from:to]))
+	}
+
+	var converted strings.Builder
+	at, hasCode := 0, false
+	for _, entity := range *entities {
+		end := entity.Offset + entity.Length
+		link := entity.Type == "custom_emoji" && entity.URL != ""
+		if entity.Type != "code" && entity.Type != "pre" && !link || entity.Offset < at || end > len(units) {
+			continue
+		}
+		converted.WriteString(decode(at, entity.Offset))
+		covered := escape(decode(entity.Offset, end))
+		switch {
+		case link:
+			fmt.Fprintf(&converted, "<%s|%s>", entity.URL, covered)
+		case entity.Type == "pre":
+			fmt.Fprintf(&converted, "```\n%s\n```", covered)
+			hasCode = true
+		default:
+			fmt.Fprintf(&converted, "`%s`", covered)
+			hasCode = true
+		}
+		at = end
+	}
+	converted.WriteString(decode(at, len(units)))
+	return converted.String(), hasCode
+}
+
+// escape replaces the characters slack needs escaped in the text of
+// messages by their entities
+func escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// relocate returns the entities of a text which is replaced by another one,
+// at the offsets where the text they cover is found in the new text, in
+// order. Entities whose text is not found are dropped.
+func relocate(entities *[]telegram.MessageEntity, text string, replacement string) *[]telegram.MessageEntity {
+	if entities == nil || text == replacement {
+		return entities
+	}
+	units := utf16.Encode([]rune(text))
+	relocated := []telegram.MessageEntity{}
+	from := 0
+	for _, entity := range *entities {
+		end := entity.Offset + entity.Length
+		if entity.Offset < 0 || end > len(units) {
+			continue
+		}
+		index := strings.Index(replacement[from:], string(utf16.Decode(units[entity.Offset:end])))
+		if index < 0 {
+			continue
+		}
+		from += index
+		entity.Offset = cable.TextLength(replacement[:from])
+		relocated = append(relocated, entity)
+	}
+	return &relocated
 }
 
 // ToTelegram is a no-op that returns an error as we dont want to re-send
@@ -163,5 +959,111 @@ func (tm Message) ToTelegram(telegramChatID int64) (telegram.MessageConfig, erro
 // String returns a human readable representation of a telegram message for
 // debugging purposes
 func (tm Message) String() string {
-	return fmt.Sprintf("%s: %s", tm.Update.Message.From.UserName, tm.Message.Text)
+	return fmt.Sprintf("%s: %s", tm.Update.Message.From.UserName, tm.Content())
+}
+
+// Content returns the text of the telegram message, or the caption of its
+// media if it has no text, or a description of what it shares if it has
+// neither, like a location or a poll, followed by the transcription of its
+// voice message, if any
+func (tm Message) Content() string {
+	content := tm.Message.Text
+	if content == "" {
+		content = tm.Message.Caption
+	}
+	if content == "" {
+		content = tm.describe()
+	}
+	return strings.Join(nonEmpty(content, tm.transcription()), "\n")
+}
+
+// transcription returns the transcription of the voice message shared in
+// the telegram message, or tells it is being transcribed, which is empty if
+// it has none
+func (tm Message) transcription() string {
+	if tm.transcribing {
+		return "📝 Transcribing…"
+	}
+	return labeled("📝", tm.transcript)
+}
+
+// ID returns the ID of the telegram message in its chat
+func (tm Message) ID() string {
+	if tm.Update.Message == nil {
+		return ""
+	}
+	return strconv.Itoa(tm.Update.Message.MessageID)
+}
+
+// WithContent returns a copy of the telegram message with the given text
+func (tm Message) WithContent(content string) cable.Message {
+	msg := *tm.Message
+	if msg.Text == "" && msg.Caption != "" {
+		msg.Caption = content
+	} else {
+		msg.Text = content
+		msg.Entities = relocate(tm.Message.Entities, tm.Message.Text, content)
+	}
+	update := tm.Update
+	update.Message = &msg
+	// the transcription is part of the content given
+	return &Message{Update: update, span: tm.span, provenance: tm.provenance, mediaURL: tm.mediaURL, mediaKey: tm.mediaKey, avatarID: tm.avatarID, extras: tm.extras, edit: tm.edit, audio: tm.audio, image: tm.image}
+}
+
+// Images returns the image the sticker shared in the telegram message is
+// converted into, if converted
+func (tm Message) Images() []cable.Image {
+	if tm.image == nil {
+		return nil
+	}
+	return []cable.Image{*tm.image}
+}
+
+// Audio returns the recording shared in the telegram message, if downloaded
+func (tm Message) Audio() []cable.Audio {
+	if tm.audio == nil {
+		return nil
+	}
+	return []cable.Audio{*tm.audio}
+}
+
+// IsEdit tells whether the telegram message is a new version of one already
+// read, like a poll whose votes changed
+func (tm Message) IsEdit() bool {
+	return tm.edit
+}
+
+// Provenance returns the provenance of the telegram message, which is known
+// for the messages this cable sent
+func (tm Message) Provenance() cable.Provenance {
+	return tm.provenance
+}
+
+// Span returns the span carried by the telegram message
+func (tm Message) Span() *tracing.Span {
+	return tm.span
+}
+
+// WithSpan returns a copy of the telegram message carrying the given span
+func (tm Message) WithSpan(span *tracing.Span) cable.Message {
+	tm.span = span
+	return &tm
+}
+
+// Author returns the telegram user name of the author of the message
+func (tm Message) Author() string {
+	return tm.Message.From.UserName
+}
+
+// FromBot tells whether the telegram message was sent by a bot
+func (tm Message) FromBot() bool {
+	return tm.Message.From.IsBot
+}
+
+// SentAt returns the time the telegram message was sent
+func (tm Message) SentAt() time.Time {
+	if tm.Message.Date == 0 {
+		return time.Time{}
+	}
+	return tm.Message.Time()
 }
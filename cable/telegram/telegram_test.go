@@ -1,9 +1,15 @@
 package telegram
 
 import (
+	"errors"
+	"fmt"
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/miguelff/cable/cable"
+	slackAPI "github.com/nlopes/slack"
 	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -29,37 +35,88 @@ func TestTelegram_GoRead(t *testing.T) {
 	}
 
 	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
 
-	// wait for the pump to to process the channel up to 1 second, or timeout
-	timeout := time.NewTimer(1 * time.Second)
-
+	// wait for the pump to read the messages selected up to 1 second, or timeout
+	var inbox []cable.Message
+	timeout := time.After(time.Second)
 WAIT:
-	for {
+	for len(inbox) < 2 {
 		select {
-		case <-timeout.C:
+		case <-timeout:
 			Fail(t, "timeout while processing the Read Pump")
 			break WAIT
-		default:
-			if len(updatesCh) == 0 {
-				close(fakeTelegram.Inbox())
-				break WAIT
-			}
-			time.Sleep(10 * time.Millisecond)
+		case message := <-fakeTelegram.Inbox():
+			inbox = append(inbox, message)
 		}
 	}
-
-	fakeTelegram.StopRead()
-
-	var inbox []cable.Message
-	for message := range fakeTelegram.Inbox() {
-		inbox = append(inbox, message)
+	for len(updatesCh) > 0 {
+		time.Sleep(10 * time.Millisecond)
 	}
+	time.Sleep(10 * time.Millisecond)
+	Equal(t, 0, len(fakeTelegram.Inbox()), "the other messages are discarded")
 
 	Equal(t, 2, len(inbox))
 	Equal(t, "freshprince: Sup Jay!", inbox[0].String())
 	Equal(t, "freshprince: Uncle Phil, you here?", inbox[1].String())
 }
 
+func TestTelegram_GoRead_Media(t *testing.T) {
+	updatesCh := make(chan telegram.Update, 2)
+	updatesCh <- createTelegramUserUpdate(telegramChatID, "Sup Jay!")
+	updatesCh <- createTelegramUserUpdate(telegramChatID, "Uncle Phil, you here?")
+	client := &mediaTelegramAPI{
+		fakeTelegramAPI: &fakeTelegramAPI{updatesChannel: updatesCh},
+		avatars:         map[int]string{telegramUserID: "AVATAR"},
+	}
+
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	fakeTelegram.mediaKey = signingKey("TOKEN")
+	fakeTelegram.SetMediaURL("https://cable.example.com/")
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-fakeTelegram.Inbox():
+			Equal(t, "https://cable.example.com"+MediaPath+"AVATAR?sig="+sign(signingKey("TOKEN"), "AVATAR"), m.(*Message).mediaLink(m.(*Message).avatarID))
+		case <-time.After(time.Second):
+			Fail(t, "the message was not read")
+		}
+	}
+	Equal(t, 1, client.lookups, "profile photos are looked up once per user")
+}
+
+func TestTelegram_GoRead_Commands(t *testing.T) {
+	updatesCh := make(chan telegram.Update, 2)
+	fakeTelegram := &Telegram{
+		relayedChatID: telegramChatID,
+		botUserID:     telegramBotID,
+		client: &fakeTelegramAPI{
+			updatesChannel: updatesCh,
+			administrators: []telegram.ChatMember{{User: &telegram.User{ID: telegramUserID, UserName: "freshprince"}}},
+		},
+		Pump: cable.NewPump(),
+	}
+
+	commands := make(chan cable.Command, 1)
+	fakeTelegram.SetCommandHandler(func(c cable.Command) { commands <- c })
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	updatesCh <- createTelegramUserUpdate(telegramChatID, "/cable@cable_bot resume")
+	command := <-commands
+	Equal(t, "resume", command.Name)
+	True(t, command.FromAdmin)
+
+	participants, err := fakeTelegram.Participants()
+	Nil(t, err)
+	Equal(t, []string{"freshprince"}, participants)
+
+	link, _ := fakeTelegram.Link()
+	Equal(t, fmt.Sprintf("https://t.me/joinchat/%d", telegramChatID), link)
+}
+
 func TestTelegram_GoWrite(t *testing.T) {
 	client := &fakeTelegramAPI{}
 
@@ -94,9 +151,181 @@ WAIT:
 
 	fakeTelegram.StopWrite()
 
-	Equal(t, 2, len(client.sent))
-	Equal(t, "*Stranger:* Sup Jay!", client.sent[0].(telegram.MessageConfig).Text)
-	Equal(t, "*Stranger:* 👏  Psss!", client.sent[1].(telegram.MessageConfig).Text)
+	Equal(t, 2, len(client.Sent()))
+	Equal(t, "*Stranger:* Sup Jay!", client.Sent()[0].(telegram.MessageConfig).Text)
+	Equal(t, "*Stranger:* 👏  Psss!", client.Sent()[1].(telegram.MessageConfig).Text)
+}
+
+func TestTelegram_GoWrite_LongMessages(t *testing.T) {
+	client := &fakeTelegramAPI{}
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	fakeTelegram.GoWrite()
+	defer fakeTelegram.StopWrite()
+
+	long := strings.Repeat("All work and no play makes Jack a dull boy. ", 100)
+	m := createSlackMessage(long, "JACK")
+	m.Timestamp = "1546300800.000100"
+	fakeTelegram.Outbox() <- m
+	waitForSent(t, client, 2)
+	for i, sent := range client.Sent() {
+		msg := sent.(telegram.MessageConfig)
+		True(t, cable.TextLength(msg.Text) <= cable.TelegramTextLimit)
+		True(t, strings.HasPrefix(msg.Text, fmt.Sprintf("(%d/2) ", i+1)), msg.Text[:10])
+	}
+	Equal(t, []string{"0", "0"}, fakeTelegram.parts.Get("1546300800.000100"), "the parts are mapped to the message")
+
+	fakeTelegram.SetMaxParts(1)
+	fakeTelegram.Outbox() <- m
+	waitForSent(t, client, 3)
+	file := client.Sent()[2].(telegram.DocumentConfig)
+	Equal(t, telegram.FileBytes{Name: "message.txt", Bytes: []byte("Stranger: " + long)}, file.File)
+	True(t, cable.TextLength(file.Caption) <= cable.TelegramCaptionLimit)
+	True(t, strings.HasPrefix(file.Caption, "*Stranger:* All work"))
+}
+
+func TestTelegram_GoWrite_Snippets(t *testing.T) {
+	client := &fakeTelegramAPI{}
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	fakeTelegram.GoWrite()
+	defer fakeTelegram.StopWrite()
+
+	code := strings.Repeat("puts 'All work and no play makes Jack a dull boy'\n", 100)
+	fakeTelegram.Outbox() <- createSlackMessage("Look:\n```ruby\n"+code+"```", "JACK")
+	waitForSent(t, client, 1)
+	document := client.Sent()[0].(telegram.DocumentConfig)
+	Equal(t, telegram.FileBytes{Name: "snippet-1.rb", Bytes: []byte(strings.TrimSuffix(code, "\n"))}, document.File)
+	Equal(t, "*Stranger:* Look:\n📎 snippet-1.rb", document.Caption, "the text is the caption of the snippet")
+	Equal(t, telegram.ModeMarkdown, document.ParseMode)
+
+	long := strings.Repeat("All work and no play makes Jack a dull boy. ", 30)
+	fakeTelegram.Outbox() <- createSlackMessage(long+"\n```ruby\n"+code+"```", "JACK")
+	waitForSent(t, client, 3)
+	True(t, strings.HasPrefix(client.Sent()[1].(telegram.MessageConfig).Text, "*Stranger:* All work"), "texts too long for a caption are sent first")
+	document = client.Sent()[2].(telegram.DocumentConfig)
+	Equal(t, "snippet-1.rb", document.File.(telegram.FileBytes).Name)
+	Empty(t, document.Caption)
+}
+
+func TestTelegram_GoRead_Edits(t *testing.T) {
+	updatesCh := make(chan telegram.Update, 2)
+	fakeTelegram := &Telegram{
+		relayedChatID: telegramChatID,
+		botUserID:     telegramBotID,
+		client:        &fakeTelegramAPI{updatesChannel: updatesCh},
+		Pump:          cable.NewPump(),
+	}
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	own := createTelegramBotUpdate(telegramChatID, "Hey Hey!, edited")
+	own.EditedMessage, own.Message = own.Message, nil
+	edited := createTelegramUserUpdate(telegramChatID, "Sup Jay!, edited")
+	edited.Message.MessageID = 42
+	edited.EditedMessage, edited.Message = edited.Message, nil
+	updatesCh <- own
+	updatesCh <- edited
+
+	select {
+	case m := <-fakeTelegram.Inbox():
+		True(t, cable.IsEdit(m))
+		Equal(t, "42", cable.IDOf(m), "edits carry the ID of the message edited")
+		Equal(t, "freshprince: Sup Jay!, edited", m.String())
+	case <-time.After(time.Second):
+		Fail(t, "the edit was not read")
+	}
+	Equal(t, 0, len(fakeTelegram.Inbox()), "the edits of the bot are discarded")
+}
+
+func TestTelegram_GoWrite_Edits(t *testing.T) {
+	client := &editingTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{}}
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	True(t, fakeTelegram.CanEdit())
+	fakeTelegram.GoWrite()
+	defer fakeTelegram.StopWrite()
+
+	lunch := createSlackMessage("Lunch?", "WILL")
+	lunch.Timestamp = "1.000"
+	fakeTelegram.Outbox() <- lunch
+	fakeTelegram.Outbox() <- edit(createSlackMessage("Lunch? Closed", "WILL"), "1.000")
+	fakeTelegram.Outbox() <- edit(createSlackMessage("Unknown", "WILL"), "2.000")
+	done := createSlackMessage("Done", "WILL")
+	done.Timestamp = "3.000"
+	fakeTelegram.Outbox() <- done
+	deadline := time.Now().Add(time.Second)
+	for len(fakeTelegram.parts.Get("3.000")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	Equal(t, 2, client.sentCount, "edits are not sent")
+	Equal(t, map[int]string{1: "*Stranger:* Lunch? Closed"}, client.edits, "edits of messages not sent are dropped")
+	Equal(t, []string{"1"}, fakeTelegram.parts.Get("1.000"))
+}
+
+func TestTelegram_GoWrite_EditsOfLongMessages(t *testing.T) {
+	client := &deletingTelegramAPI{editingTelegramAPI: &editingTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{}}}
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	True(t, fakeTelegram.CanDelete())
+	False(t, NewTelegramWithAPI(client.editingTelegramAPI, telegramChatID, telegramBotID).CanDelete())
+	fakeTelegram.GoWrite()
+	defer fakeTelegram.StopWrite()
+
+	written := func(id string, parts ...string) {
+		deadline := time.Now().Add(time.Second)
+		for !reflect.DeepEqual(parts, fakeTelegram.parts.Get(id)) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		Equal(t, parts, fakeTelegram.parts.Get(id))
+	}
+	long := strings.Repeat("All work and no play makes Jack a dull boy.\n\n", 100)
+	jack := createSlackMessage(long, "JACK")
+	jack.Timestamp = "1.000"
+	fakeTelegram.Outbox() <- jack
+	written("1.000", "1", "2")
+
+	fakeTelegram.Outbox() <- edit(createSlackMessage("All work", "JACK"), "1.000")
+	written("1.000", "1")
+	client.mutex.Lock()
+	Equal(t, "*Stranger:* All work", client.edits[1])
+	Equal(t, []int{2}, client.deleted, "the parts left over are deleted")
+	client.mutex.Unlock()
+
+	fakeTelegram.Outbox() <- edit(createSlackMessage(long, "JACK"), "1.000")
+	written("1.000", "1", "3")
+	client.mutex.Lock()
+	True(t, strings.HasPrefix(client.edits[1], "(1/2) *Stranger:* All work"), client.edits[1])
+	Equal(t, 3, client.sentCount, "the parts missing are sent")
+	client.mutex.Unlock()
+
+	code := "```ruby\n" + strings.Repeat("puts 'All work and no play makes Jack a dull boy'\n", 100) + "```"
+	snippet := createSlackMessage("Look:\n"+code, "JACK")
+	snippet.Timestamp = "4.000"
+	fakeTelegram.Outbox() <- snippet
+	written("4.000", "4")
+	fakeTelegram.Outbox() <- edit(createSlackMessage("Look again:\n"+code, "JACK"), "4.000")
+	fakeTelegram.Outbox() <- cable.NewDeletion("slack", "", "1.000")
+	fakeTelegram.Outbox() <- cable.NewDeletion("slack", "", "4.000")
+	written("4.000")
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	Equal(t, map[int]string{4: "*Stranger:* Look again:\n📎 snippet-1.rb"}, client.captions, "texts sent as captions edit them")
+	Equal(t, []int{2, 1, 3, 4}, client.deleted, "every part is deleted")
+	Empty(t, fakeTelegram.parts.Get("1.000"))
+}
+
+// waitForSent waits up to a second for the client to send the given number of
+// messages, failing the test otherwise
+func waitForSent(t *testing.T, client *fakeTelegramAPI, n int) {
+	deadline := time.Now().Add(time.Second)
+	for len(client.Sent()) < n {
+		if time.Now().After(deadline) {
+			Fail(t, "the messages were not sent", "%d of %d", len(client.Sent()), n)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
 }
 
 func TestTelegramMessage_String(t *testing.T) {
@@ -119,9 +348,154 @@ func TestTelegramMessage_ToSlack(t *testing.T) {
 	Equal(t, expected, actual)
 }
 
+func TestTelegramMessage_ToSlack_Code(t *testing.T) {
+	msg := createTelegramMessage("🚀 Run go test if a<b\nfunc main() {}", "Jeffrey", "Townes", "Jazz")
+	msg.Message.Entities = &[]telegram.MessageEntity{
+		{Type: "bold", Offset: 0, Length: 2},
+		{Type: "code", Offset: 7, Length: 7},
+		{Type: "code", Offset: 18, Length: 3},
+		{Type: "pre", Offset: 22, Length: 14},
+	}
+	slackMessages, _ := msg.ToSlack()
+
+	expected := slackJSONMessage{
+		Fallback:   "🚀 Run go test if a<b\nfunc main() {}",
+		AuthorName: "Jeffrey Townes (Jazz)",
+		Text:       "🚀 Run `go test` if `a&lt;b`\n```\nfunc main() {}\n```",
+		MarkdownIn: []string{"text"},
+	}
+	Equal(t, expected, asSlackJSONMessage(slackMessages[0]))
+}
+
+func TestTelegramMessage_ToSlack_Photo(t *testing.T) {
+	msg := createTelegramMessage("", "Jeffrey", "Townes", "Jazz")
+	msg.Message.Caption = "Look at this"
+	msg.Message.Photo = &[]telegram.PhotoSize{{FileID: "SMALL"}, {FileID: "LARGE"}}
+	Equal(t, "Look at this", msg.Content(), "the caption is the content of media")
+
+	slackMessages, _ := msg.ToSlack()
+	_, values, _ := slackAPI.UnsafeApplyMsgOptions("", "", slackMessages...)
+	NotContains(t, values.Get("attachments"), "image_url", "photos are not linked unless served")
+
+	msg.mediaURL = "https://cable.example.com"
+	slackMessages, _ = msg.ToSlack()
+	_, values, _ = slackAPI.UnsafeApplyMsgOptions("", "", slackMessages...)
+	Contains(t, values.Get("attachments"), `"image_url":"https://cable.example.com/media/telegram/LARGE?sig=`+sign(nil, "LARGE")+`"`, "the links to the photos are signed")
+	Contains(t, values.Get("attachments"), `"text":"Look at this"`)
+
+	edited := msg.WithContent("Look at [redacted]")
+	Equal(t, "Look at [redacted]", edited.(*Message).Message.Caption)
+	Equal(t, "https://cable.example.com", edited.(*Message).mediaURL)
+}
+
+func TestTelegramMessage_ToSlackBlocks(t *testing.T) {
+	msg := createTelegramMessage("Sup Will! go test <3", "Jeffrey", "Townes", "Jazz")
+	msg.Message.Entities = &[]telegram.MessageEntity{{Type: "code", Offset: 10, Length: 7}}
+	msg.Message.ReplyToMessage = &telegram.Message{
+		From: &telegram.User{FirstName: "Will", UserName: "freshprince"},
+		Text: "Anyone?\nHello?",
+	}
+	slackMessages, err := msg.ToSlackBlocks()
+	NoError(t, err)
+	_, values, _ := slackAPI.UnsafeApplyMsgOptions("", "", slackMessages...)
+	Equal(t, "Jeffrey Townes (Jazz): Sup Will! go test &lt;3", values.Get("text"))
+	Equal(t, `[{"type":"context","elements":[{"type":"mrkdwn","text":"*Jeffrey Townes (Jazz)*"}]},`+
+		`{"type":"section","text":{"type":"mrkdwn","text":"\u003e *Will (freshprince)*: Anyone?\n\u003e Hello?"}},`+
+		`{"type":"section","text":{"type":"mrkdwn","text":"Sup Will! `+"`go test`"+` \u003c3"}}]`, values.Get("blocks"))
+
+	photo := createTelegramMessage("", "Jeffrey", "Townes", "Jazz")
+	photo.Message.Photo = &[]telegram.PhotoSize{{FileID: "SMALL"}, {FileID: "LARGE"}}
+	photo.mediaURL, photo.avatarID = "https://cable.example.com", "AVATAR"
+	slackMessages, err = photo.ToSlackBlocks()
+	NoError(t, err)
+	_, values, _ = slackAPI.UnsafeApplyMsgOptions("", "", slackMessages...)
+	Equal(t, `[{"type":"context","elements":[{"type":"image","image_url":"https://cable.example.com/media/telegram/AVATAR?sig=`+sign(nil, "AVATAR")+`","alt_text":"Jeffrey Townes (Jazz)"},{"type":"mrkdwn","text":"*Jeffrey Townes (Jazz)*"}]},`+
+		`{"type":"image","image_url":"https://cable.example.com/media/telegram/LARGE?sig=`+sign(nil, "LARGE")+`","alt_text":"photo"}]`, values.Get("blocks"))
+}
+
 func TestTelegramMessage_ToTelegram(t *testing.T) {
 	msg := createTelegramMessage("Sup will! :punch: :thumbs_up:", "Jeffrey", "Townes", "Jazz")
 	telegramChatID := int64(123)
 	_, e := msg.ToTelegram(telegramChatID)
 	Error(t, e)
 }
+
+func TestTelegramMessage_WithContent(t *testing.T) {
+	msg := createTelegramMessage("Sup will! pss", "Jeffrey", "Townes", "Jazz")
+
+	edited := msg.WithContent("Sup Will!")
+	Equal(t, "Jazz: Sup Will!", edited.String())
+	Equal(t, "Sup will! pss", msg.Content())
+
+	msg = createTelegramMessage("run make and make test", "Jeffrey", "Townes", "Jazz")
+	msg.Message.Entities = &[]telegram.MessageEntity{{Type: "code", Offset: 4, Length: 4}, {Type: "code", Offset: 13, Length: 9}}
+	edited = msg.WithContent("[🚀] run make and make test, please")
+	Equal(t, &[]telegram.MessageEntity{{Type: "code", Offset: 9, Length: 4}, {Type: "code", Offset: 18, Length: 9}}, edited.(*Message).Message.Entities, "entities follow their text")
+	edited = msg.WithContent("run make")
+	Equal(t, &[]telegram.MessageEntity{{Type: "code", Offset: 4, Length: 4}}, edited.(*Message).Message.Entities, "entities whose text is gone are dropped")
+}
+
+func TestTelegramMessage_AuthorAndFromBot(t *testing.T) {
+	msg := createTelegramMessage("Sup will! pss", "Jeffrey", "Townes", "Jazz")
+	Equal(t, "Jazz", msg.Author())
+	False(t, msg.FromBot())
+
+	msg.Message.From.IsBot = true
+	True(t, msg.FromBot())
+}
+
+func TestTelegramMessage_ID(t *testing.T) {
+	msg := createTelegramMessage("Sup will! pss", "Jeffrey", "Townes", "Jazz")
+	msg.Message.MessageID = 42
+	Equal(t, "42", msg.ID())
+}
+
+func TestPollingHealthTransport(t *testing.T) {
+	health := cable.NewHealthTracker()
+	responses := map[string]*http.Response{
+		"/botTOKEN/getUpdates": {StatusCode: http.StatusOK, Status: "200 OK"},
+	}
+	var failure error
+	transport := &pollingHealthTransport{
+		Base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return responses[req.URL.Path], failure
+		}),
+		Health: health,
+	}
+
+	get := func(method string) {
+		req, _ := http.NewRequest("GET", "https://api.telegram.org/botTOKEN/"+method, nil)
+		_, _ = transport.RoundTrip(req)
+	}
+
+	get("getUpdates")
+	Equal(t, cable.StateConnected, health.Health().State)
+
+	responses["/botTOKEN/getUpdates"] = &http.Response{StatusCode: http.StatusConflict, Status: "409 Conflict"}
+	get("getUpdates")
+	Equal(t, cable.StateDisconnected, health.Health().State)
+	Equal(t, "getUpdates returned 409 Conflict", health.Health().LastError)
+
+	failure = errors.New("connection refused")
+	get("sendMessage")
+	Equal(t, 1, health.Health().ReadErrors, "calls other than getUpdates do not affect the health of the read pump")
+}
+
+func TestGroupSendPace(t *testing.T) {
+	limiter := cable.NewRateLimiter(groupSendInterval, groupSendBurst)
+	// the messages are sent after waiting for the limiter, which is asked
+	// for them all at once
+	var sent []time.Duration
+	for i := 0; i < 100; i++ {
+		sent = append(sent, limiter.Reserve())
+	}
+	for i, start := range sent {
+		inWindow := 0
+		for _, at := range sent[i:] {
+			if at-start < time.Minute {
+				inWindow++
+			}
+		}
+		True(t, inWindow <= 20, "%d messages sent in the minute after %v", inWindow, start)
+	}
+}
@@ -0,0 +1,425 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// pollLogSize is the number of polls whose updates are relayed as edits
+	pollLogSize = 100
+	// updatesRetryInterval is the time to wait before polling for updates
+	// again when telegram fails to return them
+	updatesRetryInterval = 3 * time.Second
+)
+
+/* Section: the parts of telegram updates the telegram client does not read */
+
+// Update is a telegram update along with the parts of it the telegram client
+// does not read, like polls and dice
+type Update struct {
+	telegram.Update
+	// Poll is the new state of a poll, in the updates telling its votes
+	// changed or it was closed
+	Poll *Poll
+	// Extras are the parts of the message of the update the telegram client
+	// does not read, if it has any
+	Extras *MessageExtras
+}
+
+// MessageExtras are the parts of a telegram message the telegram client does
+// not read
+type MessageExtras struct {
+	// Poll is the poll sent in the message, if any
+	Poll *Poll `json:"poll,omitempty"`
+	// Dice is the dice thrown in the message, if any
+	Dice *Dice `json:"dice,omitempty"`
+	// Contact is the vCard of the contact shared in the message, if any
+	Contact *ContactCard `json:"contact,omitempty"`
+	// Sticker tells the format of the sticker shared in the message, if any
+	Sticker *StickerFormat `json:"sticker,omitempty"`
+	// Entities are the IDs of the custom emoji of the entities of the text,
+	// in the same order, if it has any
+	Entities []EntityExtras `json:"entities,omitempty"`
+}
+
+// EntityExtras are the parts of an entity of the text of a telegram message
+// the telegram client does not read
+type EntityExtras struct {
+	// CustomEmojiID is the ID of the custom emoji of custom_emoji entities
+	CustomEmojiID string `json:"custom_emoji_id,omitempty"`
+}
+
+// Poll is a telegram poll, and its votes
+type Poll struct {
+	ID                    string       `json:"id"`
+	Question              string       `json:"question"`
+	Options               []PollOption `json:"options"`
+	TotalVoterCount       int          `json:"total_voter_count"`
+	IsClosed              bool         `json:"is_closed"`
+	Type                  string       `json:"type,omitempty"`
+	AllowsMultipleAnswers bool         `json:"allows_multiple_answers,omitempty"`
+}
+
+// PollOption is an answer of a telegram poll, and the number of people who
+// chose it
+type PollOption struct {
+	Text       string `json:"text"`
+	VoterCount int    `json:"voter_count"`
+}
+
+// Dice is an animated emoji thrown with a random value, like a die
+type Dice struct {
+	Emoji string `json:"emoji"`
+	Value int    `json:"value"`
+}
+
+// ContactCard is the vCard of a contact shared in telegram
+type ContactCard struct {
+	VCard string `json:"vcard,omitempty"`
+}
+
+// StickerFormat tells whether a sticker shared in telegram is animated, in
+// the TGS format, or a WebM video, rather than a WebP image
+type StickerFormat struct {
+	IsAnimated bool `json:"is_animated,omitempty"`
+	IsVideo    bool `json:"is_video,omitempty"`
+}
+
+// UnmarshalJSON reads an update, and the parts of it the telegram client
+// does not read
+func (u *Update) UnmarshalJSON(data []byte) error {
+	*u = Update{}
+	if err := json.Unmarshal(data, &u.Update); err != nil {
+		return err
+	}
+	var extras struct {
+		Message *MessageExtras `json:"message"`
+		Poll    *Poll          `json:"poll"`
+	}
+	if err := json.Unmarshal(data, &extras); err != nil {
+		return err
+	}
+	u.Poll = extras.Poll
+	if m := extras.Message; m != nil && (m.Poll != nil || m.Dice != nil || (m.Contact != nil && m.Contact.VCard != "") || (m.Sticker != nil && *m.Sticker != StickerFormat{}) || hasCustomEmoji(m.Entities)) {
+		if !hasCustomEmoji(m.Entities) {
+			m.Entities = nil
+		}
+		u.Extras = m
+	}
+	return nil
+}
+
+// hasCustomEmoji tells whether any of the entities is a custom emoji
+func hasCustomEmoji(entities []EntityExtras) bool {
+	for _, entity := range entities {
+		if entity.CustomEmojiID != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// MarshalJSON writes an update like telegram does, with the parts of it the
+// telegram client does not read, so recorded updates can be replayed
+func (u Update) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(u.Update)
+	if err != nil {
+		return nil, err
+	}
+	extras, err := json.Marshal(struct {
+		Message *MessageExtras `json:"message,omitempty"`
+		Poll    *Poll          `json:"poll,omitempty"`
+	}{u.Extras, u.Poll})
+	if err != nil {
+		return nil, err
+	}
+	return mergeJSON(data, extras)
+}
+
+// mergeJSON adds the members of the extra JSON object to the base one,
+// merging the objects both have, and the elements of the arrays of the same
+// length both have. Any other extra value replaces the base one.
+func mergeJSON(base []byte, extra []byte) ([]byte, error) {
+	var baseElements, extraElements []json.RawMessage
+	if json.Unmarshal(base, &baseElements) == nil && json.Unmarshal(extra, &extraElements) == nil && len(baseElements) == len(extraElements) {
+		for i := range baseElements {
+			merged, err := mergeJSON(baseElements[i], extraElements[i])
+			if err != nil {
+				return nil, err
+			}
+			baseElements[i] = merged
+		}
+		return json.Marshal(baseElements)
+	}
+	var baseMembers, extraMembers map[string]json.RawMessage
+	if json.Unmarshal(base, &baseMembers) != nil || json.Unmarshal(extra, &extraMembers) != nil || baseMembers == nil {
+		return extra, nil
+	}
+	for name, value := range extraMembers {
+		if old, ok := baseMembers[name]; ok {
+			merged, err := mergeJSON(old, value)
+			if err != nil {
+				return nil, err
+			}
+			value = merged
+		}
+		baseMembers[name] = value
+	}
+	return json.Marshal(baseMembers)
+}
+
+// UpdatesReader is implemented by the clients that read updates along with
+// the parts of them the telegram client does not read
+type UpdatesReader interface {
+	// ReadUpdates polls for updates like GetUpdatesChan
+	ReadUpdates(config telegram.UpdateConfig) (<-chan Update, error)
+}
+
+// APIAdapter adapts a telegram.BotAPI to read the parts of updates it does
+// not read
+type APIAdapter struct {
+	*telegram.BotAPI
+}
+
+// ReadUpdates polls for updates with getUpdates, like GetUpdatesChan does,
+// retrying when telegram fails to return them
+func (adapter *APIAdapter) ReadUpdates(config telegram.UpdateConfig) (<-chan Update, error) {
+	ch := make(chan Update, adapter.Buffer)
+	go func() {
+		for {
+			updates, err := adapter.getUpdates(config)
+			if err != nil {
+				log.WithField("platform", "telegram").WithError(err).Warnf("Telegram error getting updates, retrying in %s", updatesRetryInterval)
+				time.Sleep(updatesRetryInterval)
+				continue
+			}
+			for _, update := range updates {
+				if update.UpdateID >= config.Offset {
+					config.Offset = update.UpdateID + 1
+					ch <- update
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// getUpdates returns the updates after the offset of the config, waiting for
+// them up to its timeout
+func (adapter *APIAdapter) getUpdates(config telegram.UpdateConfig) ([]Update, error) {
+	v := url.Values{}
+	if config.Offset != 0 {
+		v.Add("offset", strconv.Itoa(config.Offset))
+	}
+	if config.Limit > 0 {
+		v.Add("limit", strconv.Itoa(config.Limit))
+	}
+	if config.Timeout > 0 {
+		v.Add("timeout", strconv.Itoa(config.Timeout))
+	}
+	resp, err := adapter.MakeRequest("getUpdates", v)
+	if err != nil {
+		return nil, err
+	}
+	var updates []Update
+	err = json.Unmarshal(resp.Result, &updates)
+	return updates, err
+}
+
+// readUpdates returns the channel of updates of the client, with the parts of
+// them the telegram client does not read if the client can read them
+func readUpdates(client API, config telegram.UpdateConfig) (<-chan Update, error) {
+	if reader, ok := client.(UpdatesReader); ok {
+		return reader.ReadUpdates(config)
+	}
+	updates, err := client.GetUpdatesChan(config)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan Update)
+	go func() {
+		defer close(ch)
+		for update := range updates {
+			ch <- Update{Update: update}
+		}
+	}()
+	return ch, nil
+}
+
+/* Section: polls relayed */
+
+// pollLog remembers the messages polls were sent in, by the ID of the poll,
+// so the updates of their votes can edit the messages relayed
+type pollLog struct {
+	ids      []string
+	messages map[string]*Message
+	mutex    sync.Mutex
+}
+
+// newPollLog returns the address of a new, empty pollLog
+func newPollLog() *pollLog {
+	return &pollLog{messages: make(map[string]*Message)}
+}
+
+// Add remembers the message a poll was sent in, forgetting the oldest poll if
+// full
+func (l *pollLog) Add(pollID string, m *Message) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.messages[pollID]; !ok {
+		l.ids = append(l.ids, pollID)
+	}
+	l.messages[pollID] = m
+	if len(l.ids) > pollLogSize {
+		delete(l.messages, l.ids[0])
+		l.ids = l.ids[1:]
+	}
+}
+
+// Get returns the message the poll with the given ID was sent in, if known
+func (l *pollLog) Get(pollID string) (*Message, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	m, ok := l.messages[pollID]
+	return m, ok
+}
+
+/* Section: describing the messages sharing something other than text */
+
+// describe returns a description of what the telegram message shares other
+// than text, like a location or a poll, or an empty string if it shares
+// nothing else
+func (tm Message) describe() string {
+	msg := tm.Message
+	var extras MessageExtras
+	if tm.extras != nil {
+		extras = *tm.extras
+	}
+	switch {
+	case msg.Venue != nil:
+		return strings.Join(nonEmpty(labeled("📍", msg.Venue.Title), msg.Venue.Address, mapURL(msg.Venue.Location)), "\n")
+	case msg.Location != nil:
+		return "📍 " + mapURL(*msg.Location)
+	case msg.Contact != nil:
+		var card string
+		if extras.Contact != nil {
+			card = extras.Contact.VCard
+		}
+		return describeContact(*msg.Contact, card)
+	case extras.Poll != nil:
+		return describePoll(*extras.Poll)
+	case msg.Sticker != nil:
+		return strings.Join(nonEmpty(msg.Sticker.Emoji, "Sticker"), " ")
+	case msg.Voice != nil:
+		return fmt.Sprintf("🎤 Voice message (%s)", duration(msg.Voice.Duration))
+	case msg.Audio != nil:
+		title := strings.Join(nonEmpty(msg.Audio.Performer, msg.Audio.Title), " - ")
+		if title == "" {
+			title = "Audio"
+		}
+		return fmt.Sprintf("🎵 %s (%s)", title, duration(msg.Audio.Duration))
+	case extras.Dice != nil:
+		emoji := extras.Dice.Emoji
+		if emoji == "" {
+			emoji = "🎲"
+		}
+		return fmt.Sprintf("%s %d", emoji, extras.Dice.Value)
+	}
+	return ""
+}
+
+// mapURL returns the URL of a map showing the given location
+func mapURL(location telegram.Location) string {
+	return fmt.Sprintf("https://www.google.com/maps/search/?api=1&query=%s,%s",
+		strconv.FormatFloat(location.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(location.Longitude, 'f', -1, 64))
+}
+
+// describeContact returns the name and phone number of a contact, followed by
+// the organization, job title, email addresses and URLs of its vCard
+func describeContact(contact telegram.Contact, card string) string {
+	lines := nonEmpty(labeled("👤", strings.TrimSpace(contact.FirstName+" "+contact.LastName)), labeled("📞", contact.PhoneNumber))
+	for _, line := range strings.Split(strings.Replace(card, "\r\n", "\n", -1), "\n") {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		// properties can have parameters, like TYPE, and be grouped, like
+		// item1.EMAIL
+		property := strings.ToUpper(strings.SplitN(line[:colon], ";", 2)[0])
+		property = property[strings.LastIndex(property, ".")+1:]
+		value := strings.TrimSpace(line[colon+1:])
+		switch property {
+		case "ORG":
+			lines = append(lines, nonEmpty(labeled("🏢", strings.Trim(strings.Replace(unescapeVCard(value), ";", ", ", -1), ", ")))...)
+		case "TITLE":
+			lines = append(lines, nonEmpty(labeled("💼", unescapeVCard(value)))...)
+		case "EMAIL":
+			lines = append(lines, nonEmpty(labeled("✉️", unescapeVCard(value)))...)
+		case "URL":
+			lines = append(lines, nonEmpty(labeled("🔗", unescapeVCard(value)))...)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// unescapeVCard replaces the characters escaped in the values of vCards by
+// themselves
+func unescapeVCard(value string) string {
+	return strings.NewReplacer(`\,`, ",", `\n`, " ", `\N`, " ", `\\`, `\`).Replace(value)
+}
+
+// describePoll returns the question of a poll, followed by its options and
+// their votes
+func describePoll(poll Poll) string {
+	lines := []string{"📊 " + poll.Question}
+	for _, option := range poll.Options {
+		line := fmt.Sprintf("• %s: %s", option.Text, votes(option.VoterCount))
+		if poll.TotalVoterCount > 0 {
+			line += fmt.Sprintf(" (%d%%)", option.VoterCount*100/poll.TotalVoterCount)
+		}
+		lines = append(lines, line)
+	}
+	total := votes(poll.TotalVoterCount)
+	if poll.IsClosed {
+		total += ", closed"
+	}
+	return strings.Join(append(lines, total), "\n")
+}
+
+// votes returns the given number of votes in words
+func votes(n int) string {
+	if n == 1 {
+		return "1 vote"
+	}
+	return fmt.Sprintf("%d votes", n)
+}
+
+// nonEmpty returns the given lines, leaving out the empty ones
+func nonEmpty(lines ...string) []string {
+	var kept []string
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+// labeled returns the given value preceded by the emoji labeling it, or
+// nothing if the value is empty
+func labeled(emoji string, value string) string {
+	if value == "" {
+		return ""
+	}
+	return emoji + " " + value
+}
@@ -0,0 +1,116 @@
+package telegram
+
+import (
+	"encoding/json"
+	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUpdate_JSON(t *testing.T) {
+	data := []byte(`{"update_id":7,"message":{"message_id":3,"date":0,"chat":{"id":2,"type":"group"},"poll":{"id":"P1","question":"Lunch?","options":[{"text":"Pizza","voter_count":0}],"total_voter_count":0,"is_closed":false}}}`)
+
+	var update Update
+	NoError(t, json.Unmarshal(data, &update))
+	Equal(t, 7, update.UpdateID)
+	Equal(t, 3, update.Message.MessageID)
+	Equal(t, &MessageExtras{Poll: &Poll{ID: "P1", Question: "Lunch?", Options: []PollOption{{Text: "Pizza"}}}}, update.Extras)
+	Nil(t, update.Poll)
+
+	encoded, err := json.Marshal(update)
+	NoError(t, err)
+	var decoded Update
+	NoError(t, json.Unmarshal(encoded, &decoded))
+	Equal(t, update, decoded, "the parts the telegram client does not read are written back")
+
+	NoError(t, json.Unmarshal([]byte(`{"update_id":8,"poll":{"id":"P1","question":"Lunch?","options":[],"total_voter_count":2,"is_closed":true}}`), &update))
+	Nil(t, update.Message)
+	Nil(t, update.Extras, "updates are reset when reading another one")
+	Equal(t, &Poll{ID: "P1", Question: "Lunch?", Options: []PollOption{}, TotalVoterCount: 2, IsClosed: true}, update.Poll)
+
+	NoError(t, json.Unmarshal([]byte(`{"update_id":9,"message":{"message_id":4,"sticker":{"file_id":"S","is_animated":false,"is_video":true}}}`), &update))
+	Equal(t, "S", update.Message.Sticker.FileID)
+	Equal(t, &MessageExtras{Sticker: &StickerFormat{IsVideo: true}}, update.Extras)
+	NoError(t, json.Unmarshal([]byte(`{"update_id":10,"message":{"message_id":5,"sticker":{"file_id":"S","is_animated":false}}}`), &update))
+	Nil(t, update.Extras, "static stickers need no extras")
+
+	data = []byte(`{"update_id":11,"message":{"message_id":6,"text":"hi 🎉 https://x.y","entities":[{"type":"custom_emoji","offset":3,"length":2,"custom_emoji_id":"E1"},{"type":"url","offset":6,"length":9}]}}`)
+	NoError(t, json.Unmarshal(data, &update))
+	Equal(t, &[]telegramAPI.MessageEntity{{Type: "custom_emoji", Offset: 3, Length: 2}, {Type: "url", Offset: 6, Length: 9}}, update.Message.Entities)
+	Equal(t, &MessageExtras{Entities: []EntityExtras{{CustomEmojiID: "E1"}, {}}}, update.Extras)
+	encoded, err = json.Marshal(update)
+	NoError(t, err)
+	decoded = Update{}
+	NoError(t, json.Unmarshal(encoded, &decoded))
+	Equal(t, update, decoded, "the custom emoji of the entities are written back into them")
+	NoError(t, json.Unmarshal([]byte(`{"update_id":12,"message":{"message_id":7,"text":"https://x.y","entities":[{"type":"url","offset":0,"length":11}]}}`), &update))
+	Nil(t, update.Extras, "entities need no extras without custom emoji")
+}
+
+func TestMessage_Content_Descriptions(t *testing.T) {
+	message := func(update func(*telegramAPI.Message), extras *MessageExtras) Message {
+		m := createTelegramMessage("", "Will", "Smith", "freshprince")
+		update(m.Message)
+		m.extras = extras
+		return m
+	}
+	none := func(*telegramAPI.Message) {}
+
+	Equal(t, "📍 https://www.google.com/maps/search/?api=1&query=34.0522,-118.2437",
+		message(func(m *telegramAPI.Message) {
+			m.Location = &telegramAPI.Location{Latitude: 34.0522, Longitude: -118.2437}
+		}, nil).Content())
+	Equal(t, "📍 Banks' mansion\n251 N Bristol Ave\nhttps://www.google.com/maps/search/?api=1&query=34.07,-118.47",
+		message(func(m *telegramAPI.Message) {
+			m.Venue = &telegramAPI.Venue{Title: "Banks' mansion", Address: "251 N Bristol Ave", Location: telegramAPI.Location{Latitude: 34.07, Longitude: -118.47}}
+			m.Location = &m.Venue.Location
+		}, nil).Content())
+	Equal(t, "👤 Philip Banks\n📞 +1 415 555 0100\n🏢 Banks & Associates, Law\n💼 Judge\n✉️ phil@example.com\n🔗 https://example.com",
+		message(func(m *telegramAPI.Message) {
+			m.Contact = &telegramAPI.Contact{FirstName: "Philip", LastName: "Banks", PhoneNumber: "+1 415 555 0100"}
+		}, &MessageExtras{Contact: &ContactCard{VCard: "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Philip Banks\r\nORG:Banks & Associates;Law\r\nTITLE:Judge\r\nitem1.EMAIL;TYPE=INTERNET:phil@example.com\r\nURL:https://example.com\r\nEND:VCARD"}}).Content())
+	Equal(t, "👤 Geoffrey", message(func(m *telegramAPI.Message) { m.Contact = &telegramAPI.Contact{FirstName: "Geoffrey"} }, nil).Content())
+	Equal(t, "📊 Lunch?\n• Pizza: 3 votes (75%)\n• Tacos: 1 vote (25%)\n4 votes, closed",
+		message(none, &MessageExtras{Poll: &Poll{Question: "Lunch?", Options: []PollOption{{"Pizza", 3}, {"Tacos", 1}}, TotalVoterCount: 4, IsClosed: true}}).Content())
+	Equal(t, "📊 Lunch?\n• Pizza: 0 votes\n0 votes", message(none, &MessageExtras{Poll: &Poll{Question: "Lunch?", Options: []PollOption{{"Pizza", 0}}}}).Content())
+	Equal(t, "🎯 6", message(none, &MessageExtras{Dice: &Dice{Emoji: "🎯", Value: 6}}).Content())
+	Equal(t, "🎲 4", message(none, &MessageExtras{Dice: &Dice{Value: 4}}).Content())
+	Equal(t, "", message(none, nil).Content())
+
+	located := message(func(m *telegramAPI.Message) { m.Location = &telegramAPI.Location{Latitude: 1, Longitude: 2} }, nil)
+	located.Message.Caption = "Here"
+	Equal(t, "Here", located.Content(), "captions are relayed instead of descriptions")
+}
+
+func TestTelegram_GoRead_Polls(t *testing.T) {
+	poll := func(total int, closed bool) *Poll {
+		return &Poll{ID: "P1", Question: "Lunch?", Options: []PollOption{{"Pizza", total}}, TotalVoterCount: total, IsClosed: closed}
+	}
+	sent := createTelegramUserUpdate(telegramChatID, "")
+	sent.Message.MessageID = 3
+	updates := make(chan Update, 3)
+	updates <- Update{Poll: &Poll{ID: "UNKNOWN"}}
+	updates <- Update{Update: sent, Extras: &MessageExtras{Poll: poll(0, false)}}
+	updates <- Update{Poll: poll(2, true)}
+
+	fakeTelegram := NewTelegramWithAPI(&updatesTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{}, updates: updates}, telegramChatID, telegramBotID)
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	var inbox []cable.Message
+	for len(inbox) < 2 {
+		select {
+		case m := <-fakeTelegram.Inbox():
+			inbox = append(inbox, m)
+		case <-time.After(time.Second):
+			FailNow(t, "the poll was not read")
+		}
+	}
+	Equal(t, "📊 Lunch?\n• Pizza: 0 votes\n0 votes", inbox[0].Content())
+	False(t, cable.IsEdit(inbox[0]))
+	Equal(t, "📊 Lunch?\n• Pizza: 2 votes (100%)\n2 votes, closed", inbox[1].Content())
+	True(t, cable.IsEdit(inbox[1]), "the new votes of a poll edit the message relayed")
+	Equal(t, "3", cable.IDOf(inbox[1]))
+}
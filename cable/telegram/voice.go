@@ -0,0 +1,201 @@
+package telegram
+
+import (
+	"fmt"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/tracing"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxAudioSize is the size of the largest recordings downloaded, which
+	// is the largest file telegram lets bots download
+	maxAudioSize = 20 << 20
+	// transcriptionQueueSize is the number of voice messages waiting to be
+	// transcribed, above which they are relayed without transcription
+	transcriptionQueueSize = 10
+)
+
+/* Section: relaying voice messages and audio */
+
+// FileDownloader is implemented by the clients that can download the files
+// shared in telegram
+type FileDownloader interface {
+	// DownloadFile downloads the contents of the file with the given ID
+	DownloadFile(fileID string) ([]byte, error)
+}
+
+// DownloadFile downloads the contents of the file with the given ID, which
+// the telegram client cannot do
+func (adapter *APIAdapter) DownloadFile(fileID string) ([]byte, error) {
+	fileURL, err := adapter.GetFileDirectURL(fileID)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := adapter.Client.Get(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("telegram server error: %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxAudioSize))
+}
+
+// SetTranscriber makes the pumper transcribe the voice messages read with
+// the given transcriber, relaying the transcription as an edit of the
+// message once it finishes, or not to transcribe them if nil
+func (t *Telegram) SetTranscriber(transcriber cable.Transcriber) {
+	t.transcriber = transcriber
+}
+
+// goDownload downloads the recordings shared in the messages read, converts
+// their stickers and links their custom emoji, pushing everything read to
+// the inbox in the order it was read once done, so the updates keep being
+// read meanwhile. Voice messages are queued to be transcribed by another
+// goroutine then. Both stop once done is closed.
+func (t *Telegram) goDownload(read chan cable.Message, done chan struct{}) {
+	transcriptions := make(chan *Message, transcriptionQueueSize)
+	go t.goTranscribe(transcriptions, done)
+	for {
+		select {
+		case m := <-read:
+			message, ok := m.(*Message)
+			if ok && !message.edit {
+				t.readAudio(message)
+				t.readSticker(message)
+				t.readCustomEmoji(message)
+			}
+			select {
+			case t.Inbox() <- m:
+			case <-done:
+				return
+			}
+			if !ok || !message.transcribing {
+				continue
+			}
+			select {
+			case transcriptions <- message:
+			default:
+				log.WithFields(cable.MessageFields(message)).WithField("platform", "telegram").Warn("Voice message not transcribed, as too many are waiting")
+				t.relayTranscript(message, "", done)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// goTranscribe transcribes the voice messages queued one at a time, until
+// done is closed
+func (t *Telegram) goTranscribe(messages chan *Message, done chan struct{}) {
+	for {
+		select {
+		case message := <-messages:
+			t.transcribe(message, done)
+		case <-done:
+			return
+		}
+	}
+}
+
+// readAudio downloads the recording shared in the message, if any, so
+// platforms able to upload files can share it, and tells whether it is being
+// transcribed
+func (t *Telegram) readAudio(message *Message) {
+	fileID, audio, ok := audioOf(message.Message)
+	if !ok {
+		return
+	}
+	downloader, ok := t.client.(FileDownloader)
+	if !ok {
+		return
+	}
+	content, err := downloader.DownloadFile(fileID)
+	if err != nil {
+		log.WithFields(cable.MessageFields(message)).WithField("platform", "telegram").WithError(err).Warn("Telegram error downloading audio")
+		return
+	}
+	audio.Content = content
+	message.audio = &audio
+	// only the speech of voice messages is transcribed, not music
+	message.transcribing = t.transcriber != nil && message.Message.Voice != nil
+}
+
+// transcribe transcribes the voice message read, relaying the transcription
+// as an edit of it. The message is relayed without transcription if it
+// fails.
+func (t *Telegram) transcribe(original *Message, done chan struct{}) {
+	transcript, err := t.transcriber.Transcribe(*original.audio)
+	if err != nil {
+		log.WithFields(cable.MessageFields(original)).WithField("platform", "telegram").WithError(err).Warn("Cannot transcribe voice message")
+	}
+	t.relayTranscript(original, transcript, done)
+}
+
+// relayTranscript pushes an edit of the voice message read with the given
+// transcription to the inbox, unless done is closed meanwhile
+func (t *Telegram) relayTranscript(original *Message, transcript string, done chan struct{}) {
+	edit := *original
+	edit.transcribing = false
+	edit.transcript = transcript
+	edit.edit = true
+	edit.span = tracing.StartTrace("relay").SetAttribute("platform", "telegram").SetAttribute("message_id", original.Message.MessageID)
+	select {
+	case t.Inbox() <- &edit:
+	case <-done:
+	}
+}
+
+// audioOf returns the ID of the file of the voice message or audio shared in
+// the message, and what is known about it before downloading it
+func audioOf(msg *telegram.Message) (string, cable.Audio, bool) {
+	switch {
+	case msg.Voice != nil && msg.Voice.FileSize <= maxAudioSize:
+		return msg.Voice.FileID, cable.Audio{
+			Filename: "voice" + extension(msg.Voice.MimeType, ".ogg"),
+			MimeType: msg.Voice.MimeType,
+			Duration: time.Duration(msg.Voice.Duration) * time.Second,
+		}, true
+	case msg.Audio != nil && msg.Audio.FileSize <= maxAudioSize:
+		name := strings.Join(nonEmpty(msg.Audio.Performer, msg.Audio.Title), " - ")
+		if name == "" {
+			name = "audio"
+		}
+		return msg.Audio.FileID, cable.Audio{
+			Filename: strings.Replace(name, "/", "-", -1) + extension(msg.Audio.MimeType, ".mp3"),
+			MimeType: msg.Audio.MimeType,
+			Duration: time.Duration(msg.Audio.Duration) * time.Second,
+		}, true
+	}
+	return "", cable.Audio{}, false
+}
+
+// extension returns the extension of the files of the given media type, or
+// the fallback if unknown
+func extension(mimeType string, fallback string) string {
+	switch mimeType {
+	case "audio/ogg":
+		return ".ogg"
+	case "audio/mpeg":
+		return ".mp3"
+	}
+	if extensions, err := mime.ExtensionsByType(mimeType); err == nil && len(extensions) > 0 {
+		return extensions[0]
+	}
+	return fallback
+}
+
+// duration returns the given number of seconds like a clock does, as in
+// 1:05
+func duration(seconds int) string {
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
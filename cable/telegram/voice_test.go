@@ -0,0 +1,127 @@
+package telegram
+
+import (
+	"errors"
+	telegramAPI "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// transcriberFunc is a fake transcriber transcribing with a function
+type transcriberFunc func(audio cable.Audio) (string, error)
+
+func (f transcriberFunc) Transcribe(audio cable.Audio) (string, error) {
+	return f(audio)
+}
+
+// voice creates an update of a voice message with the given ID, recorded in
+// the file with the given ID
+func voice(id int, fileID string) telegramAPI.Update {
+	update := createTelegramUserUpdate(telegramChatID, "")
+	update.Message.MessageID = id
+	update.Message.Voice = &telegramAPI.Voice{FileID: fileID, Duration: 65, MimeType: "audio/ogg"}
+	return update
+}
+
+func TestTelegram_GoRead_Voice(t *testing.T) {
+	updates := make(chan telegramAPI.Update, 2)
+	updates <- voice(1, "VOICE")
+	updates <- voice(2, "MISSING")
+	client := &downloadingTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{updatesChannel: updates}, files: map[string]string{"VOICE": "Sup Jay!"}}
+
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	fakeTelegram.SetTranscriber(transcriberFunc(func(audio cable.Audio) (string, error) {
+		return string(audio.Content), nil
+	}))
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	inbox := make(map[string][]cable.Message)
+	for len(inbox["1"]) < 2 || len(inbox["2"]) < 1 {
+		select {
+		case m := <-fakeTelegram.Inbox():
+			inbox[cable.IDOf(m)] = append(inbox[cable.IDOf(m)], m)
+		case <-time.After(time.Second):
+			FailNow(t, "the voice messages were not read")
+		}
+	}
+
+	read, transcribed := inbox["1"][0], inbox["1"][1]
+	Equal(t, "🎤 Voice message (1:05)\n📝 Transcribing…", read.Content())
+	Equal(t, []cable.Audio{{Filename: "voice.ogg", MimeType: "audio/ogg", Duration: 65 * time.Second, Content: []byte("Sup Jay!")}}, cable.AudioOf(read))
+	False(t, cable.IsEdit(read))
+	Equal(t, "🎤 Voice message (1:05)\n📝 Sup Jay!", transcribed.Content())
+	True(t, cable.IsEdit(transcribed), "transcriptions edit the message relayed")
+	Equal(t, cable.AudioOf(read), cable.AudioOf(transcribed))
+	Equal(t, "🎤 Voice message (1:05)\n📝 Sup Jay!", transcribed.WithContent(transcribed.Content()).Content(), "transcriptions are part of the content")
+
+	Equal(t, "🎤 Voice message (1:05)", inbox["2"][0].Content(), "recordings that cannot be downloaded are not transcribed")
+	Nil(t, cable.AudioOf(inbox["2"][0]))
+}
+
+func TestTelegram_GoRead_TranscriptionQueue(t *testing.T) {
+	n := transcriptionQueueSize + 2
+	updates := make(chan telegramAPI.Update, n)
+	for i := 1; i <= n; i++ {
+		updates <- voice(i, "VOICE")
+	}
+	client := &downloadingTelegramAPI{fakeTelegramAPI: &fakeTelegramAPI{updatesChannel: updates}, files: map[string]string{"VOICE": "Sup Jay!"}}
+
+	fakeTelegram := NewTelegramWithAPI(client, telegramChatID, telegramBotID)
+	release := make(chan struct{})
+	defer close(release)
+	fakeTelegram.SetTranscriber(transcriberFunc(func(audio cable.Audio) (string, error) {
+		<-release
+		return string(audio.Content), nil
+	}))
+	fakeTelegram.GoRead()
+	defer fakeTelegram.StopRead()
+
+	read, untranscribed := 0, 0
+	for read < n || untranscribed == 0 {
+		select {
+		case m := <-fakeTelegram.Inbox():
+			if !cable.IsEdit(m) {
+				read++
+				continue
+			}
+			Equal(t, "🎤 Voice message (1:05)", m.Content(), "voice messages over the queue are relayed without transcription")
+			untranscribed++
+		case <-time.After(time.Second):
+			FailNow(t, "the voice messages were not read while transcribing", "%d of %d read", read, n)
+		}
+	}
+}
+
+func TestTelegram_Transcribe_Error(t *testing.T) {
+	fakeTelegram := NewTelegramWithAPI(&fakeTelegramAPI{}, telegramChatID, telegramBotID)
+	fakeTelegram.SetTranscriber(transcriberFunc(func(audio cable.Audio) (string, error) {
+		return "", errors.New("model not found")
+	}))
+	message := createTelegramMessage("", "Will", "Smith", "freshprince")
+	message.Message.Voice = &telegramAPI.Voice{Duration: 5}
+	message.audio = &cable.Audio{Filename: "voice.ogg"}
+	message.transcribing = true
+
+	fakeTelegram.transcribe(&message, make(chan struct{}))
+	edit := <-fakeTelegram.Inbox()
+	Equal(t, "🎤 Voice message (0:05)", edit.Content(), "messages failing to be transcribed are relayed without transcription")
+	True(t, cable.IsEdit(edit))
+}
+
+func TestAudioOf(t *testing.T) {
+	msg := &telegramAPI.Message{Audio: &telegramAPI.Audio{FileID: "SONG", Duration: 200, Performer: "DJ Jazzy Jeff", Title: "Summertime/Remix", MimeType: "audio/mpeg"}}
+	fileID, audio, ok := audioOf(msg)
+	True(t, ok)
+	Equal(t, "SONG", fileID)
+	Equal(t, cable.Audio{Filename: "DJ Jazzy Jeff - Summertime-Remix.mp3", MimeType: "audio/mpeg", Duration: 200 * time.Second}, audio)
+	Equal(t, "🎵 DJ Jazzy Jeff - Summertime/Remix (3:20)", Message{Update: telegramAPI.Update{Message: msg}}.Content())
+
+	msg.Audio.FileSize = maxAudioSize + 1
+	_, _, ok = audioOf(msg)
+	False(t, ok, "files too large for bots are not downloaded")
+	_, _, ok = audioOf(&telegramAPI.Message{})
+	False(t, ok)
+}
@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var exportedSpan = SpanData{
+	TraceID:      "0af7651916cd43dd8448eb211c80319c",
+	SpanID:       "b7ad6b7169203331",
+	ParentSpanID: "00f067aa0ba902b7",
+	Name:         "slack.chat.postMessage",
+	Start:        time.Unix(1561975000, 0),
+	End:          time.Unix(1561975001, 0),
+	Attributes:   map[string]interface{}{"endpoint": "slack", "messages": 2},
+	Error:        "channel_not_found",
+}
+
+func TestWriterExporter(t *testing.T) {
+	var out bytes.Buffer
+	NoError(t, NewWriterExporter(&out).Export([]SpanData{exportedSpan, exportedSpan}))
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	Len(t, lines, 2)
+	var span map[string]interface{}
+	NoError(t, json.Unmarshal(lines[0], &span))
+	Equal(t, "slack.chat.postMessage", span["name"])
+	Equal(t, "b7ad6b7169203331", span["span_id"])
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var path, authorization string
+	var request map[string]interface{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		authorization = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&request)
+	}))
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(collector.URL+"/", map[string]string{"Authorization": "Bearer secret"}, "cable")
+	NoError(t, exporter.Export([]SpanData{exportedSpan}))
+	Equal(t, "/v1/traces", path)
+	Equal(t, "Bearer secret", authorization)
+
+	resourceSpans := request["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	resource := resourceSpans["resource"].(map[string]interface{})
+	Equal(t, []interface{}{map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "cable"}}}, resource["attributes"])
+
+	span := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+	Equal(t, "0af7651916cd43dd8448eb211c80319c", span["traceId"])
+	Equal(t, "b7ad6b7169203331", span["spanId"])
+	Equal(t, "00f067aa0ba902b7", span["parentSpanId"])
+	Equal(t, "1561975000000000000", span["startTimeUnixNano"])
+	Equal(t, "1561975001000000000", span["endTimeUnixNano"])
+	Equal(t, map[string]interface{}{"code": float64(2), "message": "channel_not_found"}, span["status"])
+	Equal(t, []interface{}{
+		map[string]interface{}{"key": "endpoint", "value": map[string]interface{}{"stringValue": "slack"}},
+		map[string]interface{}{"key": "messages", "value": map[string]interface{}{"intValue": "2"}},
+	}, span["attributes"])
+}
+
+func TestOTLPExporter_CollectorError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer collector.Close()
+
+	err := NewOTLPExporter(collector.URL, nil, "cable").Export([]SpanData{exportedSpan})
+	EqualError(t, err, "collector responded 400 Bad Request")
+}
+
+func TestParseHeaders(t *testing.T) {
+	headers, err := ParseHeaders("api-key=secret, x-team = ops,")
+	NoError(t, err)
+	Equal(t, map[string]string{"api-key": "secret", "x-team": "ops"}, headers)
+
+	headers, err = ParseHeaders("")
+	NoError(t, err)
+	Empty(t, headers)
+
+	_, err = ParseHeaders("api-key")
+	Error(t, err)
+}
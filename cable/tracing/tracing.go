@@ -0,0 +1,283 @@
+// Package tracing implements the subset of OpenTelemetry tracing cable needs
+// to follow messages along the relay path: spans grouped in traces, batched
+// and exported to an OTLP collector or written to the standard output.
+//
+// Tracing is disabled until an exporter is set with Start. Meanwhile, spans
+// are nil, and every method of Span is a no-op on a nil span, so callers do
+// not need to check whether tracing is enabled.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// batchSize is the maximum number of spans exported at once
+	batchSize = 512
+	// queueSize is the number of ended spans waiting to be exported, beyond
+	// which spans are dropped
+	queueSize = 2048
+	// exportInterval is the time between exports of incomplete batches
+	exportInterval = 5 * time.Second
+)
+
+// SpanData is the immutable representation of an ended span, as exported
+type SpanData struct {
+	TraceID      string                 `json:"trace_id"`
+	SpanID       string                 `json:"span_id"`
+	ParentSpanID string                 `json:"parent_span_id,omitempty"`
+	Name         string                 `json:"name"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	// Error is the description of the error the operation ended with, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Exporter sends batches of ended spans to their destination
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+/* Section: Span */
+
+// Span is an operation within a trace, like writing a message to slack
+type Span struct {
+	mutex sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// StartTrace starts the root span of a new trace, or returns nil if tracing
+// is disabled
+func StartTrace(name string) *Span {
+	if !Enabled() {
+		return nil
+	}
+	return newSpan(newID(16), "", name, time.Now())
+}
+
+// Child starts a span within the trace of s, as a child of it
+func (s *Span) Child(name string) *Span {
+	return s.ChildSince(name, time.Now())
+}
+
+// ChildSince starts a span within the trace of s, as a child of it, that
+// started at the given time, which is useful when the beginning of an
+// operation is only known after the fact, like the time spent in a queue
+func (s *Span) ChildSince(name string, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	return newSpan(s.data.TraceID, s.data.SpanID, name, start)
+}
+
+// StartTime returns the time s started, or the zero time if s is nil
+func (s *Span) StartTime() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return s.data.Start
+}
+
+// SetAttribute sets an attribute describing the operation, returning s for
+// chaining. Values should be strings, integers, floats or booleans.
+func (s *Span) SetAttribute(key string, value interface{}) *Span {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Attributes[key] = value
+	return s
+}
+
+// SetError records the operation failed because of err, if not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data.Error = err.Error()
+}
+
+// EndWithError records the operation failed because of err, if not nil, and
+// ends it
+func (s *Span) EndWithError(err error) {
+	s.SetError(err)
+	s.End()
+}
+
+// End ends the operation and queues s to be exported. Spans are only exported
+// the first time they end.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.mutex.Unlock()
+
+	processorMutex.RLock()
+	defer processorMutex.RUnlock()
+	if current != nil {
+		current.enqueue(data)
+	}
+}
+
+// newSpan returns the address of a new span
+func newSpan(traceID string, parentSpanID string, name string, start time.Time) *Span {
+	return &Span{data: SpanData{
+		TraceID:      traceID,
+		SpanID:       newID(8),
+		ParentSpanID: parentSpanID,
+		Name:         name,
+		Start:        start,
+		Attributes:   make(map[string]interface{}),
+	}}
+}
+
+// newID returns a random identifier of the given number of bytes, encoded in
+// hexadecimal as OTLP expects
+func newID(bytes int) string {
+	id := make([]byte, bytes)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+/* Section: processor */
+
+var (
+	processorMutex sync.RWMutex
+	current        *processor
+)
+
+// Start enables tracing, exporting spans in batches through the exporter
+func Start(exporter Exporter) {
+	processorMutex.Lock()
+	defer processorMutex.Unlock()
+	if current != nil {
+		current.stop()
+	}
+	current = newProcessor(exporter)
+}
+
+// Stop disables tracing, exporting the spans that already ended
+func Stop() {
+	processorMutex.Lock()
+	defer processorMutex.Unlock()
+	if current != nil {
+		current.stop()
+		current = nil
+	}
+}
+
+// Flush exports the spans that already ended right away
+func Flush() {
+	processorMutex.RLock()
+	defer processorMutex.RUnlock()
+	if current != nil {
+		current.flush()
+	}
+}
+
+// Enabled tells whether tracing is enabled
+func Enabled() bool {
+	processorMutex.RLock()
+	defer processorMutex.RUnlock()
+	return current != nil
+}
+
+// processor batches the spans ended and exports them periodically
+type processor struct {
+	exporter Exporter
+	spans    chan SpanData
+	flushes  chan chan struct{}
+	done     chan struct{}
+}
+
+// newProcessor returns the address of a new processor, exporting spans in a
+// different goroutine until stopped
+func newProcessor(exporter Exporter) *processor {
+	p := &processor{
+		exporter: exporter,
+		spans:    make(chan SpanData, queueSize),
+		flushes:  make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// enqueue queues a span to be exported, dropping it if the queue is full, as
+// tracing must never slow down relaying messages
+func (p *processor) enqueue(span SpanData) {
+	select {
+	case p.spans <- span:
+	default:
+	}
+}
+
+// flush exports the queued spans, and waits until they are exported
+func (p *processor) flush() {
+	flushed := make(chan struct{})
+	p.flushes <- flushed
+	<-flushed
+}
+
+// stop exports the queued spans, and stops exporting
+func (p *processor) stop() {
+	p.flush()
+	close(p.done)
+}
+
+// run exports the queued spans every exportInterval, or as soon as a batch is
+// complete
+func (p *processor) run() {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	export := func() {
+		if len(batch) > 0 {
+			if err := p.exporter.Export(batch); err != nil {
+				log.WithError(err).WithField("spans", len(batch)).Warn("Cannot export spans")
+			}
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case span := <-p.spans:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-p.flushes:
+			for len(p.spans) > 0 {
+				batch = append(batch, <-p.spans)
+			}
+			export()
+			close(flushed)
+		case <-p.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingExporter struct {
+	mutex sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(spans []SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestSpan_Disabled(t *testing.T) {
+	False(t, Enabled())
+	span := StartTrace("relay")
+	Nil(t, span)
+
+	// every method is a no-op on nil spans
+	child := span.Child("convert").SetAttribute("platform", "slack")
+	Nil(t, child)
+	child.EndWithError(errors.New("boom"))
+	True(t, span.StartTime().IsZero())
+}
+
+func TestSpan_Trace(t *testing.T) {
+	exporter := &recordingExporter{}
+	Start(exporter)
+	defer Stop()
+
+	root := StartTrace("relay").SetAttribute("platform", "slack")
+	queued := root.ChildSince("outbox", root.StartTime().Add(-time.Second))
+	queued.End()
+	call := root.Child("slack.chat.postMessage")
+	call.EndWithError(errors.New("channel_not_found"))
+	root.End()
+	root.End()
+	Flush()
+
+	exporter.mutex.Lock()
+	defer exporter.mutex.Unlock()
+	if !Len(t, exporter.spans, 3) {
+		return
+	}
+	outbox, postMessage, relay := exporter.spans[0], exporter.spans[1], exporter.spans[2]
+
+	Equal(t, "relay", relay.Name)
+	Len(t, relay.TraceID, 32)
+	Len(t, relay.SpanID, 16)
+	Empty(t, relay.ParentSpanID)
+	Equal(t, "slack", relay.Attributes["platform"])
+
+	for _, child := range []SpanData{outbox, postMessage} {
+		Equal(t, relay.TraceID, child.TraceID)
+		Equal(t, relay.SpanID, child.ParentSpanID)
+		NotEqual(t, relay.SpanID, child.SpanID)
+	}
+	Equal(t, "outbox", outbox.Name)
+	Equal(t, relay.Start.Add(-time.Second), outbox.Start)
+	Equal(t, "channel_not_found", postMessage.Error)
+	False(t, relay.End.Before(relay.Start))
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/admin"
+	"github.com/miguelff/cable/cable/metrics"
+	"github.com/miguelff/cable/cable/recording"
+	"github.com/miguelff/cable/cable/replay"
+	s "github.com/miguelff/cable/cable/slack"
+	t "github.com/miguelff/cable/cable/telegram"
+	"github.com/miguelff/cable/cable/tracing"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+/* Section: command line interface */
+
+// command is a subcommand of the cable CLI
+type command struct {
+	// name is the name the command is run with, as in "cable <name>"
+	name string
+	// usage describes the arguments of the command
+	usage string
+	// summary is a one line description of the command
+	summary string
+	// run runs the command with the given arguments, writing its results to
+	// out and its errors and usage to errOut
+	run func(args []string, out io.Writer, errOut io.Writer) error
+}
+
+// commands are the subcommands of the cable CLI. The first one is run when
+// no command is given.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "", "relay messages through the bridges (the default)", serveCommand},
+		{"validate-config", "", "check the configuration and the stored bridges, without connecting", validateConfigCommand},
+		{"discover", "[-slack-token token] [-telegram-token token]", "list the slack channels and telegram chats the bots can relay, and their IDs", discoverCommand},
+		{"send", "[-bridge name] [-from endpoint] [-url url] [-token token] <text>", "relay a test message through a bridge of a running cable", sendCommand},
+		{"dlq", "list|replay [-bridge name] [-endpoint name] [-url url] [-token token]", "list or replay the messages a running cable could not write", dlqCommand},
+		{"replay", "<recording.jsonl> [bridge]", "feed a recording through a bridge, printing the API calls made", replayCommand},
+		{"help", "", "show this help", helpCommand},
+	}
+}
+
+// errUsage is returned when a command is run with wrong arguments, once its
+// usage is written
+var errUsage = errors.New("wrong usage")
+
+// run runs the command named in args with the rest of them, returning the
+// exit status of the process
+func run(args []string, out io.Writer, errOut io.Writer) int {
+	name := commands[0].name
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "-h" || name == "-help" || name == "--help" {
+		name = "help"
+	}
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(args, out, errOut)
+		switch {
+		case err == nil:
+			return 0
+		case err == errUsage || err == flag.ErrHelp:
+			return 2
+		default:
+			fmt.Fprintf(errOut, "cable %s: %v\n", name, err)
+			return 1
+		}
+	}
+	fmt.Fprintf(errOut, "cable: unknown command %q\n\n", name)
+	printUsage(errOut)
+	return 2
+}
+
+// printUsage writes the usage of every command
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: cable [command] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.summary)
+	}
+	_ = tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run cable <command> -h for the arguments of a command.")
+}
+
+// newFlagSet returns a flag set of the given command, writing its usage and
+// errors to errOut
+func newFlagSet(name string, errOut io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	for _, c := range commands {
+		if c.name == name {
+			c := c
+			fs.Usage = func() {
+				fmt.Fprintf(errOut, "Usage: cable %s %s\n\n%s\n", c.name, c.usage, c.summary)
+				fs.PrintDefaults()
+			}
+		}
+	}
+	return fs
+}
+
+// loadConfig reads the configuration from the environment with the given
+// function, like cable.NewConfig, returning an error instead of panicking if
+// it is not valid
+func loadConfig(newConfig func() *cable.Config) (config *cable.Config, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid configuration: %v", r)
+		}
+	}()
+	return newConfig(), nil
+}
+
+// adminClient adds the flags to reach the admin API of a running cable to the
+// flag set, returning a function creating its client once parsed
+func adminClient(fs *flag.FlagSet) func() *admin.Client {
+	port := strings.TrimPrefix(os.Getenv("PORT"), ":")
+	if port == "" {
+		port = "8080"
+	}
+	url := fs.String("url", "http://localhost:"+port, "base `URL` of the running cable")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "`token` of the admin API, ADMIN_TOKEN by default")
+	return func() *admin.Client {
+		return admin.NewClient(*url, *token)
+	}
+}
+
+/* Section: commands */
+
+// serveCommand relays messages through the bridges, serving the health,
+// metrics and admin endpoints
+func serveCommand(args []string, out io.Writer, errOut io.Writer) error {
+	if err := newFlagSet("serve", errOut).Parse(args); err != nil {
+		return err
+	}
+	config, err := loadConfig(cable.NewConfig)
+	if err != nil {
+		return err
+	}
+	if err := cable.ConfigureLogging(errOut, config.LogLevel, config.LogFormat); err != nil {
+		return fmt.Errorf("cannot configure logging: %v", err)
+	}
+	cable.LogMessageBodies = config.LogMessageBodies
+
+	if err := startTracing(config); err != nil {
+		return fmt.Errorf("cannot start tracing: %v", err)
+	}
+	defer tracing.Stop()
+
+	if config.RecordFile != "" {
+		recorder, err := recording.Create(config.RecordFile, config.RecordRedaction)
+		if err != nil {
+			return fmt.Errorf("cannot record events: %v", err)
+		}
+		defer recorder.Close()
+		s.SetRecorder(recorder)
+		t.SetRecorder(recorder)
+	}
+
+	health := cable.NewHealthChecker(config.HealthGracePeriod)
+	manager := admin.NewManager(&admin.FileStore{Path: config.BridgesFile}, health, builders(config))
+	if err := manager.Load(defaultBridge(config)); err != nil {
+		return fmt.Errorf("cannot start bridges: %v", err)
+	}
+
+	http.Handle("/_health", health.LivenessHandler())
+	http.Handle("/healthz", health.LivenessHandler())
+	http.Handle("/readyz", health.ReadinessHandler())
+	http.Handle("/metrics", metrics.Handler())
+	if config.AdminToken != "" {
+		http.Handle("/admin/", admin.Handler(manager, config.AdminToken))
+	}
+	if config.PublicURL != "" {
+		http.Handle(t.MediaPath, t.MediaHandler(config.TelegramToken, config.TelegramAPIURL))
+	}
+	http.HandleFunc("/", ok)
+
+	return http.ListenAndServe(config.ListeningPort, nil)
+}
+
+// validateConfigCommand checks the configuration, and the specs of the
+// default and the stored bridges, without connecting to any platform
+func validateConfigCommand(args []string, out io.Writer, errOut io.Writer) error {
+	if err := newFlagSet("validate-config", errOut).Parse(args); err != nil {
+		return err
+	}
+	config, err := loadConfig(cable.NewBridgeConfig)
+	if err != nil {
+		return err
+	}
+	if err := cable.ConfigureLogging(errOut, config.LogLevel, config.LogFormat); err != nil {
+		return fmt.Errorf("invalid logging configuration: %v", err)
+	}
+	if _, err := tracing.ParseHeaders(config.TracingHeaders); err != nil {
+		return fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS: %v", err)
+	}
+
+	store := &admin.FileStore{Path: config.BridgesFile}
+	specs, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %v", config.BridgesFile, err)
+	}
+	specs = admin.MergeDefaults(specs, []admin.BridgeSpec{defaultBridge(config)})
+
+	manager := admin.NewManager(store, nil, builders(config))
+	invalid := 0
+	for _, spec := range specs {
+		if err := manager.Validate(spec); err != nil {
+			fmt.Fprintf(out, "bridge %q: %v\n", spec.Name, err)
+			invalid++
+		} else {
+			fmt.Fprintf(out, "bridge %q: ok\n", spec.Name)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d bridges are not valid", invalid, len(specs))
+	}
+	fmt.Fprintln(out, "the configuration is valid")
+	return nil
+}
+
+// discoverCommand prints the IDs of the bots, and of the slack channels and
+// telegram chats they can relay, which are needed to configure cable
+func discoverCommand(args []string, out io.Writer, errOut io.Writer) error {
+	fs := newFlagSet("discover", errOut)
+	slackToken := fs.String("slack-token", os.Getenv("SLACK_TOKEN"), "`token` of the slack bot, SLACK_TOKEN by default")
+	telegramToken := fs.String("telegram-token", os.Getenv("TELEGRAM_TOKEN"), "`token` of the telegram bot, TELEGRAM_TOKEN by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *slackToken == "" && *telegramToken == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+	if *slackToken != "" {
+		discovery, err := s.Discover(*slackToken, os.Getenv("SLACK_API_URL"))
+		if err != nil {
+			return fmt.Errorf("slack: %v", err)
+		}
+		fmt.Fprintf(tw, "Slack bot\tSLACK_BOT_USER_ID=%s\t(user %s)\n", discovery.BotID, discovery.UserID)
+		fmt.Fprintln(tw, "Slack channels\tSLACK_RELAYED_CHANNEL")
+		for _, c := range discovery.Channels {
+			var notes []string
+			if c.Private {
+				notes = append(notes, "private")
+			}
+			if !c.Member {
+				notes = append(notes, "the bot is not a member")
+			}
+			fmt.Fprintf(tw, "  #%s\t%s\t%s\n", c.Name, c.ID, strings.Join(notes, ", "))
+		}
+	}
+	if *telegramToken != "" {
+		discovery, err := t.Discover(*telegramToken, os.Getenv("TELEGRAM_API_URL"))
+		if err != nil {
+			return fmt.Errorf("telegram: %v", err)
+		}
+		fmt.Fprintf(tw, "Telegram bot\tTELEGRAM_BOT_USER_ID=%d\t(@%s)\n", discovery.BotUserID, discovery.BotUserName)
+		fmt.Fprintln(tw, "Telegram chats\tTELEGRAM_RELAYED_CHANNEL")
+		for _, c := range discovery.Chats {
+			fmt.Fprintf(tw, "  %s\t%d\t%s\n", c.Title, c.ID, c.Type)
+		}
+		if len(discovery.Chats) == 0 {
+			fmt.Fprintln(tw, "  write a message in the chats to relay, and run discover again")
+		}
+	}
+	return nil
+}
+
+// sendCommand relays a test message through a bridge of a running cable
+func sendCommand(args []string, out io.Writer, errOut io.Writer) error {
+	fs := newFlagSet("send", errOut)
+	bridge := fs.String("bridge", "default", "`name` of the bridge")
+	from := fs.String("from", "", "`endpoint` the message is relayed from, the first one reading messages by default")
+	client := adminClient(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	text := strings.Join(fs.Args(), " ")
+	if text == "" {
+		fs.Usage()
+		return errUsage
+	}
+
+	if err := client().Send(*bridge, admin.OutgoingMessage{Endpoint: *from, Text: text}); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "message sent through bridge %q\n", *bridge)
+	return nil
+}
+
+// dlqCommand lists or replays the messages a running cable could not write
+// to the endpoints of a bridge
+func dlqCommand(args []string, out io.Writer, errOut io.Writer) error {
+	fs := newFlagSet("dlq", errOut)
+	bridge := fs.String("bridge", "default", "`name` of the bridge")
+	endpoint := fs.String("endpoint", "", "`name` of the endpoint to replay the messages of, all of them by default")
+	client := adminClient(fs)
+	if len(args) == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	action := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		letters, err := client().DeadLetters(*bridge)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "FAILED AT\tENDPOINT\tERROR\tMESSAGE")
+		for _, letter := range letters {
+			if *endpoint == "" || letter.Endpoint == *endpoint {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", letter.FailedAt.Format("2006-01-02 15:04:05"), letter.Endpoint, letter.Error, letter.Message)
+			}
+		}
+		return tw.Flush()
+	case "replay":
+		replayed, err := client().Replay(*bridge, *endpoint)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%d messages replayed\n", replayed)
+		return nil
+	default:
+		fs.Usage()
+		return errUsage
+	}
+}
+
+// replayCommand feeds the recording at the path given in args through the
+// default bridge, or through the stored bridge named after it, writing the
+// API calls made to out
+func replayCommand(args []string, out io.Writer, errOut io.Writer) error {
+	fs := newFlagSet("replay", errOut)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errUsage
+	}
+	config, err := loadConfig(cable.NewBridgeConfig)
+	if err != nil {
+		return err
+	}
+	entries, err := recording.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	bridge := defaultBridge(config)
+	if fs.NArg() == 2 {
+		specs, err := (&admin.FileStore{Path: config.BridgesFile}).Load()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, spec := range specs {
+			if spec.Name == fs.Arg(1) {
+				bridge, found = spec, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("bridge %q not found in %s", fs.Arg(1), config.BridgesFile)
+		}
+	}
+
+	return replay.Run(entries, replay.Options{
+		Bridge:            bridge,
+		SlackBotUserID:    config.SlackBotUserID,
+		TelegramBotUserID: config.TelegramBotUserID,
+	}, out)
+}
+
+// helpCommand writes the usage of every command
+func helpCommand(args []string, out io.Writer, errOut io.Writer) error {
+	printUsage(out)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/admin"
+	"github.com/miguelff/cable/cable/emulator"
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setEnv sets the given environment variables, returning a function
+// restoring their previous values
+func setEnv(vars map[string]string) func() {
+	previous := make(map[string]*string)
+	for k, v := range vars {
+		if old, ok := os.LookupEnv(k); ok {
+			previous[k] = &old
+		} else {
+			previous[k] = nil
+		}
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range previous {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+// fakePumper is a pumper reading and writing nothing but its channels
+type fakePumper struct {
+	*cable.Pump
+}
+
+func (p *fakePumper) GoRead()  { go func() { <-p.ReadStopper }() }
+func (p *fakePumper) GoWrite() { go func() { <-p.WriteStopper }() }
+
+func TestRun_Usage(test *testing.T) {
+	var out, errOut bytes.Buffer
+	Equal(test, 0, run([]string{"help"}, &out, &errOut))
+	Contains(test, out.String(), "validate-config")
+	Contains(test, out.String(), "discover")
+
+	errOut.Reset()
+	Equal(test, 2, run([]string{"unknown"}, &out, &errOut))
+	Contains(test, errOut.String(), `unknown command "unknown"`)
+
+	errOut.Reset()
+	Equal(test, 2, run([]string{"send"}, &out, &errOut), "the text of the message is required")
+	Contains(test, errOut.String(), "Usage: cable send")
+
+	Equal(test, 2, run([]string{"dlq", "forget"}, &out, &errOut))
+}
+
+func TestRun_ValidateConfig(test *testing.T) {
+	dir, err := ioutil.TempDir("", "cable")
+	NoError(test, err)
+	defer os.RemoveAll(dir)
+	bridgesFile := filepath.Join(dir, "bridges.json")
+
+	// neither the port nor the tokens are needed
+	defer setEnv(map[string]string{
+		"SLACK_RELAYED_CHANNEL":    "CRELAYED",
+		"SLACK_BOT_USER_ID":        "BCABLE",
+		"TELEGRAM_RELAYED_CHANNEL": "-1001234567",
+		"TELEGRAM_BOT_USER_ID":     "1000",
+		"BRIDGES_FILE":             bridgesFile,
+	})()
+
+	var out, errOut bytes.Buffer
+	Equal(test, 0, run([]string{"validate-config"}, &out, &errOut), errOut.String())
+	Contains(test, out.String(), `bridge "default": ok`)
+	Contains(test, out.String(), "the configuration is valid")
+	_, err = os.Stat(bridgesFile)
+	True(test, os.IsNotExist(err), "nothing is stored")
+
+	bridges := `[{"name":"broken","endpoints":[{"platform":"slack","channel":"C1"},{"platform":"irc","channel":"#cable"}]}]`
+	NoError(test, ioutil.WriteFile(bridgesFile, []byte(bridges), 0600))
+	out.Reset()
+	errOut.Reset()
+	Equal(test, 1, run([]string{"validate-config"}, &out, &errOut))
+	Contains(test, out.String(), `bridge "broken": endpoint "irc": unknown platform "irc"`)
+
+	defer setEnv(map[string]string{"TELEGRAM_RELAYED_CHANNEL": "general"})()
+	errOut.Reset()
+	Equal(test, 1, run([]string{"validate-config"}, &out, &errOut))
+	Contains(test, errOut.String(), "invalid configuration")
+}
+
+func TestRun_Discover(test *testing.T) {
+	slackEmulator := emulator.NewSlack()
+	defer slackEmulator.Close()
+	telegramEmulator := emulator.NewTelegram("123:discover")
+	defer telegramEmulator.Close()
+	defer setEnv(map[string]string{"SLACK_API_URL": slackEmulator.URL(), "TELEGRAM_API_URL": telegramEmulator.URL()})()
+
+	slackEmulator.AddChannel("CGENERAL", "general", false)
+	slackEmulator.AddChannel("CSECRET", "secret", true)
+	slackEmulator.SetMembers("CGENERAL", slackEmulator.UserID)
+	telegramEmulator.Say(-1001234567, telegram.User{ID: 42, UserName: "jazz"}, "hi bot")
+
+	var out, errOut bytes.Buffer
+	args := []string{"discover", "-slack-token", "xoxb-discover", "-telegram-token", telegramEmulator.Token}
+	Equal(test, 0, run(args, &out, &errOut), errOut.String())
+	Contains(test, out.String(), "SLACK_BOT_USER_ID="+slackEmulator.BotID)
+	Contains(test, out.String(), "#general")
+	Contains(test, out.String(), "CGENERAL")
+	Contains(test, out.String(), "private, the bot is not a member")
+	Contains(test, out.String(), "TELEGRAM_BOT_USER_ID=1000")
+	Contains(test, out.String(), "-1001234567")
+
+	Equal(test, 2, run([]string{"discover", "-slack-token", "", "-telegram-token", ""}, &out, &errOut))
+}
+
+func TestRun_SendAndDeadLetters(test *testing.T) {
+	pumpers := make(map[string]*fakePumper)
+	builders := map[string]admin.Builder{
+		"fake": func(spec admin.EndpointSpec) (cable.Pumper, error) {
+			pumpers[spec.Channel] = &fakePumper{Pump: cable.NewPump()}
+			return pumpers[spec.Channel], nil
+		},
+	}
+	manager := admin.NewManager(discardStore{}, nil, builders)
+	NoError(test, manager.Create(admin.BridgeSpec{Name: "ops", Endpoints: []admin.EndpointSpec{
+		{Name: "a", Platform: "fake", Channel: "a"},
+		{Name: "b", Platform: "fake", Channel: "b"},
+	}}))
+	defer manager.Delete("ops")
+	server := httptest.NewServer(admin.Handler(manager, "secret"))
+	defer server.Close()
+	flags := []string{"-bridge", "ops", "-url", server.URL, "-token", "secret"}
+
+	var out, errOut bytes.Buffer
+	Equal(test, 0, run(append(append([]string{"send"}, flags...), "-from", "b", "testing", "1, 2"), &out, &errOut), errOut.String())
+	select {
+	case m := <-pumpers["a"].Outbox():
+		Equal(test, "testing 1, 2", m.String())
+	case <-time.After(waitTimeout):
+		Fail(test, "the message was not relayed")
+	}
+
+	pumpers["b"].DeadLetters().Add(&cable.Notice{Text: "lost", Plain: true}, errors.New("timeout"))
+	out.Reset()
+	Equal(test, 0, run(append([]string{"dlq", "list"}, flags...), &out, &errOut), errOut.String())
+	Contains(test, out.String(), "timeout")
+	Contains(test, out.String(), "lost")
+
+	out.Reset()
+	Equal(test, 0, run(append([]string{"dlq", "replay"}, flags...), &out, &errOut), errOut.String())
+	Equal(test, "1 messages replayed\n", out.String())
+
+	errOut.Reset()
+	Equal(test, 1, run([]string{"send", "-bridge", "ops", "-url", server.URL, "-token", "wrong", "testing"}, &out, &errOut))
+	Contains(test, errOut.String(), "401 Unauthorized")
+}
+
+// discardStore is an admin.Store not storing anything
+type discardStore struct{}
+
+func (discardStore) Load() ([]admin.BridgeSpec, error) { return nil, nil }
+func (discardStore) Save([]admin.BridgeSpec) error     { return nil }
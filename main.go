@@ -3,33 +3,138 @@ package main
 import (
 	"github.com/joho/godotenv"
 	"github.com/miguelff/cable/cable"
+	"github.com/miguelff/cable/cable/admin"
+	e "github.com/miguelff/cable/cable/email"
 	s "github.com/miguelff/cable/cable/slack"
 	t "github.com/miguelff/cable/cable/telegram"
+	"github.com/miguelff/cable/cable/tracing"
 	log "github.com/sirupsen/logrus"
 	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
 )
 
 func ok(w http.ResponseWriter, _ *http.Request) {
 	_, _ = w.Write([]byte("OK"))
 }
 
-func main() {
-	log.SetLevel(log.DebugLevel)
-
-	if err := godotenv.Load(); err != nil {
-		log.Info("Cannot load env file: ", err)
+// builders returns the functions creating the endpoints of each platform,
+// using the credentials in the config
+func builders(config *cable.Config) map[string]admin.Builder {
+	// the kinds of events are validated when reading the config
+	events, _ := cable.ParseEventRelay(config.RelayedEvents, config.MirroredEvents)
+	return map[string]admin.Builder{
+		"slack": func(spec admin.EndpointSpec) (cable.Pumper, error) {
+			pumper := s.NewSlack(config.SlackToken, config.SlackAPIURL, spec.Channel, config.SlackBotUserID)
+			pumper.SetCoalesceBacklog(config.SlackCoalesceBacklog)
+			pumper.SetMaxParts(config.SlackMaxParts)
+			pumper.SetMessageFormat(config.SlackMessageFormat)
+			pumper.SetEventRelay(events)
+			return pumper, nil
+		},
+		"telegram": func(spec admin.EndpointSpec) (cable.Pumper, error) {
+			chatID, err := strconv.ParseInt(spec.Channel, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			pumper := t.NewTelegram(config.TelegramToken, config.TelegramAPIURL, chatID, config.TelegramBotUserID, false)
+			pumper.SetCoalesceBacklog(config.TelegramCoalesceBacklog)
+			pumper.SetMaxParts(config.TelegramMaxParts)
+			pumper.SetMediaURL(config.PublicURL)
+			if config.TranscriptionCommand != "" {
+				pumper.SetTranscriber(&cable.WhisperTranscriber{
+					Command:  config.TranscriptionCommand,
+					Model:    config.TranscriptionModel,
+					Language: config.TranscriptionLanguage,
+					FFmpeg:   config.FFmpeg,
+				})
+			}
+			if ffmpeg, err := exec.LookPath(config.FFmpeg); err == nil {
+				pumper.SetImageConverter(&cable.FFmpegConverter{FFmpeg: ffmpeg})
+			} else {
+				log.WithError(err).Warn("Stickers shared in telegram are relayed without image, as ffmpeg is not found")
+			}
+			pumper.SetEventRelay(events)
+			return pumper, nil
+		},
+		"email": func(spec admin.EndpointSpec) (cable.Pumper, error) {
+			return e.NewEmail(e.Options{
+				SMTPAddr: config.EmailSMTPAddr,
+				IMAPAddr: config.EmailIMAPAddr,
+				IMAPTLS:  config.EmailIMAPTLS,
+				Username: config.EmailUsername,
+				Password: config.EmailPassword,
+				Mailbox:  config.EmailMailbox,
+				From:     config.EmailFrom,
+				To:       spec.Channel,
+				Digest:   config.EmailDigest,
+			}), nil
+		},
 	}
+}
 
-	config := cable.NewConfig()
-	log.Debugf("Config %v", config)
+// defaultBridge returns the bridge configured through environment variables,
+// which is started when no bridges are stored yet
+func defaultBridge(config *cable.Config) admin.BridgeSpec {
+	bridge := admin.BridgeSpec{
+		Name:     "default",
+		Batching: config.Batching,
+		Endpoints: []admin.EndpointSpec{
+			{
+				Name:      "slack",
+				Platform:  "slack",
+				Channel:   config.SlackRelayedChannel,
+				Direction: config.SlackDirection.String(),
+				Inbound:   config.SlackInbound,
+				Outbound:  config.SlackOutbound,
+			},
+			{
+				Name:      "telegram",
+				Platform:  "telegram",
+				Channel:   strconv.FormatInt(config.TelegramRelayedChannel, 10),
+				Direction: config.TelegramDirection.String(),
+				Inbound:   config.TelegramInbound,
+				Outbound:  config.TelegramOutbound,
+			},
+		},
+	}
 
-	slack := s.NewSlack(config.SlackToken, config.SlackRelayedChannel, config.SlackBotUserID)
-	telegram := t.NewTelegram(config.TelegramToken, config.TelegramRelayedChannel, config.TelegramBotUserID, false)
-	cable.NewBidirectionalPumpConnection(slack, telegram).Go()
-	log.Infoln("Slack and Telegram are now connected.")
+	if config.EmailTo != "" {
+		bridge.Endpoints = append(bridge.Endpoints, admin.EndpointSpec{
+			Name:      "email",
+			Platform:  "email",
+			Channel:   config.EmailTo,
+			Direction: config.EmailDirection.String(),
+			Inbound:   config.EmailInbound,
+			Outbound:  config.EmailOutbound,
+		})
+	}
+	return bridge
+}
 
-	http.HandleFunc("/_health", ok)
-	http.HandleFunc("/", ok)
+// startTracing enables tracing the messages relayed, exporting their traces
+// as configured
+func startTracing(config *cable.Config) error {
+	switch config.TracingExporter {
+	case "stdout":
+		tracing.Start(tracing.NewWriterExporter(os.Stdout))
+	case "otlp":
+		headers, err := tracing.ParseHeaders(config.TracingHeaders)
+		if err != nil {
+			return err
+		}
+		tracing.Start(tracing.NewOTLPExporter(config.TracingEndpoint, headers, config.TracingServiceName))
+	}
+	return nil
+}
 
-	_ = http.ListenAndServe(config.ListeningPort, nil)
+func main() {
+	envErr := godotenv.Load()
+	// commands reading the configuration report invalid logging settings
+	_ = cable.ConfigureLogging(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if envErr != nil {
+		log.WithError(envErr).Info("Cannot load env file")
+	}
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
 }